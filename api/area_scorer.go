@@ -0,0 +1,318 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/gnasnik/titan-explorer/core/geo"
+)
+
+const (
+	// areaStatsWindow 统计区域传输表现的时间范围
+	areaStatsWindow = 24 * time.Hour
+	// minAreaStatsSamples 传输记录少于此数时使用更粗粒度的统计
+	minAreaStatsSamples = 20
+	// areaCapacityTTL 区域在线节点数的缓存时间
+	areaCapacityTTL = 10 * time.Minute
+	// areaScoreNeutral 没有统计数据时的得分
+	areaScoreNeutral = 0.5
+
+	areaWeightDistance    = 0.35
+	areaWeightThroughput  = 0.3
+	areaWeightReliability = 0.2
+	areaWeightCapacity    = 0.15
+)
+
+var areaCapacityCache = struct {
+	sync.Mutex
+	expireAt time.Time
+	maps     map[string]*dao.AreaCapacity
+}{}
+
+// AreaScore 区域的各项得分, Score 越高越优先
+type AreaScore struct {
+	AreaID      string                   `json:"area_id"`
+	DistanceKm  float64                  `json:"distance_km"`
+	Stats       *model.AreaTransferStats `json:"stats"`
+	OnlineNodes int64                    `json:"online_nodes"`
+	Distance    float64                  `json:"distance_score"`
+	Throughput  float64                  `json:"throughput_score"`
+	Reliability float64                  `json:"reliability_score"`
+	Capacity    float64                  `json:"capacity_score"`
+	Score       float64                  `json:"score"`
+}
+
+// ScoreAreas 按距离, 最近的传输速度和失败率以及区域的在线节点数给区域打分, 按得分从高到低返回
+// 传输表现优先使用同一国家和运营商的统计, 样本不足时依次使用国家级和全局的统计
+func ScoreAreas(ctx context.Context, ip, transferType string, areaIDs []string) ([]*AreaScore, error) {
+	if len(areaIDs) == 0 {
+		return nil, fmt.Errorf("no area to score")
+	}
+
+	var (
+		country, isp string
+		lat, lng     float64
+		hasLoc       bool
+	)
+	if loc, err := geo.GetIpLocation(ctx, ip); err == nil {
+		country, isp = loc.Country, loc.Isp
+		lat, err = strconv.ParseFloat(loc.Latitude, 64)
+		if err == nil {
+			lng, err = strconv.ParseFloat(loc.Longitude, 64)
+		}
+		hasLoc = err == nil
+	}
+
+	statsList, err := dao.GetAreaTransferStats(ctx, areaIDs, transferType, country, isp)
+	if err != nil {
+		return nil, fmt.Errorf("get area transfer stats: %w", err)
+	}
+	capacities := getAreaCapacities(ctx)
+
+	scores := make([]*AreaScore, 0, len(areaIDs))
+	coordinate := NewIPCoordinate()
+	for _, areaID := range areaIDs {
+		s := &AreaScore{AreaID: areaID, DistanceKm: -1, Stats: pickAreaTransferStats(statsList, areaID, country, isp)}
+		if hasLoc {
+			if areaIP, err := GetAreaIPByID(ctx, areaID); err == nil && areaIP != "" {
+				if lat2, lng2, err := coordinate.GetLatLng(ctx, areaIP); err == nil {
+					s.DistanceKm = calculateDistance(lat, lng, lat2, lng2)
+				}
+			}
+		}
+		if c, ok := capacities[areaID]; ok {
+			s.OnlineNodes = c.OnlineNodes
+		}
+		scores = append(scores, s)
+	}
+
+	var maxP50, maxP95, maxNodes float64
+	for _, s := range scores {
+		if s.Stats != nil {
+			maxP50 = math.Max(maxP50, float64(s.Stats.P50Rate))
+			maxP95 = math.Max(maxP95, float64(s.Stats.P95Rate))
+		}
+		maxNodes = math.Max(maxNodes, float64(s.OnlineNodes))
+	}
+
+	for _, s := range scores {
+		// 距离 0 时得 1 分, 1000km 时得 0.5 分
+		s.Distance = areaScoreNeutral
+		if s.DistanceKm >= 0 {
+			s.Distance = 1 / (1 + s.DistanceKm/1000)
+		}
+
+		s.Throughput, s.Reliability = areaScoreNeutral, areaScoreNeutral
+		if s.Stats != nil {
+			s.Throughput = 0.6*ratio(float64(s.Stats.P50Rate), maxP50) + 0.4*ratio(float64(s.Stats.P95Rate), maxP95)
+			s.Reliability = 1 - s.Stats.FailRate
+		}
+
+		s.Capacity = ratio(float64(s.OnlineNodes), maxNodes)
+
+		s.Score = areaWeightDistance*s.Distance + areaWeightThroughput*s.Throughput +
+			areaWeightReliability*s.Reliability + areaWeightCapacity*s.Capacity
+	}
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].Score > scores[j].Score
+	})
+
+	return scores, nil
+}
+
+// GetBestAreaID 获取得分最高的区域, 打分失败时按距离选择
+func GetBestAreaID(ctx context.Context, ip, transferType string, areaIDs []string) (string, error) {
+	scores, err := ScoreAreas(ctx, ip, transferType, areaIDs)
+	if err == nil {
+		return scores[0].AreaID, nil
+	}
+	log.Errorf("score areas error:%v", err)
+
+	return GetNearestAreaID(ctx, ip, areaIDs)
+}
+
+func pickAreaTransferStats(list []*model.AreaTransferStats, areaID, country, isp string) *model.AreaTransferStats {
+	levels := [][2]string{{country, isp}, {country, ""}, {"", ""}}
+	for _, level := range levels {
+		for _, v := range list {
+			if v.AreaID == areaID && v.Country == level[0] && v.Isp == level[1] && v.Samples >= minAreaStatsSamples {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+func ratio(v, max float64) float64 {
+	if max <= 0 {
+		return 0
+	}
+	return v / max
+}
+
+func getAreaCapacities(ctx context.Context) map[string]*dao.AreaCapacity {
+	areaCapacityCache.Lock()
+	defer areaCapacityCache.Unlock()
+
+	if time.Now().Before(areaCapacityCache.expireAt) {
+		return areaCapacityCache.maps
+	}
+
+	list, err := dao.GetAreaCapacities(ctx)
+	if err != nil {
+		log.Errorf("GetAreaCapacities error: %v", err)
+		return areaCapacityCache.maps
+	}
+
+	maps := make(map[string]*dao.AreaCapacity, len(list))
+	for _, v := range list {
+		maps[v.AreaID] = v
+	}
+	areaCapacityCache.maps = maps
+	areaCapacityCache.expireAt = time.Now().Add(areaCapacityTTL)
+
+	return maps
+}
+
+// SyncAreaTransferStats 按区域, 国家, 运营商统计最近的传输速度分位数和失败率
+func SyncAreaTransferStats(ctx context.Context) error {
+	now := time.Now()
+	samples, err := dao.GetAreaTransferSamples(ctx, now.Add(-areaStatsWindow))
+	if err != nil {
+		return fmt.Errorf("get transfer samples: %w", err)
+	}
+
+	type bucket struct {
+		stats *model.AreaTransferStats
+		rates []int64
+		fails int64
+	}
+	buckets := make(map[[4]string]*bucket)
+	for _, v := range samples {
+		levels := [][2]string{{v.Country, v.Isp}, {v.Country, ""}, {"", ""}}
+		for i, level := range levels {
+			// 国家或运营商未知的记录只计入更粗粒度的统计
+			if i == 0 && (v.Country == "" || v.Isp == "") || i == 1 && v.Country == "" {
+				continue
+			}
+			key := [4]string{v.Area, level[0], level[1], v.TransferType}
+			b, ok := buckets[key]
+			if !ok {
+				b = &bucket{stats: &model.AreaTransferStats{AreaID: v.Area, Country: level[0], Isp: level[1], TransferType: v.TransferType}}
+				buckets[key] = b
+			}
+			b.stats.Samples++
+			if v.State == dao.AssetTransferStateSuccess {
+				b.rates = append(b.rates, v.Rate)
+			} else {
+				b.fails++
+			}
+		}
+	}
+
+	list := make([]*model.AreaTransferStats, 0, len(buckets))
+	for _, b := range buckets {
+		sort.Slice(b.rates, func(i, j int) bool { return b.rates[i] < b.rates[j] })
+		b.stats.P50Rate = percentile(b.rates, 0.5)
+		b.stats.P95Rate = percentile(b.rates, 0.95)
+		b.stats.FailRate = float64(b.fails) / float64(b.stats.Samples)
+		list = append(list, b.stats)
+	}
+
+	return dao.SaveAreaTransferStats(ctx, list, now)
+}
+
+// percentile 获取已排序数据的分位数
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+// GetAreaScoresHandler 查看区域对某个 ip 的得分
+// @Summary 区域得分
+// @Security ApiKeyAuth
+// @Tags admin
+// @Param ip query string true "用户ip"
+// @Param transfer_type query string false "upload, download, 默认 upload"
+// @Param area_id query []string false "区域, 默认所有区域"
+// @Success 200 {object} JsonObject "{list:[]}"
+// @Router /api/v1/admin/area_scores [get]
+func GetAreaScoresHandler(c *gin.Context) {
+	ip := c.Query("ip")
+	if ip == "" {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	transferType := c.DefaultQuery("transfer_type", dao.AssetTransferTypeUpload)
+
+	areaIDs := c.QueryArray("area_id")
+	if len(areaIDs) == 0 {
+		_, maps, err := GetAndStoreAreaIDs()
+		if err != nil {
+			log.Errorf("GetAndStoreAreaIDs error: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
+		}
+		for _, v := range maps {
+			areaIDs = append(areaIDs, v...)
+		}
+	}
+
+	scores, err := ScoreAreas(c.Request.Context(), ip, transferType, areaIDs)
+	if err != nil {
+		log.Errorf("ScoreAreas error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list": scores,
+	}))
+}
+
+// GetAreaTransferStatsHandler 获取区域的传输表现统计
+// @Summary 区域传输表现
+// @Security ApiKeyAuth
+// @Tags admin
+// @Param area_id query string false "区域"
+// @Param country query string false "国家"
+// @Param page query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} JsonObject "{list:[],total:0}"
+// @Router /api/v1/admin/area_transfer_stats [get]
+func GetAreaTransferStatsHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	total, list, err := dao.ListAreaTransferStats(c.Request.Context(), c.Query("area_id"), c.Query("country"), dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListAreaTransferStats error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  list,
+		"total": total,
+	}))
+}
@@ -0,0 +1,262 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Filecoin-Titan/titan/api/terrors"
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/geo"
+	"github.com/gnasnik/titan-explorer/core/storage"
+	"github.com/gnasnik/titan-explorer/pkg/iptool"
+	"github.com/golang-module/carbon/v2"
+)
+
+const (
+	assetAccessIntervalHour = "hour"
+	assetAccessIntervalDay  = "day"
+
+	// 按小时查询最多 7 天, 按天查询最多 90 天
+	maxAssetAccessHourDays = 7
+	maxAssetAccessDayDays  = 90
+
+	assetAccessTopLimit = 10
+
+	// 热门文件的访问权重每 3 天减半
+	hotAssetHalfLifeDays = 3
+	maxHotAssetDays      = 30
+	maxHotAssetLimit     = 100
+
+	assetAccessReferrerDirect = "direct"
+)
+
+// recordAssetAccess 异步记录下载者的国家和来源网站
+func recordAssetAccess(c *gin.Context, userID, hash string) {
+	ip := iptool.GetClientIP(c.Request)
+	referrer := assetAccessReferrerDirect
+	if u, err := url.Parse(c.Request.Referer()); err == nil && u.Host != "" {
+		referrer = u.Host
+	}
+	now := time.Now()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		dims := map[string]string{dao.AssetAccessDimensionReferrer: referrer}
+		if loc, err := geo.GetIpLocation(ctx, ip); err == nil && loc != nil {
+			dims[dao.AssetAccessDimensionCountry] = loc.Country
+		}
+
+		if err := dao.AddAssetAccessDimensions(ctx, userID, hash, now, dims); err != nil {
+			log.Errorf("AddAssetAccessDimensions: %v", err)
+		}
+	}()
+}
+
+// parseAssetAccessFilter 解析查询的时间范围和粒度, 默认按小时查询最近 24 小时
+func parseAssetAccessFilter(c *gin.Context, userID string) (*dao.AssetAccessFilter, string, bool) {
+	interval := c.DefaultQuery("interval", assetAccessIntervalHour)
+	maxDays := maxAssetAccessHourDays
+	switch interval {
+	case assetAccessIntervalHour:
+	case assetAccessIntervalDay:
+		maxDays = maxAssetAccessDayDays
+	default:
+		return nil, "", false
+	}
+
+	start, end := carbon.Now().SubDay().StartOfHour(), carbon.Now().EndOfHour()
+	if interval == assetAccessIntervalDay {
+		start, end = carbon.Now().SubDays(29).StartOfDay(), carbon.Now().EndOfDay()
+	}
+	if v := c.Query("start_time"); v != "" {
+		start = carbon.Parse(v)
+	}
+	if v := c.Query("end_time"); v != "" {
+		end = carbon.Parse(v)
+	}
+	if start.Error != nil || end.Error != nil || start.Gt(end) || start.DiffInDays(end) >= int64(maxDays) {
+		return nil, "", false
+	}
+
+	return &dao.AssetAccessFilter{UserID: userID, Start: start.StdTime(), End: end.StdTime()}, interval, true
+}
+
+func respondAssetAccessStats(c *gin.Context, filter *dao.AssetAccessFilter, interval string) {
+	ctx := c.Request.Context()
+
+	var (
+		series []*dao.AssetAccessPoint
+		err    error
+	)
+	if interval == assetAccessIntervalDay {
+		series, err = dao.GetAssetAccessDaily(ctx, filter)
+	} else {
+		series, err = dao.GetAssetAccessHourly(ctx, filter)
+	}
+	if err != nil {
+		log.Errorf("GetAssetAccess %s: %v", interval, err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	var total dao.AssetAccessPoint
+	for _, p := range series {
+		total.DownloadCount += p.DownloadCount
+		total.TotalTraffic += p.TotalTraffic
+		if p.PeakBandwidth > total.PeakBandwidth {
+			total.PeakBandwidth = p.PeakBandwidth
+		}
+	}
+
+	countries, err := dao.GetAssetAccessTop(ctx, filter, dao.AssetAccessDimensionCountry, assetAccessTopLimit)
+	if err != nil {
+		log.Errorf("GetAssetAccessTop country: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	referrers, err := dao.GetAssetAccessTop(ctx, filter, dao.AssetAccessDimensionReferrer, assetAccessTopLimit)
+	if err != nil {
+		log.Errorf("GetAssetAccessTop referrer: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"interval":       interval,
+		"series":         series,
+		"download_count": total.DownloadCount,
+		"total_traffic":  total.TotalTraffic,
+		"peak_bandwidth": total.PeakBandwidth,
+		"top_countries":  countries,
+		"top_referrers":  referrers,
+	}))
+}
+
+// GetAssetAccessStatsHandler 获取单个文件的下载次数, 流量, 峰值带宽, 以及下载最多的国家和来源
+// @Summary 获取文件的访问统计
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param cid query string true "文件cid"
+// @Param interval query string false "hour, day"
+// @Param start_time query string false "开始时间"
+// @Param end_time query string false "结束时间"
+// @Success 200 {object} JsonObject "{interval:"",series:[]dao.AssetAccessPoint,download_count:0,total_traffic:0,peak_bandwidth:0,top_countries:[],top_referrers:[]}"
+// @Router /api/v1/storage/asset/access_stats [get]
+func GetAssetAccessStatsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	filter, interval, ok := parseAssetAccessFilter(c, username)
+	if !ok {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	hash, err := storage.CIDToHash(c.Query("cid"))
+	if err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	if _, err = dao.GetUserAsset(c.Request.Context(), hash, username); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusOK, respErrorCode(int(terrors.NotFound), c))
+			return
+		}
+		log.Errorf("GetUserAsset: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	filter.Hash = hash
+
+	respondAssetAccessStats(c, filter, interval)
+}
+
+// GetAssetGroupAccessStatsHandler 获取文件组(包括子文件组)内所有文件的访问统计
+// @Summary 获取文件组的访问统计
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param group_id query int true "文件组id"
+// @Param interval query string false "hour, day"
+// @Param start_time query string false "开始时间"
+// @Param end_time query string false "结束时间"
+// @Success 200 {object} JsonObject "{interval:"",series:[]dao.AssetAccessPoint,download_count:0,total_traffic:0,peak_bandwidth:0,top_countries:[],top_referrers:[]}"
+// @Router /api/v1/storage/group/access_stats [get]
+func GetAssetGroupAccessStatsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	filter, interval, ok := parseAssetAccessFilter(c, username)
+	if !ok {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	groupID, err := strconv.ParseInt(c.Query("group_id"), 10, 64)
+	if err != nil || groupID <= 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	filter.GroupIDs, err = dao.GetAssetGroupTreeIDs(c.Request.Context(), username, groupID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusOK, respErrorCode(int(terrors.GroupNotExist), c))
+			return
+		}
+		log.Errorf("GetAssetGroupTreeIDs: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	respondAssetAccessStats(c, filter, interval)
+}
+
+// GetHotAssetsHandler 获取用户最近一段时间的热门文件, 越近的下载权重越高
+// @Summary 获取热门文件
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param days query int false "最近几天, 默认 7"
+// @Param limit query int false "数量, 默认 20"
+// @Success 200 {object} JsonObject "{list:[]}"
+// @Router /api/v1/storage/asset/hot [get]
+func GetHotAssetsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	days, _ := strconv.Atoi(c.DefaultQuery("days", "7"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if days <= 0 || days > maxHotAssetDays || limit <= 0 || limit > maxHotAssetLimit {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	now := time.Now()
+	since := carbon.CreateFromStdTime(now).SubDays(days - 1).StartOfDay().StdTime()
+	list, err := dao.GetHotAssets(c.Request.Context(), username, since, now, hotAssetHalfLifeDays, uint64(limit))
+	if err != nil {
+		log.Errorf("GetHotAssets: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list": list,
+	}))
+}
+
+// SyncAssetAccessDaily 把昨天零点以来的小时数据汇总到每天的访问记录
+func SyncAssetAccessDaily(ctx context.Context) error {
+	start := carbon.Now().SubDay().StartOfDay().StdTime()
+	return dao.RollupAssetAccessDaily(ctx, start, time.Now())
+}
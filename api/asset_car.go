@@ -0,0 +1,675 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Filecoin-Titan/titan/api/terrors"
+	"github.com/Filecoin-Titan/titan/api/types"
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/config"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/gnasnik/titan-explorer/core/opasynq"
+	"github.com/gnasnik/titan-explorer/core/storage"
+	"github.com/gnasnik/titan-explorer/pkg/carutil"
+	"github.com/gnasnik/titan-explorer/pkg/oss"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+const (
+	// maxCarImportBlocks 导入时解析 CAR 的块数上限
+	maxCarImportBlocks = 1000000
+
+	// carExportRetention 导出的 CAR 文件保留时间
+	carExportRetention = 7 * 24 * time.Hour
+	// carExportLinkTTL 下载链接的有效期
+	carExportLinkTTL = 24 * time.Hour
+)
+
+type (
+	// CarImportRoot 导入的 CAR 中的一个根
+	CarImportRoot struct {
+		CID       string `json:"cid" binding:"required"`
+		Name      string `json:"name"`
+		AssetType string `json:"asset_type"`
+		Size      int64  `json:"size"`
+	}
+
+	// CarImportReq 导入 CAR 的请求, 已在本地解析 CAR 时直接提交根列表
+	CarImportReq struct {
+		Roots   []*CarImportRoot `json:"roots" binding:"required"`
+		GroupID int64            `json:"group_id"`
+		AreaID  []string         `json:"area_id"`
+	}
+
+	// CarImportResult 每个根的导入结果, 需要上传时客户端把 CAR 文件上传到返回的地址
+	CarImportResult struct {
+		CID     string       `json:"cid"`
+		Name    string       `json:"name"`
+		Exists  bool         `json:"exists"`
+		Code    int          `json:"code,omitempty"`
+		Uploads []JsonObject `json:"uploads"`
+	}
+
+	// CarExportReq 导出文件组为 CAR 的请求
+	CarExportReq struct {
+		GroupID int64 `json:"group_id"`
+	}
+
+	// carExportManifest 导出的 CAR 中的清单文件
+	carExportManifest struct {
+		Name      string                   `json:"name"`
+		GroupID   int64                    `json:"group_id"`
+		CreatedAt time.Time                `json:"created_at"`
+		Assets    []*carExportManifestItem `json:"assets"`
+	}
+
+	carExportManifestItem struct {
+		Path        string    `json:"path"`
+		CID         string    `json:"cid"`
+		AssetType   string    `json:"asset_type"`
+		Size        int64     `json:"size"`
+		CreatedTime time.Time `json:"created_time"`
+	}
+)
+
+// ImportCarHandler 导入 CAR 文件, 为每个根创建用户文件
+// 上传 CAR 文件时 (multipart, 字段 file) 由服务端解析根和大小, 也可以直接提交根列表
+// @Summary 导入 CAR 文件
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param req body CarImportReq false "根列表, 上传文件时使用 multipart 字段 file, group_id, area_id"
+// @Success 200 {object} JsonObject "{list:[]CarImportResult}"
+// @Router /api/v1/storage/car/import [post]
+func ImportCarHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req CarImportReq
+	if c.ContentType() == gin.MIMEMultipartPOSTForm {
+		roots, ok := readCarImportRoots(c)
+		if !ok {
+			return
+		}
+		req.Roots = roots
+		req.GroupID, _ = strconv.ParseInt(c.PostForm("group_id"), 10, 64)
+		req.AreaID = c.PostFormArray("area_id")
+	} else if err := c.ShouldBindJSON(&req); err != nil || len(req.Roots) == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	areaIds := getAreaIDsByArea(c, req.AreaID)
+	if len(areaIds) == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	user, err := dao.GetUserByUsername(c.Request.Context(), userId)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.UserNotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetUserByUsername error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	if req.GroupID > 0 {
+		if _, err = dao.GetUserAssetGroupInfo(c.Request.Context(), userId, int(req.GroupID)); err != nil {
+			c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+			return
+		}
+	}
+
+	// 先找出需要新建的根, 一起判断存储空间是否够用
+	type pending struct {
+		root          *CarImportRoot
+		hash          string
+		notExistsAids []string
+	}
+	var (
+		results  = make([]*CarImportResult, 0, len(req.Roots))
+		creates  []*pending
+		needSize int64
+	)
+	for _, root := range req.Roots {
+		if root.Name == "" {
+			root.Name = root.CID
+		}
+		if root.AssetType == "" {
+			root.AssetType = "file"
+		}
+
+		hash, err := storage.CIDToHash(root.CID)
+		if err != nil || root.Size < 0 {
+			results = append(results, &CarImportResult{CID: root.CID, Name: root.Name, Code: errors.InvalidParams})
+			continue
+		}
+
+		notExistsAids, err := dao.GetUserAssetNotAreaIDs(c.Request.Context(), hash, userId, areaIds)
+		if err != nil {
+			log.Errorf("GetUserAssetNotAreaIDs error: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
+		}
+		if len(notExistsAids) == 0 {
+			results = append(results, &CarImportResult{CID: root.CID, Name: root.Name, Exists: true})
+			continue
+		}
+
+		creates = append(creates, &pending{root: root, hash: hash, notExistsAids: notExistsAids})
+		needSize += root.Size
+	}
+
+	// 判断用户存储空间是否够用 (租户子账户除外)
+	if user.TenantID == "" && user.TotalStorageSize-user.UsedStorageSize < needSize {
+		c.JSON(http.StatusOK, respErrorCode(int(terrors.UserStorageSizeNotEnough), c))
+		return
+	}
+
+	schedulerClient, err := getSchedulerClient(c.Request.Context(), areaIds[0])
+	if err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.NoSchedulerFound, c))
+		return
+	}
+
+	for _, p := range creates {
+		result := &CarImportResult{CID: p.root.CID, Name: p.root.Name, Uploads: make([]JsonObject, 0)}
+		results = append(results, result)
+
+		createAssetRsp, err := schedulerClient.CreateAsset(c.Request.Context(), &types.CreateAssetReq{
+			UserID: userId, AssetCID: p.root.CID, AssetSize: p.root.Size, Owner: userId})
+		if err != nil {
+			log.Errorf("ImportCarHandler CreateAsset error: %v", err)
+			result.Code = webErrCode(err)
+			continue
+		}
+
+		if err = dao.AddAssetAndUpdateSize(c.Request.Context(), &model.UserAsset{
+			UserID:      userId,
+			Hash:        p.hash,
+			Cid:         p.root.CID,
+			AssetName:   p.root.Name,
+			AssetType:   p.root.AssetType,
+			CreatedTime: time.Now(),
+			TotalSize:   p.root.Size,
+			GroupID:     req.GroupID,
+		}, p.notExistsAids, areaIds[0]); err != nil {
+			log.Errorf("ImportCarHandler AddAsset error: %v", err)
+			result.Code = errors.InternalServer
+			continue
+		}
+		if err = EnqueueUserAssetSync(c.Request.Context(), userId, userId, p.root.CID, p.hash, areaIds[0], p.notExistsAids); err != nil {
+			log.Errorf("EnqueueUserAssetSync error: %v", err)
+		}
+		addAssetVersion(c.Request.Context(), userId, p.hash)
+
+		if createAssetRsp.AlreadyExists {
+			addAssetDedupHit(c.Request.Context(), userId, p.hash, areaIds[0], p.root.Size)
+			result.Exists = true
+			continue
+		}
+		for _, v := range createAssetRsp.List {
+			result.Uploads = append(result.Uploads, JsonObject{"CandidateAddr": v.UploadURL, "Token": v.Token})
+		}
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list": results,
+		"area": areaIds[0],
+	}))
+}
+
+// readCarImportRoots 解析上传的 CAR 文件, 只有块完整的根才能导入
+func readCarImportRoots(c *gin.Context) ([]*CarImportRoot, bool) {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return nil, false
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		log.Errorf("open car file error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return nil, false
+	}
+	defer f.Close()
+
+	infos, err := carutil.ReadRoots(f, maxCarImportBlocks)
+	if err != nil || len(infos) == 0 {
+		log.Errorf("read car roots error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return nil, false
+	}
+
+	base := strings.TrimSuffix(fh.Filename, filepath.Ext(fh.Filename))
+	roots := make([]*CarImportRoot, 0, len(infos))
+	for i, info := range infos {
+		if !info.Complete {
+			c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+			return nil, false
+		}
+		name := base
+		if len(infos) > 1 {
+			name = fmt.Sprintf("%s-%d", base, i+1)
+		}
+		roots = append(roots, &CarImportRoot{CID: info.Cid.String(), Name: name, Size: int64(info.Size)})
+	}
+	return roots, true
+}
+
+func carExportDir() string {
+	if config.Cfg.CarExportDir != "" {
+		return config.Cfg.CarExportDir
+	}
+	return filepath.Join(os.TempDir(), "car_export")
+}
+
+func signCarExport(id, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(config.Cfg.SecretKey))
+	fmt.Fprintf(mac, "car_export:%d:%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func carExportDownloadURL(id int64) string {
+	expires := time.Now().Add(carExportLinkTTL).Unix()
+	return fmt.Sprintf("%s/api/v1/storage/car/export/download?id=%d&expires=%d&sign=%s", config.Cfg.BaseURL, id, expires, signCarExport(id, expires))
+}
+
+// CreateCarExportHandler 把文件组及其子文件组中的文件导出为一个 CAR 文件
+// @Summary 导出文件组为 CAR
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param req body CarExportReq true "group_id 为 0 时导出根目录"
+// @Success 200 {object} JsonObject "{id:0}"
+// @Router /api/v1/storage/car/export [post]
+func CreateCarExportHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req CarExportReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.GroupID < 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	name := "root"
+	if req.GroupID > 0 {
+		group, err := dao.GetUserAssetGroupInfo(c.Request.Context(), userId, int(req.GroupID))
+		if err != nil {
+			c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+			return
+		}
+		name = group.Name
+	}
+
+	now := time.Now()
+	id, err := dao.AddAssetCarExport(c.Request.Context(), &model.AssetCarExport{
+		UserID:    userId,
+		GroupID:   req.GroupID,
+		Name:      name,
+		State:     dao.CarExportStateQueued,
+		CreatedAt: now,
+		ExpireAt:  now.Add(carExportRetention),
+	})
+	if err != nil {
+		log.Errorf("AddAssetCarExport error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	if err = opasynq.DefaultCli.EnqueueCarExport(c.Request.Context(), opasynq.CarExportPayload{ID: id}); err != nil {
+		log.Errorf("EnqueueCarExport error: %v", err)
+		dao.UpdateAssetCarExportState(c.Request.Context(), id, dao.CarExportStateFailed, "enqueue failed")
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"id": id,
+	}))
+}
+
+// ListCarExportsHandler 获取用户的 CAR 导出任务, 完成的任务返回下载链接
+func ListCarExportsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	total, list, err := dao.ListUserAssetCarExports(c.Request.Context(), userId, dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListUserAssetCarExports error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	type carExport struct {
+		*model.AssetCarExport
+		DownloadURL string `json:"download_url,omitempty"`
+	}
+	out := make([]*carExport, 0, len(list))
+	for _, e := range list {
+		item := &carExport{AssetCarExport: e}
+		if e.State == dao.CarExportStateDone {
+			item.DownloadURL = carExportDownloadURL(e.ID)
+		}
+		out = append(out, item)
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  out,
+		"total": total,
+	}))
+}
+
+// DownloadCarExportHandler 通过签名链接下载导出的 CAR 文件
+func DownloadCarExportHandler(c *gin.Context) {
+	id, _ := strconv.ParseInt(c.Query("id"), 10, 64)
+	expires, _ := strconv.ParseInt(c.Query("expires"), 10, 64)
+	sign := c.Query("sign")
+
+	if id <= 0 || expires < time.Now().Unix() || !hmac.Equal([]byte(sign), []byte(signCarExport(id, expires))) {
+		c.JSON(http.StatusForbidden, respErrorCode(errors.PermissionNotAllowed, c))
+		return
+	}
+
+	e, err := dao.GetAssetCarExport(c.Request.Context(), id)
+	if err == sql.ErrNoRows || (err == nil && e.State != dao.CarExportStateDone) {
+		c.JSON(http.StatusNotFound, respErrorCode(errors.NotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetAssetCarExport error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	if oss.OssInstance == nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	u, err := oss.OssInstance.SignUrl(config.Cfg.Oss.Bucket, e.ObjectKey, int64(carExportLinkTTL.Seconds()))
+	if err != nil || u == "" {
+		log.Errorf("SignUrl %s error: %v", e.ObjectKey, err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.Redirect(http.StatusFound, u)
+}
+
+// RunCarExport 处理 CAR 导出任务: 按文件组结构生成 unixfs 目录和清单, 再从节点拉取每个文件的块写入同一个 CAR
+func RunCarExport(ctx context.Context, id int64) error {
+	e, err := dao.GetAssetCarExport(ctx, id)
+	if err != nil {
+		return err
+	}
+	if e.State != dao.CarExportStateQueued {
+		return nil
+	}
+
+	if err = dao.UpdateAssetCarExportState(ctx, id, dao.CarExportStateRunning, ""); err != nil {
+		return err
+	}
+
+	if oss.OssInstance == nil {
+		return dao.UpdateAssetCarExportState(ctx, id, dao.CarExportStateFailed, "oss is not configured")
+	}
+	if err = os.MkdirAll(carExportDir(), 0o755); err != nil {
+		return dao.UpdateAssetCarExportState(ctx, id, dao.CarExportStateFailed, fmt.Sprintf("create dir: %v", err))
+	}
+
+	// 本地只作为临时文件, 上传到 oss 后删除, 下载和清理不依赖生成文件的实例
+	file := filepath.Join(carExportDir(), fmt.Sprintf("%d.car", id))
+	defer os.Remove(file)
+	if err = writeCarExport(ctx, e, file); err != nil {
+		return dao.UpdateAssetCarExportState(ctx, id, dao.CarExportStateFailed, err.Error())
+	}
+
+	e.ObjectKey = carExportObjectKey(e)
+	if err = uploadCarExport(file, e.ObjectKey); err != nil {
+		return dao.UpdateAssetCarExportState(ctx, id, dao.CarExportStateFailed, fmt.Sprintf("upload: %v", err))
+	}
+
+	e.State = dao.CarExportStateDone
+	return dao.FinishAssetCarExport(ctx, e)
+}
+
+// carExportObjectKey oss 对象名带上签名, 不能通过 id 猜到其他用户的导出文件
+func carExportObjectKey(e *model.AssetCarExport) string {
+	name := strings.ReplaceAll(e.Name, "/", "_")
+	return fmt.Sprintf("car_export/%d-%s/%s.car", e.ID, signCarExport(e.ID, 0)[:16], name)
+}
+
+func uploadCarExport(file, key string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return oss.OssInstance.Upload(config.Cfg.Oss.Bucket, key, f)
+}
+
+func writeCarExport(ctx context.Context, e *model.AssetCarExport, file string) error {
+	groups, assets, err := dao.GetAssetGroupTreeAssets(ctx, e.UserID, e.GroupID)
+	if err != nil {
+		return fmt.Errorf("get assets: %w", err)
+	}
+
+	children := make(map[int64][]*dao.AssetGroup)
+	for _, g := range groups[1:] {
+		children[g.Parent] = append(children[g.Parent], g)
+	}
+	groupAssets := make(map[int64][]*model.UserAsset)
+	for _, a := range assets {
+		groupAssets[a.GroupID] = append(groupAssets[a.GroupID], a)
+	}
+
+	manifest := &carExportManifest{Name: e.Name, GroupID: e.GroupID, CreatedAt: time.Now(), Assets: make([]*carExportManifestItem, 0)}
+	// 目录节点和清单写在 CAR 的开头
+	var nodes []format.Node
+
+	var buildDir func(g *dao.AssetGroup, path string) (cid.Cid, uint64, error)
+	buildDir = func(g *dao.AssetGroup, path string) (cid.Cid, uint64, error) {
+		var (
+			links []carutil.Link
+			size  uint64
+			names = make(map[string]int)
+		)
+		uniqueName := func(name string) string {
+			names[name]++
+			if n := names[name]; n > 1 {
+				return fmt.Sprintf("%s_%d", name, n)
+			}
+			return name
+		}
+
+		for _, child := range children[g.ID] {
+			name := uniqueName(child.Name)
+			c, s, err := buildDir(child, path+"/"+name)
+			if err != nil {
+				return cid.Undef, 0, err
+			}
+			links = append(links, carutil.Link{Name: name, Cid: c, Size: s})
+			size += s
+		}
+
+		for _, a := range groupAssets[g.ID] {
+			c, err := cid.Decode(a.Cid)
+			if err != nil {
+				return cid.Undef, 0, fmt.Errorf("decode cid %s: %w", a.Cid, err)
+			}
+			name := uniqueName(a.AssetName)
+			links = append(links, carutil.Link{Name: name, Cid: c, Size: uint64(a.TotalSize)})
+			size += uint64(a.TotalSize)
+			manifest.Assets = append(manifest.Assets, &carExportManifestItem{
+				Path: path + "/" + name, CID: a.Cid, AssetType: a.AssetType, Size: a.TotalSize, CreatedTime: a.CreatedTime,
+			})
+		}
+
+		// 清单放在导出的根目录中, 此时所有文件都已经遍历
+		if g.ID == e.GroupID {
+			data, err := json.MarshalIndent(manifest, "", "  ")
+			if err != nil {
+				return cid.Undef, 0, err
+			}
+			mf := carutil.NewRawNode(data)
+			nodes = append(nodes, mf)
+			links = append(links, carutil.Link{Name: uniqueName("manifest.json"), Cid: mf.Cid(), Size: uint64(len(data))})
+			size += uint64(len(data))
+		}
+
+		nd, err := carutil.NewDirNode(links)
+		if err != nil {
+			return cid.Undef, 0, err
+		}
+		nodes = append(nodes, nd)
+		return nd.Cid(), size + uint64(len(nd.RawData())), nil
+	}
+
+	root, _, err := buildDir(groups[0], "")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	defer f.Close()
+
+	w, err := carutil.NewWriter(f, root)
+	if err != nil {
+		return err
+	}
+	for _, nd := range nodes {
+		if err = w.PutNode(nd); err != nil {
+			return err
+		}
+	}
+	for _, a := range assets {
+		if err = fetchAssetCar(ctx, w, e.UserID, a); err != nil {
+			return fmt.Errorf("fetch %s: %w", a.Cid, err)
+		}
+	}
+
+	e.RootCid = root.String()
+	e.AssetCount = int64(len(assets))
+	e.Size = w.Size()
+	return f.Close()
+}
+
+// fetchAssetCar 从存储文件的节点拉取文件的 CAR 并写入, 依次尝试调度器返回的地址
+func fetchAssetCar(ctx context.Context, w *carutil.Writer, userId string, a *model.UserAsset) error {
+	areaIds, err := dao.GetUserAssetAreaIDs(ctx, a.Hash, userId)
+	if err != nil {
+		return err
+	}
+	if len(areaIds) == 0 {
+		return fmt.Errorf("asset is not synced")
+	}
+
+	schedulerClient, err := getSchedulerClient(ctx, areaIds[0])
+	if err != nil {
+		return err
+	}
+
+	ret, err := schedulerClient.ShareAssetV2(ctx, &types.ShareAssetReq{
+		UserID:   userId,
+		AssetCID: a.Cid,
+		FilePass: a.Password,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = fmt.Errorf("no download url")
+	for _, u := range ret.URLs {
+		if err = copyAssetCar(ctx, w, u+"&format=car", a.Cid); err == nil {
+			return nil
+		}
+		log.Errorf("copy asset car from %s: %v", u, err)
+	}
+	return err
+}
+
+// copyAssetCar 先把节点返回的 CAR 下载到临时文件, 确认根和块都完整后再写入导出的 CAR, 下载中断时不会留下部分块
+func copyAssetCar(ctx context.Context, w *carutil.Writer, url, assetCid string) error {
+	c, err := cid.Decode(assetCid)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.car")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	tmp, err := os.CreateTemp(carExportDir(), "asset-*.car")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err = io.Copy(tmp, resp.Body); err != nil {
+		return err
+	}
+
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	infos, err := carutil.ReadRoots(tmp, maxCarImportBlocks)
+	if err != nil {
+		return err
+	}
+	if len(infos) == 0 || !bytes.Equal(infos[0].Cid.Hash(), c.Hash()) {
+		return fmt.Errorf("unexpected car roots")
+	}
+	if !infos[0].Complete {
+		return fmt.Errorf("incomplete car")
+	}
+
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = w.CopyFrom(tmp)
+	return err
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+// addAssetDedupHit 上传的文件在区域内已存在时记录一次秒传, 失败不影响上传
+func addAssetDedupHit(ctx context.Context, userId, hash, areaId string, size int64) {
+	if err := dao.AddAssetDedupHit(ctx, &model.AssetDedupHit{
+		UserID:    userId,
+		Hash:      hash,
+		AreaID:    areaId,
+		TotalSize: size,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		log.Errorf("AddAssetDedupHit: %v", err)
+	}
+}
+
+// GetAssetDedupReportHandler 去重统计: 各区域的逻辑/实际存储大小, 共享最多的文件和用户秒传排行
+// @Summary 去重统计
+// @Tags admin
+// @Param start_time query string false "秒传统计开始日期 2006-01-02"
+// @Param end_time query string false "秒传统计结束日期 2006-01-02"
+// @Success 200 {object} JsonObject "{areas:[],summary:{},top_hashes:[],user_hits:[],total:0}"
+// @Router /api/v1/admin/storage/dedup [get]
+func GetAssetDedupReportHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	var start, end time.Time
+	if st, err := time.ParseInLocation("2006-01-02", c.Query("start_time"), time.Local); err == nil {
+		start = st
+	}
+	if et, err := time.ParseInLocation("2006-01-02", c.Query("end_time"), time.Local); err == nil {
+		end = et.Add(24*time.Hour - time.Second)
+	}
+
+	areas, err := dao.GetAssetDedupAreas(c.Request.Context())
+	if err != nil {
+		log.Errorf("GetAssetDedupAreas: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	var logical, physical int64
+	for _, a := range areas {
+		logical += a.LogicalSize
+		physical += a.PhysicalSize
+	}
+
+	top, err := dao.GetAssetDedupTopHashes(c.Request.Context(), 20)
+	if err != nil {
+		log.Errorf("GetAssetDedupTopHashes: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	hitTotal, err := dao.GetAssetDedupHitTotal(c.Request.Context(), start, end)
+	if err != nil {
+		log.Errorf("GetAssetDedupHitTotal: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	total, userHits, err := dao.ListAssetDedupUserHits(c.Request.Context(), start, end, dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListAssetDedupUserHits: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"areas": areas,
+		"summary": JsonObject{
+			"logical_size":  logical,
+			"physical_size": physical,
+			"saved_size":    logical - physical,
+			"hits":          hitTotal.Hits,
+			"hit_size":      hitTotal.SavedSize,
+		},
+		"top_hashes": top,
+		"user_hits":  userHits,
+		"total":      total,
+	}))
+}
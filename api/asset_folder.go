@@ -0,0 +1,338 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Filecoin-Titan/titan/api"
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/config"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/gnasnik/titan-explorer/core/opasynq"
+	"github.com/gnasnik/titan-explorer/core/storage"
+)
+
+const defaultTrashRetentionDays = 30
+
+// BulkAssetReq 批量操作文件和文件组的请求, 指定 source_group_id 时只操作文件在该文件组中的副本
+type BulkAssetReq struct {
+	AssetCIDs     []string `json:"asset_cids"`
+	GroupIDs      []int64  `json:"group_ids"`
+	TargetGroupID int      `json:"target_group_id"`
+	SourceGroupID *int64   `json:"source_group_id"`
+}
+
+// BulkFailedItem 批量操作中失败的数据
+type BulkFailedItem struct {
+	AssetCID string `json:"asset_cid,omitempty"`
+	GroupID  int64  `json:"group_id,omitempty"`
+	Code     int    `json:"code"`
+}
+
+// CopyGroupReq 复制文件组请求
+type CopyGroupReq struct {
+	GroupID       int `json:"group_id" binding:"required"`
+	TargetGroupID int `json:"target_group_id"`
+}
+
+// TrashReq 回收站操作请求, 清空时 ids 为空且 all 为 true
+type TrashReq struct {
+	IDs []int64 `json:"ids"`
+	All bool    `json:"all"`
+}
+
+func trashExpireAt() time.Time {
+	days := config.Cfg.TrashRetentionDays
+	if days <= 0 {
+		days = defaultTrashRetentionDays
+	}
+	return time.Now().AddDate(0, 0, days)
+}
+
+// assetSourceGroup 要移动的文件所在的文件组, 没有指定时使用文件的任意一个副本
+func assetSourceGroup(ctx context.Context, userID, hash string, source *int64) (int64, error) {
+	if source != nil {
+		return *source, nil
+	}
+	ua, err := dao.GetUserAsset(ctx, hash, userID)
+	if err != nil {
+		return 0, err
+	}
+	return ua.GroupID, nil
+}
+
+func webErrCode(err error) int {
+	if webErr, ok := err.(*api.ErrWeb); ok {
+		return webErr.Code
+	}
+	return errors.InternalServer
+}
+
+// BulkMoveHandler 批量移动文件和文件组到目标文件组
+func BulkMoveHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req BulkAssetReq
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.AssetCIDs)+len(req.GroupIDs) == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	var failed []*BulkFailedItem
+	for _, gid := range req.GroupIDs {
+		if err := dao.MoveAssetGroup(c.Request.Context(), userId, int(gid), req.TargetGroupID); err != nil {
+			log.Errorf("MoveAssetGroup: %v", err)
+			failed = append(failed, &BulkFailedItem{GroupID: gid, Code: webErrCode(err)})
+		}
+	}
+
+	for _, cid := range req.AssetCIDs {
+		hash, err := storage.CIDToHash(cid)
+		if err != nil {
+			failed = append(failed, &BulkFailedItem{AssetCID: cid, Code: errors.InvalidParams})
+			continue
+		}
+		source, err := assetSourceGroup(c.Request.Context(), userId, hash, req.SourceGroupID)
+		if err == sql.ErrNoRows {
+			failed = append(failed, &BulkFailedItem{AssetCID: cid, Code: errors.NotFound})
+			continue
+		}
+		if err == nil {
+			err = dao.UpdateAssetGroup(c.Request.Context(), userId, hash, source, req.TargetGroupID)
+		}
+		if err != nil {
+			log.Errorf("UpdateAssetGroup: %v", err)
+			failed = append(failed, &BulkFailedItem{AssetCID: cid, Code: errors.InternalServer})
+		}
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"msg":    "success",
+		"failed": failed,
+	}))
+}
+
+// BulkDeleteHandler 批量删除文件和文件组, 删除的数据先放入回收站
+func BulkDeleteHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req BulkAssetReq
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.AssetCIDs)+len(req.GroupIDs) == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	hashes := make([]string, 0, len(req.AssetCIDs))
+	for _, cid := range req.AssetCIDs {
+		hash, err := storage.CIDToHash(cid)
+		if err != nil {
+			c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+			return
+		}
+		hashes = append(hashes, hash)
+	}
+
+	expireAt := trashExpireAt()
+	var err error
+	if req.SourceGroupID != nil {
+		err = dao.MoveAssetCopiesToTrash(c.Request.Context(), userId, hashes, *req.SourceGroupID, expireAt)
+	} else {
+		err = dao.MoveAssetsToTrash(c.Request.Context(), userId, hashes, expireAt)
+	}
+	if err != nil {
+		log.Errorf("MoveAssetsToTrash: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	if err := dao.MoveGroupsToTrash(c.Request.Context(), userId, req.GroupIDs, expireAt); err != nil {
+		log.Errorf("MoveGroupsToTrash: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"msg": "success",
+	}))
+}
+
+// CopyGroupHandler 递归复制文件组到目标文件组
+func CopyGroupHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req CopyGroupReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	gid, err := dao.CopyAssetGroup(c.Request.Context(), userId, req.GroupID, req.TargetGroupID)
+	if err != nil {
+		log.Errorf("CopyAssetGroup: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(webErrCode(err), c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"group_id": gid,
+	}))
+}
+
+// GetTrashListHandler 回收站列表
+func GetTrashListHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	total, list, err := dao.ListAssetTrash(c.Request.Context(), userId, dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListAssetTrash: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  list,
+		"total": total,
+	}))
+}
+
+func getTrashItems(c *gin.Context, userId string, req *TrashReq) ([]*model.AssetTrash, bool) {
+	if len(req.IDs) == 0 && !req.All {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return nil, false
+	}
+
+	items, err := dao.GetAssetTrashItems(c.Request.Context(), userId, req.IDs)
+	if err != nil {
+		log.Errorf("GetAssetTrashItems: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return nil, false
+	}
+	return items, true
+}
+
+// RestoreTrashHandler 从回收站恢复文件和文件组
+func RestoreTrashHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req TrashReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	items, ok := getTrashItems(c, userId, &req)
+	if !ok {
+		return
+	}
+
+	for _, item := range items {
+		if err := dao.RestoreAssetTrash(c.Request.Context(), item); err != nil {
+			log.Errorf("RestoreAssetTrash: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"msg": "success",
+	}))
+}
+
+// PurgeTrashHandler 彻底删除回收站中的数据
+func PurgeTrashHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req TrashReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	items, ok := getTrashItems(c, userId, &req)
+	if !ok {
+		return
+	}
+
+	if err := PurgeAssetTrash(c.Request.Context(), items); err != nil {
+		log.Errorf("PurgeAssetTrash: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"msg": "success",
+	}))
+}
+
+// PurgeAssetTrash 彻底删除回收站中的数据, 文件从调度器删除, 文件组交给 asynq 递归删除
+func PurgeAssetTrash(ctx context.Context, items []*model.AssetTrash) error {
+	for _, item := range items {
+		switch item.ItemType {
+		case dao.AssetTrashTypeAsset:
+			released, err := dao.ReleaseAssetTrashCopy(ctx, item)
+			if err != nil {
+				return err
+			}
+			if released {
+				break
+			}
+			if err = purgeUserAsset(ctx, item.UserID, item.Hash, item.Cid); err != nil {
+				return err
+			}
+		case dao.AssetTrashTypeGroup:
+			if err := dao.DeleteAssetGroupAndUpdateSize(ctx, item.UserID, int(item.GroupID)); err != nil {
+				return err
+			}
+			opasynq.DefaultCli.EnqueueAssetGroupID(ctx, opasynq.AssetGroupPayload{UserID: item.UserID, GroupID: []int64{item.GroupID}})
+		}
+
+		if err := dao.DeleteAssetTrash(ctx, item.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeUserAsset 删除用户文件并更新已使用空间, 没有其他用户存储该文件时从调度器删除, 同时清理版本记录
+func purgeUserAsset(ctx context.Context, userID, hash, cid string) error {
+	areaIds, _, err := dao.CheckUserAseetNeedDel(ctx, hash, userID, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, areaId := range areaIds {
+		isOnly, err := dao.CheckUserAssetIsOnly(ctx, hash, areaId)
+		if err != nil || !isOnly {
+			continue
+		}
+		opasynq.DefaultCli.EnqueueDeleteAssetOperation(ctx, opasynq.DeleteAssetPayload{
+			CID: cid, AreaID: areaId,
+		})
+	}
+
+	if err = dao.DelAssetAndUpdateSize(ctx, hash, userID, areaIds, true); err != nil {
+		return err
+	}
+	if err = dao.DeleteUserAssetKeys(ctx, userID, hash); err != nil {
+		return err
+	}
+	return purgeAssetVersions(ctx, userID, hash)
+}
@@ -0,0 +1,497 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/gnasnik/titan-explorer/core/storage"
+)
+
+// 客户端加密文件的信封加密方案, 所有加解密都在客户端完成, 服务端只保存加密后的密钥:
+//
+//  1. 主密钥: 每个用户一对 X25519 密钥. 私钥用口令 (passphrase, 参数如 salt 保存在 kdf_params) 或
+//     钱包对固定消息的签名 (wallet) 派生的密钥加密后上传, 公钥明文保存, 用于别人给该用户分享文件
+//  2. 数据密钥: 每个文件一个随机的对称密钥, 用于加密文件内容. 上传时用自己的公钥加密 (sealed box) 后随
+//     create_asset 一起提交, 下载时取回加密的数据密钥, 用私钥解开后解密文件
+//  3. 分享: 解开数据密钥后用接收者的公钥重新加密并提交, 接收者通过普通分享链接获取密文
+//  4. 轮换: 生成新的密钥对, 把自己持有的所有数据密钥用新公钥重新加密后一次提交, 主密钥版本加 1.
+//     只修改口令时公钥不变, 只需要提交重新加密的私钥. 用钱包加密的主密钥需要先验证钱包签名
+const (
+	encryptionPublicKeySize = 32
+	maxKeyBlobSize          = 384
+)
+
+type (
+	// EncryptionKeyReq 创建或轮换主密钥
+	EncryptionKeyReq struct {
+		// Version 轮换时为当前的主密钥版本
+		Version           int64  `json:"version"`
+		WrapMethod        string `json:"wrap_method" binding:"required"`
+		KdfParams         string `json:"kdf_params"`
+		PublicKey         string `json:"public_key" binding:"required"`
+		WrappedPrivateKey string `json:"wrapped_private_key" binding:"required"`
+		// Keys 轮换时用新公钥重新加密的所有数据密钥
+		Keys []*AssetKeyItem `json:"keys"`
+	}
+
+	AssetKeyItem struct {
+		CID        string `json:"cid" binding:"required"`
+		WrappedKey string `json:"wrapped_key" binding:"required"`
+	}
+
+	// ShareAssetKeyReq 把数据密钥分享给接收者
+	ShareAssetKeyReq struct {
+		CID        string `json:"cid" binding:"required"`
+		Username   string `json:"username" binding:"required"`
+		KeyVersion int64  `json:"key_version" binding:"required"`
+		WrappedKey string `json:"wrapped_key" binding:"required"`
+	}
+
+	// RevokeAssetKeyShareReq 撤销分享给接收者的数据密钥
+	RevokeAssetKeyShareReq struct {
+		CID      string `json:"cid" binding:"required"`
+		Username string `json:"username" binding:"required"`
+	}
+)
+
+// validKeyBlob 判断加密后的密钥是否为合法的 base64
+func validKeyBlob(s string) bool {
+	b, err := base64.StdEncoding.DecodeString(s)
+	return err == nil && len(b) > 0 && len(b) <= maxKeyBlobSize
+}
+
+// checkWrappedAssetKey 校验上传时提交的数据密钥, 返回错误码
+func checkWrappedAssetKey(ctx context.Context, userID string, req *createAssetRequest) int {
+	if !req.Encrypted || !validKeyBlob(req.WrappedKey) {
+		return errors.InvalidParams
+	}
+	key, err := dao.GetUserEncryptionKey(ctx, userID)
+	if err == sql.ErrNoRows {
+		return errors.EncryptionKeyNotFound
+	}
+	if err != nil {
+		log.Errorf("GetUserEncryptionKey error: %v", err)
+		return errors.InternalServer
+	}
+	if key.Version != req.KeyVersion {
+		return errors.EncryptionKeyVersionMismatch
+	}
+	return 0
+}
+
+// saveWrappedAssetKey 保存上传时提交的数据密钥
+func saveWrappedAssetKey(ctx context.Context, userID, hash string, req *createAssetRequest) error {
+	return dao.SaveUserAssetKey(ctx, &model.UserAssetKey{
+		UserID:     userID,
+		Hash:       hash,
+		Cid:        req.AssetCID,
+		KeyVersion: req.KeyVersion,
+		WrappedKey: req.WrappedKey,
+		CreatedAt:  time.Now(),
+	})
+}
+
+func validEncryptionKeyReq(req *EncryptionKeyReq) bool {
+	if req.WrapMethod != dao.KeyWrapMethodPassphrase && req.WrapMethod != dao.KeyWrapMethodWallet {
+		return false
+	}
+	if len(req.KdfParams) > 512 || !validKeyBlob(req.WrappedPrivateKey) {
+		return false
+	}
+	pub, err := base64.StdEncoding.DecodeString(req.PublicKey)
+	return err == nil && len(pub) == encryptionPublicKeySize
+}
+
+// GetEncryptionKeyHandler 获取自己的主密钥 (私钥为加密后的)
+// @Summary 获取主密钥
+// @Security ApiKeyAuth
+// @Tags storage
+// @Success 200 {object} JsonObject "{key:model.UserEncryptionKey}"
+// @Router /api/v1/storage/encryption/key [get]
+func GetEncryptionKeyHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	key, err := dao.GetUserEncryptionKey(c.Request.Context(), userId)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.EncryptionKeyNotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetUserEncryptionKey error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"key": key,
+	}))
+}
+
+// CreateEncryptionKeyHandler 创建主密钥, 每个用户只能创建一次, 之后只能轮换
+// @Summary 创建主密钥
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param req body EncryptionKeyReq true "wrap_method: passphrase, wallet"
+// @Success 200 {object} JsonObject "{version:1}"
+// @Router /api/v1/storage/encryption/key [post]
+func CreateEncryptionKeyHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req EncryptionKeyReq
+	if err := c.ShouldBindJSON(&req); err != nil || !validEncryptionKeyReq(&req) {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	_, err := dao.GetUserEncryptionKey(c.Request.Context(), userId)
+	if err == nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.EncryptionKeyExists, c))
+		return
+	}
+	if err != sql.ErrNoRows {
+		log.Errorf("GetUserEncryptionKey error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	now := time.Now()
+	if err = dao.AddUserEncryptionKey(c.Request.Context(), &model.UserEncryptionKey{
+		UserID:            userId,
+		Version:           1,
+		WrapMethod:        req.WrapMethod,
+		KdfParams:         req.KdfParams,
+		PublicKey:         req.PublicKey,
+		WrappedPrivateKey: req.WrappedPrivateKey,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}); err != nil {
+		log.Errorf("AddUserEncryptionKey error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.EncryptionKeyExists, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"version": 1,
+	}))
+}
+
+// RotateEncryptionKeyHandler 轮换主密钥或修改私钥的加密方式
+// @Summary 轮换主密钥
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param req body EncryptionKeyReq true "公钥变化时 keys 需要包含所有数据密钥"
+// @Success 200 {object} JsonObject "{version:0}"
+// @Router /api/v1/storage/encryption/key/rotate [post]
+func RotateEncryptionKeyHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req EncryptionKeyReq
+	if err := c.ShouldBindJSON(&req); err != nil || !validEncryptionKeyReq(&req) {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	current, err := dao.GetUserEncryptionKey(c.Request.Context(), userId)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.EncryptionKeyNotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetUserEncryptionKey error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	// 用钱包加密的主密钥, 需要先用钱包签名证明持有, 验证结果只能使用一次
+	verifiedKey := fmt.Sprintf(FilePassVerifiedKey, userId)
+	if current.WrapMethod == dao.KeyWrapMethodWallet && dao.RedisCache.Get(c.Request.Context(), verifiedKey).Val() == "" {
+		c.JSON(http.StatusOK, respErrorCode(errors.EncryptionKeyVerifyRequired, c))
+		return
+	}
+
+	keys := make(map[string]string, len(req.Keys))
+	for _, k := range req.Keys {
+		hash, err := storage.CIDToHash(k.CID)
+		if err != nil || !validKeyBlob(k.WrappedKey) {
+			c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+			return
+		}
+		keys[hash] = k.WrappedKey
+	}
+
+	key := &model.UserEncryptionKey{
+		UserID:            userId,
+		WrapMethod:        req.WrapMethod,
+		KdfParams:         req.KdfParams,
+		PublicKey:         req.PublicKey,
+		WrappedPrivateKey: req.WrappedPrivateKey,
+		UpdatedAt:         time.Now(),
+	}
+	err = dao.RotateUserEncryptionKey(c.Request.Context(), key, req.Version, keys)
+	switch err {
+	case nil:
+	case dao.ErrKeyVersionMismatch:
+		c.JSON(http.StatusOK, respErrorCode(errors.EncryptionKeyVersionMismatch, c))
+		return
+	case dao.ErrAssetKeysIncomplete:
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	default:
+		log.Errorf("RotateUserEncryptionKey error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	dao.RedisCache.Del(c.Request.Context(), verifiedKey)
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"version": key.Version,
+	}))
+}
+
+// GetEncryptionPublicKeyHandler 获取用户的公钥, 用于分享加密文件
+// @Summary 获取用户公钥
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param username query string true "接收者"
+// @Success 200 {object} JsonObject "{username:"",public_key:"",version:0}"
+// @Router /api/v1/storage/encryption/public_key [get]
+func GetEncryptionPublicKeyHandler(c *gin.Context) {
+	username := c.Query("username")
+	if username == "" {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	key, err := dao.GetUserEncryptionKey(c.Request.Context(), username)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.EncryptionKeyNotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetUserEncryptionKey error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"username":   username,
+		"public_key": key.PublicKey,
+		"version":    key.Version,
+	}))
+}
+
+// GetAssetKeyHandler 获取文件加密后的数据密钥
+// @Summary 获取文件数据密钥
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param cid query string true "文件cid"
+// @Success 200 {object} JsonObject "{key:model.UserAssetKey}"
+// @Router /api/v1/storage/encryption/asset_key [get]
+func GetAssetKeyHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	hash, err := storage.CIDToHash(c.Query("cid"))
+	if err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	key, err := dao.GetUserAssetKey(c.Request.Context(), userId, hash)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.EncryptionKeyNotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetUserAssetKey error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"key": key,
+	}))
+}
+
+// ListAssetKeysHandler 获取持有的数据密钥, 轮换主密钥时用于重新加密
+// @Summary 获取数据密钥列表
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param shared query bool false "只返回别人分享的"
+// @Param page query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} JsonObject "{list:[]model.UserAssetKey,total:0}"
+// @Router /api/v1/storage/encryption/asset_keys [get]
+func ListAssetKeysHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	total, list, err := dao.ListUserAssetKeys(c.Request.Context(), userId, c.Query("shared") == "true", dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListUserAssetKeys error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  list,
+		"total": total,
+	}))
+}
+
+// ShareAssetKeyHandler 把用接收者公钥重新加密的数据密钥分享给接收者
+// @Summary 分享加密文件
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param req body ShareAssetKeyReq true "key_version 为接收者主密钥的版本"
+// @Success 200 {object} JsonObject "{msg:"success"}"
+// @Router /api/v1/storage/encryption/share [post]
+func ShareAssetKeyHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req ShareAssetKeyReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.Username == userId || !validKeyBlob(req.WrappedKey) {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	hash, err := storage.CIDToHash(req.CID)
+	if err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	// 只能分享自己上传的加密文件
+	own, err := dao.GetUserAssetKey(c.Request.Context(), userId, hash)
+	if err == sql.ErrNoRows || (err == nil && own.SharedBy != "") {
+		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetUserAssetKey error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	recipient, err := dao.GetUserEncryptionKey(c.Request.Context(), req.Username)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.EncryptionKeyNotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetUserEncryptionKey error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	// 接收者在此期间轮换了主密钥, 需要重新获取公钥加密
+	if recipient.Version != req.KeyVersion {
+		c.JSON(http.StatusOK, respErrorCode(errors.EncryptionKeyVersionMismatch, c))
+		return
+	}
+
+	if err = dao.ShareUserAssetKey(c.Request.Context(), &model.UserAssetKey{
+		UserID:     req.Username,
+		Hash:       hash,
+		Cid:        own.Cid,
+		KeyVersion: req.KeyVersion,
+		WrappedKey: req.WrappedKey,
+		SharedBy:   userId,
+		CreatedAt:  time.Now(),
+	}); err != nil {
+		log.Errorf("ShareUserAssetKey error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"msg": "success",
+	}))
+}
+
+// ListAssetKeySharesHandler 获取加密文件的接收者
+// @Summary 获取加密文件的接收者
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param cid query string true "文件cid"
+// @Success 200 {object} JsonObject "{list:[]string}"
+// @Router /api/v1/storage/encryption/share [get]
+func ListAssetKeySharesHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	hash, err := storage.CIDToHash(c.Query("cid"))
+	if err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	list, err := dao.ListAssetKeyRecipients(c.Request.Context(), userId, hash)
+	if err != nil {
+		log.Errorf("ListAssetKeyRecipients error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list": list,
+	}))
+}
+
+// RevokeAssetKeyShareHandler 撤销分享给接收者的数据密钥
+// @Summary 撤销加密文件的分享
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param req body RevokeAssetKeyShareReq true "请求参数"
+// @Success 200 {object} JsonObject "{msg:"success"}"
+// @Router /api/v1/storage/encryption/share/revoke [post]
+func RevokeAssetKeyShareHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req RevokeAssetKeyShareReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	hash, err := storage.CIDToHash(req.CID)
+	if err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	n, err := dao.DeleteSharedAssetKey(c.Request.Context(), userId, req.Username, hash)
+	if err != nil {
+		log.Errorf("DeleteSharedAssetKey error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if n == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"msg": "success",
+	}))
+}
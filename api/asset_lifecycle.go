@@ -0,0 +1,346 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+// maxLifecycleDays 生命周期规则的最大天数
+const maxLifecycleDays = 3650
+
+// LifecycleRuleReq 添加或修改文件夹的生命周期规则
+type LifecycleRuleReq struct {
+	GroupID   int64  `json:"group_id"`
+	Action    string `json:"action" binding:"required"`
+	Days      int64  `json:"days" binding:"required"`
+	KeepAreas int64  `json:"keep_areas"`
+	Enabled   bool   `json:"enabled"`
+}
+
+// SaveLifecycleRuleHandler 添加或修改文件夹的生命周期规则, 规则同样作用于子文件夹
+// @Summary 保存生命周期规则
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param req body LifecycleRuleReq true "action: delete, unshare, archive, reduce_replica"
+// @Success 200 {object} JsonObject "{msg:"success"}"
+// @Router /api/v1/storage/lifecycle/rule [post]
+func SaveLifecycleRuleHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req LifecycleRuleReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.GroupID < 0 || req.Days <= 0 || req.Days > maxLifecycleDays {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	switch req.Action {
+	case dao.LifecycleActionDelete, dao.LifecycleActionUnshare, dao.LifecycleActionArchive:
+		req.KeepAreas = 0
+	case dao.LifecycleActionReduceReplica:
+		if req.KeepAreas <= 0 {
+			c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+			return
+		}
+	default:
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	if req.GroupID > 0 {
+		if _, err := dao.GetUserAssetGroupInfo(c.Request.Context(), userId, int(req.GroupID)); err != nil {
+			c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+			return
+		}
+	}
+
+	now := time.Now()
+	if err := dao.SaveAssetLifecycleRule(c.Request.Context(), &model.AssetLifecycleRule{
+		UserID:    userId,
+		GroupID:   req.GroupID,
+		Action:    req.Action,
+		Days:      req.Days,
+		KeepAreas: req.KeepAreas,
+		Enabled:   req.Enabled,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}); err != nil {
+		log.Errorf("SaveAssetLifecycleRule error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"msg": "success",
+	}))
+}
+
+// ListLifecycleRulesHandler 获取生命周期规则
+// @Summary 获取生命周期规则
+// @Security ApiKeyAuth
+// @Tags storage
+// @Success 200 {object} JsonObject "{list:[]model.AssetLifecycleRule}"
+// @Router /api/v1/storage/lifecycle/rules [get]
+func ListLifecycleRulesHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	list, err := dao.ListAssetLifecycleRules(c.Request.Context(), userId)
+	if err != nil {
+		log.Errorf("ListAssetLifecycleRules error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list": list,
+	}))
+}
+
+// DeleteLifecycleRuleHandler 删除生命周期规则
+// @Summary 删除生命周期规则
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param id query int true "规则id"
+// @Success 200 {object} JsonObject "{msg:"success"}"
+// @Router /api/v1/storage/lifecycle/rule/delete [post]
+func DeleteLifecycleRuleHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	id, _ := strconv.ParseInt(c.Query("id"), 10, 64)
+	if id <= 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	n, err := dao.DeleteAssetLifecycleRule(c.Request.Context(), userId, id)
+	if err != nil {
+		log.Errorf("DeleteAssetLifecycleRule error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if n == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"msg": "success",
+	}))
+}
+
+// ListLifecycleLogsHandler 获取生命周期处理日志
+// @Summary 获取生命周期处理日志
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param page query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} JsonObject "{list:[]model.AssetLifecycleLog,total:0}"
+// @Router /api/v1/storage/lifecycle/logs [get]
+func ListLifecycleLogsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	total, list, err := dao.ListAssetLifecycleLogs(c.Request.Context(), userId, dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListAssetLifecycleLogs error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  list,
+		"total": total,
+	}))
+}
+
+// RunAssetLifecycle 处理用户到期的文件和生命周期规则, 每个被处理的文件记录一条日志
+func RunAssetLifecycle(ctx context.Context, userID string) error {
+	now := time.Now()
+	var logs []*model.AssetLifecycleLog
+	addLogs := func(ruleID int64, action, detail string, assets []*model.UserAsset) {
+		for _, a := range assets {
+			logs = append(logs, &model.AssetLifecycleLog{
+				UserID: userID, RuleID: ruleID, Action: action, Hash: a.Hash, Cid: a.Cid, AssetName: a.AssetName, Detail: detail, CreatedAt: now,
+			})
+		}
+	}
+
+	// 到期的文件放入回收站
+	expired, err := dao.GetExpiredUserAssets(ctx, userID, now)
+	if err != nil {
+		return fmt.Errorf("get expired assets: %w", err)
+	}
+	if err = moveAssetCopiesToTrash(ctx, userID, expired); err != nil {
+		return fmt.Errorf("move expired assets to trash: %w", err)
+	}
+	addLogs(0, dao.LifecycleActionExpire, "moved to trash", expired)
+
+	rules, err := dao.ListAssetLifecycleRules(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("list rules: %w", err)
+	}
+
+	// 减少副本时优先保留的区域, 有减少副本的规则时才计算
+	var preferred []string
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+
+		_, assets, err := dao.GetAssetGroupTreeAssets(ctx, userID, rule.GroupID)
+		if err == sql.ErrNoRows {
+			// 文件夹已被删除
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("get assets of group %d: %w", rule.GroupID, err)
+		}
+
+		before := now.AddDate(0, 0, -int(rule.Days))
+		var matched []*model.UserAsset
+		for _, a := range assets {
+			if a.CreatedTime.Before(before) {
+				matched = append(matched, a)
+			}
+		}
+
+		switch rule.Action {
+		case dao.LifecycleActionDelete:
+			if err = moveAssetCopiesToTrash(ctx, userID, matched); err != nil {
+				return fmt.Errorf("move assets to trash: %w", err)
+			}
+			addLogs(rule.ID, rule.Action, "moved to trash", matched)
+
+		case dao.LifecycleActionUnshare:
+			var shared []*model.UserAsset
+			cids := make([]string, 0)
+			for _, a := range matched {
+				if a.ShareStatus == 1 {
+					shared = append(shared, a)
+					cids = append(cids, a.Cid)
+				}
+			}
+			if err = dao.UnshareUserAssets(ctx, userID, assetHashes(shared), cids); err != nil {
+				return fmt.Errorf("unshare assets: %w", err)
+			}
+			addLogs(rule.ID, rule.Action, "share links revoked", shared)
+
+		case dao.LifecycleActionArchive, dao.LifecycleActionReduceReplica:
+			downloaded, err := dao.GetDownloadedAssetHashes(ctx, userID, assetHashes(matched), before)
+			if err != nil {
+				return fmt.Errorf("get downloaded assets: %w", err)
+			}
+			var cold []*model.UserAsset
+			for _, a := range matched {
+				if !downloaded[a.Hash] {
+					cold = append(cold, a)
+				}
+			}
+
+			if rule.Action == dao.LifecycleActionArchive {
+				queued, err := dao.AddAssetBackupRequests(ctx, userID, cold)
+				if err != nil {
+					return fmt.Errorf("add backup requests: %w", err)
+				}
+				addLogs(rule.ID, rule.Action, "queued for filecoin backup", queued)
+				continue
+			}
+
+			if preferred == nil {
+				preferred = preferredAssetAreas(ctx, userID)
+			}
+			for _, a := range cold {
+				removed, err := reduceAssetAreas(ctx, userID, a, int(rule.KeepAreas), preferred)
+				if err != nil {
+					log.Errorf("reduce areas of %s error: %v", a.Cid, err)
+					continue
+				}
+				if len(removed) > 0 {
+					addLogs(rule.ID, rule.Action, fmt.Sprintf("removed from %v", removed), []*model.UserAsset{a})
+				}
+			}
+		}
+	}
+
+	return dao.AddAssetLifecycleLogs(ctx, logs)
+}
+
+// preferredAssetAreas 用户偏好的区域, 依次为副本策略中离下载请求最近的区域和策略允许的区域,
+// 没有副本策略时为离下载请求最近的区域
+func preferredAssetAreas(ctx context.Context, userID string) []string {
+	policy, err := dao.GetEffectiveReplicationPolicy(ctx, userID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Errorf("GetEffectiveReplicationPolicy error: %v", err)
+		}
+		policy = &model.ReplicationPolicy{}
+	}
+
+	candidates := replicationCandidates(policy)
+	out := nearestReplicationAreas(ctx, userID, candidates)
+	if policy.ID > 0 {
+		out = append(out, candidates...)
+	}
+	return uniqueAreas(out)
+}
+
+// reduceAssetAreas 只保留文件在 keep 个区域的副本, 优先保留 preferred 中的区域, 返回删除的区域
+func reduceAssetAreas(ctx context.Context, userID string, a *model.UserAsset, keep int, preferred []string) ([]string, error) {
+	areaIds, err := dao.GetUserAssetAreaIDs(ctx, a.Hash, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(areaIds) <= keep {
+		return nil, nil
+	}
+
+	sort.Strings(areaIds)
+	removed := subtractAreas(areaIds, desiredReplicationAreas(preferred, areaIds, areaIds, keep))
+	if err = removeAssetAreas(ctx, userID, a, removed); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// moveAssetCopiesToTrash 只把 assets 所在文件组中的副本放入回收站, 文件在其他文件组中的副本保留
+func moveAssetCopiesToTrash(ctx context.Context, userID string, assets []*model.UserAsset) error {
+	groups := make(map[int64][]string)
+	for _, a := range assets {
+		groups[a.GroupID] = append(groups[a.GroupID], a.Hash)
+	}
+
+	expireAt := trashExpireAt()
+	for gid, hashes := range groups {
+		if err := dao.MoveAssetCopiesToTrash(ctx, userID, hashes, gid, expireAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func assetHashes(assets []*model.UserAsset) []string {
+	out := make([]string, 0, len(assets))
+	for _, a := range assets {
+		out = append(out, a.Hash)
+	}
+	return out
+}
@@ -0,0 +1,322 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Filecoin-Titan/titan/api/types"
+	"github.com/gnasnik/titan-explorer/config"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/gnasnik/titan-explorer/core/opasynq"
+	"github.com/gnasnik/titan-explorer/pkg/oss"
+	"github.com/gnasnik/titan-explorer/pkg/preview"
+)
+
+const (
+	defaultPreviewMaxImageSize   = 20 << 20
+	defaultPreviewMaxImagePixels = 50000000
+	defaultPreviewThumbnailSize  = 320
+	defaultPreviewURLExpire      = 3600
+)
+
+// AssetPreviewInfo 返回给前端的预览信息, 图片地址为 oss 的签名地址
+type AssetPreviewInfo struct {
+	Kind         string `json:"kind"`
+	State        string `json:"state"`
+	Width        int64  `json:"width"`
+	Height       int64  `json:"height"`
+	Duration     int64  `json:"duration"`
+	ThumbnailURL string `json:"thumbnail_url"`
+	PosterURL    string `json:"poster_url"`
+}
+
+func previewMaxImageSize() int64 {
+	if config.Cfg.Preview.MaxImageSize > 0 {
+		return config.Cfg.Preview.MaxImageSize
+	}
+	return defaultPreviewMaxImageSize
+}
+
+func previewMaxImagePixels() int {
+	if config.Cfg.Preview.MaxImagePixels > 0 {
+		return config.Cfg.Preview.MaxImagePixels
+	}
+	return defaultPreviewMaxImagePixels
+}
+
+func previewThumbnailSize() int {
+	if config.Cfg.Preview.ThumbnailSize > 0 {
+		return config.Cfg.Preview.ThumbnailSize
+	}
+	return defaultPreviewThumbnailSize
+}
+
+func previewURLExpire() int64 {
+	if config.Cfg.Preview.URLExpireSeconds > 0 {
+		return config.Cfg.Preview.URLExpireSeconds
+	}
+	return defaultPreviewURLExpire
+}
+
+// enqueueAssetPreview 上传完成后为图片和视频塞入预览任务, 设置了密码或客户端加密的文件不生成预览
+func enqueueAssetPreview(ctx context.Context, ua *model.UserAsset) {
+	if !config.Cfg.Preview.Enable || ua.Password != "" {
+		return
+	}
+	kind := preview.Kind(ua.AssetName)
+	if kind == "" {
+		return
+	}
+
+	_, err := dao.GetUserAssetKey(ctx, ua.UserID, ua.Hash)
+	if err != sql.ErrNoRows {
+		if err != nil {
+			log.Errorf("GetUserAssetKey: %v", err)
+		}
+		return
+	}
+
+	if err := dao.AddAssetPreview(ctx, &model.AssetPreview{Hash: ua.Hash, Cid: ua.Cid, Kind: kind}); err != nil {
+		log.Errorf("AddAssetPreview: %v", err)
+		return
+	}
+	if err := opasynq.DefaultCli.EnqueueAssetPreview(ctx, opasynq.AssetPreviewPayload{UserID: ua.UserID, Hash: ua.Hash}); err != nil {
+		log.Errorf("EnqueueAssetPreview: %v", err)
+	}
+}
+
+// RunAssetPreview 从节点获取文件生成预览并上传到 oss, 文件还未同步时返回错误等待重试, 其他失败记录到数据库
+func RunAssetPreview(ctx context.Context, userID, hash string) error {
+	p, err := dao.GetAssetPreview(ctx, hash)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if p.State != dao.AssetPreviewStatePending {
+		return nil
+	}
+
+	ua, err := dao.GetUserAsset(ctx, hash, userID)
+	if err != nil {
+		p.State, p.Reason = dao.AssetPreviewStateFailed, fmt.Sprintf("get asset: %v", err)
+		return dao.UpdateAssetPreview(ctx, p)
+	}
+
+	urls, err := assetNodeURLs(ctx, ua)
+	if err != nil {
+		return err
+	}
+
+	switch p.Kind {
+	case preview.KindImage:
+		err = generateImagePreview(ctx, p, ua, urls)
+	case preview.KindVideo:
+		err = generateVideoPreview(ctx, p, ua, urls)
+	default:
+		err = preview.ErrUnsupported
+	}
+
+	switch {
+	case err == preview.ErrUnsupported:
+		p.State, p.Reason = dao.AssetPreviewStateUnsupported, ""
+	case err != nil:
+		p.State, p.Reason = dao.AssetPreviewStateFailed, err.Error()
+	default:
+		p.State, p.Reason = dao.AssetPreviewStateDone, ""
+	}
+	return dao.UpdateAssetPreview(ctx, p)
+}
+
+// assetNodeURLs 获取用户文件在节点上的下载地址
+func assetNodeURLs(ctx context.Context, ua *model.UserAsset) ([]string, error) {
+	areaIds, err := dao.GetUserAssetAreaIDs(ctx, ua.Hash, ua.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if len(areaIds) == 0 {
+		return nil, fmt.Errorf("asset is not synced")
+	}
+
+	schedulerClient, err := getSchedulerClient(ctx, areaIds[0])
+	if err != nil {
+		return nil, err
+	}
+	ret, err := schedulerClient.ShareAssetV2(ctx, &types.ShareAssetReq{
+		UserID:   ua.UserID,
+		AssetCID: ua.Cid,
+		FilePass: ua.Password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ret.URLs) == 0 {
+		return nil, fmt.Errorf("no download url")
+	}
+	return ret.URLs, nil
+}
+
+func generateImagePreview(ctx context.Context, p *model.AssetPreview, ua *model.UserAsset, urls []string) error {
+	if ua.TotalSize > previewMaxImageSize() {
+		return preview.ErrUnsupported
+	}
+
+	var (
+		img *preview.Image
+		err = fmt.Errorf("no download url")
+	)
+	for _, u := range urls {
+		if img, err = fetchThumbnail(ctx, u); err == nil || err == preview.ErrUnsupported {
+			break
+		}
+		log.Errorf("fetch thumbnail from %s: %v", u, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("preview/%s/thumbnail.jpg", p.Hash)
+	if err = uploadPreview(key, img.Data); err != nil {
+		return err
+	}
+	p.ThumbnailKey, p.Width, p.Height = key, int64(img.Width), int64(img.Height)
+	return nil
+}
+
+func fetchThumbnail(ctx context.Context, url string) (*preview.Image, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return preview.Thumbnail(io.LimitReader(resp.Body, previewMaxImageSize()), previewThumbnailSize(), previewMaxImagePixels())
+}
+
+// generateVideoPreview mp4 读取时长和尺寸, 有 ffmpeg 时截取封面, 两者都没有时不支持
+func generateVideoPreview(ctx context.Context, p *model.AssetPreview, ua *model.UserAsset, urls []string) error {
+	if preview.IsMP4(ua.AssetName) {
+		info, err := preview.ReadMP4Info(&rangeReaderAt{ctx: ctx, url: urls[0]}, ua.TotalSize)
+		if err != nil && err != preview.ErrUnsupported {
+			log.Errorf("ReadMP4Info %s: %v", ua.Cid, err)
+		}
+		if info != nil {
+			p.Width, p.Height, p.Duration = int64(info.Width), int64(info.Height), info.Duration.Milliseconds()
+		}
+	}
+
+	pctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	poster, err := preview.Poster(pctx, config.Cfg.Preview.FFmpegPath, urls[0], previewThumbnailSize())
+	if err != nil {
+		// 截取封面失败时, 已经读到视频信息也算生成成功
+		if p.Duration > 0 {
+			if err != preview.ErrUnsupported {
+				log.Errorf("Poster %s: %v", ua.Cid, err)
+			}
+			return nil
+		}
+		return err
+	}
+
+	key := fmt.Sprintf("preview/%s/poster.jpg", p.Hash)
+	if err = uploadPreview(key, poster); err != nil {
+		return err
+	}
+	p.PosterKey = key
+	return nil
+}
+
+func uploadPreview(key string, data []byte) error {
+	if oss.OssInstance == nil {
+		return fmt.Errorf("oss is not configured")
+	}
+	return oss.OssInstance.Upload(config.Cfg.Oss.Bucket, key, bytes.NewReader(data))
+}
+
+// rangeReaderAt 通过 Range 请求按需读取节点上的文件
+type rangeReaderAt struct {
+	ctx context.Context
+	url string
+}
+
+func (r *rangeReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadFull(resp.Body, p)
+}
+
+// newAssetPreviewInfo 生成预览的签名地址, 没有预览时返回 nil
+func newAssetPreviewInfo(p *model.AssetPreview) *AssetPreviewInfo {
+	if p == nil {
+		return nil
+	}
+
+	info := &AssetPreviewInfo{Kind: p.Kind, State: p.State, Width: p.Width, Height: p.Height, Duration: p.Duration}
+	if oss.OssInstance == nil {
+		return info
+	}
+	var err error
+	if p.ThumbnailKey != "" {
+		if info.ThumbnailURL, err = oss.OssInstance.SignUrl(config.Cfg.Oss.Bucket, p.ThumbnailKey, previewURLExpire()); err != nil {
+			log.Errorf("SignUrl %s: %v", p.ThumbnailKey, err)
+		}
+	}
+	if p.PosterKey != "" {
+		if info.PosterURL, err = oss.OssInstance.SignUrl(config.Cfg.Oss.Bucket, p.PosterKey, previewURLExpire()); err != nil {
+			log.Errorf("SignUrl %s: %v", p.PosterKey, err)
+		}
+	}
+	return info
+}
+
+// fillAssetPreviews 给文件列表加上预览
+func fillAssetPreviews(ctx context.Context, list []*AccessOverview) {
+	hashes := make([]string, 0, len(list))
+	for _, ao := range list {
+		if ao.UserAssetDetail != nil {
+			hashes = append(hashes, ao.UserAssetDetail.Hash)
+		}
+	}
+	if len(hashes) == 0 {
+		return
+	}
+
+	previews, err := dao.GetAssetPreviews(ctx, hashes)
+	if err != nil {
+		log.Errorf("GetAssetPreviews: %v", err)
+		return
+	}
+	for _, ao := range list {
+		if ao.UserAssetDetail != nil {
+			ao.Preview = newAssetPreviewInfo(previews[ao.UserAssetDetail.Hash])
+		}
+	}
+}
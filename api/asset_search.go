@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+)
+
+// SearchAssetsHandler 按名称和元数据搜索用户的文件或文件组, kind=group 时搜索文件组
+// @Summary 搜索用户文件
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param keyword query string false "名称关键字, 空格分隔的每个词都需要匹配"
+// @Param kind query string false "asset 或 group, 默认 asset"
+// @Param cursor query string false "上一页返回的 next_cursor"
+// @Success 200 {object} JsonObject "{list:[],next_cursor:""}"
+// @Router /api/v1/storage/search [get]
+func SearchAssetsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	option := &dao.AssetSearchOption{
+		Keyword:   c.Query("keyword"),
+		AssetType: c.Query("type"),
+		StartTime: c.Query("start_time"),
+		EndTime:   c.Query("end_time"),
+		CidPrefix: c.Query("cid_prefix"),
+		AreaID:    c.Query("area_id"),
+		Sort:      c.Query("sort"),
+		Order:     c.Query("order"),
+		Cursor:    c.Query("cursor"),
+	}
+	option.MinSize, _ = strconv.ParseInt(c.Query("min_size"), 10, 64)
+	option.MaxSize, _ = strconv.ParseInt(c.Query("max_size"), 10, 64)
+	option.Limit, _ = strconv.Atoi(c.Query("limit"))
+	if s := c.Query("share_status"); s != "" {
+		status, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+			return
+		}
+		option.ShareStatus = &status
+	}
+
+	search := dao.SearchUserAssets
+	if c.Query("kind") == "group" {
+		search = dao.SearchUserAssetGroups
+	}
+
+	list, next, err := search(c.Request.Context(), userId, option)
+	if err == dao.ErrInvalidCursor {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("search assets: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":        list,
+		"next_cursor": next,
+	}))
+}
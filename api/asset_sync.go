@@ -0,0 +1,304 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Filecoin-Titan/titan/api/types"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/gnasnik/titan-explorer/core/opasynq"
+	"github.com/gnasnik/titan-explorer/core/oprds"
+)
+
+const (
+	// maxAssetSyncAttempts 同步任务最多处理的次数, 超过后标记为失败
+	maxAssetSyncAttempts = 15
+	// assetSyncBaseDelay 第一次重试的间隔, 之后每次翻倍
+	assetSyncBaseDelay = 10 * time.Second
+	// assetSyncMaxDelay 重试间隔的上限
+	assetSyncMaxDelay = 30 * time.Minute
+	// assetSyncStuckAfter 创建超过此时间仍未完成的任务视为卡住
+	assetSyncStuckAfter = time.Hour
+)
+
+// assetSyncReadyStates 调度器上文件已经可以作为下载源的状态
+var assetSyncReadyStates = []string{"EdgesSelect", "EdgesPulling", "Servicing", "EdgesFailed"}
+
+func isAssetSyncReady(state string) bool {
+	for _, v := range assetSyncReadyStates {
+		if strings.EqualFold(v, state) {
+			return true
+		}
+	}
+	return false
+}
+
+// assetSyncBackoff 第 attempt 次处理之后等待的时间
+func assetSyncBackoff(attempt int64) time.Duration {
+	delay := assetSyncBaseDelay
+	for i := int64(1); i < attempt; i++ {
+		delay *= 2
+		if delay >= assetSyncMaxDelay {
+			return assetSyncMaxDelay
+		}
+	}
+	return delay
+}
+
+// EnqueueUserAssetSync 为登录用户的文件创建同步到其他区域的任务, 上传的区域不需要同步
+func EnqueueUserAssetSync(ctx context.Context, userID, owner, cid, hash, sourceArea string, areaIds []string) error {
+	tasks := make([]*model.AssetSyncTask, 0, len(areaIds))
+	for _, v := range areaIds {
+		if v == sourceArea {
+			continue
+		}
+		tasks = append(tasks, &model.AssetSyncTask{
+			Kind:         dao.AssetSyncKindUser,
+			UserID:       userID,
+			Owner:        owner,
+			Cid:          cid,
+			Hash:         hash,
+			SourceAreaID: sourceArea,
+			AreaID:       v,
+		})
+	}
+	return enqueueAssetSyncTasks(ctx, tasks)
+}
+
+// EnqueueTempAssetSync 为未登录上传的文件创建同步任务, areaIds[0] 是上传的区域
+func EnqueueTempAssetSync(ctx context.Context, cid, hash string, areaIds []string) error {
+	if len(areaIds) < 2 {
+		return nil
+	}
+
+	tasks := make([]*model.AssetSyncTask, 0, len(areaIds)-1)
+	for _, v := range areaIds[1:] {
+		if v == areaIds[0] {
+			continue
+		}
+		tasks = append(tasks, &model.AssetSyncTask{
+			Kind:         dao.AssetSyncKindTemp,
+			Cid:          cid,
+			Hash:         hash,
+			SourceAreaID: areaIds[0],
+			AreaID:       v,
+		})
+	}
+	return enqueueAssetSyncTasks(ctx, tasks)
+}
+
+func enqueueAssetSyncTasks(ctx context.Context, tasks []*model.AssetSyncTask) error {
+	list, err := dao.CreateAssetSyncTasks(ctx, tasks)
+	if err != nil {
+		return fmt.Errorf("create asset sync tasks: %w", err)
+	}
+
+	// 上传刚完成时文件还没到 L1, 等第一个间隔后再处理; 塞入失败的任务由定时任务补上
+	for _, t := range list {
+		if err = opasynq.DefaultCli.EnqueueAssetSync(ctx, opasynq.AssetSyncPayload{ID: t.ID, Attempt: t.Attempts}, assetSyncBaseDelay); err != nil {
+			log.Errorf("EnqueueAssetSync %d error: %v", t.ID, err)
+		}
+	}
+	return nil
+}
+
+// RunAssetSync 处理一次同步任务, 未完成时按退避间隔重新塞入队列, 超过最大次数后标记为失败
+// attempt 和数据库中的不一致时说明是过期的重复任务, 直接忽略
+func RunAssetSync(ctx context.Context, id, attempt int64) error {
+	t, err := dao.GetAssetSyncTask(ctx, id)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get task: %w", err)
+	}
+	if t.State == dao.AssetSyncStateServicing || t.State == dao.AssetSyncStateFailed || t.Attempts != attempt {
+		return nil
+	}
+
+	prevAttempts := t.Attempts
+	t.Attempts++
+	t.LastError = ""
+
+	done, err := stepAssetSync(ctx, t)
+	if err == nil && done {
+		// 完成处理是幂等的, 先更新文件的区域记录, 失败时按重试处理
+		err = completeAssetSync(ctx, t)
+	}
+
+	var delay time.Duration
+	switch {
+	case err == nil && done:
+		t.State = dao.AssetSyncStateServicing
+	case t.Attempts >= maxAssetSyncAttempts:
+		t.State = dao.AssetSyncStateFailed
+	default:
+		delay = assetSyncBackoff(t.Attempts)
+		t.NextRunAt = time.Now().Add(delay)
+	}
+	if err != nil {
+		t.LastError = err.Error()
+		if len(t.LastError) > 512 {
+			t.LastError = t.LastError[:512]
+		}
+	}
+
+	ok, err := dao.UpdateAssetSyncTask(ctx, t, prevAttempts)
+	if err != nil {
+		return fmt.Errorf("update task: %w", err)
+	}
+	if !ok || t.State != dao.AssetSyncStatePending && t.State != dao.AssetSyncStatePulling {
+		return nil
+	}
+
+	return opasynq.DefaultCli.EnqueueAssetSync(ctx, opasynq.AssetSyncPayload{ID: t.ID, Attempt: t.Attempts}, delay)
+}
+
+// stepAssetSync 推进同步任务的状态, 目标区域的文件可用时返回 true
+// pending: 等待上传区域的文件可用后在目标区域创建同步; pulling: 等待目标区域拉取完成, 记录丢失时回到 pending
+func stepAssetSync(ctx context.Context, t *model.AssetSyncTask) (bool, error) {
+	scli, err := getSchedulerClient(ctx, t.AreaID)
+	if err != nil {
+		return false, fmt.Errorf("get scheduler of %s: %w", t.AreaID, err)
+	}
+
+	rs, err := scli.GetAssetRecord(ctx, t.Cid)
+	if err == nil && len(rs.ReplicaInfos) > 0 && isAssetSyncReady(rs.State) && (t.Kind == dao.AssetSyncKindTemp || rs.Owner == t.Owner) {
+		return true, nil
+	}
+
+	if t.State == dao.AssetSyncStatePulling {
+		if err == nil && !strings.EqualFold(rs.State, "Remove") {
+			return false, nil
+		}
+		t.State = dao.AssetSyncStatePending
+		return false, fmt.Errorf("asset record of %s is missing, sync again", t.AreaID)
+	}
+
+	sourceCli, err := getSchedulerClient(ctx, t.SourceAreaID)
+	if err != nil {
+		return false, fmt.Errorf("get scheduler of %s: %w", t.SourceAreaID, err)
+	}
+	source, err := sourceCli.GetAssetRecord(ctx, t.Cid)
+	if err != nil {
+		return false, fmt.Errorf("get source asset record: %w", err)
+	}
+	if !isAssetSyncReady(source.State) || (t.Kind == dao.AssetSyncKindTemp && len(source.ReplicaInfos) == 0) {
+		return false, fmt.Errorf("source asset is not ready, state %s", source.State)
+	}
+
+	req := &types.CreateSyncAssetReq{AssetCID: t.Cid, AssetSize: source.TotalSize}
+	if t.Kind == dao.AssetSyncKindTemp {
+		req.DownloadInfo, err = sourceCli.GenerateTokenForDownloadSource(ctx, "", t.Cid)
+		req.ReplicaCount = 5
+		req.ExpirationDay = tempAssetExpirationDay()
+	} else {
+		req.DownloadInfos, err = sourceCli.GenerateTokenForDownloadSources(ctx, t.Cid)
+		req.Owner = t.Owner
+		req.ExpirationDay = 4 * 365
+	}
+	if err != nil {
+		return false, fmt.Errorf("generate token for download source: %w", err)
+	}
+	if err = scli.CreateSyncAsset(ctx, req); err != nil {
+		return false, fmt.Errorf("create sync asset in %s: %w", t.AreaID, err)
+	}
+
+	t.State = dao.AssetSyncStatePulling
+	return false, nil
+}
+
+// completeAssetSync 标记文件在目标区域同步完成
+func completeAssetSync(ctx context.Context, t *model.AssetSyncTask) error {
+	if t.Kind == dao.AssetSyncKindTemp {
+		return oprds.GetClient().SetUnloginAssetAreaSynced(ctx, t.Hash, t.AreaID)
+	}
+	return dao.UpdateUnSyncAreaIDs(ctx, t.UserID, t.Hash, []string{t.AreaID})
+}
+
+// AdminListAssetSyncTasksHandler 获取文件同步任务
+// @Summary 文件同步任务
+// @Security ApiKeyAuth
+// @Tags admin
+// @Param state query string false "状态: pending, pulling, servicing, failed"
+// @Param stuck query bool false "只获取创建超过1小时仍未完成的任务"
+// @Param page query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} JsonObject "{list:[],total:0,states:[]}"
+// @Router /api/v1/admin/asset_sync/tasks [get]
+func AdminListAssetSyncTasksHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	var stuckBefore time.Time
+	if stuck, _ := strconv.ParseBool(c.Query("stuck")); stuck {
+		stuckBefore = time.Now().Add(-assetSyncStuckAfter)
+	}
+
+	total, list, err := dao.ListAssetSyncTasks(c.Request.Context(), c.Query("state"), stuckBefore, dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListAssetSyncTasks error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	states, err := dao.CountAssetSyncTasksByState(c.Request.Context())
+	if err != nil {
+		log.Errorf("CountAssetSyncTasksByState error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":   list,
+		"total":  total,
+		"states": states,
+	}))
+}
+
+// AdminRetryAssetSyncTasksReq 重新同步的任务
+type AdminRetryAssetSyncTasksReq struct {
+	IDs []int64 `json:"ids" binding:"required"`
+}
+
+// AdminRetryAssetSyncTasksHandler 失败的同步任务重新开始
+// @Summary 重试文件同步任务
+// @Security ApiKeyAuth
+// @Tags admin
+// @Param req body AdminRetryAssetSyncTasksReq true "任务id"
+// @Success 200 {object} JsonObject "{count:0}"
+// @Router /api/v1/admin/asset_sync/retry [post]
+func AdminRetryAssetSyncTasksHandler(c *gin.Context) {
+	var req AdminRetryAssetSyncTasksReq
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.IDs) == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	list, err := dao.RetryAssetSyncTasks(c.Request.Context(), req.IDs)
+	if err != nil {
+		log.Errorf("RetryAssetSyncTasks error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	for _, t := range list {
+		if err = opasynq.DefaultCli.EnqueueAssetSync(c.Request.Context(), opasynq.AssetSyncPayload{ID: t.ID, Attempt: t.Attempts}, 0); err != nil {
+			log.Errorf("EnqueueAssetSync %d error: %v", t.ID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"count": len(list),
+	}))
+}
@@ -0,0 +1,289 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/gnasnik/titan-explorer/core/storage"
+)
+
+// AssetVersioningReq 设置文件夹版本管理的请求
+type AssetVersioningReq struct {
+	GroupID      int64 `json:"group_id"`
+	Enabled      bool  `json:"enabled"`
+	KeepVersions int64 `json:"keep_versions"`
+}
+
+// AssetVersionReq 恢复或清理版本的请求, 清理时保留最新的 keep 个历史版本
+type AssetVersionReq struct {
+	ID       int64  `json:"id"`
+	AssetCID string `json:"asset_cid"`
+	Keep     int64  `json:"keep"`
+}
+
+// addAssetVersion 上传完成后记录文件版本, 并按文件夹的设置清理多余的历史版本, 失败不影响上传
+func addAssetVersion(ctx context.Context, userId, hash string) {
+	if err := dao.AddAssetVersion(ctx, userId, hash); err != nil {
+		log.Errorf("AddAssetVersion: %v", err)
+		return
+	}
+
+	asset, err := dao.GetUserAsset(ctx, hash, userId)
+	if err != nil {
+		log.Errorf("GetUserAsset: %v", err)
+		return
+	}
+
+	setting, err := dao.GetAssetVersioning(ctx, userId, asset.GroupID)
+	if err != nil || setting.KeepVersions <= 0 {
+		return
+	}
+
+	if err = pruneAssetVersions(ctx, userId, asset.GroupID, asset.AssetName, setting.KeepVersions); err != nil {
+		log.Errorf("pruneAssetVersions: %v", err)
+	}
+}
+
+func pruneAssetVersions(ctx context.Context, userId string, groupId int64, assetName string, keep int64) error {
+	versions, err := dao.GetPrunableAssetVersions(ctx, userId, groupId, assetName, keep)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range versions {
+		if err = dao.DeleteAssetVersion(ctx, v.ID); err != nil {
+			return err
+		}
+		if err = purgeAssetVersionFile(ctx, userId, v.Hash, v.Cid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeAssetVersionFile 删除历史版本的文件记录, 用户在其他文件组或回收站中还有相同内容的文件时只删除历史版本这一条
+func purgeAssetVersionFile(ctx context.Context, userId, hash, cid string) error {
+	released, err := dao.DeleteUserAssetCopy(ctx, userId, hash, dao.AssetVersionGroupID)
+	if err != nil || released {
+		return err
+	}
+	return purgeUserAsset(ctx, userId, hash, cid)
+}
+
+// purgeAssetVersions 文件被彻底删除后清理版本记录, 删除的是当前版本时, 历史版本的文件也一起删除
+func purgeAssetVersions(ctx context.Context, userId, hash string) error {
+	v, err := dao.GetAssetVersionByHash(ctx, userId, hash)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !v.IsCurrent {
+		return dao.DeleteAssetVersion(ctx, v.ID)
+	}
+
+	versions, err := dao.ListAssetVersions(ctx, userId, v.GroupID, v.AssetName)
+	if err != nil {
+		return err
+	}
+	if err = dao.DeleteAssetVersions(ctx, userId, v.GroupID, v.AssetName); err != nil {
+		return err
+	}
+	for _, h := range versions {
+		if h.IsCurrent {
+			continue
+		}
+		if err = purgeAssetVersionFile(ctx, userId, h.Hash, h.Cid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetAssetVersioningHandler 开启或关闭文件夹的版本管理
+func SetAssetVersioningHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req AssetVersioningReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.GroupID < 0 || req.KeepVersions < 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	if req.GroupID > 0 {
+		if _, err := dao.GetUserAssetGroupInfo(c.Request.Context(), userId, int(req.GroupID)); err != nil {
+			c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+			return
+		}
+	}
+
+	var err error
+	if req.Enabled {
+		err = dao.SetAssetVersioning(c.Request.Context(), userId, req.GroupID, req.KeepVersions)
+	} else {
+		err = dao.DisableAssetVersioning(c.Request.Context(), userId, req.GroupID)
+	}
+	if err != nil {
+		log.Errorf("set asset versioning: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{"msg": "success"}))
+}
+
+// GetAssetVersioningHandler 获取文件夹的版本管理设置
+func GetAssetVersioningHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	groupId, _ := strconv.ParseInt(c.Query("group_id"), 10, 64)
+
+	setting, err := dao.GetAssetVersioning(c.Request.Context(), userId, groupId)
+	if err != nil && err != sql.ErrNoRows {
+		log.Errorf("GetAssetVersioning: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"enabled":       err == nil,
+		"keep_versions": setting.KeepVersions,
+	}))
+}
+
+// getCurrentAssetVersion 根据当前版本的 cid 找到逻辑文件
+func getCurrentAssetVersion(c *gin.Context, userId, cid string) (*model.UserAsset, bool) {
+	hash, err := storage.CIDToHash(cid)
+	if err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return nil, false
+	}
+
+	asset, err := dao.GetUserAsset(c.Request.Context(), hash, userId)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+		return nil, false
+	}
+	if err != nil {
+		log.Errorf("GetUserAsset: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return nil, false
+	}
+	return asset, true
+}
+
+// ListAssetVersionsHandler 获取文件的所有版本
+func ListAssetVersionsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	asset, ok := getCurrentAssetVersion(c, userId, c.Query("asset_cid"))
+	if !ok {
+		return
+	}
+
+	list, err := dao.ListAssetVersions(c.Request.Context(), userId, asset.GroupID, asset.AssetName)
+	if err != nil {
+		log.Errorf("ListAssetVersions: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list": list,
+	}))
+}
+
+func getAssetVersion(c *gin.Context, userId string, id int64) (*model.AssetVersion, bool) {
+	v, err := dao.GetAssetVersion(c.Request.Context(), userId, id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+		return nil, false
+	}
+	if err != nil {
+		log.Errorf("GetAssetVersion: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return nil, false
+	}
+	return v, true
+}
+
+// DownloadAssetVersionHandler 下载指定版本, 和分享文件一样返回下载地址
+func DownloadAssetVersionHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	id, _ := strconv.ParseInt(c.Query("id"), 10, 64)
+	v, ok := getAssetVersion(c, userId, id)
+	if !ok {
+		return
+	}
+
+	query := c.Request.URL.Query()
+	query.Set("asset_cid", v.Cid)
+	c.Request.URL.RawQuery = query.Encode()
+
+	ShareAssetsHandler(c)
+}
+
+// RestoreAssetVersionHandler 把历史版本恢复为当前版本
+func RestoreAssetVersionHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req AssetVersionReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.ID <= 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	v, ok := getAssetVersion(c, userId, req.ID)
+	if !ok {
+		return
+	}
+
+	if !v.IsCurrent {
+		if err := dao.RestoreAssetVersion(c.Request.Context(), v); err != nil {
+			log.Errorf("RestoreAssetVersion: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{"msg": "success"}))
+}
+
+// PruneAssetVersionsHandler 清理文件的历史版本, 只保留最新的 keep 个
+func PruneAssetVersionsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req AssetVersionReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.Keep < 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	asset, ok := getCurrentAssetVersion(c, userId, req.AssetCID)
+	if !ok {
+		return
+	}
+
+	if err := pruneAssetVersions(c.Request.Context(), userId, asset.GroupID, asset.AssetName, req.Keep); err != nil {
+		log.Errorf("pruneAssetVersions: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{"msg": "success"}))
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/core/availability"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/pkg/formatter"
+	"github.com/gnasnik/titan-explorer/pkg/pdf"
+)
+
+// defaultSLATarget 默认的 SLA 目标可用率
+const defaultSLATarget = 95.0
+
+type (
+	// DeviceSLAReport 单个节点的 SLA 报告
+	DeviceSLAReport struct {
+		DeviceID string                 `json:"device_id"`
+		Report   *availability.Report   `json:"report"`
+		Buckets  []*availability.Bucket `json:"buckets"`
+	}
+	// UserSLAReport 用户所有节点的 SLA 报告
+	UserSLAReport struct {
+		Period  availability.Period        `json:"period"`
+		Start   time.Time                  `json:"start"`
+		End     time.Time                  `json:"end"`
+		Summary *availability.FleetSummary `json:"summary"`
+		Devices []*DeviceSLAReport         `json:"devices"`
+	}
+)
+
+func parseSLAPeriod(c *gin.Context) (availability.Period, bool) {
+	period := availability.Period(c.DefaultQuery("period", string(availability.PeriodDaily)))
+	switch period {
+	case availability.PeriodDaily, availability.PeriodWeekly, availability.PeriodMonthly:
+		return period, true
+	default:
+		return "", false
+	}
+}
+
+func buildDeviceSLAReports(ctx context.Context, deviceIds []string, period availability.Period) ([]*DeviceSLAReport, time.Time, time.Time, error) {
+	start, end := availability.Range(period, time.Now())
+
+	samples, err := dao.GetDeviceOnlineSamples(ctx, deviceIds, start, end)
+	if err != nil {
+		return nil, start, end, err
+	}
+
+	out := make([]*DeviceSLAReport, 0, len(deviceIds))
+	for _, deviceId := range deviceIds {
+		out = append(out, &DeviceSLAReport{
+			DeviceID: deviceId,
+			Report:   availability.Calculate(samples[deviceId], start, end),
+			Buckets:  availability.Buckets(samples[deviceId], period, start, end),
+		})
+	}
+
+	return out, start, end, nil
+}
+
+func buildUserSLAReport(ctx context.Context, userId string, period availability.Period, target float64) (*UserSLAReport, error) {
+	deviceIds, err := dao.GetUserDeviceIDs(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, start, end, err := buildDeviceSLAReports(ctx, deviceIds, period)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]*availability.Report, 0, len(devices))
+	for _, device := range devices {
+		reports = append(reports, device.Report)
+	}
+
+	return &UserSLAReport{
+		Period:  period,
+		Start:   start,
+		End:     end,
+		Summary: availability.Summarize(reports, target),
+		Devices: devices,
+	}, nil
+}
+
+// GetNodeSLAHandler 获取单个节点的可用率、离线窗口以及 MTBF/MTTR
+func GetNodeSLAHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	deviceId := c.Query("device_id")
+	period, ok := parseSLAPeriod(c)
+	if deviceId == "" || !ok {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	status, err := dao.CheckIsNodeOwner(c.Request.Context(), uid, deviceId)
+	if err != nil {
+		log.Errorf("CheckIsNodeOwner error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if status == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.DeviceNotExists, c))
+		return
+	}
+
+	reports, _, _, err := buildDeviceSLAReports(c.Request.Context(), []string{deviceId}, period)
+	if err != nil {
+		log.Errorf("buildDeviceSLAReports: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(reports[0]))
+}
+
+// GetUserSLAReportHandler 获取用户所有节点的 SLA 报告
+func GetUserSLAReportHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	period, ok := parseSLAPeriod(c)
+	if !ok {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	target, err := strconv.ParseFloat(c.DefaultQuery("target", fmt.Sprint(defaultSLATarget)), 64)
+	if err != nil || target < 0 || target > 100 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	report, err := buildUserSLAReport(c.Request.Context(), uid, period, target)
+	if err != nil {
+		log.Errorf("buildUserSLAReport: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(report))
+}
+
+// ExportUserSLAReportHandler 导出用户所有节点的 SLA 报告, format 支持 csv 和 pdf
+func ExportUserSLAReportHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	period, ok := parseSLAPeriod(c)
+	format := c.DefaultQuery("format", "csv")
+	if !ok || (format != "csv" && format != "pdf") {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	report, err := buildUserSLAReport(c.Request.Context(), uid, period, defaultSLATarget)
+	if err != nil {
+		log.Errorf("buildUserSLAReport: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	filename := fmt.Sprintf("SLA-%s-%s.%s", period, time.Now().Format(formatter.TimeFormatDateOnly), format)
+	c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s", filename))
+
+	switch format {
+	case "pdf":
+		c.Writer.Header().Set("Content-Type", "application/pdf")
+		err = writeSLAReportPDF(c, report)
+	default:
+		c.Writer.Header().Set("Content-Type", "text/csv")
+		err = writeSLAReportCSV(c, report)
+	}
+
+	if err != nil {
+		log.Errorf("write sla report: %v", err)
+	}
+}
+
+func writeSLAReportCSV(c *gin.Context, report *UserSLAReport) error {
+	w := csv.NewWriter(c.Writer)
+	if err := w.Write([]string{"device_id", "uptime", "online_minutes", "total_minutes", "outages", "mtbf", "mttr"}); err != nil {
+		return err
+	}
+
+	for _, device := range report.Devices {
+		r := device.Report
+		if err := w.Write([]string{
+			device.DeviceID,
+			strconv.FormatFloat(r.Uptime, 'f', 2, 64),
+			strconv.FormatFloat(r.OnlineMinutes, 'f', 0, 64),
+			strconv.FormatFloat(r.TotalMinutes, 'f', 0, 64),
+			strconv.Itoa(len(r.Outages)),
+			strconv.FormatFloat(r.MTBF, 'f', 0, 64),
+			strconv.FormatFloat(r.MTTR, 'f', 0, 64),
+		}); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+func writeSLAReportPDF(c *gin.Context, report *UserSLAReport) error {
+	doc := pdf.New()
+	doc.AddLine("Node SLA Report (%s)", report.Period)
+	doc.AddLine("Range: %s - %s", report.Start.Format(formatter.TimeFormatDateOnly), report.End.Format(formatter.TimeFormatDateOnly))
+	doc.AddLine("")
+	doc.AddLine("Nodes: %d  Avg uptime: %.2f%%  Min uptime: %.2f%%", report.Summary.Nodes, report.Summary.AvgUptime, report.Summary.MinUptime)
+	doc.AddLine("Outages: %d  Nodes meeting %.1f%% target: %d", report.Summary.OutageCount, report.Summary.Target, report.Summary.MeetTarget)
+	doc.AddLine("")
+	doc.AddLine("%-48s %8s %8s %10s %10s", "DEVICE", "UPTIME", "OUTAGES", "MTBF(min)", "MTTR(min)")
+
+	for _, device := range report.Devices {
+		r := device.Report
+		doc.AddLine("%-48s %7.2f%% %8d %10.0f %10.0f", device.DeviceID, r.Uptime, len(r.Outages), r.MTBF, r.MTTR)
+	}
+
+	_, err := doc.WriteTo(c.Writer)
+	return err
+}
@@ -100,6 +100,11 @@ type (
 )
 
 func getAreaIDsByAreaID(c *gin.Context, areaIDs []string) ([]string, map[string][]string) {
+	return getAreaIDsByTransfer(c, areaIDs, dao.AssetTransferTypeUpload)
+}
+
+// getAreaIDsByTransfer 获取请求的区域, 第一个是对用户得分最高的区域
+func getAreaIDsByTransfer(c *gin.Context, areaIDs []string, transferType string) ([]string, map[string][]string) {
 	var (
 		aids, naids []string
 		isAuto      bool
@@ -158,7 +163,7 @@ func getAreaIDsByAreaID(c *gin.Context, areaIDs []string) ([]string, map[string]
 			}
 		}
 
-		areaID, err := GetNearestAreaID(c.Request.Context(), ip, tadis)
+		areaID, err := GetBestAreaID(c.Request.Context(), ip, transferType, tadis)
 		if err != nil {
 			log.Error(err)
 		} else {
@@ -881,23 +886,12 @@ func checkUserTotalFlow(ctx context.Context, username string) (bool, error) {
 	} else {
 		json.Unmarshal([]byte(value), fInfo)
 	}
-	// 判断是否为vip
-	switch {
-	case user.EnableVIP:
-		if fInfo.TotalTraffic < maxVipTotalFlow {
-			return true, nil
-		}
-
-	case user.TenantID != "":
+	// 租户子账户不是 vip 时不限制, 其他用户按套餐或 vip 判断
+	if user.TenantID != "" && !user.EnableVIP {
 		return true, nil
-
-	default:
-		if fInfo.TotalTraffic < maxTotalFlow {
-			return true, nil
-		}
 	}
 
-	return false, nil
+	return fInfo.TotalTraffic < userTrafficLimit(ctx, user), nil
 }
 
 // 判断 apikey 是否存在
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+)
+
+// ReviewDeviceAnomalyReq 审核异常节点请求
+type ReviewDeviceAnomalyReq struct {
+	ID     int64  `json:"id" binding:"required"`
+	Status int    `json:"status"`
+	Remark string `json:"remark"`
+}
+
+// GetDeviceAnomalyListHandler 异常节点审核列表, status 默认为待审核, 传 -1 返回所有状态
+func GetDeviceAnomalyListHandler(c *gin.Context) {
+	status, err := strconv.Atoi(c.DefaultQuery("status", strconv.Itoa(dao.DeviceAnomalyStatusPending)))
+	if err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	total, list, err := dao.ListDeviceAnomalies(c.Request.Context(), status, c.Query("device_id"), c.Query("user_id"), dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListDeviceAnomalies: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  list,
+		"total": total,
+	}))
+}
+
+// ReviewDeviceAnomalyHandler 审核异常节点, 确认作弊或者排除
+func ReviewDeviceAnomalyHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	var req ReviewDeviceAnomalyReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	if req.Status != dao.DeviceAnomalyStatusConfirmed && req.Status != dao.DeviceAnomalyStatusDismissed {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	_, err := dao.GetDeviceAnomaly(c.Request.Context(), req.ID)
+	if err == dao.ErrNoRow {
+		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetDeviceAnomaly: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	if err = dao.ReviewDeviceAnomaly(c.Request.Context(), req.ID, req.Status, username, req.Remark); err != nil {
+		log.Errorf("ReviewDeviceAnomaly: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{"msg": "success"}))
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+// addDeviceEvents 记录节点事件, 写入失败不影响主流程
+func addDeviceEvents(ctx context.Context, events ...*model.DeviceEvent) {
+	if err := dao.AddDeviceEvents(ctx, events...); err != nil {
+		log.Errorf("AddDeviceEvents: %v", err)
+	}
+}
+
+func getDeviceEvents(c *gin.Context, deviceId string) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	total, list, err := dao.GetDeviceEvents(c.Request.Context(), deviceId, c.Query("event"), dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("GetDeviceEvents: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  list,
+		"total": total,
+	}))
+}
+
+// GetDeviceEventsHandler 节点的生命周期时间线, 只有节点的所有者可以查看
+func GetDeviceEventsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	deviceId := c.Query("device_id")
+	if deviceId == "" {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	status, err := dao.CheckIsNodeOwner(c.Request.Context(), uid, deviceId)
+	if err != nil {
+		log.Errorf("CheckIsNodeOwner error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if status == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.DeviceNotExists, c))
+		return
+	}
+
+	getDeviceEvents(c, deviceId)
+}
+
+// AdminGetDeviceEventsHandler 管理员查看任意节点的生命周期时间线
+func AdminGetDeviceEventsHandler(c *gin.Context) {
+	deviceId := c.Query("device_id")
+	if deviceId == "" {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	getDeviceEvents(c, deviceId)
+}
@@ -0,0 +1,594 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Filecoin-Titan/titan/api"
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+const (
+	// maxBulkDevices 单次请求可以操作的节点数量上限
+	maxBulkDevices = 500
+	// maxFilterDevices 按分组或标签批量操作时的节点数量上限
+	maxFilterDevices = 1000
+)
+
+type (
+	// DeviceGroupReq 创建或者修改分组请求
+	DeviceGroupReq struct {
+		GroupID int64  `json:"group_id"`
+		Name    string `json:"name" binding:"required"`
+	}
+	// DeviceGroupMembersReq 分组添加或移除节点请求
+	DeviceGroupMembersReq struct {
+		GroupID   int64    `json:"group_id" binding:"required"`
+		DeviceIDs []string `json:"device_ids" binding:"required"`
+	}
+	// DeviceTagsReq 节点添加或移除标签请求
+	DeviceTagsReq struct {
+		DeviceIDs []string `json:"device_ids" binding:"required"`
+		Tags      []string `json:"tags" binding:"required"`
+	}
+	// BulkDeviceReq 按分组或者标签批量操作节点请求, group_id 和 tag 至少需要一个
+	BulkDeviceReq struct {
+		GroupID int64  `json:"group_id"`
+		Tag     string `json:"tag"`
+		// Pattern 批量重命名的格式, 支持 {n} 序号, {id} 节点ID, {name} 原名称
+		Pattern string `json:"pattern"`
+		Code    string `json:"code"`
+		Hours   int    `json:"hours"`
+		Config  string `json:"config"`
+	}
+	// BulkDeviceFailure 批量操作失败的节点
+	BulkDeviceFailure struct {
+		DeviceID string `json:"device_id"`
+		Reason   string `json:"reason"`
+	}
+)
+
+func validDeviceList(ids []string) bool {
+	if len(ids) == 0 || len(ids) > maxBulkDevices {
+		return false
+	}
+	for _, id := range ids {
+		if id == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeTags(tags []string) []string {
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]struct{})
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || len(tag) > 32 {
+			continue
+		}
+		if _, ok := seen[tag]; ok {
+			continue
+		}
+		seen[tag] = struct{}{}
+		out = append(out, tag)
+	}
+	return out
+}
+
+// checkDeviceGroup 校验分组是否属于当前用户, 失败时直接返回错误响应
+func checkDeviceGroup(c *gin.Context, uid string, groupId int64) bool {
+	if groupId <= 0 {
+		return true
+	}
+
+	_, err := dao.GetDeviceGroup(c.Request.Context(), uid, groupId)
+	if err == dao.ErrNoRow {
+		c.JSON(http.StatusOK, respErrorCode(errors.DeviceGroupNotExists, c))
+		return false
+	}
+	if err != nil {
+		log.Errorf("GetDeviceGroup: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return false
+	}
+	return true
+}
+
+// bindBulkDevices 解析批量操作请求并获取需要操作的节点
+func bindBulkDevices(c *gin.Context, uid string) (*BulkDeviceReq, []*model.DeviceInfo, bool) {
+	var req BulkDeviceReq
+	if err := c.ShouldBindJSON(&req); err != nil || (req.GroupID <= 0 && req.Tag == "") {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return nil, nil, false
+	}
+
+	if !checkDeviceGroup(c, uid, req.GroupID) {
+		return nil, nil, false
+	}
+
+	// 多取一个节点判断是否超出上限, 超出时整体拒绝, 不能只处理一部分节点
+	devices, err := dao.GetUserDeviceListByFilter(c.Request.Context(), uid, req.GroupID, req.Tag, maxFilterDevices+1)
+	if err != nil {
+		log.Errorf("GetUserDeviceListByFilter: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return nil, nil, false
+	}
+	if len(devices) > maxFilterDevices {
+		c.JSON(http.StatusOK, respErrorCode(errors.BulkDevicesExceeded, c))
+		return nil, nil, false
+	}
+
+	return &req, devices, true
+}
+
+// CreateDeviceGroupHandler 创建节点分组
+func CreateDeviceGroupHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	var req DeviceGroupReq
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+
+	_, err := dao.GetDeviceGroupByName(c.Request.Context(), uid, name)
+	if err == nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.DeviceGroupExists, c))
+		return
+	}
+	if err != dao.ErrNoRow {
+		log.Errorf("GetDeviceGroupByName: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	id, err := dao.CreateDeviceGroup(c.Request.Context(), &model.DeviceGroup{
+		UserID:    uid,
+		Name:      name,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Errorf("CreateDeviceGroup: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{"group_id": id}))
+}
+
+// ListDeviceGroupsHandler 获取用户的节点分组
+func ListDeviceGroupsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	groups, err := dao.ListDeviceGroups(c.Request.Context(), uid)
+	if err != nil {
+		log.Errorf("ListDeviceGroups: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{"list": groups}))
+}
+
+// RenameDeviceGroupHandler 修改分组名称
+func RenameDeviceGroupHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	var req DeviceGroupReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.GroupID <= 0 || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+
+	if !checkDeviceGroup(c, uid, req.GroupID) {
+		return
+	}
+
+	exist, err := dao.GetDeviceGroupByName(c.Request.Context(), uid, name)
+	if err == nil && exist.ID != req.GroupID {
+		c.JSON(http.StatusOK, respErrorCode(errors.DeviceGroupExists, c))
+		return
+	}
+	if err != nil && err != dao.ErrNoRow {
+		log.Errorf("GetDeviceGroupByName: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	if err = dao.RenameDeviceGroup(c.Request.Context(), uid, req.GroupID, name); err != nil {
+		log.Errorf("RenameDeviceGroup: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{"msg": "success"}))
+}
+
+// DeleteDeviceGroupHandler 删除分组, 不会影响分组内的节点
+func DeleteDeviceGroupHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	groupId, _ := strconv.ParseInt(c.Query("group_id"), 10, 64)
+	if groupId <= 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	if !checkDeviceGroup(c, uid, groupId) {
+		return
+	}
+
+	if err := dao.DeleteDeviceGroup(c.Request.Context(), uid, groupId); err != nil {
+		log.Errorf("DeleteDeviceGroup: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{"msg": "success"}))
+}
+
+// AddDeviceGroupMembersHandler 把节点加入分组, 一个节点只能属于一个分组
+func AddDeviceGroupMembersHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	var req DeviceGroupMembersReq
+	if err := c.ShouldBindJSON(&req); err != nil || !validDeviceList(req.DeviceIDs) {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	if !checkDeviceGroup(c, uid, req.GroupID) {
+		return
+	}
+
+	if _, err := dao.AddDevicesToGroup(c.Request.Context(), uid, req.GroupID, req.DeviceIDs); err != nil {
+		log.Errorf("AddDevicesToGroup: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{"msg": "success"}))
+}
+
+// RemoveDeviceGroupMembersHandler 把节点移出分组
+func RemoveDeviceGroupMembersHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	var req DeviceGroupMembersReq
+	if err := c.ShouldBindJSON(&req); err != nil || !validDeviceList(req.DeviceIDs) {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	if err := dao.RemoveDevicesFromGroup(c.Request.Context(), uid, req.GroupID, req.DeviceIDs); err != nil {
+		log.Errorf("RemoveDevicesFromGroup: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{"msg": "success"}))
+}
+
+// GetDeviceGroupDevicesHandler 按分组或者标签分页获取节点, 同时返回节点的标签
+func GetDeviceGroupDevicesHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	groupId, _ := strconv.ParseInt(c.Query("group_id"), 10, 64)
+	tag := c.Query("tag")
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	if !checkDeviceGroup(c, uid, groupId) {
+		return
+	}
+
+	devices, total, err := dao.GetUserDevicesByFilter(c.Request.Context(), uid, groupId, tag, dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("GetUserDevicesByFilter: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	deviceIds := make([]string, 0, len(devices))
+	for _, device := range devices {
+		deviceIds = append(deviceIds, device.DeviceID)
+	}
+
+	tags, err := dao.GetDeviceTags(c.Request.Context(), uid, deviceIds)
+	if err != nil {
+		log.Errorf("GetDeviceTags: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  devices,
+		"tags":  tags,
+		"total": total,
+	}))
+}
+
+// GetDeviceGroupStatsHandler 按分组或者标签统计节点的收益、带宽以及在线数量
+func GetDeviceGroupStatsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	groupId, _ := strconv.ParseInt(c.Query("group_id"), 10, 64)
+	tag := c.Query("tag")
+
+	if !checkDeviceGroup(c, uid, groupId) {
+		return
+	}
+
+	stats, err := dao.SumDeviceGroupStats(c.Request.Context(), uid, groupId, tag)
+	if err != nil {
+		log.Errorf("SumDeviceGroupStats: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(stats))
+}
+
+// AddDeviceTagsHandler 给节点添加标签
+func AddDeviceTagsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	var req DeviceTagsReq
+	if err := c.ShouldBindJSON(&req); err != nil || !validDeviceList(req.DeviceIDs) {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	tags := normalizeTags(req.Tags)
+	if len(tags) == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	if err := dao.AddDeviceTags(c.Request.Context(), uid, req.DeviceIDs, tags); err != nil {
+		log.Errorf("AddDeviceTags: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{"msg": "success"}))
+}
+
+// RemoveDeviceTagsHandler 删除节点的标签
+func RemoveDeviceTagsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	var req DeviceTagsReq
+	if err := c.ShouldBindJSON(&req); err != nil || !validDeviceList(req.DeviceIDs) || len(req.Tags) == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	if err := dao.RemoveDeviceTags(c.Request.Context(), uid, req.DeviceIDs, req.Tags); err != nil {
+		log.Errorf("RemoveDeviceTags: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{"msg": "success"}))
+}
+
+// ListDeviceTagsHandler 获取用户所有的标签
+func ListDeviceTagsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	tags, err := dao.ListUserDeviceTags(c.Request.Context(), uid)
+	if err != nil {
+		log.Errorf("ListUserDeviceTags: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{"list": tags}))
+}
+
+// BulkRenameDevicesHandler 按格式批量修改节点名称
+func BulkRenameDevicesHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	req, devices, ok := bindBulkDevices(c, uid)
+	if !ok {
+		return
+	}
+
+	if strings.TrimSpace(req.Pattern) == "" {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	names := make(map[string]string, len(devices))
+	for i, device := range devices {
+		names[device.DeviceID] = strings.NewReplacer(
+			"{n}", strconv.Itoa(i+1),
+			"{id}", device.DeviceID,
+			"{name}", device.DeviceName,
+		).Replace(req.Pattern)
+	}
+
+	if err := dao.BulkUpdateDeviceName(c.Request.Context(), uid, names); err != nil {
+		log.Errorf("BulkUpdateDeviceName: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	events := make([]*model.DeviceEvent, 0, len(devices))
+	for _, device := range devices {
+		events = append(events, dao.NewDeviceEvent(device.DeviceID, uid, dao.DeviceEventRename, uid, device.DeviceName, names[device.DeviceID]))
+	}
+	addDeviceEvents(c.Request.Context(), events...)
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{"total": len(names)}))
+}
+
+// BulkUnbindDevicesHandler 批量解绑节点
+func BulkUnbindDevicesHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	_, devices, ok := bindBulkDevices(c, uid)
+	if !ok {
+		return
+	}
+
+	var (
+		failures = make([]*BulkDeviceFailure, 0)
+		events   = make([]*model.DeviceEvent, 0, len(devices))
+	)
+	for _, device := range devices {
+		err := dao.UpdateUserDeviceInfo(c.Request.Context(), &model.DeviceInfo{
+			DeviceID:     device.DeviceID,
+			BindStatus:   "unbinding",
+			ActiveStatus: 2,
+		})
+		if err != nil {
+			log.Errorf("UpdateUserDeviceInfo: %v", err)
+			failures = append(failures, &BulkDeviceFailure{DeviceID: device.DeviceID, Reason: "internal error"})
+			continue
+		}
+		events = append(events, dao.NewDeviceEvent(device.DeviceID, uid, dao.DeviceEventUnbind, uid, uid, ""))
+	}
+	addDeviceEvents(c.Request.Context(), events...)
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"total":    len(devices),
+		"failures": failures,
+	}))
+}
+
+// BulkDeactiveDevicesHandler 批量下线节点, 需要先获取下线验证码
+func BulkDeactiveDevicesHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	req, devices, ok := bindBulkDevices(c, uid)
+	if !ok {
+		return
+	}
+
+	// 校验验证码是否正确
+	code, err := getNonceFromCache(c.Request.Context(), uid, NonceStringTypeDeactive)
+	if err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if req.Code == "" || !strings.EqualFold(code, req.Code) {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidVerifyCode, c))
+		return
+	}
+
+	var (
+		clients  = make(map[string]api.Scheduler)
+		failures = make([]*BulkDeviceFailure, 0)
+		events   = make([]*model.DeviceEvent, 0, len(devices))
+	)
+
+	for _, device := range devices {
+		status, err := dao.CheckIsNodeOwner(c.Request.Context(), uid, device.DeviceID)
+		if err != nil {
+			log.Errorf("CheckIsNodeOwner error: %v", err)
+			failures = append(failures, &BulkDeviceFailure{DeviceID: device.DeviceID, Reason: "internal error"})
+			continue
+		}
+		if status == 0 || status == 11 {
+			failures = append(failures, &BulkDeviceFailure{DeviceID: device.DeviceID, Reason: "node can not be deactivated"})
+			continue
+		}
+
+		scli, ok := clients[device.AreaID]
+		if !ok {
+			scli, err = getSchedulerClient(c.Request.Context(), device.AreaID)
+			if err != nil {
+				log.Errorf("getSchedulerClient error: %v", err)
+				failures = append(failures, &BulkDeviceFailure{DeviceID: device.DeviceID, Reason: "scheduler not found"})
+				continue
+			}
+			clients[device.AreaID] = scli
+		}
+
+		if err = scli.DeactivateNode(c.Request.Context(), device.DeviceID, req.Hours); err != nil {
+			log.Errorf("DeactivateNode error: %v", err)
+			failures = append(failures, &BulkDeviceFailure{DeviceID: device.DeviceID, Reason: err.Error()})
+			continue
+		}
+
+		if err = dao.UpdateNodeOperationStatus(c.Request.Context(), uid, device.DeviceID, 1, req.Hours); err != nil {
+			log.Errorf("UpdateNodeOperationStatus error: %v", err)
+			failures = append(failures, &BulkDeviceFailure{DeviceID: device.DeviceID, Reason: "internal error"})
+			continue
+		}
+		events = append(events, dao.NewDeviceEvent(device.DeviceID, uid, dao.DeviceEventDeactivate, uid, "", fmt.Sprintf("%d hours", req.Hours)))
+	}
+	addDeviceEvents(c.Request.Context(), events...)
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"total":    len(devices),
+		"failures": failures,
+	}))
+}
+
+// BulkSetEdgeConfigHandler 批量设置节点的配置
+func BulkSetEdgeConfigHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	req, devices, ok := bindBulkDevices(c, uid)
+	if !ok {
+		return
+	}
+
+	if req.Config == "" {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	failures := make([]*BulkDeviceFailure, 0)
+	for _, device := range devices {
+		err := dao.SetEdgeConfig(c.Request.Context(), &model.EdgeConfig{
+			NodeId:    device.DeviceID,
+			Config:    req.Config,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		})
+		if err != nil {
+			log.Errorf("SetEdgeConfig: %v", err)
+			failures = append(failures, &BulkDeviceFailure{DeviceID: device.DeviceID, Reason: "internal error"})
+		}
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"total":    len(devices),
+		"failures": failures,
+	}))
+}
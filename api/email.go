@@ -35,8 +35,11 @@ func sendEmail(sendTo string, vc, lang string) error {
 	}
 	content = fmt.Sprintf(content, verificationBtn)
 
-	contentType := "text/html"
+	return deliverEmail(sendTo, emailSubject[lang], "text/html", content)
+}
 
+// deliverEmail 随机选择一个邮箱配置发送邮件
+func deliverEmail(sendTo, subject, contentType, content string) error {
 	var mailCfg config.EmailConfig
 	if len(config.Cfg.Emails) > 0 {
 		mailCfg = config.Cfg.Emails[rand.Intn(len(config.Cfg.Emails))]
@@ -50,7 +53,7 @@ func sendEmail(sendTo string, vc, lang string) error {
 		log.Errorf("parse port: %v", err)
 	}
 
-	message := mail.NewEmailMessage(mailCfg.From, mailCfg.Nickname, emailSubject[lang], contentType, content, "", []string{sendTo}, nil)
+	message := mail.NewEmailMessage(mailCfg.From, mailCfg.Nickname, subject, contentType, content, "", []string{sendTo}, nil)
 	client := mail.NewEmailClient(mailCfg.SMTPHost, mailCfg.Username, mailCfg.Password, int(port), message)
 	_, err = client.SendMessage()
 	if err != nil {
@@ -37,9 +37,11 @@ import (
 	"github.com/gnasnik/titan-explorer/core/dao"
 	"github.com/gnasnik/titan-explorer/core/errors"
 	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/gnasnik/titan-explorer/core/geo"
 	"github.com/gnasnik/titan-explorer/core/opasynq"
 	"github.com/gnasnik/titan-explorer/core/oprds"
 	"github.com/gnasnik/titan-explorer/core/storage"
+	"github.com/go-redis/redis/v9"
 )
 
 // GetDefaultTitanCandidateEntrypointInfo  specify candidate to upload file in testnet, only for storage api
@@ -275,8 +277,7 @@ func GetAllocateStorageHandler(c *gin.Context) {
 // @Router /api/v1/storage/get_storage_size [get]
 func GetStorageSizeHandler(c *gin.Context) {
 	var (
-		fInfo        = new(dao.UserStorageFlowInfo)
-		totaltraffic = maxTotalFlow
+		fInfo = new(dao.UserStorageFlowInfo)
 	)
 
 	claims := jwt.ExtractClaims(c)
@@ -303,9 +304,7 @@ func GetStorageSizeHandler(c *gin.Context) {
 	} else {
 		json.Unmarshal([]byte(value), fInfo)
 	}
-	if user.EnableVIP {
-		totaltraffic = maxVipTotalFlow
-	}
+	totaltraffic := userTrafficLimit(c.Request.Context(), user)
 
 	c.JSON(http.StatusOK, respJSON(JsonObject{
 		"PeakBandwidth": fInfo.PeakBandwidth,
@@ -332,10 +331,15 @@ func GetUserVipInfoHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
 		return
 	}
-	c.JSON(http.StatusOK, respJSON(JsonObject{
+	resp := JsonObject{
 		"vip": user.EnableVIP,
 		"uid": username,
-	}))
+	}
+	if sub, err := dao.GetUserSubscription(c.Request.Context(), username); err == nil && sub.State == dao.SubscriptionStateActive {
+		resp["plan_id"] = sub.PlanID
+		resp["expires_at"] = sub.ExpiresAt
+	}
+	c.JSON(http.StatusOK, respJSON(resp))
 	return
 }
 
@@ -459,6 +463,9 @@ func md5Str(s string) string {
 // @Param asset_type query string true "文件类型"
 // @Param asset_size query int64 true "文件大小"
 // @Param group_id query int true "group id"
+// @Param encrypted query bool false "是否加密"
+// @Param wrapped_key query string false "用主密钥公钥加密的数据密钥"
+// @Param key_version query int false "主密钥版本"
 // @Success 200 {object} JsonObject "{[]{CandidateAddr:"",Token:""}}"
 // @Router /api/v1/storage/create_asset [get]
 func CreateAssetHandler(c *gin.Context) {
@@ -485,8 +492,28 @@ func CreateAssetHandler(c *gin.Context) {
 		return
 	}
 
+	log.Debugf("CreateAssetHandler clientIP:%s, areaId:%v\n", c.ClientIP(), areaIds)
+
+	var createAssetReq createAssetRequest
+	createAssetReq.AssetName = c.Query("asset_name")
+	createAssetReq.AssetCID = c.Query("asset_cid")
+	createAssetReq.NodeID = c.Query("node_id")
+	createAssetReq.AssetType = c.Query("asset_type")
+	createAssetReq.AssetSize = formatter.Str2Int64(c.Query("asset_size"))
+	createAssetReq.GroupID, _ = strconv.ParseInt(c.Query("group_id"), 10, 64)
+	createAssetReq.ExtraID = c.Query("extra_id")
+	createAssetReq.Encrypted = c.Query("encrypted") == "true"
+	createAssetReq.WrappedKey = c.Query("wrapped_key")
+	createAssetReq.KeyVersion, _ = strconv.ParseInt(c.Query("key_version"), 10, 64)
+
+	// 客户端加密的文件只保存数据密钥, 否则沿用旧的随机密码
 	var randomPassNonce string
-	if c.Query("encrypted") == "true" {
+	if createAssetReq.WrappedKey != "" {
+		if code := checkWrappedAssetKey(c.Request.Context(), userId, &createAssetReq); code > 0 {
+			c.JSON(http.StatusOK, respErrorCode(code, c))
+			return
+		}
+	} else if createAssetReq.Encrypted {
 		passKey := fmt.Sprintf(FileUploadPassKey, userId)
 		randomPassNonce = dao.RedisCache.Get(c.Request.Context(), passKey).Val()
 		if randomPassNonce == "" {
@@ -497,16 +524,12 @@ func CreateAssetHandler(c *gin.Context) {
 		dao.RedisCache.Del(c.Request.Context(), passKey)
 	}
 
-	log.Debugf("CreateAssetHandler clientIP:%s, areaId:%v\n", c.ClientIP(), areaIds)
-
-	var createAssetReq createAssetRequest
-	createAssetReq.AssetName = c.Query("asset_name")
-	createAssetReq.AssetCID = c.Query("asset_cid")
-	createAssetReq.NodeID = c.Query("node_id")
-	createAssetReq.AssetType = c.Query("asset_type")
-	createAssetReq.AssetSize = formatter.Str2Int64(c.Query("asset_size"))
-	createAssetReq.GroupID, _ = strconv.ParseInt(c.Query("group_id"), 10, 64)
-	createAssetReq.ExtraID = c.Query("extra_id")
+	// 按套餐限制文件大小和同步的区域数
+	areaIds, code := checkUserPlanUpload(c.Request.Context(), user, createAssetReq.AssetSize, areaIds)
+	if code > 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
 
 	// 获取文件hash
 	hash, err := storage.CIDToHash(createAssetReq.AssetCID)
@@ -522,6 +545,19 @@ func CreateAssetHandler(c *gin.Context) {
 	}
 
 	if len(notExistsAids) == 0 {
+		addExistingUserAsset(c.Request.Context(), &model.UserAsset{
+			UserID:      userId,
+			Hash:        hash,
+			Cid:         createAssetReq.AssetCID,
+			AssetName:   createAssetReq.AssetName,
+			AssetType:   createAssetReq.AssetType,
+			CreatedTime: time.Now(),
+			TotalSize:   createAssetReq.AssetSize,
+			Password:    randomPassNonce,
+			GroupID:     int64(createAssetReq.GroupID),
+			ExtraID:     createAssetReq.ExtraID,
+		})
+
 		var directUrl string
 		schedulerClient, err := getSchedulerClient(c.Request.Context(), areaIds[0])
 		if err == nil {
@@ -600,17 +636,18 @@ func CreateAssetHandler(c *gin.Context) {
 			return
 		}
 	}
-	// 判断是否需要同步调度器信息
-	if len(notExistsAids) > 0 {
-		err = oprds.GetClient().PushSchedulerInfo(c.Request.Context(), &oprds.Payload{UserID: userId, CID: createAssetReq.AssetCID, Hash: hash, AreaID: areaIds[0]})
-		if err != nil {
-			log.Errorf("PushSchedulerInfo error: %v", err)
-		}
-	}
-
 	// aids, _ := syncShedulers(c.Request.Context(), schedulerClient, createAssetReq.NodeID, createAssetReq.AssetCID, createAssetReq.AssetSize, areaIds)
 	// aids = append(aids, areaIds[0])
 
+	// 先保存数据密钥, 文件记录创建失败时重试会覆盖
+	if createAssetReq.WrappedKey != "" {
+		if err = saveWrappedAssetKey(c.Request.Context(), userId, hash, &createAssetReq); err != nil {
+			log.Errorf("SaveUserAssetKey error: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
+		}
+	}
+
 	if err := dao.AddAssetAndUpdateSize(c.Request.Context(), &model.UserAsset{
 		UserID:      userId,
 		Hash:        hash,
@@ -627,6 +664,14 @@ func CreateAssetHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
 		return
 	}
+	// 同步到其他区域
+	if err = EnqueueUserAssetSync(c.Request.Context(), userId, userId, createAssetReq.AssetCID, hash, areaIds[0], notExistsAids); err != nil {
+		log.Errorf("EnqueueUserAssetSync error: %v", err)
+	}
+	addAssetVersion(c.Request.Context(), userId, hash)
+	if createAssetRsp.AlreadyExists {
+		addAssetDedupHit(c.Request.Context(), userId, hash, areaIds[0], createAssetReq.AssetSize)
+	}
 
 	rsp := make([]JsonObject, len(createAssetRsp.List))
 	if !createAssetRsp.AlreadyExists {
@@ -651,9 +696,12 @@ type createAssetRequest struct {
 	AssetSize int64    `json:"asset_size" binding:"required"`
 	GroupID   int64    `json:"group_id"`
 	Encrypted bool     `json:"encrypted"`
-	MD5       string   `json:"md5"`
-	ExtraID   string   `json:"extra_id"`
-	NeedTrace bool     `json:"need_trace"`
+	// WrappedKey 客户端加密时, 用主密钥公钥加密的数据密钥
+	WrappedKey string `json:"wrapped_key"`
+	KeyVersion int64  `json:"key_version"`
+	MD5        string `json:"md5"`
+	ExtraID    string `json:"extra_id"`
+	NeedTrace  bool   `json:"need_trace"`
 }
 
 // CreateAssetPostHandler 创建文件
@@ -698,8 +746,20 @@ func CreateAssetPostHandler(c *gin.Context) {
 		return
 	}
 
-	// 判断上传文件是否需要加密
-	if c.Query("encrypted") == "true" {
+	// 按套餐限制文件大小和同步的区域数
+	areaIds, code := checkUserPlanUpload(c.Request.Context(), user, createAssetReq.AssetSize, areaIds)
+	if code > 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
+
+	// 判断上传文件是否需要加密, 客户端加密的文件只保存数据密钥
+	if createAssetReq.WrappedKey != "" {
+		if code := checkWrappedAssetKey(c.Request.Context(), username, &createAssetReq); code > 0 {
+			c.JSON(http.StatusOK, respErrorCode(code, c))
+			return
+		}
+	} else if c.Query("encrypted") == "true" {
 		passKey := fmt.Sprintf(FileUploadPassKey, username)
 		randomPassNonce = dao.RedisCache.Get(c.Request.Context(), passKey).Val()
 		if randomPassNonce == "" {
@@ -726,6 +786,21 @@ func CreateAssetPostHandler(c *gin.Context) {
 	}
 	notExistsAids = getUnSyncAreas(c.Request.Context(), username, createAssetReq.AssetCID, hash, areaIds, notExistsAids)
 	if len(notExistsAids) == 0 {
+		addExistingUserAsset(c.Request.Context(), &model.UserAsset{
+			UserID:      username,
+			Hash:        hash,
+			Cid:         createAssetReq.AssetCID,
+			AssetName:   createAssetReq.AssetName,
+			AssetType:   createAssetReq.AssetType,
+			CreatedTime: time.Now(),
+			TotalSize:   createAssetReq.AssetSize,
+			Password:    randomPassNonce,
+			GroupID:     int64(createAssetReq.GroupID),
+			MD5:         createAssetReq.MD5,
+			ExtraID:     createAssetReq.ExtraID,
+			ClientIP:    clientIP,
+		})
+
 		var directUrl string
 		schedulerClient, err := getSchedulerClient(c.Request.Context(), areaIds[0])
 		if err == nil {
@@ -810,11 +885,12 @@ func CreateAssetPostHandler(c *gin.Context) {
 		}
 	}
 
-	// 判断是否需要同步调度器信息
-	if len(notExistsAids) > 0 {
-		err = oprds.GetClient().PushSchedulerInfo(c.Request.Context(), &oprds.Payload{UserID: username, CID: createAssetReq.AssetCID, Hash: hash, AreaID: areaIds[0], Owner: username})
-		if err != nil {
-			log.Errorf("PushSchedulerInfo error: %v", err)
+	// 先保存数据密钥, 文件记录创建失败时重试会覆盖
+	if createAssetReq.WrappedKey != "" {
+		if err = saveWrappedAssetKey(c.Request.Context(), username, hash, &createAssetReq); err != nil {
+			log.Errorf("SaveUserAssetKey error: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
 		}
 	}
 
@@ -836,6 +912,14 @@ func CreateAssetPostHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
 		return
 	}
+	// 同步到其他区域
+	if err = EnqueueUserAssetSync(c.Request.Context(), username, username, createAssetReq.AssetCID, hash, areaIds[0], notExistsAids); err != nil {
+		log.Errorf("EnqueueUserAssetSync error: %v", err)
+	}
+	addAssetVersion(c.Request.Context(), username, hash)
+	if createAssetRsp.AlreadyExists {
+		addAssetDedupHit(c.Request.Context(), username, hash, areaIds[0], createAssetReq.AssetSize)
+	}
 
 	rsp := make([]JsonObject, len(createAssetRsp.List))
 	if !createAssetRsp.AlreadyExists {
@@ -863,52 +947,46 @@ func CreateAssetFromIPFSHandler(c *gin.Context) {
 
 }
 
-func ExportAssetToIPFSHandler(c *gin.Context) {
-
-}
-
-// func FilePassNonceHandler(c *gin.Context) {
+// FilePassNonceKey 钱包签名验证的随机数, 参数为用户和时间戳
+const FilePassNonceKey = "TITAN::FILE::PASS::NONCE::%s::%s"
 
-// 	pass := rand.Reader
-// 	claims := jwt.ExtractClaims(c)
-// 	userId := claims[identityKey].(string)
+// FilePassVerifiedKey 钱包签名验证通过的标记, 轮换用钱包签名加密的主密钥前需要先验证
+const FilePassVerifiedKey = "TITAN::FILE::PASS::VERIFIED::%s"
 
-// 	if pass == "" {
-// 		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
-// 		return
-// 	}
-
-// 	nonce := rsa.EncryptPassWithSalt(pass)
-
-// 	_, err := dao.RedisCache.SetEx(c.Request.Context(), userId+ts, nonce, 60*time.Minute).Result()
-// 	if err != nil {
-// 		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
-// 	}
+// FilePassNonceHandler 获取需要用钱包签名的随机数
+// @Summary 获取钱包签名的随机数
+// @Security ApiKeyAuth
+// @Tags storage
+// @Success 200 {object} JsonObject "{nonce:"",ts:""}"
+// @Router /api/v1/storage/file_pass/nonce [get]
+func FilePassNonceHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
 
-// 	c.JSON(http.StatusOK, respJSON(JsonObject{
-// 		"nonce": nonce,
-// 	}))
-// }
+	ts := strconv.FormatInt(time.Now().UnixNano(), 10)
+	nonce := rsa.EncryptPassWithSalt(userId + ts)
 
-// ----- upload process --------
-// 1. pass + timestamp -> nonce
-// 2. metamask + nonce -> signature
-// 3. signature + timestamp -> verify
-// 4. get_upload_node_info + signature + timestamp -> url + token
-// 5. source + token -> L1 -> cid
-// 6. cid + node_id + signature -> create_asset -> nonce + asset -> db
+	_, err := dao.RedisCache.SetEx(c.Request.Context(), fmt.Sprintf(FilePassNonceKey, userId, ts), nonce, 10*time.Minute).Result()
+	if err != nil {
+		log.Errorf("FilePassNonceHandler SetEx error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
 
-// ----- share process --------
-// 1. expire_time + asset -> token link
-//
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"nonce": nonce,
+		"ts":    ts,
+	}))
+}
 
-// ----- upload process --------
-//  1. encrypted ? -> get_upload_info
-//     yes -> randomPassNonce -> token -> savePass2redis
-//     no -> upload_file_without_encryption
-//  2. upload with token
-//
-// 3.
+// FilePassVerifyHandler 验证钱包对随机数的签名, 签名地址需要是用户绑定的钱包
+// @Summary 验证钱包签名
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param ts query string true "获取随机数时返回的 ts"
+// @Param signature query string true "签名"
+// @Success 200 {object} JsonObject "{msg:"success"}"
+// @Router /api/v1/storage/file_pass/verify [get]
 func FilePassVerifyHandler(c *gin.Context) {
 	ts := c.Query("ts")
 	signature := c.Query("signature")
@@ -921,20 +999,39 @@ func FilePassVerifyHandler(c *gin.Context) {
 		return
 	}
 
-	nonce, err := dao.RedisCache.Get(c.Request.Context(), userId+ts).Result()
+	nonceKey := fmt.Sprintf(FilePassNonceKey, userId, ts)
+	nonce, err := dao.RedisCache.Get(c.Request.Context(), nonceKey).Result()
+	if err == redis.Nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidSignature, c))
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
 		return
 	}
+	// 随机数只能使用一次
+	dao.RedisCache.Del(c.Request.Context(), nonceKey)
 
-	addr, err := rsa.VerifyAddrSign(nonce, signature)
+	addr, err := verifyWalletSign(nonce, signature)
 	if err != nil {
 		c.JSON(http.StatusOK, respErrorCode(errors.InvalidSignature, c))
 		return
 	}
 
-	if addr != userId {
-		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+	user, err := dao.GetUserByUsername(c.Request.Context(), userId)
+	if err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.UserNotFound, c))
+		return
+	}
+
+	if !strings.EqualFold(addr, userId) && !strings.EqualFold(addr, user.WalletAddress) {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidSignature, c))
+		return
+	}
+
+	_, err = dao.RedisCache.SetEx(c.Request.Context(), fmt.Sprintf(FilePassVerifiedKey, userId), addr, 10*time.Minute).Result()
+	if err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
 		return
 	}
 
@@ -943,6 +1040,16 @@ func FilePassVerifyHandler(c *gin.Context) {
 	}))
 }
 
+// verifyWalletSign 校验签名, 签名格式错误时返回错误而不是 panic
+func verifyWalletSign(nonce, signature string) (addr string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid signature: %v", r)
+		}
+	}()
+	return rsa.VerifyAddrSign(nonce, signature)
+}
+
 // CreateKeyHandler 创建key
 // @Summary 创建key
 // @Description 创建key
@@ -1033,8 +1140,9 @@ func DeleteKeyHandler(c *gin.Context) {
 // @Description 删除文件
 // @Security ApiKeyAuth
 // @Tags storage
-// @Param area_id query string false "节点区域"
+// @Param area_id query string false "节点区域, 不传时文件放入回收站"
 // @Param asset_cid query string true "文件cid"
+// @Param group_id query int false "只删除该文件组中的副本"
 // @Success 200 {object} JsonObject "{msg:""}"
 // @Router /api/v1/storage/delete_asset [get]
 func DeleteAssetHandler(c *gin.Context) {
@@ -1057,6 +1165,26 @@ func DeleteAssetHandler(c *gin.Context) {
 	// 获取文件信息
 	assetInfo, _ := dao.GetUserAsset(c.Request.Context(), hash, userID)
 
+	// 没有指定区域时放入回收站, 彻底删除时再从调度器删除
+	if len(areaIds) == 0 {
+		if gid, ok := c.GetQuery("group_id"); ok {
+			groupID, _ := strconv.ParseInt(gid, 10, 64)
+			err = dao.MoveAssetCopiesToTrash(c.Request.Context(), userID, []string{hash}, groupID, trashExpireAt())
+		} else {
+			err = dao.MoveAssetsToTrash(c.Request.Context(), userID, []string{hash}, trashExpireAt())
+		}
+		if err != nil {
+			log.Errorf("api DeleteAsset: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
+		}
+		notifyTenantAssetDelete(c.Request.Context(), userID, assetInfo)
+		c.JSON(http.StatusOK, respJSON(JsonObject{
+			"msg": "delete success",
+		}))
+		return
+	}
+
 	areaIds, isNeedDel, err := dao.CheckUserAseetNeedDel(c.Request.Context(), hash, userID, areaIds)
 	if err != nil {
 		log.Errorf("get areaIds error: %+v", err)
@@ -1118,41 +1246,69 @@ func DeleteAssetHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
 		return
 	}
-
-	// handle tenant asset delete callback
-	{
-		if assetInfo == nil || assetInfo.ExtraID == "" {
-			log.Infof("GetUserAsset() error: %+v or assetInfo != nil or assetInfo.ExtraID is empty", err)
-			goto End
+	if isNeedDel {
+		// 文件已从所有区域删除, 回收站中的副本也一起删除
+		if err = dao.DeleteAssetTrashByHash(c.Request.Context(), userID, hash); err != nil {
+			log.Errorf("DeleteAssetTrashByHash: %v", err)
 		}
-
-		userInfo, err := dao.GetUserByUsername(c.Request.Context(), userID)
-		if err != nil || userInfo == nil || userInfo.TenantID == "" {
-			log.Errorf("GetUserByUsername() error: %+v or userInfo == nil or userInfo.TenantID is empty", err)
-			goto End
+		if err = dao.DeleteUserAssetKeys(c.Request.Context(), userID, hash); err != nil {
+			log.Errorf("DeleteUserAssetKeys: %v", err)
 		}
-
-		tenantInfo, err := dao.GetTenantByBuilder(c.Request.Context(), squirrel.Select("*").Where("tenant_id=?", userInfo.TenantID))
-		if err != nil || tenantInfo == nil || tenantInfo.ApiKey == nil || tenantInfo.DeleteNotifyUrl == "" {
-			log.Errorf("GetTenantByBuilder() error: %+v or  tenantInfo != nil or tenantInfo.ApiKey == nil or tenantInfo.UploadNotifyUrl is empty", err)
-			goto End
+		if err = purgeAssetVersions(c.Request.Context(), userID, hash); err != nil {
+			log.Errorf("purgeAssetVersions: %v", err)
 		}
-
-		opasynq.DefaultCli.EnqueueAssetDeleteNotify(c.Request.Context(), opasynq.AssetDeleteNotifyPayload{
-			ExtraID:  assetInfo.ExtraID,
-			TenantID: tenantInfo.TenantID,
-			UserID:   userID,
-
-			AssetCID: assetInfo.Cid,
-		})
 	}
 
-End:
+	notifyTenantAssetDelete(c.Request.Context(), userID, assetInfo)
+
 	c.JSON(http.StatusOK, respJSON(JsonObject{
 		"msg": msg,
 	}))
 }
 
+// addExistingUserAsset 文件的区域都已存在但用户看不到该文件 (文件在回收站或历史版本中) 时直接补上文件记录
+func addExistingUserAsset(ctx context.Context, asset *model.UserAsset) {
+	_, err := dao.GetUserAsset(ctx, asset.Hash, asset.UserID)
+	if err != sql.ErrNoRows {
+		if err != nil {
+			log.Errorf("GetUserAsset error: %v", err)
+		}
+		return
+	}
+	if err = dao.AddUserAssetRecord(ctx, asset); err != nil {
+		log.Errorf("AddUserAssetRecord error: %v", err)
+		return
+	}
+	addAssetVersion(ctx, asset.UserID, asset.Hash)
+}
+
+// notifyTenantAssetDelete 租户用户的文件删除后回调通知租户
+func notifyTenantAssetDelete(ctx context.Context, userID string, assetInfo *model.UserAsset) {
+	if assetInfo == nil || assetInfo.ExtraID == "" {
+		return
+	}
+
+	userInfo, err := dao.GetUserByUsername(ctx, userID)
+	if err != nil || userInfo == nil || userInfo.TenantID == "" {
+		log.Errorf("GetUserByUsername() error: %+v or userInfo == nil or userInfo.TenantID is empty", err)
+		return
+	}
+
+	tenantInfo, err := dao.GetTenantByBuilder(ctx, squirrel.Select("*").Where("tenant_id=?", userInfo.TenantID))
+	if err != nil || tenantInfo == nil || tenantInfo.ApiKey == nil || tenantInfo.DeleteNotifyUrl == "" {
+		log.Errorf("GetTenantByBuilder() error: %+v or  tenantInfo != nil or tenantInfo.ApiKey == nil or tenantInfo.UploadNotifyUrl is empty", err)
+		return
+	}
+
+	opasynq.DefaultCli.EnqueueAssetDeleteNotify(ctx, opasynq.AssetDeleteNotifyPayload{
+		ExtraID:  assetInfo.ExtraID,
+		TenantID: tenantInfo.TenantID,
+		UserID:   userID,
+
+		AssetCID: assetInfo.Cid,
+	})
+}
+
 // ShareAssetsHandler 分享文件
 // @Summary 分享文件
 // @Description 分享文件
@@ -1195,6 +1351,11 @@ func ShareAssetsHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
 		return
 	}
+	// 被屏蔽的文件和被禁止分享的用户不能访问
+	if code := checkContentAccess(c.Request.Context(), hash, userId); code != 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
 	// 如果用户指定了区域，则先判断区域是否存在
 	if len(areaIds) > 0 {
 		exist, err := dao.CheckUserAssetIsInAreaID(c.Request.Context(), userId, hash, areaIds[0])
@@ -1237,7 +1398,7 @@ func ShareAssetsHandler(c *gin.Context) {
 			// return
 			areaId = areaIDs[0]
 		} else {
-			aids, _ := getAreaIDsByAreaID(c, areaIDs)
+			aids, _ := getAreaIDsByTransfer(c, areaIDs, dao.AssetTransferTypeDownload)
 			// areaId, err = GetNearestAreaID(c.Request.Context(), ip, areaIDs)
 			// if err != nil {
 			// 	log.Error(err)
@@ -1320,14 +1481,32 @@ func ShareAssetsHandler(c *gin.Context) {
 		urls[i] = fmt.Sprintf("%s&filename=%s", ret.URLs[i], url.QueryEscape(userAsset.AssetName))
 	}
 
-	// 成功的时候，下载量+1
-	oprds.GetClient().IncrAssetHourDownload(c.Request.Context(), hash, userId)
+	// 成功的时候，下载量+1, 开启下载网关时由网关在实际下载时计数
+	if !config.Cfg.Gateway.Enable {
+		oprds.GetClient().IncrAssetHourDownload(c.Request.Context(), hash, userId)
+		recordAssetAccess(c, userId, hash)
+	}
 
 	fileSize := getFileSize(cid, urls)
 	if fileSize == 0 {
 		fileSize = userAsset.TotalSize
 	}
 
+	// 开启下载网关时返回网关的签名地址
+	if config.Cfg.Gateway.Enable {
+		gatewayURL, _, err := newGatewayURL(c, userId, cid, 0, -1)
+		switch err {
+		case nil:
+			urls = []string{gatewayURL}
+		case errGatewayProxyRequired:
+			// 绑定ip的链接需要代理模式, 未开启时仍返回节点地址
+		default:
+			log.Errorf("newGatewayURL: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
+		}
+	}
+
 	c.PureJSON(http.StatusOK, respJSON(JsonObject{
 		"asset_cid":       cid,
 		"size":            fileSize,
@@ -1437,6 +1616,11 @@ func OpenAssetHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
 		return
 	}
+	// 被屏蔽的文件和被禁止分享的用户不能访问
+	if code := checkContentAccess(c.Request.Context(), hash, userId); code != 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
 
 	// 如果用户指定了区域，则先判断区域是否存在
 	if len(areaIds) > 0 {
@@ -1570,6 +1754,7 @@ func OpenAssetHandler(c *gin.Context) {
 
 	// 成功的时候，下载量+1
 	oprds.GetClient().IncrAssetHourDownload(c.Request.Context(), hash, userId)
+	recordAssetAccess(c, userId, hash)
 
 	c.JSON(http.StatusOK, respJSON(JsonObject{
 		"asset_cid":       cid,
@@ -1814,6 +1999,10 @@ func CreateShareLinkHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
 		return
 	}
+	if code := checkCIDAccess(c.Request.Context(), cid, username); code != 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
 
 	gid, _ := strconv.Atoi(cid)
 	if gid <= 0 {
@@ -2022,13 +2211,17 @@ func GetShareLinkHandler(c *gin.Context) {
 		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
 		return
 	}
-	link := dao.GetLongLink(c.Request.Context(), cid)
-	if link == "" {
+	link, err := dao.GetLink(c.Request.Context(), squirrel.Select("*").Where("cid = ?", cid))
+	if err != nil || link.LongLink == "" {
 		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
 		return
 	}
+	if code := checkCIDAccess(c.Request.Context(), link.Cid, link.UserName); code != 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
 	// 解码 URL
-	decodedLink, err := url.QueryUnescape(link)
+	decodedLink, err := url.QueryUnescape(link.LongLink)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode URL"})
 		return
@@ -2088,6 +2281,7 @@ type AccessOverview struct {
 	VisitCount       int64
 	RemainVisitCount int64
 	FilcoinCount     int64
+	Preview          *AssetPreviewInfo
 }
 
 func GetAssetListHandler(c *gin.Context) {
@@ -2130,6 +2324,7 @@ func GetAssetListHandler(c *gin.Context) {
 			FilcoinCount:     filReplicas,
 		})
 	}
+	fillAssetPreviews(c.Request.Context(), list)
 
 	c.JSON(http.StatusOK, respJSON(JsonObject{
 		"list":  list,
@@ -2358,6 +2553,11 @@ func GetAssetDetailHandler(c *gin.Context) {
 		log.Errorf("count fil storage: %v", err)
 	}
 
+	var assetPreview *AssetPreviewInfo
+	if p, err := dao.GetAssetPreview(c.Request.Context(), info.Hash); err == nil {
+		assetPreview = newAssetPreviewInfo(p)
+	}
+
 	c.JSON(http.StatusOK, respJSON(JsonObject{
 		"cid":               cid,
 		"cid_name":          info.AssetName,
@@ -2367,6 +2567,7 @@ func GetAssetDetailHandler(c *gin.Context) {
 		"fileCoin_count":    filReplicas,
 		"list":              mapList,
 		"total":             len(mapList),
+		"preview":           assetPreview,
 	}))
 }
 
@@ -2841,7 +3042,10 @@ func GetAssetGroupListHandler(c *gin.Context) {
 		return
 	}
 
-	var list []*AssetOrGroup
+	var (
+		list   []*AssetOrGroup
+		assets []*AccessOverview
+	)
 	for _, assetGroup := range assetSummary.List {
 		if assetGroup.AssetGroup != nil {
 			list = append(list, &AssetOrGroup{Group: assetGroup.AssetGroup})
@@ -2869,7 +3073,9 @@ func GetAssetGroupListHandler(c *gin.Context) {
 		}
 
 		list = append(list, &AssetOrGroup{AssetOverview: ao})
+		assets = append(assets, ao)
 	}
+	fillAssetPreviews(c.Request.Context(), assets)
 
 	c.JSON(http.StatusOK, respJSON(JsonObject{
 		"list":  list,
@@ -2911,6 +3117,7 @@ func GetAssetGroupInfoHandler(c *gin.Context) {
 		}
 
 		res.AssetOverview.FilcoinCount = filReplicas
+		fillAssetPreviews(c.Request.Context(), []*AccessOverview{res.AssetOverview})
 	}
 
 	groupid := c.Query("groupid")
@@ -2949,25 +3156,14 @@ func DeleteGroupHandler(c *gin.Context) {
 		return
 	}
 
-	// 数据库将最外层的文件组删除
-	err := dao.DeleteAssetGroupAndUpdateSize(c.Request.Context(), uid, gid)
+	// 文件组放入回收站, 彻底删除时再交给 asynq 递归删除
+	err := dao.MoveGroupsToTrash(c.Request.Context(), uid, []int64{int64(gid)}, trashExpireAt())
 	if err != nil {
-		log.Errorf("delete outer asset group error: %v", err)
+		log.Errorf("MoveGroupsToTrash error: %v", err)
 		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
 		return
 	}
-	// 将用户对应的文件组id塞到asynq中去处理
-	opasynq.DefaultCli.EnqueueAssetGroupID(c.Request.Context(), opasynq.AssetGroupPayload{UserID: uid, GroupID: []int64{int64(gid)}})
 
-	// err := dao.DeleteAssetGroup(c.Request.Context(), uid, gid)
-	// if err != nil {
-	// 	if webErr, ok := err.(*api.ErrWeb); ok {
-	// 		c.JSON(http.StatusOK, respErrorCode(webErr.Code, c))
-	// 	} else {
-	// 		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
-	// 	}
-	// 	return
-	// }
 	c.JSON(http.StatusOK, respJSON(JsonObject{
 		"msg": "success",
 	}))
@@ -3099,7 +3295,20 @@ func MoveAssetToGroupHandler(c *gin.Context) {
 		return
 	}
 
-	err = dao.UpdateAssetGroup(c.Request.Context(), userId, hash, groupId)
+	// 文件有多个副本时通过 source_group_id 指定移动哪一个
+	var source *int64
+	if v, ok := c.GetQuery("source_group_id"); ok {
+		gid, _ := strconv.ParseInt(v, 10, 64)
+		source = &gid
+	}
+	from, err := assetSourceGroup(c.Request.Context(), userId, hash, source)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+		return
+	}
+	if err == nil {
+		err = dao.UpdateAssetGroup(c.Request.Context(), userId, hash, from, groupId)
+	}
 	if err != nil {
 		log.Errorf("UpdateAssetGroup error: %v", err)
 		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
@@ -3223,6 +3432,10 @@ func MoveNode(c *gin.Context) {
 		return
 	}
 
+	claims := jwt.ExtractClaims(c)
+	operator, _ := claims[identityKey].(string)
+	addDeviceEvents(c.Request.Context(), dao.NewDeviceEvent(req.NodeID, "", dao.DeviceEventMoveArea, operator, req.FromAreaID, req.ToAreaID))
+
 	c.JSON(http.StatusOK, gin.H{
 		"msg": "success",
 	})
@@ -3303,6 +3516,12 @@ func AssetTransferReport(c *gin.Context) {
 
 	// set ip
 	req.Ip, _ = GetIPFromRequest(c.Request)
+	req.Country, req.Isp = "", ""
+	if req.Ip != "" {
+		if loc, err := geo.GetIpLocation(c.Request.Context(), req.Ip); err == nil {
+			req.Country, req.Isp = loc.Country, loc.Isp
+		}
+	}
 
 	// set area
 	for _, v := range req.Details {
@@ -3342,6 +3561,13 @@ func AssetTransferReport(c *gin.Context) {
 		return
 	}
 
+	// 上传完成后生成预览
+	if req.State == dao.AssetTransferStateSuccess && req.UserId != "" && req.TransferType == dao.AssetTransferTypeUpload {
+		if ua, err := dao.GetUserAsset(c.Request.Context(), req.Hash, req.UserId); err == nil {
+			enqueueAssetPreview(c.Request.Context(), ua)
+		}
+	}
+
 	if req.State == dao.AssetTransferStateSuccess && req.UserId != "" {
 		assetInfo, err := dao.GetUserAsset(c.Request.Context(), req.Hash, req.UserId)
 		if err != nil || assetInfo == nil || assetInfo.ExtraID == "" {
@@ -3488,6 +3714,11 @@ func GetShareGroupInfo(c *gin.Context) {
 	page, _ := strconv.Atoi(c.Query("page"))
 	parentId, _ := strconv.Atoi(c.Query("group_id"))
 
+	if code := checkCIDAccess(c.Request.Context(), c.Query("group_id"), userId); code != 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
+
 	assetSummary, err := listAssetSummary(c.Request.Context(), userId, parentId, page, pageSize)
 	if err != nil {
 		if webErr, ok := err.(*api.ErrWeb); ok {
@@ -3499,7 +3730,27 @@ func GetShareGroupInfo(c *gin.Context) {
 		return
 	}
 
-	var list []*AssetOrGroup
+	// 被屏蔽的文件不出现在分享的列表中
+	var hashes []string
+	for _, assetGroup := range assetSummary.List {
+		if assetGroup.AssetOverview != nil {
+			if hash, err := storage.CIDToHash(assetGroup.AssetOverview.AssetRecord.CID); err == nil {
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+	blocked, err := dao.GetBlockedHashes(c.Request.Context(), hashes)
+	if err != nil {
+		log.Errorf("GetBlockedHashes: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	var (
+		list   []*AssetOrGroup
+		assets []*AccessOverview
+	)
+	total := assetSummary.Total
 	for _, assetGroup := range assetSummary.List {
 		if assetGroup.AssetGroup != nil {
 			list = append(list, &AssetOrGroup{Group: assetGroup.AssetGroup})
@@ -3507,6 +3758,10 @@ func GetShareGroupInfo(c *gin.Context) {
 		}
 
 		asset := assetGroup.AssetOverview
+		if hash, _ := storage.CIDToHash(asset.AssetRecord.CID); blocked[hash] {
+			total--
+			continue
+		}
 		filReplicas, err := dao.CountFilStorage(c.Request.Context(), asset.AssetRecord.CID)
 		if err != nil {
 			log.Errorf("count fil storage: %v", err)
@@ -3527,7 +3782,9 @@ func GetShareGroupInfo(c *gin.Context) {
 		}
 
 		list = append(list, &AssetOrGroup{AssetOverview: ao})
+		assets = append(assets, ao)
 	}
+	fillAssetPreviews(c.Request.Context(), assets)
 
 	info, err := dao.GetUserAssetGroupInfo(c.Request.Context(), userId, parentId)
 	if err != nil {
@@ -3542,7 +3799,7 @@ func GetShareGroupInfo(c *gin.Context) {
 			"size": info.AssetSize,
 			"id":   info.ID,
 		},
-		"total": assetSummary.Total,
+		"total": total,
 	}))
 }
 
@@ -0,0 +1,494 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/config"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/gnasnik/titan-explorer/core/opasynq"
+)
+
+const (
+	// maxFilBackupClaim 备份程序每次最多领取的任务数
+	maxFilBackupClaim = 100
+	// maxFilBackupRetry 备份失败后自动重试的次数
+	maxFilBackupRetry = 3
+	// filBackupPackTimeout 备份程序超过该时间未返回结果时任务重新排队
+	filBackupPackTimeout = 24 * time.Hour
+	// filBackupDealTimeout 打包完成后超过该时间订单仍未上链时任务标记为失败
+	filBackupDealTimeout = 7 * 24 * time.Hour
+)
+
+// SyncFilBackupJobs 为需要备份的文件创建任务, 并把超时和可重试的任务重新排队
+func SyncFilBackupJobs(ctx context.Context) error {
+	assets, _, err := dao.GetAssetsByEmptyPath(ctx)
+	if err != nil {
+		return fmt.Errorf("get backup assets: %w", err)
+	}
+	if err = dao.CreateFilBackupJobs(ctx, assets); err != nil {
+		return fmt.Errorf("create backup jobs: %w", err)
+	}
+	if _, err = dao.ResetStaleFilBackupJobs(ctx, time.Now().Add(-filBackupPackTimeout)); err != nil {
+		return fmt.Errorf("reset stale backup jobs: %w", err)
+	}
+	if _, err = dao.RetryFailedFilBackupJobs(ctx, maxFilBackupRetry); err != nil {
+		return fmt.Errorf("retry failed backup jobs: %w", err)
+	}
+	return nil
+}
+
+// CheckFilBackupDeals 检查所有备份任务的订单, 更新到期提醒和续期状态
+func CheckFilBackupDeals(ctx context.Context) error {
+	return checkFilBackupDeals(ctx, nil)
+}
+
+// checkFilBackupDeals 根据订单更新任务状态, paths 为空时检查所有任务
+func checkFilBackupDeals(ctx context.Context, paths []string) error {
+	now := time.Now()
+	if err := dao.RefreshFilBackupJobDeals(ctx, paths, now); err != nil {
+		return fmt.Errorf("refresh deals: %w", err)
+	}
+
+	jobs, err := dao.ListFilBackupJobsByStates(ctx, dao.FilBackupDealStates, paths)
+	if err != nil {
+		return fmt.Errorf("list jobs: %w", err)
+	}
+
+	alertDays, renewDays := config.Cfg.FilBackupAlertDays, config.Cfg.FilBackupRenewDays
+	if alertDays <= 0 {
+		alertDays = 30
+	}
+	if renewDays <= 0 {
+		renewDays = 14
+	}
+
+	for _, job := range jobs {
+		state, alert := nextFilBackupState(job, now, alertDays, renewDays)
+		if state == dao.FilBackupStateFailed {
+			// 订单迟迟未上链, 标记失败后由 SyncFilBackupJobs 重新打包, 超过重试次数后需要人工处理
+			log.Warnf("filecoin deals of %s (path %s) not found after %s, deal end time %s", job.Cid, job.Path, filBackupDealTimeout, job.DealEndTime.Format("2006-01-02 15:04:05"))
+			if err = dao.FailFilBackupJob(ctx, job.Cid, "deal timeout"); err != nil {
+				log.Errorf("FailFilBackupJob %d: %v", job.ID, err)
+			}
+			continue
+		}
+		if alert {
+			log.Warnf("filecoin deals of %s (path %s) will expire at %s", job.Cid, job.Path, job.DealEndTime.Format("2006-01-02 15:04:05"))
+		}
+		if state == job.State && !alert {
+			continue
+		}
+		if err = dao.SetFilBackupJobState(ctx, job.ID, state, alert); err != nil {
+			log.Errorf("SetFilBackupJobState %d: %v", job.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// nextFilBackupState 根据有效订单计算任务的下一个状态, 进入到期提醒时 alert 为 true
+func nextFilBackupState(job *model.FilBackupJob, now time.Time, alertDays, renewDays int) (string, bool) {
+	renewAt := now.AddDate(0, 0, renewDays)
+	switch {
+	case job.State == dao.FilBackupStateRenewing:
+		// 续期中的任务等待备份程序重新打包
+		return job.State, false
+	case job.State == dao.FilBackupStateDealing && (job.DealCount == 0 || job.DealEndTime.Before(renewAt)):
+		// 等待新的订单上链, 订单结束时间移出续期窗口前不再重新续期, 超时仍未上链时标记失败
+		if job.DispatchedAt.Before(now.Add(-filBackupDealTimeout)) {
+			return dao.FilBackupStateFailed, true
+		}
+		return job.State, false
+	case job.DealCount == 0:
+		return dao.FilBackupStateExpired, false
+	case job.DealEndTime.Before(renewAt):
+		return dao.FilBackupStateRenewing, false
+	case job.DealEndTime.Before(now.AddDate(0, 0, alertDays)):
+		return dao.FilBackupStateExpiring, job.State != dao.FilBackupStateExpiring
+	default:
+		return dao.FilBackupStateActive, false
+	}
+}
+
+// GetFilBackupHandler 获取文件的 Filecoin 备份状态和有效订单
+// @Summary 获取文件的 Filecoin 备份状态
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param cid query string true "文件cid"
+// @Success 200 {object} JsonObject "{job:model.FilBackupJob,deals:[]model.FilStorage}"
+// @Router /api/v1/storage/filecoin/backup [get]
+func GetFilBackupHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	cid := c.Query("cid")
+	if cid == "" {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	job, err := dao.GetFilBackupJobByCID(c.Request.Context(), cid)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.FilBackupNotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetFilBackupJobByCID error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	// 只能查看自己存储或归档过的文件
+	owned := true
+	_, err = dao.GetUserAsset(c.Request.Context(), job.Hash, userId)
+	if err == sql.ErrNoRows {
+		owned, err = dao.IsAssetBackupRequester(c.Request.Context(), userId, cid)
+	}
+	if err != nil {
+		log.Errorf("check backup owner error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if !owned {
+		c.JSON(http.StatusOK, respErrorCode(errors.FilBackupNotFound, c))
+		return
+	}
+
+	deals := make([]*model.FilStorage, 0)
+	if job.Path != "" {
+		deals, err = dao.GetFilDealsByPath(c.Request.Context(), job.Path, time.Now())
+		if err != nil {
+			log.Errorf("GetFilDealsByPath error: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"job":   job,
+		"deals": deals,
+	}))
+}
+
+// FilRestoreReq 从 Filecoin 恢复文件
+type FilRestoreReq struct {
+	Cid     string `json:"cid" binding:"required"`
+	AreaID  string `json:"area_id"`
+	GroupID int64  `json:"group_id"`
+}
+
+// CreateFilRestoreHandler 从 Filecoin 恢复文件到指定区域, 只能恢复自己上传或归档过的文件
+// @Summary 从 Filecoin 恢复文件
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param req body FilRestoreReq true "恢复请求"
+// @Success 200 {object} JsonObject "{id:0}"
+// @Router /api/v1/storage/filecoin/restore [post]
+func CreateFilRestoreHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req FilRestoreReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.GroupID < 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	areaIds := getAreaIDsByArea(c, []string{req.AreaID})
+	if len(areaIds) == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	areaId := areaIds[0]
+
+	if req.GroupID > 0 {
+		if _, err := dao.GetUserAssetGroupInfo(c.Request.Context(), userId, int(req.GroupID)); err != nil {
+			c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+			return
+		}
+	}
+
+	job, err := dao.GetFilBackupJobByCID(c.Request.Context(), req.Cid)
+	if err != nil && err != sql.ErrNoRows {
+		log.Errorf("GetFilBackupJobByCID error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if err == sql.ErrNoRows || job.Path == "" || job.DealCount == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.FilBackupNotFound, c))
+		return
+	}
+
+	name := req.Cid
+	ua, err := dao.GetUserAsset(c.Request.Context(), job.Hash, userId)
+	switch err {
+	case nil:
+		name = ua.AssetName
+		areas, err := dao.GetUserAssetAreaIDs(c.Request.Context(), job.Hash, userId)
+		if err != nil {
+			log.Errorf("GetUserAssetAreaIDs error: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
+		}
+		for _, a := range areas {
+			if a == areaId {
+				c.JSON(http.StatusOK, respErrorCode(errors.FileExists, c))
+				return
+			}
+		}
+	case sql.ErrNoRows:
+		ok, err := dao.IsAssetBackupRequester(c.Request.Context(), userId, req.Cid)
+		if err != nil {
+			log.Errorf("IsAssetBackupRequester error: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusOK, respErrorCode(errors.FilBackupNotFound, c))
+			return
+		}
+	default:
+		log.Errorf("GetUserAsset error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	r := &model.FilRestoreRequest{
+		UserID:    userId,
+		Cid:       req.Cid,
+		Hash:      job.Hash,
+		AreaID:    areaId,
+		GroupID:   req.GroupID,
+		Name:      name,
+		State:     dao.FilRestoreStatePending,
+		CreatedAt: time.Now(),
+	}
+	added, err := dao.AddFilRestoreRequest(c.Request.Context(), r)
+	if err != nil {
+		log.Errorf("AddFilRestoreRequest error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if !added {
+		c.JSON(http.StatusOK, respErrorCode(errors.FilRestoreExists, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"id": r.ID,
+	}))
+}
+
+// ListFilRestoreHandler 获取用户的恢复请求, 导入中的请求同时返回导入 IPFS 的状态
+// @Summary 获取 Filecoin 恢复请求
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param page query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} JsonObject "{list:[]dao.FilRestoreRequestInfo,total:0}"
+// @Router /api/v1/storage/filecoin/restore [get]
+func ListFilRestoreHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	total, list, err := dao.ListUserFilRestoreRequests(c.Request.Context(), userId, dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListUserFilRestoreRequests error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  list,
+		"total": total,
+	}))
+}
+
+// FilRestoreTask 交给备份程序的恢复任务
+type FilRestoreTask struct {
+	ID    int64               `json:"id"`
+	Cid   string              `json:"cid"`
+	Deals []*model.FilStorage `json:"deals"`
+}
+
+// GetPendingFilRestoreHandler 备份程序领取恢复任务, 从订单中检索文件并放到 IPFS 节点
+func GetPendingFilRestoreHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	list, err := dao.ClaimFilRestoreRequests(ctx, maxFilBackupClaim)
+	if err != nil {
+		log.Errorf("ClaimFilRestoreRequests: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	now := time.Now()
+	tasks := make([]*FilRestoreTask, 0, len(list))
+	for _, r := range list {
+		job, err := dao.GetFilBackupJobByCID(ctx, r.Cid)
+		if err != nil {
+			log.Errorf("GetFilBackupJobByCID %s: %v", r.Cid, err)
+			dao.UpdateFilRestoreRequestState(ctx, r.ID, dao.FilRestoreStateFailed, "backup job not found")
+			continue
+		}
+		deals, err := dao.GetFilDealsByPath(ctx, job.Path, now)
+		if err != nil || len(deals) == 0 {
+			log.Errorf("GetFilDealsByPath %s: %v", job.Path, err)
+			dao.UpdateFilRestoreRequestState(ctx, r.ID, dao.FilRestoreStateFailed, "no active deal")
+			continue
+		}
+		tasks = append(tasks, &FilRestoreTask{ID: r.ID, Cid: r.Cid, Deals: deals})
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  tasks,
+		"total": len(tasks),
+	}))
+}
+
+// FilRestoreResultReq 备份程序返回的恢复结果
+type FilRestoreResultReq struct {
+	ID      int64  `json:"id" binding:"required"`
+	Success bool   `json:"success"`
+	Reason  string `json:"reason"`
+}
+
+// FilRestoreResultHandler 备份程序检索完成后, 通过 IPFS 导入流程把文件同步到用户选择的区域
+func FilRestoreResultHandler(c *gin.Context) {
+	var req FilRestoreResultReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	ctx := c.Request.Context()
+	r, err := dao.GetFilRestoreRequest(ctx, req.ID)
+	if err == sql.ErrNoRows || (err == nil && r.State != dao.FilRestoreStateRetrieving) {
+		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetFilRestoreRequest: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	if !req.Success {
+		if err = dao.UpdateFilRestoreRequestState(ctx, r.ID, dao.FilRestoreStateFailed, req.Reason); err != nil {
+			log.Errorf("UpdateFilRestoreRequestState: %v", err)
+		}
+		c.JSON(http.StatusOK, respJSON(nil))
+		return
+	}
+
+	if err = importFilRestore(ctx, r); err != nil {
+		log.Errorf("import restore %d: %v", r.ID, err)
+		dao.UpdateFilRestoreRequestState(ctx, r.ID, dao.FilRestoreStateFailed, "import failed")
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(nil))
+}
+
+// importFilRestore 添加ipfs导入记录并排队
+func importFilRestore(ctx context.Context, r *model.FilRestoreRequest) error {
+	record, err := dao.RequeueIPFSRecord(ctx, model.SyncIPFSRecord{
+		Username:  r.UserID,
+		Name:      r.Name,
+		CID:       r.Cid,
+		GroupID:   r.GroupID,
+		AreaID:    r.AreaID,
+		Timestamp: time.Now().Unix(),
+	})
+	if err == sql.ErrNoRows {
+		// 相同的文件正在导入中
+		return dao.UpdateFilRestoreRequestState(ctx, r.ID, dao.FilRestoreStateImporting, "")
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = dao.UpdateFilRestoreRequestState(ctx, r.ID, dao.FilRestoreStateImporting, ""); err != nil {
+		return err
+	}
+	if err = opasynq.DefaultCli.EnqueueIPFSImport(ctx, opasynq.IPFSTaskPayload{ID: record.ID}); err != nil {
+		dao.UpdateIPFSRecordState(ctx, record.ID, dao.IPFSStateFailed, "enqueue failed")
+		return err
+	}
+	return nil
+}
+
+// AdminListFilBackupJobsHandler 获取 Filecoin 备份任务, 可按状态筛选, 按订单结束时间排序
+// @Summary 获取 Filecoin 备份任务
+// @Security ApiKeyAuth
+// @Tags admin
+// @Param state query string false "状态"
+// @Param page query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} JsonObject "{list:[]model.FilBackupJob,total:0}"
+// @Router /api/v1/admin/filecoin/backup_jobs [get]
+func AdminListFilBackupJobsHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	total, list, err := dao.ListFilBackupJobs(c.Request.Context(), c.Query("state"), dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListFilBackupJobs error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  list,
+		"total": total,
+	}))
+}
+
+// AdminRenewFilBackupJobsReq 手动续期的文件
+type AdminRenewFilBackupJobsReq struct {
+	Cids []string `json:"cids" binding:"required"`
+}
+
+// AdminRenewFilBackupJobsHandler 手动续期, 文件重新交给备份程序
+// @Summary 续期 Filecoin 备份
+// @Security ApiKeyAuth
+// @Tags admin
+// @Param req body AdminRenewFilBackupJobsReq true "文件cid"
+// @Success 200 {object} JsonObject "{count:0}"
+// @Router /api/v1/admin/filecoin/backup_jobs/renew [post]
+func AdminRenewFilBackupJobsHandler(c *gin.Context) {
+	var req AdminRenewFilBackupJobsReq
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Cids) == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	n, err := dao.RenewFilBackupJobs(c.Request.Context(), req.Cids)
+	if err != nil {
+		log.Errorf("RenewFilBackupJobs error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"count": n,
+	}))
+}
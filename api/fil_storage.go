@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/gnasnik/titan-explorer/config"
 	"github.com/gnasnik/titan-explorer/core/dao"
@@ -51,6 +52,14 @@ func CreateFilStorageHandler(c *gin.Context) {
 		return
 	}
 
+	paths := make([]string, 0, len(params))
+	for _, p := range params {
+		paths = append(paths, p.Path)
+	}
+	if err := checkFilBackupDeals(c.Request.Context(), paths); err != nil {
+		log.Errorf("checkFilBackupDeals: %v", err)
+	}
+
 	c.JSON(http.StatusOK, respJSON(nil))
 }
 
@@ -146,18 +155,34 @@ func SaveProviderLocation(providerId string) error {
 	})
 }
 
+// GetBackupAssetsHandler 备份程序领取需要备份或续期的文件
 func GetBackupAssetsHandler(c *gin.Context) {
 	ctx := context.Background()
-	assets, total, err := dao.GetAssetsByEmptyPath(ctx)
+	if err := SyncFilBackupJobs(ctx); err != nil {
+		log.Errorf("SyncFilBackupJobs: %v", err)
+	}
+
+	jobs, err := dao.ClaimFilBackupJobs(ctx, maxFilBackupClaim)
 	if err != nil {
-		log.Errorf("GetAssertsByEmptyPath: %v", err)
+		log.Errorf("ClaimFilBackupJobs: %v", err)
 		c.JSON(http.StatusInternalServerError, err.Error())
 		return
 	}
 
+	assets := make([]*model.Asset, 0, len(jobs))
+	for _, job := range jobs {
+		asset, err := dao.GetAssetByCID(ctx, job.Cid)
+		if err != nil {
+			log.Errorf("GetAssetByCID %s: %v", job.Cid, err)
+			dao.FailFilBackupJob(ctx, job.Cid, "asset not found")
+			continue
+		}
+		assets = append(assets, asset)
+	}
+
 	c.JSON(http.StatusOK, respJSON(JsonObject{
 		"list":  assets,
-		"total": total,
+		"total": len(assets),
 	}))
 }
 
@@ -174,6 +199,9 @@ func BackupResultHandler(c *gin.Context) {
 			if err != nil {
 				log.Errorf("update assets path: %v", err)
 			}
+			if err = dao.UpdateFilBackupJobPath(c.Request.Context(), assets.Cid, assets.Path); err != nil {
+				log.Errorf("update backup job path: %v", err)
+			}
 			continue
 		}
 
@@ -181,6 +209,16 @@ func BackupResultHandler(c *gin.Context) {
 		if err != nil {
 			log.Errorf("update assets backup result: %v", err)
 		}
+
+		// backup_result 为 1 表示需要重新备份, 其他值表示备份失败
+		if assets.BackupResult == 1 {
+			err = dao.RequeueFilBackupJob(c.Request.Context(), assets.Cid)
+		} else {
+			err = dao.FailFilBackupJob(c.Request.Context(), assets.Cid, fmt.Sprintf("backup result %d", assets.BackupResult))
+		}
+		if err != nil {
+			log.Errorf("update backup job: %v", err)
+		}
 	}
 
 	c.JSON(http.StatusOK, respJSON(nil))
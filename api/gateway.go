@@ -0,0 +1,408 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	ge "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Filecoin-Titan/titan/api/types"
+	"github.com/Filecoin-Titan/titan/node/cidutil"
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/config"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/oprds"
+	"github.com/gnasnik/titan-explorer/pkg/iptool"
+)
+
+const defaultGatewayTokenTTL = 600 * time.Second
+
+// 代理时透传给客户端的节点响应头
+var gatewayProxyHeaders = []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Content-Disposition", "ETag", "Last-Modified"}
+
+// gatewayToken 下载链接中签名的内容, End 为 -1 时表示到文件末尾
+type gatewayToken struct {
+	Cid     string `json:"c"`
+	UserID  string `json:"u"`
+	Expires int64  `json:"e"`
+	Start   int64  `json:"s"`
+	End     int64  `json:"n"`
+	IP      string `json:"ip,omitempty"`
+}
+
+func gatewayTokenTTL() time.Duration {
+	if config.Cfg.Gateway.TokenTTLSeconds > 0 {
+		return time.Duration(config.Cfg.Gateway.TokenTTLSeconds) * time.Second
+	}
+	return defaultGatewayTokenTTL
+}
+
+func signGatewayPayload(payload string) string {
+	mac := hmac.New(sha256.New, []byte(config.Cfg.SecretKey))
+	fmt.Fprintf(mac, "gateway:%s", payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// encodeGatewayToken 生成 payload.sign 格式的下载凭证
+func encodeGatewayToken(t *gatewayToken) (string, error) {
+	b, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + signGatewayPayload(payload), nil
+}
+
+// decodeGatewayToken 校验签名和有效期, 不通过时返回对应的错误码
+func decodeGatewayToken(token string) (*gatewayToken, int) {
+	payload, sign, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sign), []byte(signGatewayPayload(payload))) {
+		return nil, errors.GatewayTokenInvalid
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.GatewayTokenInvalid
+	}
+	var t gatewayToken
+	if err = json.Unmarshal(b, &t); err != nil {
+		return nil, errors.GatewayTokenInvalid
+	}
+	if t.Expires < time.Now().Unix() {
+		return nil, errors.GatewayTokenExpired
+	}
+	return &t, 0
+}
+
+// errGatewayProxyRequired 重定向到节点后无法再限制范围和ip, 这类链接只有代理模式支持
+var errGatewayProxyRequired = ge.New("range limited or ip bound link requires gateway proxy")
+
+// newGatewayURL 生成网关的签名下载地址
+func newGatewayURL(c *gin.Context, userID, cid string, start, end int64) (string, int64, error) {
+	if !config.Cfg.Gateway.Proxy && (start > 0 || end >= 0 || config.Cfg.Gateway.BindIP) {
+		return "", 0, errGatewayProxyRequired
+	}
+
+	t := &gatewayToken{
+		Cid:     cid,
+		UserID:  userID,
+		Expires: time.Now().Add(gatewayTokenTTL()).Unix(),
+		Start:   start,
+		End:     end,
+	}
+	if config.Cfg.Gateway.BindIP {
+		t.IP = iptool.GetClientIP(c.Request)
+	}
+
+	token, err := encodeGatewayToken(t)
+	if err != nil {
+		return "", 0, err
+	}
+	return fmt.Sprintf("%s/d/%s", config.Cfg.BaseURL, token), t.Expires, nil
+}
+
+// parseGatewayRange 解析单个 bytes 范围, 返回闭区间, 不支持多个范围
+func parseGatewayRange(header string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		return max(size-n, 0), size - 1, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end, true
+}
+
+// resolveGatewayURLs 选择下载最快的区域, 获取节点的下载地址
+func resolveGatewayURLs(c *gin.Context, userID, cid, hash, filePass, filename string) ([]string, error) {
+	areaIDs, err := dao.GetUserAssetAreaIDs(c.Request.Context(), hash, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(areaIDs) == 0 {
+		return nil, fmt.Errorf("asset is not synced")
+	}
+	if aids, _ := getAreaIDsByTransfer(c, areaIDs, dao.AssetTransferTypeDownload); len(aids) > 0 {
+		areaIDs = aids
+	}
+
+	schedulerClient, err := getSchedulerClient(c.Request.Context(), areaIDs[0])
+	if err != nil {
+		return nil, err
+	}
+	ret, err := schedulerClient.ShareAssetV2(c.Request.Context(), &types.ShareAssetReq{
+		UserID:   userID,
+		AssetCID: cid,
+		FilePass: filePass,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ret.URLs) == 0 {
+		return nil, fmt.Errorf("no download url")
+	}
+
+	urls := make([]string, len(ret.URLs))
+	for i := range ret.URLs {
+		urls[i] = fmt.Sprintf("%s&filename=%s", ret.URLs[i], url.QueryEscape(filename))
+	}
+	return urls, nil
+}
+
+// CreateGatewayURLHandler 获取文件的网关签名下载地址, 可以限制下载的字节范围
+func CreateGatewayURLHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	if !config.Cfg.Gateway.Enable {
+		c.JSON(http.StatusOK, respErrorCode(errors.GatewayDisabled, c))
+		return
+	}
+
+	cid := c.Query("asset_cid")
+	hash, err := cidutil.CIDToHash(cid)
+	if err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	start, end := int64(0), int64(-1)
+	if v := c.Query("range_start"); v != "" {
+		start, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || start < 0 {
+			c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+			return
+		}
+	}
+	if v := c.Query("range_end"); v != "" {
+		end, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || end < start {
+			c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+			return
+		}
+	}
+
+	if _, err = dao.GetUserAsset(c.Request.Context(), hash, username); err != nil {
+		log.Errorf("GetUserAsset: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+		return
+	}
+	if code := checkContentAccess(c.Request.Context(), hash, username); code != 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
+
+	u, expires, err := newGatewayURL(c, username, cid, start, end)
+	if err == errGatewayProxyRequired {
+		c.JSON(http.StatusOK, respErrorCode(errors.GatewayProxyRequired, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("newGatewayURL: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"url":     u,
+		"expires": expires,
+	}))
+}
+
+// GatewayDownloadHandler 校验签名下载地址, 按配置代理或重定向到节点
+func GatewayDownloadHandler(c *gin.Context) {
+	if !config.Cfg.Gateway.Enable {
+		c.JSON(http.StatusNotFound, respErrorCode(errors.GatewayDisabled, c))
+		return
+	}
+
+	t, code := decodeGatewayToken(c.Param("token"))
+	if code != 0 {
+		c.JSON(http.StatusForbidden, respErrorCode(code, c))
+		return
+	}
+	if t.IP != "" && t.IP != iptool.GetClientIP(c.Request) {
+		c.JSON(http.StatusForbidden, respErrorCode(errors.GatewayTokenInvalid, c))
+		return
+	}
+	// 节点地址不受签名的有效期, 范围和ip限制, 这类链接不能重定向
+	if !config.Cfg.Gateway.Proxy && (t.IP != "" || t.Start > 0 || t.End >= 0) {
+		c.JSON(http.StatusForbidden, respErrorCode(errors.GatewayProxyRequired, c))
+		return
+	}
+
+	ctx := c.Request.Context()
+	hash, err := cidutil.CIDToHash(t.Cid)
+	if err != nil {
+		c.JSON(http.StatusForbidden, respErrorCode(errors.GatewayTokenInvalid, c))
+		return
+	}
+	if code = checkContentAccess(ctx, hash, t.UserID); code != 0 {
+		c.JSON(http.StatusForbidden, respErrorCode(code, c))
+		return
+	}
+	if ok, err := checkUserTotalFlow(ctx, t.UserID); err == nil && !ok {
+		c.JSON(http.StatusForbidden, respErrorCode(errors.OutTotalFlow, c))
+		return
+	}
+
+	userAsset, err := dao.GetUserAssetDetail(ctx, hash, t.UserID)
+	if err != nil {
+		log.Errorf("GetUserAssetDetail: %v", err)
+		c.JSON(http.StatusNotFound, respErrorCode(errors.NotFound, c))
+		return
+	}
+
+	// 请求的范围必须在签名的范围内, 没有请求范围时使用签名的范围
+	size := userAsset.TotalSize
+	start, end := t.Start, size-1
+	if t.End >= 0 && t.End < end {
+		end = t.End
+	}
+	if start > end {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+		c.JSON(http.StatusRequestedRangeNotSatisfiable, respErrorCode(errors.GatewayRangeNotAllowed, c))
+		return
+	}
+	rangeHeader := c.GetHeader("Range")
+	if rangeHeader != "" {
+		rs, re, ok := parseGatewayRange(rangeHeader, size)
+		if !ok || rs < start || re > end {
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+			c.JSON(http.StatusRequestedRangeNotSatisfiable, respErrorCode(errors.GatewayRangeNotAllowed, c))
+			return
+		}
+		start, end = rs, re
+	}
+	if rangeHeader != "" || start > 0 || end < size-1 {
+		rangeHeader = fmt.Sprintf("bytes=%d-%d", start, end)
+	}
+
+	urls, err := resolveGatewayURLs(c, t.UserID, t.Cid, hash, userAsset.Password, userAsset.AssetName)
+	if err != nil {
+		log.Errorf("resolveGatewayURLs: %v", err)
+		c.JSON(http.StatusBadGateway, respErrorCode(errors.NoSchedulerFound, c))
+		return
+	}
+
+	// 从头开始的请求才算一次下载, 避免分段下载重复计数
+	if start == 0 {
+		oprds.GetClient().IncrAssetHourDownload(ctx, hash, t.UserID)
+		recordAssetAccess(c, t.UserID, hash)
+	}
+
+	if !config.Cfg.Gateway.Proxy {
+		c.Redirect(http.StatusFound, urls[0])
+		return
+	}
+
+	// 代理的字节由节点上报给调度器, 和直连下载一样计入每小时流量, 这里不再重复累加
+	if err = proxyGatewayDownload(c, urls, rangeHeader); err != nil {
+		log.Errorf("proxyGatewayDownload %s: %v", t.Cid, err)
+		if !c.Writer.Written() {
+			c.JSON(http.StatusBadGateway, respErrorCode(errors.InternalServer, c))
+		}
+	}
+}
+
+// proxyGatewayDownload 依次尝试节点地址, 把节点的响应转发给客户端
+func proxyGatewayDownload(c *gin.Context, urls []string, rangeHeader string) error {
+	err := fmt.Errorf("no download url")
+	for _, u := range urls {
+		req, rerr := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, u, nil)
+		if rerr != nil {
+			err = rerr
+			continue
+		}
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+
+		resp, rerr := http.DefaultClient.Do(req)
+		if rerr != nil {
+			err = rerr
+			continue
+		}
+		// 请求了范围时节点必须返回 206, 否则会把整个文件发给客户端
+		if (rangeHeader == "" && resp.StatusCode != http.StatusOK) || (rangeHeader != "" && resp.StatusCode != http.StatusPartialContent) {
+			resp.Body.Close()
+			err = fmt.Errorf("unexpected status %d from %s", resp.StatusCode, req.URL.Host)
+			continue
+		}
+
+		for _, h := range gatewayProxyHeaders {
+			if v := resp.Header.Get(h); v != "" {
+				c.Header(h, v)
+			}
+		}
+		c.Status(resp.StatusCode)
+		_, err = copyGatewayBody(c.Writer, resp.Body, config.Cfg.Gateway.MaxBytesPerSecond)
+		resp.Body.Close()
+		return err
+	}
+	return err
+}
+
+// copyGatewayBody 转发数据, limit 大于 0 时按每秒字节数限速
+func copyGatewayBody(w io.Writer, r io.Reader, limit int64) (int64, error) {
+	if limit <= 0 {
+		return io.Copy(w, r)
+	}
+
+	var (
+		written int64
+		begin   = time.Now()
+		buf     = make([]byte, 32*1024)
+	)
+	for {
+		nr, rerr := r.Read(buf)
+		if nr > 0 {
+			nw, werr := w.Write(buf[:nr])
+			written += int64(nw)
+			if werr != nil {
+				return written, werr
+			}
+			if wait := time.Duration(float64(written)/float64(limit)*float64(time.Second)) - time.Since(begin); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+		if rerr == io.EOF {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+}
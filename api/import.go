@@ -1,25 +1,41 @@
 package api
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/Filecoin-Titan/titan/api"
-	"github.com/Filecoin-Titan/titan/api/terrors"
 	"github.com/Filecoin-Titan/titan/api/types"
 	jwt "github.com/appleboy/gin-jwt/v2"
 	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/config"
 	"github.com/gnasnik/titan-explorer/core/dao"
 	"github.com/gnasnik/titan-explorer/core/errors"
 	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/gnasnik/titan-explorer/core/opasynq"
+	"github.com/gnasnik/titan-explorer/core/storage"
 	"github.com/gnasnik/titan-explorer/pkg/opfie"
 )
 
+const (
+	defaultIPFSAPI = "/ip4/39.108.214.29/tcp/5001"
+
+	// maxIPFSImportBlocks 导入时遍历 DAG 的块数上限
+	maxIPFSImportBlocks = 100000
+
+	// maxIPFSReasonLen 失败原因的长度上限, 与 reason 字段的 varchar(255) 一致
+	maxIPFSReasonLen = 255
+)
+
 var (
-	ipfsCli, _ = opfie.NewIPFSClient("/ip4/39.108.214.29/tcp/5001")
+	ipfsPool     *opfie.Pool
+	ipfsPoolErr  error
+	ipfsPoolOnce sync.Once
 )
 
 type (
@@ -29,16 +45,33 @@ type (
 		AreaID  []string `json:"area_id"`
 		GroupID int64    `json:"group_id"`
 	}
+
+	// ExportAssetToIPFSReq 导出文件到ipfs节点的请求
+	ExportAssetToIPFSReq struct {
+		AssetCID string `json:"asset_cid" binding:"required"`
+	}
 )
 
-// SyncIPFSInfoByCIDs 通过cid获取ipfs的信息
+// getIPFSPool 按配置的地址创建ipfs节点池
+func getIPFSPool() (*opfie.Pool, error) {
+	ipfsPoolOnce.Do(func() {
+		apis := config.Cfg.IPFSAPIs
+		if len(apis) == 0 {
+			apis = []string{defaultIPFSAPI}
+		}
+		ipfsPool, ipfsPoolErr = opfie.NewPool(apis)
+	})
+	return ipfsPool, ipfsPoolErr
+}
+
+// SyncIPFSInfoByCIDs 通过cid导入ipfs文件, 每个cid排队后异步解析、pin 并同步到区域
 // @Summary 导入ipfs文件
 // @Description 导入ipfs文件
 // @Security ApiKeyAuth
 // @Tags import
 // @Param req body GetIPFSInfoByCIDSReq true
-// @Success 200 {object} JsonObject "{[]{CandidateAddr:"",Token:""}}"
-// @Router /api/v1/storage/sync_ipfs [post]
+// @Success 200 {object} JsonObject "{list:[]}"
+// @Router /api/v1/storage/ipfs_info [post]
 func SyncIPFSInfoByCIDs(c *gin.Context) {
 	var (
 		req      GetIPFSInfoByCIDSReq
@@ -47,8 +80,7 @@ func SyncIPFSInfoByCIDs(c *gin.Context) {
 		irs      []model.SyncIPFSRecord
 		tnow     = time.Now().Unix()
 
-		cids      []string
-		totalSize int64
+		cids []string
 	)
 
 	err := c.ShouldBindJSON(&req)
@@ -63,78 +95,268 @@ func SyncIPFSInfoByCIDs(c *gin.Context) {
 		return
 	}
 	// 判断用户是否存在
-	user, err := dao.GetUserByUsername(c.Request.Context(), username)
+	_, err = dao.GetUserByUsername(c.Request.Context(), username)
 	switch err {
 	case sql.ErrNoRows:
 		c.JSON(http.StatusOK, respErrorCode(errors.UserNotFound, c))
 		return
 	case nil:
 	default:
-		log.Errorf("CreateAssetHandler dao.GetUserByUsername() error: %+v", err)
+		log.Errorf("SyncIPFSInfoByCIDs dao.GetUserByUsername() error: %+v", err)
 		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
 		return
 	}
 
 	// 处理cids，筛选掉用户已经存在的数据
-	cidList := strings.Split(req.CIDs, "\n")
-	for _, v := range cidList {
+	for _, v := range strings.Split(req.CIDs, "\n") {
 		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if _, err = storage.CIDToHash(v); err != nil {
+			c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+			return
+		}
 		cids = append(cids, v)
 	}
+	if len(cids) == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
 	ncids, err := dao.GetNoExistCIDs(c.Request.Context(), username, cids)
 	if err != nil {
 		log.Errorf("GetNoExistCIDs error: %v", err)
-		c.JSON(http.StatusOK, respErrorCode(int(terrors.UserStorageSizeNotEnough), c))
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if len(ncids) == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.FileExists, c))
 		return
 	}
+
 	for _, v := range ncids {
-		name := v
-		// 获取此次同步的文件全部大小
-		links, size, _ := ipfsCli.GetInfoByCID(c.Request.Context(), v)
-		if len(links) > 0 && links[0].Name != "" {
-			name = links[0].Name
-		}
-		totalSize += int64(size)
 		irs = append(irs, model.SyncIPFSRecord{Username: username, CID: v, Timestamp: tnow, AreaID: areaIds[0],
-			GroupID: req.GroupID, Size: int64(size), Name: name})
+			GroupID: req.GroupID, Name: v})
 	}
-	// 判断用户存储空间是否够用
-	if user.TotalStorageSize-user.UsedStorageSize < totalSize {
-		c.JSON(http.StatusOK, respErrorCode(int(terrors.UserStorageSizeNotEnough), c))
+
+	// 将数据增加到sync_ipfs_record表中
+	err = dao.AddIPFSRecords(c.Request.Context(), irs)
+	if err != nil {
+		log.Errorf("AddIPFSRecords error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
 		return
 	}
 
-	// 调用调度器同步ipfs文件
-	sc, err := getSchedulerClient(c.Request.Context(), areaIds[0])
+	// 正在处理或已完成的记录不再重复导入
+	queued, err := dao.GetQueuedIPFSRecords(c.Request.Context(), username, ncids)
 	if err != nil {
-		log.Errorf("CreateAssetHandler getSchedulerClient error: %v", err)
-		if webErr, ok := err.(*api.ErrWeb); ok {
-			c.JSON(http.StatusOK, gin.H{
-				"code": -1,
-				"err":  webErr.Code,
-				"msg":  webErr.Message,
-				"Log":  areaIds[0],
-			})
-			return
+		log.Errorf("GetQueuedIPFSRecords error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	for _, v := range queued {
+		if err = opasynq.DefaultCli.EnqueueIPFSImport(c.Request.Context(), opasynq.IPFSTaskPayload{ID: v.ID}); err != nil {
+			log.Errorf("EnqueueIPFSImport error: %v", err)
+			dao.UpdateIPFSRecordState(c.Request.Context(), v.ID, dao.IPFSStateFailed, "enqueue failed")
+		}
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"msg":  "success",
+		"list": queued,
+	}))
+}
+
+// RunIPFSImport 处理一条ipfs导入记录: 解析 DAG 计算大小并检查空间, pin 到ipfs节点, 再让调度器拉取到区域
+// 同步完成后由定时任务写入用户文件, 每一步失败都会记录原因
+func RunIPFSImport(ctx context.Context, id int64) error {
+	record, err := dao.GetIPFSRecordByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if record.State != dao.IPFSStateQueued {
+		return nil
+	}
+
+	fail := func(reason string, err error) error {
+		if err != nil {
+			reason = fmt.Sprintf("%s: %v", reason, err)
+		}
+		return dao.UpdateIPFSRecordState(ctx, id, dao.IPFSStateFailed, truncateIPFSReason(reason))
+	}
+
+	pool, err := getIPFSPool()
+	if err != nil {
+		return fail("no ipfs node", err)
+	}
+
+	if err = dao.UpdateIPFSRecordState(ctx, id, dao.IPFSStateResolving, ""); err != nil {
+		return err
+	}
+	stat, err := pool.StatDag(ctx, record.CID, maxIPFSImportBlocks)
+	if err != nil {
+		return fail("resolve dag", err)
+	}
+	name := record.Name
+	if stat.Name != "" {
+		name = stat.Name
+	}
+	if err = dao.UpdateIPFSRecordResolved(ctx, id, name, int64(stat.Size)); err != nil {
+		return err
+	}
+
+	// 判断用户存储空间是否够用 (租户子账户除外), 正在导入的文件也需要计入
+	user, err := dao.GetUserByUsername(ctx, record.Username)
+	if err != nil {
+		return fail("get user", err)
+	}
+	if user.TenantID == "" {
+		pending, err := dao.GetPendingIPFSImportSize(ctx, record.Username, id)
+		if err != nil {
+			return fail("get pending import size", err)
 		}
+		if user.TotalStorageSize-user.UsedStorageSize-pending < int64(stat.Size) {
+			return fail("user storage size not enough", nil)
+		}
+	}
+
+	if err = dao.UpdateIPFSRecordState(ctx, id, dao.IPFSStatePinning, ""); err != nil {
+		return err
+	}
+	if err = pool.AddFileByCID(ctx, record.CID); err != nil {
+		return fail("pin", err)
 	}
-	err = sc.PullAsset(c.Request.Context(), &types.PullAssetReq{CIDs: cids, Replicas: 20, Owner: username, Expiration: time.Now().AddDate(99, 0, 0)})
+
+	sc, err := getSchedulerClient(ctx, record.AreaID)
+	if err != nil {
+		return fail("no scheduler", err)
+	}
+	err = sc.PullAsset(ctx, &types.PullAssetReq{CIDs: []string{record.CID}, Replicas: 20, Owner: record.Username, Expiration: time.Now().AddDate(99, 0, 0)})
 	if err != nil {
-		log.Errorf("PullAssetReq error: %v", err)
+		return fail("pull asset", err)
+	}
+
+	return dao.UpdateIPFSRecordSyncing(ctx, id)
+}
+
+// ExportAssetToIPFSHandler 把用户的文件 pin 到ipfs节点
+// @Summary 导出文件到ipfs
+// @Security ApiKeyAuth
+// @Tags import
+// @Param req body ExportAssetToIPFSReq true
+// @Success 200 {object} JsonObject "{id:0}"
+// @Router /api/v1/storage/export_to_ipfs [post]
+func ExportAssetToIPFSHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	var req ExportAssetToIPFSReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	hash, err := storage.CIDToHash(req.AssetCID)
+	if err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	_, err = dao.GetUserAsset(c.Request.Context(), hash, username)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetUserAsset error: %v", err)
 		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
 		return
 	}
 
-	// 将数据增加到sync_ipfs_record表中
-	err = dao.AddIPFSRecords(c.Request.Context(), irs)
+	id, err := dao.AddIPFSExportRecord(c.Request.Context(), username, req.AssetCID)
 	if err != nil {
-		log.Errorf("AddIPFSRecords error: %v", err)
+		log.Errorf("AddIPFSExportRecord error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	if err = opasynq.DefaultCli.EnqueueIPFSExport(c.Request.Context(), opasynq.IPFSTaskPayload{ID: id}); err != nil {
+		log.Errorf("EnqueueIPFSExport error: %v", err)
 		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
 		return
 	}
 
 	c.JSON(http.StatusOK, respJSON(JsonObject{
-		"msg": "success",
+		"id": id,
+	}))
+}
+
+// RunIPFSExport 处理一条导出记录, 在ipfs节点上 pin 文件
+func RunIPFSExport(ctx context.Context, id int64) error {
+	record, err := dao.GetIPFSExportRecordByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if record.State != dao.IPFSStateQueued {
+		return nil
+	}
+
+	pool, err := getIPFSPool()
+	if err != nil {
+		return dao.UpdateIPFSExportRecordState(ctx, id, dao.IPFSStateFailed, truncateIPFSReason(fmt.Sprintf("no ipfs node: %v", err)))
+	}
+
+	if err = dao.UpdateIPFSExportRecordState(ctx, id, dao.IPFSStatePinning, ""); err != nil {
+		return err
+	}
+	if err = pool.AddFileByCID(ctx, record.CID); err != nil {
+		return dao.UpdateIPFSExportRecordState(ctx, id, dao.IPFSStateFailed, truncateIPFSReason(fmt.Sprintf("pin: %v", err)))
+	}
+
+	return dao.UpdateIPFSExportRecordState(ctx, id, dao.IPFSStateDone, "")
+}
+
+// truncateIPFSReason 截断失败原因, 避免超出字段长度
+func truncateIPFSReason(reason string) string {
+	r := []rune(reason)
+	if len(r) <= maxIPFSReasonLen {
+		return reason
+	}
+	return string(r[:maxIPFSReasonLen])
+}
+
+// GetIPFSExportRecords 获取用户导出到ipfs的记录
+// @Summary 获取用户导出到ipfs的记录
+// @Security ApiKeyAuth
+// @Tags import
+// @Param page query int true
+// @Param size query int true
+// @Success 200 {object} JsonObject "{total:0,list:[]}"
+// @Router /api/v1/storage/export_to_ipfs [get]
+func GetIPFSExportRecords(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	size, _ := strconv.Atoi(c.Query("size"))
+	if page <= 0 {
+		page = 1
+	}
+	if size <= 0 {
+		size = 10
+	}
+
+	total, list, err := dao.GetIPFSExportRecordsByUsername(c.Request.Context(), username, page, size)
+	if err != nil {
+		log.Errorf("GetIPFSExportRecordsByUsername error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"total": total,
+		"list":  list,
 	}))
 }
 
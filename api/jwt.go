@@ -220,7 +220,7 @@ func loginByPassword(c *gin.Context, username, password string) (interface{}, er
 	}
 
 	if user.TotalStorageSize == 0 {
-		err = dao.UpdateUserTotalSize(c.Request.Context(), user.Username, 100*1024*1024)
+		err = dao.UpdateUserTotalSize(c.Request.Context(), user.Username, defaultUserStorageSize)
 		if err != nil {
 			log.Errorf(err.Error())
 		}
@@ -382,7 +382,8 @@ func Cors() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, jwtauthorization, lang")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, jwtauthorization, lang, X-Fingerprint")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Claim-Token")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(200)
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+)
+
+const (
+	// leaderboardNeighbours 查询自己排名时返回前后相邻的节点数量
+	leaderboardNeighbours  = 5
+	maxLeaderboardPageSize = 100
+)
+
+// parseLeaderboardQuery 解析排行榜的指标, 周期和范围, 默认为全局的累计收益排行
+func parseLeaderboardQuery(c *gin.Context) (dao.LeaderboardMetric, dao.LeaderboardPeriod, string, bool) {
+	metric := dao.LeaderboardMetric(c.DefaultQuery("metric", string(dao.LeaderboardMetricIncome)))
+	period := dao.LeaderboardPeriod(c.DefaultQuery("period", string(dao.LeaderboardPeriodAll)))
+	if !dao.ValidLeaderboard(metric, period) {
+		return "", "", "", false
+	}
+
+	scope := dao.LeaderboardScopeGlobal
+	if country := c.Query("country"); country != "" {
+		scope = dao.LeaderboardCountryScope(country)
+	} else if areaId := c.Query("area_id"); areaId != "" {
+		scope = dao.LeaderboardAreaScope(areaId)
+	}
+
+	return metric, period, scope, true
+}
+
+// GetLeaderboardHandler 获取节点排行榜
+func GetLeaderboardHandler(c *gin.Context) {
+	metric, period, scope, ok := parseLeaderboardQuery(c)
+	if !ok {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	page, _ := strconv.ParseInt(c.Query("page"), 10, 64)
+	pageSize, _ := strconv.ParseInt(c.Query("page_size"), 10, 64)
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 || pageSize > maxLeaderboardPageSize {
+		pageSize = 50
+	}
+
+	list, total, err := dao.GetLeaderboardFromCache(c.Request.Context(), metric, period, scope, (page-1)*pageSize, pageSize)
+	if err != nil {
+		log.Errorf("GetLeaderboardFromCache: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  list,
+		"total": total,
+	}))
+}
+
+// GetMyLeaderboardRankHandler 获取用户节点的排名, 以及指定节点(默认为排名最高的节点)前后相邻的节点
+func GetMyLeaderboardRankHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	uid := claims[identityKey].(string)
+
+	metric, period, scope, ok := parseLeaderboardQuery(c)
+	if !ok {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	deviceIds, err := dao.GetUserDeviceIDs(c.Request.Context(), uid)
+	if err != nil {
+		log.Errorf("GetUserDeviceIDs: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	ranks, err := dao.GetLeaderboardRanks(c.Request.Context(), metric, period, scope, deviceIds)
+	if err != nil {
+		log.Errorf("GetLeaderboardRanks: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	deviceId := c.Query("device_id")
+	if deviceId == "" {
+		var best *dao.LeaderboardEntry
+		for _, rank := range ranks {
+			if best == nil || rank.Rank < best.Rank {
+				best = rank
+			}
+		}
+		if best != nil {
+			deviceId = best.DeviceID
+		}
+	}
+
+	var neighbours []*dao.LeaderboardEntry
+	if deviceId != "" {
+		mine := false
+		for _, rank := range ranks {
+			if rank.DeviceID == deviceId {
+				mine = true
+				break
+			}
+		}
+		if !mine {
+			c.JSON(http.StatusOK, respErrorCode(errors.DeviceNotExists, c))
+			return
+		}
+
+		_, neighbours, err = dao.GetLeaderboardNeighbours(c.Request.Context(), metric, period, scope, deviceId, leaderboardNeighbours)
+		if err != nil {
+			log.Errorf("GetLeaderboardNeighbours: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"ranks":      ranks,
+		"device_id":  deviceId,
+		"neighbours": neighbours,
+	}))
+}
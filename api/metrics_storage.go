@@ -129,6 +129,36 @@ var (
 		Name: "today_upload_avg_speed_mb_s",
 		Help: "Today Average upload speed in MB/s",
 	})
+
+	// 各区域用户文件大小之和 (以 GB 记录)
+	View_DedupLogicalSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dedup_logical_size_gb",
+		Help: "Total size of user files per area in GB",
+	}, []string{"area"})
+
+	// 各区域去重后实际存储大小 (以 GB 记录)
+	View_DedupPhysicalSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dedup_physical_size_gb",
+		Help: "Deduplicated size of stored files per area in GB",
+	}, []string{"area"})
+
+	// 各区域去重节省的大小 (以 GB 记录)
+	View_DedupSavedSize = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dedup_saved_size_gb",
+		Help: "Size saved by deduplication per area in GB",
+	}, []string{"area"})
+
+	// 今日秒传数
+	View_TodayDedupHits = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "today_dedup_hit_count",
+		Help: "Today number of instant uploads",
+	})
+
+	// 各状态的 Filecoin 备份任务数
+	View_FilBackupJobs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fil_backup_job_count",
+		Help: "Number of filecoin backup jobs per state",
+	}, []string{"state"})
 )
 
 func init() {
@@ -153,6 +183,12 @@ func init() {
 	prometheus.MustRegister(View_TodayUploadFailure)
 	prometheus.MustRegister(View_TodayDownloadAvgSpeed)
 	prometheus.MustRegister(View_TodayUploadAvgSpeed)
+
+	prometheus.MustRegister(View_DedupLogicalSize)
+	prometheus.MustRegister(View_DedupPhysicalSize)
+	prometheus.MustRegister(View_DedupSavedSize)
+	prometheus.MustRegister(View_TodayDedupHits)
+	prometheus.MustRegister(View_FilBackupJobs)
 }
 
 // var (
@@ -198,4 +234,30 @@ func setStorageGatherer(ctx context.Context) {
 		View_TodayDownloadAvgSpeed.Set(float64(todayStats.DownloadAvgSpeed))
 		View_TodayUploadAvgSpeed.Set(float64(todayStats.UploadAvgSpeed))
 	}
+
+	dedupAreas, err := dao.GetAssetDedupAreas(ctx)
+	if err != nil {
+		log.Errorf("[gatherer] get dedup stats error: %s", err.Error())
+	}
+	for _, a := range dedupAreas {
+		View_DedupLogicalSize.WithLabelValues(a.AreaID).Set(float64(a.LogicalSize) / (1024 * 1024 * 1024))
+		View_DedupPhysicalSize.WithLabelValues(a.AreaID).Set(float64(a.PhysicalSize) / (1024 * 1024 * 1024))
+		View_DedupSavedSize.WithLabelValues(a.AreaID).Set(float64(a.LogicalSize-a.PhysicalSize) / (1024 * 1024 * 1024))
+	}
+
+	todayHits, err := dao.GetAssetDedupHitTotal(ctx, time.Unix(beginToday, 0), time.Time{})
+	if err != nil {
+		log.Errorf("[gatherer] get today dedup hits error: %s", err.Error())
+	}
+	if todayHits != nil {
+		View_TodayDedupHits.Set(float64(todayHits.Hits))
+	}
+
+	backupStates, err := dao.CountFilBackupJobsByState(ctx)
+	if err != nil {
+		log.Errorf("[gatherer] get filecoin backup jobs error: %s", err.Error())
+	}
+	for _, s := range backupStates {
+		View_FilBackupJobs.WithLabelValues(s.State).Set(float64(s.Count))
+	}
 }
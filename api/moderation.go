@@ -0,0 +1,545 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/gnasnik/titan-explorer/core/opasynq"
+	"github.com/gnasnik/titan-explorer/core/oprds"
+	"github.com/gnasnik/titan-explorer/core/storage"
+	"github.com/gnasnik/titan-explorer/pkg/iptool"
+)
+
+const (
+	// maxDailyReportsPerIP 每个 ip 每天最多举报次数
+	maxDailyReportsPerIP = 20
+	moderationReportKey  = "TITAN::MODERATION_REPORT_%s_%s"
+)
+
+// moderationCategories 举报类型
+var moderationCategories = map[string]bool{
+	"spam":      true,
+	"copyright": true,
+	"illegal":   true,
+	"abuse":     true,
+	"malware":   true,
+	"other":     true,
+}
+
+// checkContentAccess 检查文件是否被屏蔽以及分享者是否被禁止分享, 返回错误码, 0 表示可以访问
+func checkContentAccess(ctx context.Context, hash, owner string) int {
+	r, err := dao.GetContentRestriction(ctx, hash, owner)
+	if err != nil {
+		log.Errorf("GetContentRestriction error: %v", err)
+		return errors.InternalServer
+	}
+	if r.Blocked {
+		return errors.ContentBlocked
+	}
+	if r.Restricted {
+		return errors.UserSharingDisabled
+	}
+	return 0
+}
+
+// checkCIDAccess 同 checkContentAccess, cid 为文件组 id 时只检查分享者
+func checkCIDAccess(ctx context.Context, cid, owner string) int {
+	hash := ""
+	if gid, _ := strconv.Atoi(cid); gid <= 0 {
+		hash, _ = storage.CIDToHash(cid)
+	}
+	return checkContentAccess(ctx, hash, owner)
+}
+
+// ReportContentReq 举报分享的内容, token 和 cid 至少一个
+type ReportContentReq struct {
+	// Token 分享链接的 token
+	Token string `json:"token"`
+	Cid   string `json:"cid"`
+	// Username 分享者, 举报 cid 时可选
+	Username    string `json:"username"`
+	Category    string `json:"category" binding:"required"`
+	Description string `json:"description"`
+}
+
+// ReportContentHandler 举报分享链接或文件
+// @Summary 举报内容
+// @Tags moderation
+// @Param req body ReportContentReq true "举报内容, category: spam, copyright, illegal, abuse, malware, other"
+// @Success 200 {object} JsonObject "{}"
+// @Router /api/v1/storage/report [post]
+func ReportContentHandler(c *gin.Context) {
+	var req ReportContentReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.Token == "" && req.Cid == "" || !moderationCategories[req.Category] {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	if len(req.Description) > 1024 {
+		req.Description = req.Description[:1024]
+	}
+	ctx := c.Request.Context()
+
+	ip := iptool.GetClientIP(c.Request)
+	key := fmt.Sprintf(moderationReportKey, time.Now().Format("20060102"), ip)
+	n, err := dao.RedisCache.Incr(ctx, key).Result()
+	if err == nil && n == 1 {
+		dao.RedisCache.Expire(ctx, key, 24*time.Hour)
+	}
+	if n > maxDailyReportsPerIP {
+		c.JSON(http.StatusOK, respErrorCode(errors.ModerationReportTooFrequent, c))
+		return
+	}
+
+	mc := &model.ModerationCase{Cid: req.Cid, UserID: req.Username, LastReason: req.Category}
+	target := req.Cid
+	if req.Token != "" {
+		link, err := dao.GetShareLinkByToken(ctx, req.Token)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+			return
+		}
+		if err != nil {
+			log.Errorf("GetShareLinkByToken error: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
+		}
+		mc.Cid, mc.UserID, target = link.Cid, link.UserID, req.Token
+	}
+
+	// 只能举报文件, 文件组没有 hash
+	if gid, _ := strconv.Atoi(mc.Cid); gid > 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	mc.Hash, err = storage.CIDToHash(mc.Cid)
+	if err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	if mc.UserID == "" {
+		if ua, err := dao.GetUserAssetByHash(ctx, mc.Hash); err == nil {
+			mc.UserID = ua.UserID
+		}
+	}
+
+	_, err = dao.AddModerationReport(ctx, mc, &model.ModerationReport{
+		Target:      target,
+		Reporter:    ip,
+		Category:    req.Category,
+		Description: req.Description,
+	})
+	if err != nil {
+		log.Errorf("AddModerationReport error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(nil))
+}
+
+// AdminListModerationCasesHandler 审核队列
+// @Summary 审核队列
+// @Security ApiKeyAuth
+// @Tags admin
+// @Param state query string false "状态: pending, actioned, dismissed, appealed, reinstated"
+// @Param page query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} JsonObject "{list:[],total:0}"
+// @Router /api/v1/admin/moderation/cases [get]
+func AdminListModerationCasesHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	total, list, err := dao.ListModerationCases(c.Request.Context(), c.Query("state"), dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListModerationCases error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  list,
+		"total": total,
+	}))
+}
+
+// AdminListModerationReportsHandler 审核记录的举报详情
+// @Summary 举报详情
+// @Security ApiKeyAuth
+// @Tags admin
+// @Param case_id query int true "审核记录id"
+// @Param page query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} JsonObject "{list:[],total:0}"
+// @Router /api/v1/admin/moderation/reports [get]
+func AdminListModerationReportsHandler(c *gin.Context) {
+	caseID, _ := strconv.ParseInt(c.Query("case_id"), 10, 64)
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if caseID <= 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	total, list, err := dao.ListModerationReports(c.Request.Context(), caseID, dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListModerationReports error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  list,
+		"total": total,
+	}))
+}
+
+// AdminModerationActionReq 处理审核记录
+type AdminModerationActionReq struct {
+	CaseID int64 `json:"case_id" binding:"required"`
+	// Action block_hash: 屏蔽文件, disable_sharing: 禁止上传者分享, delete: 屏蔽并从所有区域删除, dismiss: 驳回举报, reinstate: 撤销处理
+	Action string `json:"action" binding:"required"`
+	Note   string `json:"note"`
+}
+
+// AdminModerationActionHandler 处理审核记录, 处理后通知上传者
+// @Summary 处理举报
+// @Security ApiKeyAuth
+// @Tags admin
+// @Param req body AdminModerationActionReq true "处理方式"
+// @Success 200 {object} JsonObject "{case:{}}"
+// @Router /api/v1/admin/moderation/action [post]
+func AdminModerationActionHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	admin := claims[identityKey].(string)
+
+	var req AdminModerationActionReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	ctx := c.Request.Context()
+
+	mc, err := dao.GetModerationCase(ctx, req.CaseID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetModerationCase error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	switch req.Action {
+	case dao.ModerationActionBlockHash, dao.ModerationActionDelete:
+		err = dao.AddContentBlock(ctx, mc.Hash, mc.ID, req.Note)
+	case dao.ModerationActionDisableSharing:
+		if mc.UserID == "" {
+			c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+			return
+		}
+		err = dao.AddShareRestriction(ctx, mc.UserID, mc.ID, req.Note)
+	case dao.ModerationActionReinstate:
+		err = reinstateModerationCase(ctx, mc)
+	case dao.ModerationActionDismiss:
+	default:
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("moderation action %s error: %v", req.Action, err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	// 删除会清理用户的文件记录, 先取出保存了文件的用户用于通知
+	var owners []string
+	if req.Action == dao.ModerationActionBlockHash || req.Action == dao.ModerationActionDelete || req.Action == dao.ModerationActionReinstate {
+		if owners, err = dao.GetAssetOwners(ctx, mc.Hash); err != nil {
+			log.Errorf("GetAssetOwners error: %v", err)
+		}
+	}
+
+	if req.Action == dao.ModerationActionDelete {
+		removeModeratedAsset(ctx, mc, owners)
+	}
+
+	switch req.Action {
+	case dao.ModerationActionDismiss:
+		mc.State = dao.ModerationStateDismissed
+	case dao.ModerationActionReinstate:
+		mc.State, mc.Action = dao.ModerationStateReinstated, ""
+	default:
+		mc.State, mc.Action = dao.ModerationStateActioned, req.Action
+	}
+	mc.AdminNote, mc.HandledBy = req.Note, admin
+	if err = dao.UpdateModerationCase(ctx, mc); err != nil {
+		log.Errorf("UpdateModerationCase error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	if req.Action != dao.ModerationActionDismiss {
+		notifyModerationAction(ctx, mc, req.Action, req.Note, owners)
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"case": mc,
+	}))
+}
+
+// reinstateModerationCase 撤销审核记录的屏蔽和分享限制, 已删除的文件不能恢复
+func reinstateModerationCase(ctx context.Context, mc *model.ModerationCase) error {
+	if err := dao.DeleteContentBlock(ctx, mc.Hash); err != nil {
+		return err
+	}
+	if mc.UserID == "" {
+		return nil
+	}
+	return dao.DeleteShareRestriction(ctx, mc.UserID, mc.ID)
+}
+
+// removeModeratedAsset 从所有区域删除文件, 包括未登录上传的文件, 并删除每个用户的文件记录和返还已使用空间
+func removeModeratedAsset(ctx context.Context, mc *model.ModerationCase, owners []string) {
+	areaIDs, err := dao.GetAssetAllAreaIDs(ctx, mc.Hash)
+	if err != nil {
+		log.Errorf("GetAssetAllAreaIDs error: %v", err)
+	}
+
+	for _, owner := range owners {
+		if err = removeUserAssetRecords(ctx, owner, mc.Hash); err != nil {
+			log.Errorf("remove asset %s of %s error: %v", mc.Hash, owner, err)
+		}
+	}
+
+	if ta, err := dao.GetTempAssetInfo(ctx, mc.Hash); err == nil {
+		if ta.AreaIDs != "" {
+			areaIDs = append(areaIDs, strings.Split(ta.AreaIDs, ",")...)
+		}
+		if err = dao.UpdateTempAssetState(ctx, mc.Hash, dao.TempAssetStateRemoved, mc.LastReason); err != nil {
+			log.Errorf("UpdateTempAssetState error: %v", err)
+		}
+		if err = oprds.GetClient().DelUnloginAssetInfo(ctx, mc.Hash); err != nil {
+			log.Errorf("DelUnloginAssetInfo error: %v", err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, areaID := range areaIDs {
+		if seen[areaID] {
+			continue
+		}
+		seen[areaID] = true
+		if err = opasynq.DefaultCli.EnqueueDeleteAssetOperation(ctx, opasynq.DeleteAssetPayload{CID: mc.Cid, AreaID: areaID}); err != nil {
+			log.Errorf("EnqueueDeleteAssetOperation error: %v", err)
+		}
+	}
+}
+
+// removeUserAssetRecords 删除用户的文件记录, 包括回收站和版本记录, 调度器中的文件由调用方删除
+func removeUserAssetRecords(ctx context.Context, userID, hash string) error {
+	areaIds, _, err := dao.CheckUserAseetNeedDel(ctx, hash, userID, nil)
+	if err != nil {
+		return err
+	}
+	if err = dao.DeleteAssetTrashByHash(ctx, userID, hash); err != nil {
+		return err
+	}
+	if err = dao.DelAssetAndUpdateSize(ctx, hash, userID, areaIds, true); err != nil {
+		return err
+	}
+	if err = dao.DeleteUserAssetKeys(ctx, userID, hash); err != nil {
+		return err
+	}
+	return removeBlockedAssetVersion(ctx, userID, hash)
+}
+
+// removeBlockedAssetVersion 只删除被屏蔽文件的版本记录, 其他版本是不同的内容, 保留.
+// 被屏蔽的是当前版本时, 最新的历史版本恢复为当前版本
+func removeBlockedAssetVersion(ctx context.Context, userID, hash string) error {
+	v, err := dao.GetAssetVersionByHash(ctx, userID, hash)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = dao.DeleteAssetVersion(ctx, v.ID); err != nil {
+		return err
+	}
+	if !v.IsCurrent {
+		return nil
+	}
+
+	versions, err := dao.ListAssetVersions(ctx, userID, v.GroupID, v.AssetName)
+	if err != nil || len(versions) == 0 {
+		return err
+	}
+	return dao.RestoreAssetVersion(ctx, versions[0])
+}
+
+// notifyModerationAction 通知受影响的用户, 屏蔽和删除通知所有保存了文件的用户 owners, 禁止分享只通知上传者
+func notifyModerationAction(ctx context.Context, mc *model.ModerationCase, action, reason string, owners []string) {
+	users := owners
+	if mc.UserID != "" {
+		users = append(users, mc.UserID)
+	}
+
+	seen := make(map[string]bool)
+	notices := make([]*model.ModerationNotice, 0, len(users))
+	for _, u := range users {
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		notices = append(notices, &model.ModerationNotice{
+			UserID: u,
+			CaseID: mc.ID,
+			Hash:   mc.Hash,
+			Cid:    mc.Cid,
+			Action: action,
+			Reason: reason,
+		})
+	}
+	if err := dao.AddModerationNotices(ctx, notices); err != nil {
+		log.Errorf("AddModerationNotices error: %v", err)
+	}
+}
+
+// GetModerationNoticesHandler 获取自己的审核通知
+// @Summary 审核通知
+// @Security ApiKeyAuth
+// @Tags moderation
+// @Param page query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} JsonObject "{list:[],total:0}"
+// @Router /api/v1/storage/moderation/notices [get]
+func GetModerationNoticesHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	total, list, err := dao.ListModerationNotices(c.Request.Context(), username, dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListModerationNotices error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  list,
+		"total": total,
+	}))
+}
+
+// ReadModerationNoticesReq 标记已读的通知
+type ReadModerationNoticesReq struct {
+	IDs []int64 `json:"ids" binding:"required"`
+}
+
+// ReadModerationNoticesHandler 标记审核通知为已读
+// @Summary 审核通知已读
+// @Security ApiKeyAuth
+// @Tags moderation
+// @Param req body ReadModerationNoticesReq true "通知id"
+// @Success 200 {object} JsonObject "{}"
+// @Router /api/v1/storage/moderation/notices/read [post]
+func ReadModerationNoticesHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	var req ReadModerationNoticesReq
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.IDs) == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	if err := dao.ReadModerationNotices(c.Request.Context(), username, req.IDs); err != nil {
+		log.Errorf("ReadModerationNotices error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(nil))
+}
+
+// AppealModerationReq 申诉
+type AppealModerationReq struct {
+	CaseID int64  `json:"case_id" binding:"required"`
+	Reason string `json:"reason" binding:"required"`
+}
+
+// AppealModerationHandler 收到处理通知的用户申诉, 申诉后等待管理员重新处理
+// @Summary 申诉
+// @Security ApiKeyAuth
+// @Tags moderation
+// @Param req body AppealModerationReq true "申诉理由"
+// @Success 200 {object} JsonObject "{}"
+// @Router /api/v1/storage/moderation/appeal [post]
+func AppealModerationHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	var req AppealModerationReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	if len(req.Reason) > 1024 {
+		req.Reason = req.Reason[:1024]
+	}
+	ctx := c.Request.Context()
+
+	notified, err := dao.HasModerationNotice(ctx, username, req.CaseID)
+	if err != nil {
+		log.Errorf("HasModerationNotice error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if !notified {
+		c.JSON(http.StatusOK, respErrorCode(errors.ModerationAppealNotAllowed, c))
+		return
+	}
+
+	mc, err := dao.GetModerationCase(ctx, req.CaseID)
+	if err != nil {
+		log.Errorf("GetModerationCase error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if mc.State != dao.ModerationStateActioned {
+		c.JSON(http.StatusOK, respErrorCode(errors.ModerationAppealNotAllowed, c))
+		return
+	}
+
+	mc.State = dao.ModerationStateAppealed
+	mc.AppealReason = fmt.Sprintf("%s: %s", username, req.Reason)
+	if err = dao.UpdateModerationCase(ctx, mc); err != nil {
+		log.Errorf("UpdateModerationCase error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(nil))
+}
@@ -746,6 +746,11 @@ func GetNodesInfoHandler(c *gin.Context) {
 	page, _ := strconv.Atoi(c.Query("page"))
 	order := c.Query("order")
 	orderField := c.Query("order_field")
+	// 排行榜只按节点数排序
+	if (orderField != "" && orderField != "node_count") || (order != "" && !strings.EqualFold(order, "asc") && !strings.EqualFold(order, "desc")) {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
 	nodeType, _ := strconv.ParseInt(c.Query("node_type"), 10, 64)
 	info.NodeType = nodeType
 	option := dao.QueryOption{
@@ -762,22 +767,11 @@ func GetNodesInfoHandler(c *gin.Context) {
 		return
 	}
 	c.JSON(http.StatusOK, respJSON(JsonObject{
-		"list":  handleNodesRank(&list, option),
+		"list":  list,
 		"total": total,
 	}))
 }
 
-func handleNodesRank(nodes *[]model.NodesInfo, opt dao.QueryOption) *[]model.NodesInfo {
-	var nodesRank []model.NodesInfo
-	offset := (opt.Page - 1) * opt.PageSize
-	for i, info := range *nodes {
-		rank := strconv.Itoa(i + 1 + offset)
-		info.Rank = rank
-		nodesRank = append(nodesRank, info)
-	}
-	return &nodesRank
-}
-
 func GetMapInfoHandler(c *gin.Context) {
 	lang := model.Language(c.GetHeader("Lang"))
 	deviceId := c.Query("device_id")
@@ -1539,6 +1533,8 @@ func DeactiveNodeHanlder(c *gin.Context) {
 		return
 	}
 
+	addDeviceEvents(c.Request.Context(), dao.NewDeviceEvent(req.NodeID, uid, dao.DeviceEventDeactivate, uid, "", fmt.Sprintf("%d hours", req.Hours)))
+
 	c.JSON(http.StatusOK, respJSON(JsonObject{"msg": "success"}))
 }
 
@@ -1590,5 +1586,7 @@ func CancelDeactiveNodeHanlder(c *gin.Context) {
 		return
 	}
 
+	addDeviceEvents(c.Request.Context(), dao.NewDeviceEvent(req.NodeID, uid, dao.DeviceEventReactivate, uid, "", ""))
+
 	c.JSON(http.StatusOK, respJSON(JsonObject{"msg": "success"}))
 }
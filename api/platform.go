@@ -28,6 +28,7 @@ var (
 	kubMgr   *kub.Mgr
 	orderMgr *order.Mgr
 	tokenMgr *token.Mgr
+	chainMgr *chain.Mgr
 )
 
 // BindKeplrReq 绑定keplr请求参数
@@ -51,7 +52,7 @@ func InitManagers(cfg *config.Config) {
 		log.Fatal("initial kub err: ", err)
 	}
 
-	chainMgr, err := chain.NewChainManager(&cfg.ChainAPI)
+	chainMgr, err = chain.NewChainManager(&cfg.ChainAPI)
 	if err != nil {
 		log.Fatal("initial chain err:", err)
 	}
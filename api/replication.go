@@ -0,0 +1,522 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/gnasnik/titan-explorer/core/opasynq"
+)
+
+const (
+	// maxReplicationReplicas 副本策略最多的区域数
+	maxReplicationReplicas = 10
+	// replicationIPWindow nearest 策略统计下载请求的时间范围
+	replicationIPWindow = 7 * 24 * time.Hour
+	// maxReplicationIPs nearest 策略最多统计的下载 ip 数
+	maxReplicationIPs = 20
+)
+
+// ReplicationPolicyReq 保存副本策略
+type ReplicationPolicyReq struct {
+	Mode      string   `json:"mode" binding:"required"`
+	Replicas  int64    `json:"replicas" binding:"required"`
+	Countries []string `json:"countries"`
+	AreaIDs   []string `json:"area_ids"`
+	Prune     bool     `json:"prune"`
+	Enabled   bool     `json:"enabled"`
+}
+
+// toReplicationPolicy 检查策略参数, 国家和区域必须是当前存在的
+func (req *ReplicationPolicyReq) toReplicationPolicy(ownerType, ownerID string) (*model.ReplicationPolicy, bool) {
+	if req.Mode != dao.ReplicationModeFixed && req.Mode != dao.ReplicationModeNearest {
+		return nil, false
+	}
+	if req.Replicas <= 0 || req.Replicas > maxReplicationReplicas {
+		return nil, false
+	}
+
+	_, maps, err := GetAndStoreAreaIDs()
+	if err != nil {
+		log.Errorf("GetAndStoreAreaIDs error: %v", err)
+	}
+	areas := make(map[string]bool)
+	for _, v := range maps {
+		for _, aid := range v {
+			areas[aid] = true
+		}
+	}
+	for _, v := range req.Countries {
+		if _, ok := maps[v]; !ok {
+			return nil, false
+		}
+	}
+	for _, v := range req.AreaIDs {
+		if !areas[v] {
+			return nil, false
+		}
+	}
+
+	now := time.Now()
+	return &model.ReplicationPolicy{
+		OwnerType: ownerType,
+		OwnerID:   ownerID,
+		Mode:      req.Mode,
+		Replicas:  req.Replicas,
+		Countries: strings.Join(req.Countries, ","),
+		AreaIDs:   strings.Join(req.AreaIDs, ","),
+		Prune:     req.Prune,
+		Enabled:   req.Enabled,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, true
+}
+
+// GetReplicationPolicyHandler 获取用户的副本策略, 用户没有自己的策略时返回所属租户的策略
+// @Summary 获取副本策略
+// @Security ApiKeyAuth
+// @Tags storage
+// @Success 200 {object} JsonObject "{policy:model.ReplicationPolicy}"
+// @Router /api/v1/storage/replication/policy [get]
+func GetReplicationPolicyHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	policy, err := dao.GetReplicationPolicy(c.Request.Context(), dao.ReplicationOwnerUser, userId)
+	if err == sql.ErrNoRows {
+		policy, err = dao.GetEffectiveReplicationPolicy(c.Request.Context(), userId)
+	}
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respJSON(JsonObject{
+			"policy": nil,
+		}))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetReplicationPolicy error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"policy": policy,
+	}))
+}
+
+// SaveReplicationPolicyHandler 保存用户的副本策略, 保存后立即开始调整副本
+// @Summary 保存副本策略
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param req body ReplicationPolicyReq true "mode: fixed, nearest"
+// @Success 200 {object} JsonObject "{msg:"success"}"
+// @Router /api/v1/storage/replication/policy [post]
+func SaveReplicationPolicyHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	var req ReplicationPolicyReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	policy, ok := req.toReplicationPolicy(dao.ReplicationOwnerUser, userId)
+	if !ok {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	if err := dao.SaveReplicationPolicy(c.Request.Context(), policy); err != nil {
+		log.Errorf("SaveReplicationPolicy error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	if policy.Enabled {
+		if err := opasynq.DefaultCli.EnqueueReplicationReconcile(c.Request.Context(), opasynq.ReplicationReconcilePayload{UserID: userId}); err != nil {
+			log.Errorf("EnqueueReplicationReconcile error: %v", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"msg": "success",
+	}))
+}
+
+// DeleteReplicationPolicyHandler 删除用户的副本策略, 已有的副本不变
+// @Summary 删除副本策略
+// @Security ApiKeyAuth
+// @Tags storage
+// @Success 200 {object} JsonObject "{msg:"success"}"
+// @Router /api/v1/storage/replication/policy/delete [post]
+func DeleteReplicationPolicyHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	n, err := dao.DeleteReplicationPolicy(c.Request.Context(), dao.ReplicationOwnerUser, userId)
+	if err != nil {
+		log.Errorf("DeleteReplicationPolicy error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if n == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+		return
+	}
+	if err = dao.DeleteAssetReplicationStatuses(c.Request.Context(), userId); err != nil {
+		log.Errorf("DeleteAssetReplicationStatuses error: %v", err)
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"msg": "success",
+	}))
+}
+
+// ListReplicationStatusHandler 获取文件的副本合规状态
+// @Summary 获取副本合规状态
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param compliant query string false "true: 只返回合规的, false: 只返回不合规的"
+// @Param page query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} JsonObject "{list:[]model.AssetReplicationStatus,total:0,summary:dao.ReplicationCompliance}"
+// @Router /api/v1/storage/replication/status [get]
+func ListReplicationStatusHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	var compliant *bool
+	if v, err := strconv.ParseBool(c.Query("compliant")); err == nil {
+		compliant = &v
+	}
+
+	summary, err := dao.GetReplicationCompliance(c.Request.Context(), userId)
+	if err != nil {
+		log.Errorf("GetReplicationCompliance error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	total, list, err := dao.ListAssetReplicationStatuses(c.Request.Context(), userId, compliant, dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListAssetReplicationStatuses error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":    list,
+		"total":   total,
+		"summary": summary,
+	}))
+}
+
+// ReconcileReplicationHandler 立即按副本策略调整副本
+// @Summary 调整副本
+// @Security ApiKeyAuth
+// @Tags storage
+// @Success 200 {object} JsonObject "{msg:"success"}"
+// @Router /api/v1/storage/replication/reconcile [post]
+func ReconcileReplicationHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	userId := claims[identityKey].(string)
+
+	if _, err := dao.GetEffectiveReplicationPolicy(c.Request.Context(), userId); err != nil {
+		if err != sql.ErrNoRows {
+			log.Errorf("GetEffectiveReplicationPolicy error: %v", err)
+		}
+		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+		return
+	}
+
+	if err := opasynq.DefaultCli.EnqueueReplicationReconcile(c.Request.Context(), opasynq.ReplicationReconcilePayload{UserID: userId}); err != nil {
+		log.Errorf("EnqueueReplicationReconcile error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"msg": "success",
+	}))
+}
+
+// GetTenantReplicationPolicyHandler 获取租户的副本策略
+func GetTenantReplicationPolicyHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	tenantID := claims[tenantID].(string)
+
+	policy, err := dao.GetReplicationPolicy(c.Request.Context(), dao.ReplicationOwnerTenant, tenantID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respJSON(JsonObject{
+			"policy": nil,
+		}))
+		return
+	}
+	if err != nil {
+		log.Errorf("[TENANT] GetReplicationPolicy error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"policy": policy,
+	}))
+}
+
+// SaveTenantReplicationPolicyHandler 保存租户的副本策略, 对没有自己策略的子账户生效
+func SaveTenantReplicationPolicyHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	tenantID := claims[tenantID].(string)
+
+	var req ReplicationPolicyReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	policy, ok := req.toReplicationPolicy(dao.ReplicationOwnerTenant, tenantID)
+	if !ok {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	if err := dao.SaveReplicationPolicy(c.Request.Context(), policy); err != nil {
+		log.Errorf("[TENANT] SaveReplicationPolicy error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"msg": "success",
+	}))
+}
+
+// RunReplicationReconcile 对比用户文件已同步的区域和副本策略要求的区域, 缺少的区域创建同步任务,
+// 开启 prune 时删除策略之外的副本, 并记录每个文件的合规状态
+func RunReplicationReconcile(ctx context.Context, userID string) error {
+	policy, err := dao.GetEffectiveReplicationPolicy(ctx, userID)
+	if err == sql.ErrNoRows {
+		return dao.DeleteAssetReplicationStatuses(ctx, userID)
+	}
+	if err != nil {
+		return fmt.Errorf("get policy: %w", err)
+	}
+
+	candidates := replicationCandidates(policy)
+	if len(candidates) == 0 {
+		return fmt.Errorf("no area matches policy %d", policy.ID)
+	}
+
+	var preferred []string
+	if policy.Mode == dao.ReplicationModeNearest {
+		preferred = nearestReplicationAreas(ctx, userID, candidates)
+	}
+
+	assets, err := dao.GetUserActiveAssets(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("get assets: %w", err)
+	}
+	areaMap, err := dao.GetUserAssetAreaMap(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("get asset areas: %w", err)
+	}
+
+	now := time.Now()
+	statuses := make([]*model.AssetReplicationStatus, 0, len(assets))
+	for _, a := range assets {
+		var synced, pending []string
+		for _, v := range areaMap[a.Hash] {
+			if v.IsSync {
+				synced = append(synced, v.AreaID)
+			} else {
+				pending = append(pending, v.AreaID)
+			}
+		}
+		sort.Strings(synced)
+
+		desired := desiredReplicationAreas(preferred, candidates, synced, int(policy.Replicas))
+		missing := subtractAreas(desired, synced)
+		extra := subtractAreas(synced, desired)
+
+		// 文件至少在一个区域同步完成后才能复制到其他区域
+		if toAdd := subtractAreas(missing, pending); len(toAdd) > 0 && len(synced) > 0 {
+			if err = dao.AddUserAssetSyncAreas(ctx, userID, a.Hash, toAdd); err != nil {
+				log.Errorf("AddUserAssetSyncAreas %s error: %v", a.Cid, err)
+			} else if err = EnqueueUserAssetSync(ctx, userID, userID, a.Cid, a.Hash, synced[0], toAdd); err != nil {
+				log.Errorf("EnqueueUserAssetSync %s error: %v", a.Cid, err)
+			}
+		}
+
+		// 策略要求的区域都同步完成后才删除多余的副本
+		if policy.Prune && len(missing) == 0 && len(extra) > 0 {
+			if err = removeAssetAreas(ctx, userID, a, extra); err != nil {
+				log.Errorf("remove areas of %s error: %v", a.Cid, err)
+			} else {
+				synced = subtractAreas(synced, extra)
+				extra = nil
+			}
+		}
+
+		statuses = append(statuses, &model.AssetReplicationStatus{
+			UserID:       userID,
+			Hash:         a.Hash,
+			Cid:          a.Cid,
+			PolicyID:     policy.ID,
+			DesiredAreas: strings.Join(desired, ","),
+			ActualAreas:  strings.Join(synced, ","),
+			MissingAreas: strings.Join(missing, ","),
+			ExtraAreas:   strings.Join(extra, ","),
+			Compliant:    len(missing) == 0,
+			CheckedAt:    now,
+		})
+	}
+
+	return dao.SaveAssetReplicationStatuses(ctx, userID, statuses, now)
+}
+
+// replicationCandidates 策略允许的区域, 没有指定国家和区域时为所有区域
+func replicationCandidates(policy *model.ReplicationPolicy) []string {
+	_, maps, err := GetAndStoreAreaIDs()
+	if err != nil {
+		log.Errorf("GetAndStoreAreaIDs error: %v", err)
+	}
+
+	exists := make(map[string]bool)
+	var all []string
+	for _, v := range maps {
+		for _, aid := range v {
+			exists[aid] = true
+			all = append(all, aid)
+		}
+	}
+
+	var out []string
+	for _, country := range strings.Split(policy.Countries, ",") {
+		if country != "" {
+			out = append(out, maps[country]...)
+		}
+	}
+	for _, aid := range strings.Split(policy.AreaIDs, ",") {
+		if aid != "" && exists[aid] {
+			out = append(out, aid)
+		}
+	}
+	if policy.Countries == "" && policy.AreaIDs == "" {
+		out = all
+	}
+
+	sort.Strings(out)
+	return uniqueAreas(out)
+}
+
+// nearestReplicationAreas 根据用户文件最近的下载请求, 按离请求最近的次数给区域排序
+func nearestReplicationAreas(ctx context.Context, userID string, candidates []string) []string {
+	ips, err := dao.GetUserDownloadIPs(ctx, userID, time.Now().Add(-replicationIPWindow), maxReplicationIPs)
+	if err != nil {
+		log.Errorf("GetUserDownloadIPs error: %v", err)
+		return nil
+	}
+
+	votes := make(map[string]int)
+	for _, ip := range ips {
+		areaID, err := GetNearestAreaID(ctx, ip, candidates)
+		if err != nil {
+			continue
+		}
+		votes[areaID]++
+	}
+
+	out := make([]string, 0, len(votes))
+	for areaID := range votes {
+		out = append(out, areaID)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if votes[out[i]] != votes[out[j]] {
+			return votes[out[i]] > votes[out[j]]
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
+
+// desiredReplicationAreas 从 candidates 中选出 n 个区域, 依次优先 preferred 和已有的区域, 避免副本来回迁移
+func desiredReplicationAreas(preferred, candidates, actual []string, n int) []string {
+	allowed := make(map[string]bool, len(candidates))
+	for _, v := range candidates {
+		allowed[v] = true
+	}
+
+	out := make([]string, 0, n)
+	picked := make(map[string]bool)
+	for _, list := range [][]string{preferred, actual, candidates} {
+		for _, v := range list {
+			if len(out) >= n {
+				return out
+			}
+			if allowed[v] && !picked[v] {
+				picked[v] = true
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
+
+// removeAssetAreas 删除文件在指定区域的副本, 其他用户也有该文件时只删除用户的记录
+func removeAssetAreas(ctx context.Context, userID string, a *model.UserAsset, areaIds []string) error {
+	for _, areaId := range areaIds {
+		isOnly, err := dao.CheckUserAssetIsOnly(ctx, a.Hash, areaId)
+		if err != nil {
+			return err
+		}
+		if !isOnly {
+			continue
+		}
+		if err = opasynq.DefaultCli.EnqueueDeleteAssetOperation(ctx, opasynq.DeleteAssetPayload{CID: a.Cid, AreaID: areaId}); err != nil {
+			return err
+		}
+	}
+
+	return dao.DelAssetAndUpdateSize(ctx, a.Hash, userID, areaIds, false)
+}
+
+func subtractAreas(a, b []string) []string {
+	exclude := make(map[string]bool, len(b))
+	for _, v := range b {
+		exclude[v] = true
+	}
+
+	var out []string
+	for _, v := range a {
+		if !exclude[v] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func uniqueAreas(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	out := make([]string, 0, len(list))
+	for _, v := range list {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -58,6 +58,7 @@ func RegisterRouterWithJWT(router *gin.Engine, cfg config.Config) {
 
 	apiV2 := router.Group("/api/v2")
 	link := router.Group("/link")
+	gateway := router.Group("/d")
 
 	var err error
 	authMiddleware, err = jwtGinMiddleware(cfg.SecretKey)
@@ -94,6 +95,7 @@ func RegisterRouterWithJWT(router *gin.Engine, cfg config.Config) {
 	apiV2.GET("/acme", AcmeHandler)
 	// index info all nodes info from device info
 	apiV2.GET("/get_nodes_info", GetNodesInfoHandler)
+	apiV2.GET("/leaderboard", GetLeaderboardHandler)
 	apiV2.GET("/get_device_info", GetDeviceInfoHandler)
 	apiV2.GET("/get_device_status", GetDeviceStatusHandler)
 	apiV2.GET("/get_map_info", GetMapInfoHandler)
@@ -113,6 +115,26 @@ func RegisterRouterWithJWT(router *gin.Engine, cfg config.Config) {
 	tnode.GET("/list", GetNodeList)
 	tnode.POST("/deactive", DeactiveNodeHanlder)
 	tnode.PUT("/deactive/cancel", CancelDeactiveNodeHanlder)
+	tnode.GET("/sla", GetNodeSLAHandler)
+	tnode.GET("/sla/report", GetUserSLAReportHandler)
+	tnode.GET("/sla/export", ExportUserSLAReportHandler)
+	tnode.GET("/leaderboard/mine", GetMyLeaderboardRankHandler)
+	tnode.GET("/events", GetDeviceEventsHandler)
+	tnode.GET("/group/list", ListDeviceGroupsHandler)
+	tnode.POST("/group/create", CreateDeviceGroupHandler)
+	tnode.POST("/group/rename", RenameDeviceGroupHandler)
+	tnode.DELETE("/group/delete", DeleteDeviceGroupHandler)
+	tnode.GET("/group/devices", GetDeviceGroupDevicesHandler)
+	tnode.POST("/group/devices/add", AddDeviceGroupMembersHandler)
+	tnode.POST("/group/devices/remove", RemoveDeviceGroupMembersHandler)
+	tnode.GET("/group/stats", GetDeviceGroupStatsHandler)
+	tnode.POST("/group/bulk/rename", BulkRenameDevicesHandler)
+	tnode.POST("/group/bulk/unbind", BulkUnbindDevicesHandler)
+	tnode.POST("/group/bulk/deactive", BulkDeactiveDevicesHandler)
+	tnode.POST("/group/bulk/edge_config", BulkSetEdgeConfigHandler)
+	tnode.GET("/tag/list", ListDeviceTagsHandler)
+	tnode.POST("/tag/add", AddDeviceTagsHandler)
+	tnode.POST("/tag/remove", RemoveDeviceTagsHandler)
 
 	// request from titan api
 	apiV2.GET("/get_cache_list", GetCacheListHandler)
@@ -212,6 +234,27 @@ func RegisterRouterWithJWT(router *gin.Engine, cfg config.Config) {
 	admin.GET("/areas", GetAreasHandler)
 	admin.GET("/total_stats", GetTotalStatsHandler)
 	admin.GET("/ip_changed_records", GetNodeIPChangedRecordsHandler)
+	admin.GET("/anomaly/list", GetDeviceAnomalyListHandler)
+	admin.POST("/anomaly/review", ReviewDeviceAnomalyHandler)
+	admin.GET("/device/events", AdminGetDeviceEventsHandler)
+	admin.GET("/storage/dedup", GetAssetDedupReportHandler)
+	admin.GET("/filecoin/backup_jobs", AdminListFilBackupJobsHandler)
+	admin.POST("/filecoin/backup_jobs/renew", AdminRenewFilBackupJobsHandler)
+	admin.GET("/asset_sync/tasks", AdminListAssetSyncTasksHandler)
+	admin.POST("/asset_sync/retry", AdminRetryAssetSyncTasksHandler)
+	admin.GET("/area_scores", GetAreaScoresHandler)
+	admin.GET("/area_transfer_stats", GetAreaTransferStatsHandler)
+	admin.GET("/transfer/analytics", AdminGetTransferAnalyticsHandler)
+	admin.GET("/transfer/analytics/export", AdminExportTransferAnalyticsHandler)
+	admin.GET("/temp_file/list", AdminListTempFilesHandler)
+	admin.POST("/temp_file/takedown", AdminTakedownTempFileHandler)
+	admin.GET("/moderation/cases", AdminListModerationCasesHandler)
+	admin.GET("/moderation/reports", AdminListModerationReportsHandler)
+	admin.POST("/moderation/action", AdminModerationActionHandler)
+	admin.GET("/plan/list", AdminListStoragePlansHandler)
+	admin.POST("/plan/save", AdminSaveStoragePlanHandler)
+	admin.POST("/plan/grant", AdminGrantSubscriptionHandler)
+	admin.GET("/plan/orders", AdminListSubscriptionOrdersHandler)
 	admin.GET("/asset_records", GetAssetRecordsHandler)
 	admin.GET("/node_asset_records", GetNodeAssetRecordsHandler)
 	admin.GET("/successful_replicas", GetSuccessfulReplicasHandler)
@@ -239,6 +282,8 @@ func RegisterRouterWithJWT(router *gin.Engine, cfg config.Config) {
 	storage.POST("/login", authMiddleware.LoginHandler)
 	storage.POST("/logout", authMiddleware.LogoutHandler)
 	link.GET("/", GetShareLinkHandler)
+	link.GET("/:token", RedirectShareLinkHandler)
+	gateway.GET("/:token", GatewayDownloadHandler)
 	storage.GET("/get_link", ShareLinkHandler)
 	storage.GET("/create_link", CreateShareLinkHandler)
 	storage.GET("/share_need_pass", ShareNeedPassHandler)
@@ -260,14 +305,18 @@ func RegisterRouterWithJWT(router *gin.Engine, cfg config.Config) {
 	storage.GET("/temp_file/info/:cid", GetUploadInfo)
 	storage.GET("/temp_file/share/:cid", ShareTempFile)
 	storage.GET("/temp_file/download/:cid", DownloadTempFile)
+	storage.POST("/report", ReportContentHandler)
 
 	// storage.Use(authMiddleware.MiddlewareFunc())
 	storage.GET("/open_asset", OpenAssetHandler) // 打开公共的文件，需要统计访问次数
 	storage.POST("/sync_data", SyncHourData)
 	storage.GET("/count", GetStorageCount)
 	storage.GET("/get_group_info", GetShareGroupInfo)
+	storage.GET("/share_link/info", GetShareLinkInfoHandler)
+	storage.POST("/share_link/access", AccessShareLinkHandler)
 
 	storage.POST("/transfer/report", AssetTransferReport)
+	storage.GET("/car/export/download", DownloadCarExportHandler)
 
 	storage.Use(AuthRequired(authMiddleware))
 	storage.GET("/share_before", ShareBeforeHandler)
@@ -275,20 +324,67 @@ func RegisterRouterWithJWT(router *gin.Engine, cfg config.Config) {
 
 	storage.GET("/share_link_info", ShareLinkInfoHandler)
 	storage.POST("/share_link_update", ShareLinkUpdateHandler)
+	storage.POST("/share_link/create", CreateShareLinkV2Handler)
+	storage.GET("/share_link/list", ListShareLinksHandler)
+	storage.POST("/share_link/update", UpdateShareLinkV2Handler)
+	storage.POST("/share_link/revoke", RevokeShareLinkHandler)
+	storage.GET("/share_link/analytics", GetShareLinkAnalyticsHandler)
 
 	storage.GET("/get_locateStorage", GetAllocateStorageHandler)
 	storage.GET("/get_storage_size", GetStorageSizeHandler) // 获取用户存储空间信息
 	storage.GET("/get_vip_info", GetUserVipInfoHandler)     // 判断用户是否为vip
+	storage.GET("/plan/list", GetStoragePlansHandler)
+	storage.GET("/plan/subscription", GetUserSubscriptionHandler)
+	storage.POST("/plan/order", CreateSubscriptionOrderHandler)
+	storage.GET("/plan/orders", GetSubscriptionOrdersHandler)
 	storage.GET("/get_user_access_token", GetUserAccessTokenHandler)
 	storage.GET("/get_upload_info", GetUploadInfoHandler)
 	// storage.GET("/create_asset", CreateAssetHandler)
 	storage.POST("/create_asset", CreateAssetPostHandler)
 	storage.POST("/import_from_ipfs", CreateAssetFromIPFSHandler)
 	storage.POST("/export_to_ipfs", ExportAssetToIPFSHandler)
+	storage.GET("/export_to_ipfs", GetIPFSExportRecords)
+	storage.POST("/car/import", ImportCarHandler)
+	storage.POST("/car/export", CreateCarExportHandler)
+	storage.GET("/car/export/list", ListCarExportsHandler)
+	storage.GET("/transfer/analytics", GetTransferAnalyticsHandler)
+	storage.GET("/transfer/analytics/export", ExportTransferAnalyticsHandler)
+	storage.POST("/temp_file/claim", ClaimTempFileHandler)
+	storage.GET("/moderation/notices", GetModerationNoticesHandler)
+	storage.POST("/moderation/notices/read", ReadModerationNoticesHandler)
+	storage.POST("/moderation/appeal", AppealModerationHandler)
+	storage.GET("/asset/access_stats", GetAssetAccessStatsHandler)
+	storage.GET("/group/access_stats", GetAssetGroupAccessStatsHandler)
+	storage.GET("/asset/hot", GetHotAssetsHandler)
+	storage.GET("/gateway/url", CreateGatewayURLHandler)
+	storage.GET("/file_pass/nonce", FilePassNonceHandler)
+	storage.GET("/file_pass/verify", FilePassVerifyHandler)
+	storage.GET("/encryption/key", GetEncryptionKeyHandler)
+	storage.POST("/encryption/key", CreateEncryptionKeyHandler)
+	storage.POST("/encryption/key/rotate", RotateEncryptionKeyHandler)
+	storage.GET("/encryption/public_key", GetEncryptionPublicKeyHandler)
+	storage.GET("/encryption/asset_key", GetAssetKeyHandler)
+	storage.GET("/encryption/asset_keys", ListAssetKeysHandler)
+	storage.POST("/encryption/share", ShareAssetKeyHandler)
+	storage.GET("/encryption/share", ListAssetKeySharesHandler)
+	storage.POST("/encryption/share/revoke", RevokeAssetKeyShareHandler)
+	storage.POST("/lifecycle/rule", SaveLifecycleRuleHandler)
+	storage.GET("/lifecycle/rules", ListLifecycleRulesHandler)
+	storage.POST("/lifecycle/rule/delete", DeleteLifecycleRuleHandler)
+	storage.GET("/lifecycle/logs", ListLifecycleLogsHandler)
+	storage.GET("/filecoin/backup", GetFilBackupHandler)
+	storage.POST("/filecoin/restore", CreateFilRestoreHandler)
+	storage.GET("/filecoin/restore", ListFilRestoreHandler)
+	storage.GET("/replication/policy", GetReplicationPolicyHandler)
+	storage.POST("/replication/policy", SaveReplicationPolicyHandler)
+	storage.POST("/replication/policy/delete", DeleteReplicationPolicyHandler)
+	storage.GET("/replication/status", ListReplicationStatusHandler)
+	storage.POST("/replication/reconcile", ReconcileReplicationHandler)
 	storage.GET("/delete_asset", DeleteAssetHandler)
 	storage.GET("/get_asset_info", GetAssetInfoHandler)
 	storage.GET("/get_asset_list", GetAssetListHandler)
 	storage.GET("/get_all_asset_list", GetAssetAllListHandler)
+	storage.GET("/search", SearchAssetsHandler)
 	storage.GET("/share_status_set", UpdateShareStatusHandler) // 修改分享状态
 	storage.GET("/create_key", CreateKeyHandler)               // TODO: 需要讨论key生成方式
 	storage.GET("/get_keys", GetKeyListHandler)
@@ -308,6 +404,18 @@ func RegisterRouterWithJWT(router *gin.Engine, cfg config.Config) {
 	storage.POST("/rename_asset", RenameAssetHandler)
 	storage.GET("/move_group_to_group", MoveGroupToGroupHandler)
 	storage.GET("/move_asset_to_group", MoveAssetToGroupHandler)
+	storage.POST("/bulk/move", BulkMoveHandler)
+	storage.POST("/bulk/delete", BulkDeleteHandler)
+	storage.POST("/copy_group", CopyGroupHandler)
+	storage.GET("/trash/list", GetTrashListHandler)
+	storage.POST("/trash/restore", RestoreTrashHandler)
+	storage.POST("/trash/purge", PurgeTrashHandler)
+	storage.GET("/versioning", GetAssetVersioningHandler)
+	storage.POST("/versioning", SetAssetVersioningHandler)
+	storage.GET("/asset_version/list", ListAssetVersionsHandler)
+	storage.GET("/asset_version/download", DownloadAssetVersionHandler)
+	storage.POST("/asset_version/restore", RestoreAssetVersionHandler)
+	storage.POST("/asset_version/prune", PruneAssetVersionsHandler)
 	storage.POST("/move_node", MoveNode)
 	storage.POST("/ipfs_info", SyncIPFSInfoByCIDs)
 	storage.GET("/ipfs_info", GetIPFSRecords)
@@ -345,6 +453,8 @@ func RegisterRouterWithJWT(router *gin.Engine, cfg config.Config) {
 	tenant.POST("/sync_user", SubUserSyncHandler)
 	tenant.DELETE("/user", SubUserDeleteHandler)
 	tenant.GET("/refresh_token", SubUserRefreshTokenHandler)
+	tenant.GET("/replication_policy", GetTenantReplicationPolicyHandler)
+	tenant.POST("/replication_policy", SaveTenantReplicationPolicyHandler)
 
 	// platform 容器平台
 	platform := apiV1.Group("/platform")
@@ -380,6 +490,8 @@ func RegisterRouterWithAPIKey(router *gin.Engine) {
 	storage.POST("/add_fil_storage", CreateFilStorageHandler)
 	storage.GET("/backup_assets", GetBackupAssetsHandler)
 	storage.POST("/backup_result", BackupResultHandler)
+	storage.GET("/filecoin/restore/pending", GetPendingFilRestoreHandler)
+	storage.POST("/filecoin/restore/result", FilRestoreResultHandler)
 
 	app := authV1.Group("/app")
 	app.Use(AuthAPIKeyMiddlewareFunc())
@@ -0,0 +1,587 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Filecoin-Titan/titan/node/cidutil"
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/config"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/gnasnik/titan-explorer/core/geo"
+	"github.com/gnasnik/titan-explorer/pkg/iptool"
+	"github.com/golang-module/carbon/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	shareLinkTokenBytes   = 16
+	shareLinkRecentAccess = 20
+)
+
+// ShareLinkReq 创建或修改分享链接的请求, 次数限制为 0 表示不限制
+type ShareLinkReq struct {
+	ID               int64    `json:"id"`
+	Cid              string   `json:"cid"`
+	Url              string   `json:"url"`
+	Password         string   `json:"password"`
+	ExpireAt         int64    `json:"expire_at"`
+	MaxDownloads     int64    `json:"max_downloads"`
+	MaxVisitors      int64    `json:"max_visitors"`
+	AllowedReferrers []string `json:"allowed_referrers"`
+}
+
+// ShareLinkAccessReq 访问分享链接的请求
+type ShareLinkAccessReq struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password"`
+}
+
+func genShareLinkToken() (string, error) {
+	b := make([]byte, shareLinkTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// setShareLinkOptions 把请求中的密码, 过期时间, 次数和来源限制写入链接
+func setShareLinkOptions(link *model.ShareLink, req *ShareLinkReq) error {
+	link.PasswordHash = ""
+	if req.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		link.PasswordHash = string(hash)
+	}
+
+	link.ExpireAt = time.Unix(0, 0)
+	if req.ExpireAt > 0 {
+		link.ExpireAt = time.Unix(req.ExpireAt, 0)
+	}
+
+	var referrers []string
+	for _, r := range req.AllowedReferrers {
+		r = strings.ToLower(strings.TrimSpace(r))
+		if r != "" {
+			referrers = append(referrers, r)
+		}
+	}
+
+	link.MaxDownloads = req.MaxDownloads
+	link.MaxVisitors = req.MaxVisitors
+	link.AllowedReferrers = strings.Join(referrers, ",")
+	return nil
+}
+
+func validShareLinkReq(req *ShareLinkReq) bool {
+	if req.MaxDownloads < 0 || req.MaxVisitors < 0 {
+		return false
+	}
+	if req.ExpireAt > 0 && req.ExpireAt < time.Now().Unix() {
+		return false
+	}
+	return true
+}
+
+// shareLinkReferrerAllowed 检查请求来源的域名是否在白名单中, 子域名也允许访问
+func shareLinkReferrerAllowed(link *model.ShareLink, referrer string) bool {
+	if link.AllowedReferrers == "" {
+		return true
+	}
+
+	u, err := url.Parse(referrer)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range strings.Split(link.AllowedReferrers, ",") {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// shareLinkTargetAllowed 分享链接只能跳转到 http(s) 的白名单域名, 防止被用作任意跳转
+func shareLinkTargetAllowed(longLink string) bool {
+	decoded, err := url.QueryUnescape(longLink)
+	if err != nil {
+		return false
+	}
+	u, err := url.Parse(decoded)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return false
+	}
+
+	hosts := config.Cfg.ShareLinkHosts
+	if len(hosts) == 0 {
+		if base, err := url.Parse(config.Cfg.BaseURL); err == nil && base.Hostname() != "" {
+			hosts = []string{base.Hostname()}
+		}
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, domain := range hosts {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+func shareLinkExpired(link *model.ShareLink) bool {
+	return link.ExpireAt.Unix() > 0 && link.ExpireAt.Before(time.Now())
+}
+
+func shareLinkVisitor(ip, userAgent string) string {
+	sum := sha256.Sum256([]byte(ip + "|" + userAgent))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordShareLinkAccess 异步记录访问日志, 写入失败不影响访问
+func recordShareLinkAccess(c *gin.Context, link *model.ShareLink, action, reason string) {
+	access := &model.ShareLinkAccess{
+		LinkID:    link.ID,
+		Action:    action,
+		IP:        iptool.GetClientIP(c.Request),
+		UserAgent: c.Request.UserAgent(),
+		Referrer:  c.Request.Referer(),
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+	if action == dao.ShareLinkActionDownload {
+		access.Bytes = link.Size
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if loc, err := geo.GetIpLocation(ctx, access.IP); err == nil && loc != nil {
+			access.Country = loc.Country
+		}
+
+		if err := dao.AddShareLinkAccess(ctx, access); err != nil {
+			log.Errorf("AddShareLinkAccess: %v", err)
+		}
+	}()
+}
+
+// checkShareLink 检查链接状态, 来源, 跳转地址, 密码和访客数, 不通过时返回对应的错误码
+func checkShareLink(c *gin.Context, link *model.ShareLink, password string) (int, string) {
+	if link.Revoked {
+		return errors.ShareLinkRevoked, "revoked"
+	}
+	if shareLinkExpired(link) {
+		return errors.ShareLinkExpired, "expired"
+	}
+	if !shareLinkReferrerAllowed(link, c.Request.Referer()) {
+		return errors.ShareLinkRefererNotAllowed, "referrer"
+	}
+	// 旧链接创建时没有校验目标地址, 访问时再检查一次
+	if !shareLinkTargetAllowed(link.LongLink) {
+		return errors.PermissionNotAllowed, "target"
+	}
+	if link.PasswordHash != "" {
+		if password == "" {
+			return errors.ShareLinkPassRequired, "password"
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)); err != nil {
+			return errors.ShareLinkPassIncorrect, "password"
+		}
+	}
+
+	ok, err := dao.AddShareLinkVisitor(c.Request.Context(), link.ID, shareLinkVisitor(iptool.GetClientIP(c.Request), c.Request.UserAgent()))
+	if err != nil {
+		log.Errorf("AddShareLinkVisitor: %v", err)
+		return errors.InternalServer, ""
+	}
+	if !ok {
+		return errors.ShareLinkVisitorLimit, "visitor_limit"
+	}
+	return 0, ""
+}
+
+// accessShareLink 校验并计入一次下载, 成功时返回原始链接
+func accessShareLink(c *gin.Context, token, password string) (string, int) {
+	link, err := dao.GetShareLinkByToken(c.Request.Context(), token)
+	if err == sql.ErrNoRows {
+		return "", errors.NotFound
+	}
+	if err != nil {
+		log.Errorf("GetShareLinkByToken: %v", err)
+		return "", errors.InternalServer
+	}
+
+	if code := checkCIDAccess(c.Request.Context(), link.Cid, link.UserID); code != 0 {
+		recordShareLinkAccess(c, link, dao.ShareLinkActionDenied, "moderation")
+		return "", code
+	}
+	if code, reason := checkShareLink(c, link, password); code != 0 {
+		if reason != "" {
+			recordShareLinkAccess(c, link, dao.ShareLinkActionDenied, reason)
+		}
+		return "", code
+	}
+
+	ok, err := dao.IncrShareLinkDownload(c.Request.Context(), link.ID)
+	if err != nil {
+		log.Errorf("IncrShareLinkDownload: %v", err)
+		return "", errors.InternalServer
+	}
+	if !ok {
+		recordShareLinkAccess(c, link, dao.ShareLinkActionDenied, "download_limit")
+		return "", errors.ShareLinkDownloadLimit
+	}
+
+	recordShareLinkAccess(c, link, dao.ShareLinkActionDownload, "")
+	return link.LongLink, 0
+}
+
+// CreateShareLinkV2Handler 创建分享链接, 每次创建都会生成新的 token
+func CreateShareLinkV2Handler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	var req ShareLinkReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	if req.Cid == "" || !shareLinkTargetAllowed(req.Url) || !validShareLinkReq(&req) {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	if code := checkCIDAccess(c.Request.Context(), req.Cid, username); code != 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
+
+	link := &model.ShareLink{
+		UserID:    username,
+		Cid:       req.Cid,
+		LongLink:  req.Url,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	// 分享的为文件的时候，则是文件cid，为文件组的时候则是文件组group_id
+	gid, _ := strconv.Atoi(req.Cid)
+	if gid <= 0 {
+		hash, err := cidutil.CIDToHash(req.Cid)
+		if err != nil {
+			c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+			return
+		}
+		asset, err := dao.GetUserAsset(c.Request.Context(), hash, username)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+			return
+		}
+		if err != nil {
+			log.Errorf("GetUserAsset: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
+		}
+		link.Name = asset.AssetName
+		link.Size = asset.TotalSize
+	} else {
+		group, err := dao.GetUserAssetGroupInfo(c.Request.Context(), username, gid)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+			return
+		}
+		if err != nil {
+			log.Errorf("GetUserAssetGroupInfo: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
+		}
+		link.Name = group.Name
+		link.Size = group.AssetSize
+	}
+
+	if err := setShareLinkOptions(link, &req); err != nil {
+		log.Errorf("setShareLinkOptions: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	token, err := genShareLinkToken()
+	if err != nil {
+		log.Errorf("genShareLinkToken: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	link.Token = token
+
+	id, err := dao.CreateShareLink(c.Request.Context(), link)
+	if err != nil {
+		log.Errorf("CreateShareLink: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"id":    id,
+		"token": token,
+		"url":   "/link/" + token,
+	}))
+}
+
+// ListShareLinksHandler 用户的分享链接列表, 可以按 cid 过滤
+func ListShareLinksHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	total, list, err := dao.ListUserShareLinks(c.Request.Context(), username, c.Query("cid"), dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListUserShareLinks: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  list,
+		"total": total,
+	}))
+}
+
+func getUserShareLink(c *gin.Context, username string, id int64) (*model.ShareLink, bool) {
+	link, err := dao.GetUserShareLink(c.Request.Context(), username, id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+		return nil, false
+	}
+	if err != nil {
+		log.Errorf("GetUserShareLink: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return nil, false
+	}
+	return link, true
+}
+
+// UpdateShareLinkV2Handler 修改分享链接的密码, 过期时间和限制, 不会重置已有的计数
+func UpdateShareLinkV2Handler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	var req ShareLinkReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	if req.ID <= 0 || !validShareLinkReq(&req) {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	link, ok := getUserShareLink(c, username, req.ID)
+	if !ok {
+		return
+	}
+	if link.Revoked {
+		c.JSON(http.StatusOK, respErrorCode(errors.ShareLinkRevoked, c))
+		return
+	}
+
+	if err := setShareLinkOptions(link, &req); err != nil {
+		log.Errorf("setShareLinkOptions: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	if err := dao.UpdateShareLink(c.Request.Context(), link); err != nil {
+		log.Errorf("UpdateShareLink: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{"msg": "success"}))
+}
+
+// RevokeShareLinkHandler 撤销分享链接
+func RevokeShareLinkHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	var req ShareLinkReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.ID <= 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	if _, ok := getUserShareLink(c, username, req.ID); !ok {
+		return
+	}
+
+	if err := dao.RevokeShareLink(c.Request.Context(), username, req.ID); err != nil {
+		log.Errorf("RevokeShareLink: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{"msg": "success"}))
+}
+
+// GetShareLinkAnalyticsHandler 分享链接的访问统计, 默认最近 30 天
+func GetShareLinkAnalyticsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	id, _ := strconv.ParseInt(c.Query("id"), 10, 64)
+	if id <= 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	link, ok := getUserShareLink(c, username, id)
+	if !ok {
+		return
+	}
+
+	option := dao.QueryOption{
+		StartTime: c.Query("start_time"),
+		EndTime:   c.Query("end_time"),
+	}
+	if option.StartTime == "" {
+		option.StartTime = carbon.Now().SubDays(29).StartOfDay().String()
+	} else {
+		option.StartTime = carbon.Parse(option.StartTime).StartOfDay().String()
+	}
+	if option.EndTime == "" {
+		option.EndTime = carbon.Now().EndOfDay().String()
+	} else {
+		option.EndTime = carbon.Parse(option.EndTime).EndOfDay().String()
+	}
+
+	ctx := c.Request.Context()
+	total, err := dao.GetShareLinkAccessTotal(ctx, link.ID, option)
+	if err != nil {
+		log.Errorf("GetShareLinkAccessTotal: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	countries, err := dao.GetShareLinkAccessByCountry(ctx, link.ID, option)
+	if err != nil {
+		log.Errorf("GetShareLinkAccessByCountry: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	daily, err := dao.GetShareLinkAccessDaily(ctx, link.ID, option)
+	if err != nil {
+		log.Errorf("GetShareLinkAccessDaily: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	recent, err := dao.GetShareLinkRecentAccesses(ctx, link.ID, shareLinkRecentAccess)
+	if err != nil {
+		log.Errorf("GetShareLinkRecentAccesses: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"link":       link,
+		"total":      total,
+		"by_country": countries,
+		"daily":      daily,
+		"recent":     recent,
+	}))
+}
+
+// GetShareLinkInfoHandler 分享链接的公开信息, 不需要登录, 不返回原始链接
+func GetShareLinkInfoHandler(c *gin.Context) {
+	link, err := dao.GetShareLinkByToken(c.Request.Context(), c.Query("token"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetShareLinkByToken: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	if link.Revoked {
+		c.JSON(http.StatusOK, respErrorCode(errors.ShareLinkRevoked, c))
+		return
+	}
+	if shareLinkExpired(link) {
+		c.JSON(http.StatusOK, respErrorCode(errors.ShareLinkExpired, c))
+		return
+	}
+	if code := checkCIDAccess(c.Request.Context(), link.Cid, link.UserID); code != 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
+
+	recordShareLinkAccess(c, link, dao.ShareLinkActionView, "")
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"name":      link.Name,
+		"size":      link.Size,
+		"need_pass": link.PasswordHash != "",
+		"expire_at": link.ExpireAt,
+	}))
+}
+
+// AccessShareLinkHandler 校验密码和限制后返回下载地址, 每次调用计一次下载
+func AccessShareLinkHandler(c *gin.Context) {
+	var req ShareLinkAccessReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+
+	longLink, code := accessShareLink(c, req.Token, req.Password)
+	if code != 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"url": longLink,
+	}))
+}
+
+// RedirectShareLinkHandler 通过 /link/:token 访问分享链接, 有密码的链接需要带上 pass 参数
+func RedirectShareLinkHandler(c *gin.Context) {
+	longLink, code := accessShareLink(c, c.Param("token"), c.Query("pass"))
+	if code != 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
+
+	decodedLink, err := url.QueryUnescape(longLink)
+	if err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.Redirect(http.StatusFound, decodedLink)
+}
@@ -0,0 +1,573 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/config"
+	"github.com/gnasnik/titan-explorer/core/chain"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultUserStorageSize 没有套餐时用户的存储空间
+	defaultUserStorageSize = 100 * 1024 * 1024
+	// subscriptionOrderTimeout 链上订单超过这个时间未支付时取消
+	subscriptionOrderTimeout = 24 * time.Hour
+	maxSubscriptionPeriods   = 12
+)
+
+// getUserActivePlan 获取用户生效中的套餐, 没有套餐时返回 nil
+func getUserActivePlan(ctx context.Context, username string) *model.StoragePlan {
+	plan, err := dao.GetUserActivePlan(ctx, username)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Errorf("GetUserActivePlan: %v", err)
+		}
+		return nil
+	}
+	return plan
+}
+
+// userTrafficLimit 获取用户的总流量限制, 有套餐时使用套餐的流量
+func userTrafficLimit(ctx context.Context, user *model.User) int64 {
+	if plan := getUserActivePlan(ctx, user.Username); plan != nil && plan.Traffic > 0 {
+		return plan.Traffic
+	}
+	if user.EnableVIP {
+		return maxVipTotalFlow
+	}
+	return maxTotalFlow
+}
+
+// checkUserPlanUpload 按套餐限制单个文件大小和同步的区域数, 返回限制后的区域, 文件超出大小时返回错误码
+func checkUserPlanUpload(ctx context.Context, user *model.User, size int64, areaIds []string) ([]string, int) {
+	if user.TenantID != "" {
+		return areaIds, 0
+	}
+	plan := getUserActivePlan(ctx, user.Username)
+	if plan == nil {
+		return areaIds, 0
+	}
+	if plan.MaxFileSize > 0 && size > plan.MaxFileSize {
+		return areaIds, errors.StoragePlanFileSizeExceeded
+	}
+	// 第一个区域是对用户最优的区域, 保留前面的区域
+	if plan.ReplicaAreas > 0 && len(areaIds) > plan.ReplicaAreas {
+		areaIds = areaIds[:plan.ReplicaAreas]
+	}
+	return areaIds, 0
+}
+
+// quoteSubscriptionOrder 计算订单的类型, 天数和金额
+// 更换套餐时原套餐剩余的时间按原价格折算抵扣, 抵扣后还有剩余时折算成新套餐的天数, 管理员赠送的天数不折算
+func quoteSubscriptionOrder(sub *model.UserSubscription, cur, plan *model.StoragePlan, periods int, now time.Time) *model.SubscriptionOrder {
+	o := &model.SubscriptionOrder{
+		PlanID: plan.ID,
+		Kind:   dao.SubscriptionOrderKindNew,
+		Days:   plan.DurationDays * periods,
+		Amount: plan.Price * int64(periods),
+	}
+	if sub == nil || cur == nil || sub.State != dao.SubscriptionStateActive || !sub.ExpiresAt.After(now) {
+		return o
+	}
+	o.SourcePlanID, o.SourceExpiresAt = sub.PlanID, sub.ExpiresAt
+	if cur.ID == plan.ID {
+		o.Kind = dao.SubscriptionOrderKindRenew
+		return o
+	}
+
+	// 按每天的价格判断升级还是降级
+	o.Kind = dao.SubscriptionOrderKindUpgrade
+	if plan.Price*int64(cur.DurationDays) < cur.Price*int64(plan.DurationDays) {
+		o.Kind = dao.SubscriptionOrderKindDowngrade
+	}
+	remainDays := sub.ExpiresAt.Sub(now).Hours()/24 - float64(sub.GrantedDays)
+	if cur.DurationDays > 0 && remainDays > 0 {
+		o.Credit = int64(float64(cur.Price) * remainDays / float64(cur.DurationDays))
+	}
+
+	if o.Credit < o.Amount {
+		o.Amount -= o.Credit
+		return o
+	}
+	if plan.Price > 0 {
+		o.Days += int(float64(o.Credit-o.Amount) * float64(plan.DurationDays) / float64(plan.Price))
+	}
+	o.Amount = 0
+	return o
+}
+
+// activateSubscriptionOrder 订单支付后让套餐生效
+func activateSubscriptionOrder(ctx context.Context, o *model.SubscriptionOrder) error {
+	plan, err := dao.GetStoragePlan(ctx, o.PlanID)
+	if err != nil {
+		return fmt.Errorf("get plan %d error:%w", o.PlanID, err)
+	}
+	return dao.ActivateSubscriptionOrder(ctx, o, plan, true)
+}
+
+// GetStoragePlansHandler 获取上架的套餐
+// @Summary 套餐列表
+// @Security ApiKeyAuth
+// @Tags storage
+// @Success 200 {object} JsonObject "{list:[]}"
+// @Router /api/v1/storage/plan/list [get]
+func GetStoragePlansHandler(c *gin.Context) {
+	list, err := dao.ListStoragePlans(c.Request.Context(), true)
+	if err != nil {
+		log.Errorf("ListStoragePlans error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list": list,
+	}))
+}
+
+// GetUserSubscriptionHandler 获取自己的套餐
+// @Summary 我的套餐
+// @Security ApiKeyAuth
+// @Tags storage
+// @Success 200 {object} JsonObject "{subscription:{},plan:{}}"
+// @Router /api/v1/storage/plan/subscription [get]
+func GetUserSubscriptionHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+	ctx := c.Request.Context()
+
+	sub, err := dao.GetUserSubscription(ctx, username)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respJSON(JsonObject{}))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetUserSubscription error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	plan, err := dao.GetStoragePlan(ctx, sub.PlanID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Errorf("GetStoragePlan error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"subscription": sub,
+		"plan":         plan,
+	}))
+}
+
+// CreateSubscriptionOrderReq 购买套餐
+type CreateSubscriptionOrderReq struct {
+	PlanID  int64 `json:"plan_id" binding:"required"`
+	Periods int   `json:"periods"`
+}
+
+// CreateSubscriptionOrderHandler 购买, 续费或更换套餐, 需要支付时返回订单号, 用户在链上支付后自动生效
+// @Summary 购买套餐
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param req body CreateSubscriptionOrderReq true "套餐和购买的周期数"
+// @Success 200 {object} JsonObject "{order:{},contract:address}"
+// @Router /api/v1/storage/plan/order [post]
+func CreateSubscriptionOrderHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	var req CreateSubscriptionOrderReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	if req.Periods <= 0 {
+		req.Periods = 1
+	}
+	if req.Periods > maxSubscriptionPeriods {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	ctx := c.Request.Context()
+
+	plan, err := dao.GetStoragePlan(ctx, req.PlanID)
+	if err == sql.ErrNoRows || (err == nil && !plan.Enabled) {
+		c.JSON(http.StatusOK, respErrorCode(errors.StoragePlanNotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetStoragePlan error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	user, err := dao.GetUserByUsername(ctx, username)
+	if err != nil {
+		log.Errorf("GetUserByUsername error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.UserNotFound, c))
+		return
+	}
+	if user.TenantID != "" || plan.DurationDays <= 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.SubscriptionOrderNotAllowed, c))
+		return
+	}
+	if user.UsedStorageSize > plan.StorageSize {
+		c.JSON(http.StatusOK, respErrorCode(errors.StoragePlanStorageNotEnough, c))
+		return
+	}
+
+	var cur *model.StoragePlan
+	sub, err := dao.GetUserSubscription(ctx, username)
+	switch err {
+	case sql.ErrNoRows:
+	case nil:
+		cur, err = dao.GetStoragePlan(ctx, sub.PlanID)
+		if err != nil && err != sql.ErrNoRows {
+			log.Errorf("GetStoragePlan error: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
+		}
+	default:
+		log.Errorf("GetUserSubscription error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	o := quoteSubscriptionOrder(sub, cur, plan, req.Periods, time.Now())
+	o.OrderID = uuid.NewString()
+	o.UserID = username
+	o.PayMethod = dao.SubscriptionPayMethodChain
+	o.State = dao.SubscriptionOrderStateCreated
+	if err = dao.CreateSubscriptionOrder(ctx, o); err != nil {
+		log.Errorf("CreateSubscriptionOrder error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	// 免费套餐或者抵扣后不需要支付的订单直接生效
+	if o.Amount == 0 {
+		err = dao.ActivateSubscriptionOrder(ctx, o, plan, false)
+		if err == dao.ErrSubscriptionOrderStale {
+			c.JSON(http.StatusOK, respErrorCode(errors.SubscriptionOrderNotAllowed, c))
+			return
+		}
+		if err != nil {
+			log.Errorf("ActivateSubscriptionOrder error: %v", err)
+			c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"order":    o,
+		"contract": config.Cfg.ChainAPI.OrderContractAddress,
+	}))
+}
+
+// GetSubscriptionOrdersHandler 获取自己的套餐订单
+// @Summary 套餐订单
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param state query string false "状态: created, paid, cancelled"
+// @Param page query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} JsonObject "{list:[],total:0}"
+// @Router /api/v1/storage/plan/orders [get]
+func GetSubscriptionOrdersHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	total, list, err := dao.ListSubscriptionOrders(c.Request.Context(), username, c.Query("state"), dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListSubscriptionOrders error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  list,
+		"total": total,
+	}))
+}
+
+// AdminListStoragePlansHandler 获取所有套餐, 包括下架的套餐
+// @Summary 套餐列表
+// @Security ApiKeyAuth
+// @Tags admin
+// @Success 200 {object} JsonObject "{list:[]}"
+// @Router /api/v1/admin/plan/list [get]
+func AdminListStoragePlansHandler(c *gin.Context) {
+	list, err := dao.ListStoragePlans(c.Request.Context(), false)
+	if err != nil {
+		log.Errorf("ListStoragePlans error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list": list,
+	}))
+}
+
+// AdminSaveStoragePlanHandler 添加或修改套餐, id 为 0 时添加
+// @Summary 保存套餐
+// @Security ApiKeyAuth
+// @Tags admin
+// @Param req body model.StoragePlan true "套餐"
+// @Success 200 {object} JsonObject "{plan:{}}"
+// @Router /api/v1/admin/plan/save [post]
+func AdminSaveStoragePlanHandler(c *gin.Context) {
+	var plan model.StoragePlan
+	if err := c.ShouldBindJSON(&plan); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	plan.Name = strings.TrimSpace(plan.Name)
+	if plan.Name == "" || plan.StorageSize <= 0 || plan.Traffic < 0 || plan.MaxFileSize < 0 || plan.ReplicaAreas < 0 ||
+		plan.Price < 0 || plan.DurationDays <= 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	ctx := c.Request.Context()
+
+	var err error
+	if plan.ID == 0 {
+		err = dao.AddStoragePlan(ctx, &plan)
+	} else {
+		if _, err = dao.GetStoragePlan(ctx, plan.ID); err == sql.ErrNoRows {
+			c.JSON(http.StatusOK, respErrorCode(errors.StoragePlanNotFound, c))
+			return
+		}
+		if err == nil {
+			err = dao.UpdateStoragePlan(ctx, &plan)
+		}
+	}
+	if err != nil {
+		log.Errorf("save storage plan error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"plan": plan,
+	}))
+}
+
+// AdminGrantSubscriptionReq 赠送套餐
+type AdminGrantSubscriptionReq struct {
+	Username string `json:"username" binding:"required"`
+	PlanID   int64  `json:"plan_id" binding:"required"`
+	Days     int    `json:"days"`
+	Note     string `json:"note"`
+}
+
+// AdminGrantSubscriptionHandler 给用户赠送套餐, 同一个套餐时顺延, 不同套餐时替换原套餐, days 为 0 时使用套餐的周期
+// @Summary 赠送套餐
+// @Security ApiKeyAuth
+// @Tags admin
+// @Param req body AdminGrantSubscriptionReq true "用户和套餐"
+// @Success 200 {object} JsonObject "{order:{}}"
+// @Router /api/v1/admin/plan/grant [post]
+func AdminGrantSubscriptionHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	admin := claims[identityKey].(string)
+
+	var req AdminGrantSubscriptionReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.Days < 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	ctx := c.Request.Context()
+
+	plan, err := dao.GetStoragePlan(ctx, req.PlanID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.StoragePlanNotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetStoragePlan error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if _, err = dao.GetUserByUsername(ctx, req.Username); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.UserNotFound, c))
+		return
+	}
+	if req.Days == 0 {
+		req.Days = plan.DurationDays
+	}
+
+	o := &model.SubscriptionOrder{
+		OrderID:   uuid.NewString(),
+		UserID:    req.Username,
+		PlanID:    plan.ID,
+		Kind:      dao.SubscriptionOrderKindNew,
+		Days:      req.Days,
+		PayMethod: dao.SubscriptionPayMethodAdmin,
+		State:     dao.SubscriptionOrderStateCreated,
+		Operator:  admin,
+		Note:      req.Note,
+	}
+	if sub, err := dao.GetUserSubscription(ctx, req.Username); err == nil && sub.PlanID == plan.ID {
+		o.Kind = dao.SubscriptionOrderKindRenew
+	}
+
+	if err = dao.CreateSubscriptionOrder(ctx, o); err == nil {
+		err = dao.ActivateSubscriptionOrder(ctx, o, plan, false)
+	}
+	if err != nil {
+		log.Errorf("grant subscription error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"order": o,
+	}))
+}
+
+// AdminListSubscriptionOrdersHandler 获取套餐订单
+// @Summary 套餐订单
+// @Security ApiKeyAuth
+// @Tags admin
+// @Param username query string false "用户"
+// @Param state query string false "状态: created, paid, cancelled"
+// @Param page query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} JsonObject "{list:[],total:0}"
+// @Router /api/v1/admin/plan/orders [get]
+func AdminListSubscriptionOrdersHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	total, list, err := dao.ListSubscriptionOrders(c.Request.Context(), c.Query("username"), c.Query("state"), dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListSubscriptionOrders error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  list,
+		"total": total,
+	}))
+}
+
+// CheckSubscriptionOrders 查询链上订单, 锁定的金额足够时让套餐生效, 并取消超时未支付的订单
+func CheckSubscriptionOrders(ctx context.Context) error {
+	if chainMgr != nil {
+		orders, err := dao.GetCreatedSubscriptionOrders(ctx, dao.SubscriptionPayMethodChain)
+		if err != nil {
+			return err
+		}
+
+		if len(orders) > 0 {
+			ids := make([]string, 0, len(orders))
+			for _, o := range orders {
+				ids = append(ids, o.OrderID)
+			}
+			tList, err := chainMgr.GetOrders(ids)
+			if err != nil {
+				return fmt.Errorf("get chain orders error:%w", err)
+			}
+
+			paid := make(map[string]*chain.TokenOrder)
+			for _, t := range tList {
+				if t.Status == chain.Active {
+					paid[t.ID] = t
+				}
+			}
+			for _, o := range orders {
+				t, ok := paid[o.OrderID]
+				if !ok || t.LockedFunds < uint64(o.Amount) {
+					continue
+				}
+				err = activateSubscriptionOrder(ctx, o)
+				if err == dao.ErrSubscriptionOrderStale {
+					log.Errorf("paid subscription order %s is stale, flagged for refund", o.OrderID)
+					continue
+				}
+				if err != nil {
+					log.Errorf("activate subscription order %s error: %v", o.OrderID, err)
+				}
+			}
+		}
+	}
+
+	n, err := dao.CancelSubscriptionOrders(ctx, time.Now().Add(-subscriptionOrderTimeout))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Infof("cancelled %d unpaid subscription orders", n)
+	}
+	return nil
+}
+
+// ExpireSubscriptions 到期的套餐恢复为默认的存储空间并通知用户
+func ExpireSubscriptions(ctx context.Context) error {
+	list, err := dao.GetExpiredSubscriptions(ctx, 500)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range list {
+		ok, err := dao.ExpireUserSubscription(ctx, sub.UserID, defaultUserStorageSize)
+		if err != nil {
+			log.Errorf("ExpireUserSubscription %s error: %v", sub.UserID, err)
+			continue
+		}
+		if ok {
+			notifySubscriptionExpired(ctx, sub)
+		}
+	}
+	return nil
+}
+
+// notifySubscriptionExpired 给绑定了邮箱的用户发送套餐到期的邮件
+func notifySubscriptionExpired(ctx context.Context, sub *model.UserSubscription) {
+	user, err := dao.GetUserByUsername(ctx, sub.UserID)
+	if err != nil {
+		log.Errorf("GetUserByUsername %s error: %v", sub.UserID, err)
+		return
+	}
+	sendTo := user.UserEmail
+	if sendTo == "" && strings.Contains(user.Username, "@") {
+		sendTo = user.Username
+	}
+	if sendTo == "" {
+		return
+	}
+
+	var planName string
+	if plan, err := dao.GetStoragePlan(ctx, sub.PlanID); err == nil {
+		planName = plan.Name
+	}
+	subject := "[Titan Network] Your storage plan has expired"
+	content := fmt.Sprintf("Your storage plan %s expired at %s, the storage space has been restored to the free quota. Files are kept, but new uploads are limited until you renew.\n\n"+
+		"您的存储套餐 %s 已于 %s 到期, 存储空间已恢复为免费额度。已上传的文件会保留, 续费前上传将受到限制。",
+		planName, sub.ExpiresAt.Format(time.DateTime), planName, sub.ExpiresAt.Format(time.DateTime))
+	if err = deliverEmail(sendTo, subject, "text/plain", content); err != nil {
+		log.Errorf("send subscription expired email to %s error: %v", sendTo, err)
+	}
+}
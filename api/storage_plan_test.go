@@ -0,0 +1,54 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+func TestQuoteSubscriptionOrder(t *testing.T) {
+	now := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	basic := &model.StoragePlan{ID: 1, Price: 300, DurationDays: 30}
+	pro := &model.StoragePlan{ID: 2, Price: 900, DurationDays: 30}
+	free := &model.StoragePlan{ID: 3, Price: 0, DurationDays: 30}
+
+	active := func(planID int64, days, granted int) *model.UserSubscription {
+		return &model.UserSubscription{PlanID: planID, State: dao.SubscriptionStateActive, ExpiresAt: now.AddDate(0, 0, days), GrantedDays: granted}
+	}
+
+	tests := []struct {
+		name    string
+		sub     *model.UserSubscription
+		cur     *model.StoragePlan
+		plan    *model.StoragePlan
+		periods int
+		kind    string
+		days    int
+		amount  int64
+		credit  int64
+	}{
+		{name: "no subscription", plan: basic, periods: 2, kind: dao.SubscriptionOrderKindNew, days: 60, amount: 600},
+		{name: "expired subscription", sub: &model.UserSubscription{PlanID: 1, State: dao.SubscriptionStateActive, ExpiresAt: now.Add(-time.Hour)},
+			cur: basic, plan: pro, periods: 1, kind: dao.SubscriptionOrderKindNew, days: 30, amount: 900},
+		{name: "renew", sub: active(1, 10, 0), cur: basic, plan: basic, periods: 1, kind: dao.SubscriptionOrderKindRenew, days: 30, amount: 300},
+		{name: "upgrade with credit", sub: active(1, 15, 0), cur: basic, plan: pro, periods: 1, kind: dao.SubscriptionOrderKindUpgrade, days: 30, amount: 750, credit: 150},
+		{name: "downgrade credit becomes days", sub: active(2, 20, 0), cur: pro, plan: basic, periods: 1, kind: dao.SubscriptionOrderKindDowngrade, days: 60, amount: 0, credit: 600},
+		{name: "granted days have no credit", sub: active(1, 15, 15), cur: basic, plan: pro, periods: 1, kind: dao.SubscriptionOrderKindUpgrade, days: 30, amount: 900},
+		{name: "partly granted", sub: active(1, 20, 10), cur: basic, plan: pro, periods: 1, kind: dao.SubscriptionOrderKindUpgrade, days: 30, amount: 800, credit: 100},
+		{name: "downgrade to free plan", sub: active(1, 10, 0), cur: basic, plan: free, periods: 1, kind: dao.SubscriptionOrderKindDowngrade, days: 30, amount: 0, credit: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := quoteSubscriptionOrder(tt.sub, tt.cur, tt.plan, tt.periods, now)
+			if o.Kind != tt.kind || o.Days != tt.days || o.Amount != tt.amount || o.Credit != tt.credit {
+				t.Fatalf("got kind=%s days=%d amount=%d credit=%d, want kind=%s days=%d amount=%d credit=%d",
+					o.Kind, o.Days, o.Amount, o.Credit, tt.kind, tt.days, tt.amount, tt.credit)
+			}
+			if tt.kind != dao.SubscriptionOrderKindNew && (o.SourcePlanID != tt.sub.PlanID || !o.SourceExpiresAt.Equal(tt.sub.ExpiresAt)) {
+				t.Fatalf("source not recorded: %d %v", o.SourcePlanID, o.SourceExpiresAt)
+			}
+		})
+	}
+}
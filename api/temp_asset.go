@@ -0,0 +1,372 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Filecoin-Titan/titan/api/terrors"
+	"github.com/Filecoin-Titan/titan/api/types"
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/config"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/gnasnik/titan-explorer/core/oprds"
+	"github.com/gnasnik/titan-explorer/core/storage"
+)
+
+const (
+	defaultTempAssetTTLHours             = 24
+	defaultTempAssetMaxSize              = 100 << 20
+	defaultTempUploadBytesPerIP          = 1 << 30
+	defaultTempUploadBytesPerFingerprint = 500 << 20
+
+	// tempAssetPurgeBatch 每次清理的过期文件数
+	tempAssetPurgeBatch = 500
+)
+
+// tempStorageConfig 获取未登录上传的配置, 未配置的字段使用默认值
+func tempStorageConfig() config.TempStorageConfig {
+	cfg := config.Cfg.TempStorage
+	if cfg.TTLHours <= 0 {
+		cfg.TTLHours = defaultTempAssetTTLHours
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = defaultTempAssetMaxSize
+	}
+	if cfg.DailyBytesPerIP <= 0 {
+		cfg.DailyBytesPerIP = defaultTempUploadBytesPerIP
+	}
+	if cfg.DailyBytesPerFingerprint <= 0 {
+		cfg.DailyBytesPerFingerprint = defaultTempUploadBytesPerFingerprint
+	}
+	if cfg.MaxDownloads <= 0 {
+		cfg.MaxDownloads = maxTempAssetDownloadCount
+	}
+	if cfg.MaxShares <= 0 {
+		cfg.MaxShares = maxTempAssetShareCount
+	}
+	return cfg
+}
+
+// tempAssetTTL 临时文件的有效期
+func tempAssetTTL() time.Duration {
+	return time.Duration(tempStorageConfig().TTLHours) * time.Hour
+}
+
+// tempAssetExpirationDay 调度器上临时文件的保存天数, 不足一天按一天算
+func tempAssetExpirationDay() int {
+	return (tempStorageConfig().TTLHours + 23) / 24
+}
+
+// checkTempAsset 检查临时文件是否可以访问, 返回错误码, 0 表示可以访问
+func checkTempAsset(ta *model.TempAsset) int {
+	switch {
+	case ta.State == dao.TempAssetStateRemoved:
+		return errors.TempAssetTakenDown
+	case ta.State == dao.TempAssetStateExpired || ta.ExpiresAt.Before(time.Now()):
+		return errors.TempAssetExpired
+	}
+	return 0
+}
+
+// removeTempAssetFromSchedulers 从调度器删除临时文件, 有登录用户保存了相同文件时不删除
+func removeTempAssetFromSchedulers(ctx context.Context, ta *model.TempAsset) error {
+	if ta.Cid == "" || ta.AreaIDs == "" {
+		return nil
+	}
+
+	_, err := dao.GetUserAssetByHash(ctx, ta.Hash)
+	if err == nil {
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	for _, areaID := range strings.Split(ta.AreaIDs, ",") {
+		scli, err := getSchedulerClient(ctx, areaID)
+		if err != nil {
+			log.Errorf("getSchedulerClient %s error: %v", areaID, err)
+			continue
+		}
+		if err = scli.RemoveAssetRecord(ctx, ta.Cid); err != nil {
+			log.Errorf("RemoveAssetRecord %s in %s error: %v", ta.Cid, areaID, err)
+		}
+	}
+	return nil
+}
+
+// PurgeExpiredTempAssets 从调度器删除已过期的临时文件
+func PurgeExpiredTempAssets(ctx context.Context) error {
+	for {
+		list, err := dao.GetExpiredTempAssets(ctx, time.Now(), tempAssetPurgeBatch)
+		if err != nil {
+			return err
+		}
+
+		for _, ta := range list {
+			if err = removeTempAssetFromSchedulers(ctx, ta); err != nil {
+				log.Errorf("remove temp asset %s error: %v", ta.Hash, err)
+				continue
+			}
+			if err = oprds.GetClient().DelUnloginAssetInfo(ctx, ta.Hash); err != nil {
+				log.Errorf("DelUnloginAssetInfo %s error: %v", ta.Hash, err)
+			}
+			if err = dao.UpdateTempAssetState(ctx, ta.Hash, dao.TempAssetStateExpired, ""); err != nil {
+				return err
+			}
+		}
+
+		if len(list) < tempAssetPurgeBatch {
+			return nil
+		}
+	}
+}
+
+// ClaimTempFileReq 认领未登录时上传的文件
+type ClaimTempFileReq struct {
+	AssetCID   string `json:"asset_cid" binding:"required"`
+	ClaimToken string `json:"claim_token" binding:"required"`
+	GroupID    int64  `json:"group_id"`
+}
+
+// ClaimTempFileHandler 注册后把未登录时上传的文件保存到自己的账户
+// @Summary 认领首页上传的文件
+// @Description 使用上传时返回的 X-Claim-Token 把文件保存到当前账户
+// @Security ApiKeyAuth
+// @Tags temp_file
+// @Param req body ClaimTempFileReq true "认领参数"
+// @Success 200 {object} JsonObject "{asset_cid:"",size:0}"
+// @Router /api/v1/storage/temp_file/claim [post]
+func ClaimTempFileHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	var req ClaimTempFileReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	ctx := c.Request.Context()
+
+	up, err := dao.GetTempAssetUploadByToken(ctx, req.ClaimToken)
+	if err == sql.ErrNoRows || err == nil && (up.Cid != req.AssetCID || up.ClaimedBy != "") {
+		c.JSON(http.StatusOK, respErrorCode(errors.TempAssetClaimInvalid, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetTempAssetUploadByToken error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	ta, err := dao.GetTempAssetInfo(ctx, up.Hash)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.TempAssetExpired, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetTempAssetInfo error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if code := checkTempAsset(ta); code != 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
+	areaIDs := strings.Split(ta.AreaIDs, ",")
+	if ta.AreaIDs == "" {
+		c.JSON(http.StatusOK, respErrorCode(errors.TempAssetExpired, c))
+		return
+	}
+
+	user, err := dao.GetUserByUsername(ctx, username)
+	if err != nil {
+		log.Errorf("GetUserByUsername error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.UserNotFound, c))
+		return
+	}
+
+	notExistsAids, err := dao.GetUserAssetNotAreaIDs(ctx, up.Hash, username, areaIDs)
+	if err != nil {
+		log.Errorf("GetUserAssetNotAreaIDs error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if len(notExistsAids) == 0 {
+		c.JSON(http.StatusOK, respErrorCode(errors.FileExists, c))
+		return
+	}
+
+	if user.TenantID == "" && user.TotalStorageSize-user.UsedStorageSize < ta.Size {
+		c.JSON(http.StatusOK, respErrorCode(int(terrors.UserStorageSizeNotEnough), c))
+		return
+	}
+
+	// 先占用凭证, 避免并发认领
+	ok, err := dao.ClaimTempAssetUpload(ctx, up.ID, username)
+	if err != nil {
+		log.Errorf("ClaimTempAssetUpload error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusOK, respErrorCode(errors.TempAssetClaimInvalid, c))
+		return
+	}
+
+	if code := claimTempAsset(ctx, user, up, ta, areaIDs[0], notExistsAids, req.GroupID); code != 0 {
+		if err = dao.UnclaimTempAssetUpload(ctx, up.ID, username); err != nil {
+			log.Errorf("UnclaimTempAssetUpload error: %v", err)
+		}
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"asset_cid": ta.Cid,
+		"size":      ta.Size,
+	}))
+}
+
+// claimTempAsset 在上传的区域把文件的所有者改为用户并保存到用户的文件列表, 返回错误码
+func claimTempAsset(ctx context.Context, user *model.User, up *model.TempAssetUpload, ta *model.TempAsset, sourceArea string, areaIDs []string, groupID int64) int {
+	scli, err := getSchedulerClient(ctx, sourceArea)
+	if err != nil {
+		log.Errorf("getSchedulerClient error: %v", err)
+		return errors.NoSchedulerFound
+	}
+
+	rs, err := scli.GetAssetRecord(ctx, ta.Cid)
+	if err != nil || !isAssetSyncReady(rs.State) {
+		log.Errorf("claim temp asset %s: asset is not ready, err: %v", ta.Cid, err)
+		return errors.TempAssetExpired
+	}
+
+	rsp, err := scli.CreateAsset(ctx, &types.CreateAssetReq{
+		UserID: user.Username, AssetCID: ta.Cid, AssetSize: ta.Size, Owner: user.Username, ExpirationDay: 4 * 365})
+	if err != nil {
+		log.Errorf("claim temp asset CreateAsset error: %v", err)
+		return errors.InternalServer
+	}
+	if !rsp.AlreadyExists {
+		return errors.TempAssetExpired
+	}
+
+	assetName := up.AssetName
+	if assetName == "" {
+		assetName = ta.Cid
+	}
+	if err = dao.AddAssetAndUpdateSize(ctx, &model.UserAsset{
+		UserID:      user.Username,
+		Hash:        ta.Hash,
+		Cid:         ta.Cid,
+		AssetName:   assetName,
+		AssetType:   "file",
+		CreatedTime: time.Now(),
+		TotalSize:   ta.Size,
+		GroupID:     groupID,
+	}, areaIDs, sourceArea); err != nil {
+		log.Errorf("claim temp asset AddAssetAndUpdateSize error: %v", err)
+		return errors.InternalServer
+	}
+	if err = EnqueueUserAssetSync(ctx, user.Username, user.Username, ta.Cid, ta.Hash, sourceArea, areaIDs); err != nil {
+		log.Errorf("EnqueueUserAssetSync error: %v", err)
+	}
+	addAssetVersion(ctx, user.Username, ta.Hash)
+
+	return 0
+}
+
+// AdminTakedownTempFileReq 下架未登录上传的文件
+type AdminTakedownTempFileReq struct {
+	Hash   string `json:"hash"`
+	Cid    string `json:"cid"`
+	Reason string `json:"reason" binding:"required"`
+}
+
+// AdminTakedownTempFileHandler 下架违规的临时文件, 下架后不能再上传, 分享和下载
+// @Summary 下架首页上传的文件
+// @Security ApiKeyAuth
+// @Tags admin
+// @Param req body AdminTakedownTempFileReq true "文件的 hash 或 cid"
+// @Success 200 {object} JsonObject "{}"
+// @Router /api/v1/admin/temp_file/takedown [post]
+func AdminTakedownTempFileHandler(c *gin.Context) {
+	var req AdminTakedownTempFileReq
+	if err := c.ShouldBindJSON(&req); err != nil || req.Hash == "" && req.Cid == "" {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	ctx := c.Request.Context()
+
+	hash := req.Hash
+	if hash == "" {
+		var err error
+		if hash, err = storage.CIDToHash(req.Cid); err != nil {
+			c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+			return
+		}
+	}
+
+	ta, err := dao.GetTempAssetInfo(ctx, hash)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
+		return
+	}
+	if err != nil {
+		log.Errorf("GetTempAssetInfo error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	if err = dao.UpdateTempAssetState(ctx, hash, dao.TempAssetStateRemoved, req.Reason); err != nil {
+		log.Errorf("UpdateTempAssetState error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+	if err = oprds.GetClient().DelUnloginAssetInfo(ctx, hash); err != nil {
+		log.Errorf("DelUnloginAssetInfo error: %v", err)
+	}
+	if err = removeTempAssetFromSchedulers(ctx, ta); err != nil {
+		log.Errorf("removeTempAssetFromSchedulers error: %v", err)
+	}
+
+	c.JSON(http.StatusOK, respJSON(nil))
+}
+
+// AdminListTempFilesHandler 获取未登录上传的文件
+// @Summary 首页上传的文件
+// @Security ApiKeyAuth
+// @Tags admin
+// @Param state query string false "状态: active, expired, removed"
+// @Param hash query string false "文件hash"
+// @Param page query int false "页码"
+// @Param page_size query int false "每页数量"
+// @Success 200 {object} JsonObject "{list:[],total:0}"
+// @Router /api/v1/admin/temp_file/list [get]
+func AdminListTempFilesHandler(c *gin.Context) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+
+	total, list, err := dao.ListTempAssets(c.Request.Context(), c.Query("state"), c.Query("hash"), dao.QueryOption{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		log.Errorf("ListTempAssets error: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	c.JSON(http.StatusOK, respJSON(JsonObject{
+		"list":  list,
+		"total": total,
+	}))
+}
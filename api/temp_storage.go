@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net/http"
@@ -18,12 +19,16 @@ import (
 	"github.com/gnasnik/titan-explorer/core/generated/model"
 	"github.com/gnasnik/titan-explorer/core/oprds"
 	"github.com/gnasnik/titan-explorer/core/storage"
+	"github.com/gnasnik/titan-explorer/pkg/random"
 	"github.com/rs/xid"
 )
 
 const (
 	maxTempAssetDownloadCount int64 = 20
 	maxTempAssetShareCount    int64 = 60
+
+	tempUploadQuotaIP          = "ip"
+	tempUploadQuotaFingerprint = "fp"
 )
 
 type (
@@ -35,12 +40,15 @@ type (
 		AssetSize int64    `json:"asset_size" binding:"required"`
 		AreaIDs   []string `json:"area_ids"` // 最多3个
 		NeedTrace bool     `json:"need_trace"`
+		// Fingerprint 浏览器指纹, 为空时使用请求头 X-Fingerprint
+		Fingerprint string `json:"fingerprint"`
 	}
 )
 
 // UploadTmepFile 未登陆用户受限制上传文件
 // @Summary 首页上传文件
 // @Description 首页上传文件，如果返回的为空数组，则不调用上传接口
+// @Description 新上传的文件在响应头 X-Claim-Token 中返回认领凭证，注册后可以用来把文件保存到账户
 // @Tags temp_file
 // @Param req body UploadTempFileReq true "文件上传参数"
 // @Success 200 {object} JsonObject "{[]{CandidateAddr: “”, Token: “”}}"
@@ -84,9 +92,10 @@ func UploadTempFile(c *gin.Context) {
 		}
 	}
 	req.AreaIDs = areaIDs
+	cfg := tempStorageConfig()
 
-	// 最多只能是100M
-	if req.AssetSize > 100*1024*1024 {
+	// 默认最多只能是100M
+	if req.AssetSize > cfg.MaxSize {
 		c.JSON(http.StatusOK, respErrorCode(int(terrors.UserStorageSizeNotEnough), c))
 		return
 	}
@@ -98,18 +107,27 @@ func UploadTempFile(c *gin.Context) {
 		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
 		return
 	}
+	if code := checkContentAccess(c.Request.Context(), hash, ""); code != 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
 	// 判断文件是否已经存在
 	aids, _ := oprds.GetClient().GetUnloginAssetAreaIDs(c.Request.Context(), hash)
-	// 判断文件是否已经被上传分享60次了
+	// 判断文件是否已经被上传分享60次了, 已过期的文件按新文件上传
 	taInfo, err := dao.GetTempAssetInfo(c.Request.Context(), hash)
 	switch err {
 	case sql.ErrNoRows:
 	case nil:
-		if taInfo.ShareCount >= maxTempAssetShareCount {
+		code := checkTempAsset(taInfo)
+		if code == errors.TempAssetTakenDown {
+			c.JSON(http.StatusOK, respErrorCode(code, c))
+			return
+		}
+		if code == 0 && taInfo.ShareCount >= cfg.MaxShares {
 			c.JSON(http.StatusOK, respErrorCode(errors.TempAssetUploadErr, c))
 			return
 		}
-		if len(aids) != 0 {
+		if code == 0 && len(aids) != 0 {
 			err = dao.AddTempAssetShareCount(c.Request.Context(), hash, req.AssetSize)
 			if err != nil {
 				log.Errorf("AddTempAssetShareCounterror: %v", err)
@@ -123,6 +141,27 @@ func UploadTempFile(c *gin.Context) {
 		return
 	}
 
+	// 每个 ip 和浏览器指纹每天的上传限额
+	ip, err := GetIPFromRequest(c.Request)
+	if err != nil {
+		ip = c.ClientIP()
+	}
+	fingerprint := req.Fingerprint
+	if fingerprint == "" {
+		fingerprint = c.GetHeader("X-Fingerprint")
+	}
+	code := useTempUploadQuota(c.Request.Context(), ip, fingerprint, req.AssetSize, cfg)
+	if code != 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
+	uploaded := false
+	defer func() {
+		if !uploaded {
+			refundTempUploadQuota(c.Request.Context(), ip, fingerprint, req.AssetSize)
+		}
+	}()
+
 	schCli, err := getSchedulerClient(c.Request.Context(), req.AreaIDs[0])
 	if err != nil {
 		log.Errorf("get scheduler client error:%v", err)
@@ -146,7 +185,7 @@ func UploadTempFile(c *gin.Context) {
 		AssetCID:      req.AssetCID,
 		AssetSize:     req.AssetSize,
 		NodeID:        req.NodeID,
-		ExpirationDay: 1,
+		ExpirationDay: tempAssetExpirationDay(),
 		UserID:        uid,
 		Owner:         uid,
 		TraceID:       traceID,
@@ -166,10 +205,6 @@ func UploadTempFile(c *gin.Context) {
 		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
 		return
 	}
-	// 塞到redis中去
-	if len(req.AreaIDs) > 1 {
-		oprds.GetClient().PushAreaIDs(c.Request.Context(), &oprds.AreaIDPayload{CID: req.AssetCID, Hash: hash, AreaIDs: req.AreaIDs})
-	}
 	// 不管是否成功，都先塞到redis中去
 	for i, v := range req.AreaIDs {
 		isSync := false
@@ -178,10 +213,14 @@ func UploadTempFile(c *gin.Context) {
 		}
 		payload.List = append(payload.List, oprds.UnloginSyncAreaDetail{AreaID: v, IsSync: isSync})
 	}
-	err = oprds.GetClient().SetUnloginAssetInfo(c.Request.Context(), hash, &payload)
+	err = oprds.GetClient().SetUnloginAssetInfo(c.Request.Context(), hash, &payload, tempAssetTTL())
 	if err != nil {
 		log.Errorf("SetUnloginAssetInfo error: %v", err)
 	}
+	// 同步到其他区域
+	if err = EnqueueTempAssetSync(c.Request.Context(), req.AssetCID, hash, req.AreaIDs); err != nil {
+		log.Errorf("EnqueueTempAssetSync error: %v", err)
+	}
 
 	if !createAssetRsp.AlreadyExists {
 		for _, v := range createAssetRsp.List {
@@ -189,9 +228,33 @@ func UploadTempFile(c *gin.Context) {
 		}
 	}
 
-	err = dao.AddTempAssetShareCount(c.Request.Context(), hash, req.AssetSize)
+	uploaded = true
+	err = dao.SaveTempAsset(c.Request.Context(), &model.TempAsset{
+		Hash:      hash,
+		Cid:       req.AssetCID,
+		Size:      req.AssetSize,
+		AreaIDs:   strings.Join(req.AreaIDs, ","),
+		ExpiresAt: time.Now().Add(tempAssetTTL()),
+	})
 	if err != nil {
-		log.Errorf("AddTempAssetShareCounterror: %v", err)
+		log.Errorf("SaveTempAsset error: %v", err)
+	}
+
+	// 注册后可以用凭证认领文件
+	claimToken := random.GenerateRandomString(32)
+	err = dao.CreateTempAssetUpload(c.Request.Context(), &model.TempAssetUpload{
+		Hash:        hash,
+		Cid:         req.AssetCID,
+		AssetName:   req.AssetName,
+		ClaimToken:  claimToken,
+		IP:          ip,
+		Fingerprint: fingerprint,
+		Size:        req.AssetSize,
+	})
+	if err != nil {
+		log.Errorf("CreateTempAssetUpload error: %v", err)
+	} else {
+		c.Header("X-Claim-Token", claimToken)
 	}
 
 	// 成功后将此次上传保存到redis，有效期为1天
@@ -204,6 +267,48 @@ func UploadTempFile(c *gin.Context) {
 	// }))
 }
 
+// useTempUploadQuota 计入 ip 和浏览器指纹当天的上传字节数, 超出限额时返回错误码
+func useTempUploadQuota(ctx context.Context, ip, fingerprint string, size int64, cfg config.TempStorageConfig) int {
+	ok, err := oprds.GetClient().IncrTempUploadBytes(ctx, tempUploadQuotaIP, ip, size, cfg.DailyBytesPerIP)
+	if err != nil {
+		log.Errorf("IncrTempUploadBytes error: %v", err)
+		return errors.InternalServer
+	}
+	if !ok {
+		return errors.TempAssetQuotaExceeded
+	}
+	if fingerprint == "" {
+		return 0
+	}
+
+	ok, incrErr := oprds.GetClient().IncrTempUploadBytes(ctx, tempUploadQuotaFingerprint, fingerprint, size, cfg.DailyBytesPerFingerprint)
+	if incrErr == nil && ok {
+		return 0
+	}
+	// 指纹超出限额时退回已计入 ip 的字节数
+	if err = oprds.GetClient().DecrTempUploadBytes(ctx, tempUploadQuotaIP, ip, size); err != nil {
+		log.Errorf("DecrTempUploadBytes error: %v", err)
+	}
+	if incrErr != nil {
+		log.Errorf("IncrTempUploadBytes error: %v", incrErr)
+		return errors.InternalServer
+	}
+	return errors.TempAssetQuotaExceeded
+}
+
+// refundTempUploadQuota 上传失败时退回限额
+func refundTempUploadQuota(ctx context.Context, ip, fingerprint string, size int64) {
+	if err := oprds.GetClient().DecrTempUploadBytes(ctx, tempUploadQuotaIP, ip, size); err != nil {
+		log.Errorf("DecrTempUploadBytes error: %v", err)
+	}
+	if fingerprint == "" {
+		return
+	}
+	if err := oprds.GetClient().DecrTempUploadBytes(ctx, tempUploadQuotaFingerprint, fingerprint, size); err != nil {
+		log.Errorf("DecrTempUploadBytes error: %v", err)
+	}
+}
+
 // ShareTempFile 分享
 func ShareTempFile(c *gin.Context) {
 	var count int64
@@ -216,6 +321,10 @@ func ShareTempFile(c *gin.Context) {
 		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
 		return
 	}
+	if code := checkContentAccess(c.Request.Context(), hash, ""); code != 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
 	// 判断文件是否已经被上传分享60次了
 	taInfo, err := dao.GetTempAssetInfo(c.Request.Context(), hash)
 	switch err {
@@ -223,7 +332,11 @@ func ShareTempFile(c *gin.Context) {
 		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
 		return
 	case nil:
-		if taInfo.ShareCount >= maxTempAssetShareCount {
+		if code := checkTempAsset(taInfo); code != 0 {
+			c.JSON(http.StatusOK, respErrorCode(code, c))
+			return
+		}
+		if taInfo.ShareCount >= tempStorageConfig().MaxShares {
 			c.JSON(http.StatusOK, respErrorCode(errors.TempAssetUploadErr, c))
 			return
 		}
@@ -308,6 +421,10 @@ func DownloadTempFile(c *gin.Context) {
 		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
 		return
 	}
+	if code := checkContentAccess(c.Request.Context(), hash, ""); code != 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
 	// 判断文件是否已经被下载20次了
 	taInfo, err := dao.GetTempAssetInfo(c.Request.Context(), hash)
 	switch err {
@@ -316,7 +433,11 @@ func DownloadTempFile(c *gin.Context) {
 		c.JSON(http.StatusOK, respErrorCode(errors.NotFound, c))
 		return
 	case nil:
-		if taInfo.DownloadCount >= maxTempAssetDownloadCount {
+		if code := checkTempAsset(taInfo); code != 0 {
+			c.JSON(http.StatusOK, respErrorCode(code, c))
+			return
+		}
+		if taInfo.DownloadCount >= tempStorageConfig().MaxDownloads {
 			c.JSON(http.StatusOK, respErrorCode(errors.TempAssetDownErr, c))
 			return
 		}
@@ -415,6 +536,10 @@ func GetUploadInfo(c *gin.Context) {
 		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
 		return
 	}
+	if code := checkContentAccess(c.Request.Context(), hash, ""); code != 0 {
+		c.JSON(http.StatusOK, respErrorCode(code, c))
+		return
+	}
 	// 获取调度器区域
 	aids, err := oprds.GetClient().GetUnloginAssetAreaIDs(c.Request.Context(), hash)
 	if err != nil || len(aids) == 0 {
@@ -0,0 +1,377 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	jwt "github.com/appleboy/gin-jwt/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/errors"
+	"github.com/gnasnik/titan-explorer/pkg/formatter"
+	"github.com/golang-module/carbon/v2"
+)
+
+const (
+	// maxTransferAnalyticsDays 传输分析最大的时间范围
+	maxTransferAnalyticsDays = 31
+	// maxTransferFailureReasons 返回的失败原因数
+	maxTransferFailureReasons = 50
+)
+
+// 传输分析的分组方式
+const (
+	transferGroupArea    = "area"
+	transferGroupNode    = "node"
+	transferGroupCountry = "country"
+	transferGroupType    = "transfer_type"
+	transferGroupHour    = "hour"
+	transferGroupDay     = "day"
+)
+
+// TransferAnalyticsGroup 一组传输记录的统计, 速率和首字节时间只统计成功的传输
+type TransferAnalyticsGroup struct {
+	Key          string  `json:"key"`
+	Total        int64   `json:"total"`
+	Success      int64   `json:"success"`
+	Failure      int64   `json:"failure"`
+	FailRate     float64 `json:"fail_rate"`
+	TotalSize    int64   `json:"total_size"`
+	AvgRate      int64   `json:"avg_rate"`
+	P50Rate      int64   `json:"p50_rate"`
+	P95Rate      int64   `json:"p95_rate"`
+	P99Rate      int64   `json:"p99_rate"`
+	P50FirstByte int64   `json:"p50_first_byte"`
+	P95FirstByte int64   `json:"p95_first_byte"`
+
+	rates, firstBytes []int64
+}
+
+func (g *TransferAnalyticsGroup) add(r *dao.TransferAnalyticsRecord) {
+	g.Total++
+	g.TotalSize += r.TotalSize
+	if r.State != dao.AssetTransferStateSuccess {
+		g.Failure++
+		return
+	}
+	g.Success++
+	g.rates = append(g.rates, r.Rate)
+	if r.FirstByteTime > 0 {
+		g.firstBytes = append(g.firstBytes, r.FirstByteTime)
+	}
+}
+
+func (g *TransferAnalyticsGroup) finish() {
+	if g.Total > 0 {
+		g.FailRate = float64(g.Failure) / float64(g.Total)
+	}
+
+	sort.Slice(g.rates, func(i, j int) bool { return g.rates[i] < g.rates[j] })
+	var sum int64
+	for _, v := range g.rates {
+		sum += v
+	}
+	if len(g.rates) > 0 {
+		g.AvgRate = sum / int64(len(g.rates))
+	}
+	g.P50Rate = percentile(g.rates, 0.5)
+	g.P95Rate = percentile(g.rates, 0.95)
+	g.P99Rate = percentile(g.rates, 0.99)
+
+	sort.Slice(g.firstBytes, func(i, j int) bool { return g.firstBytes[i] < g.firstBytes[j] })
+	g.P50FirstByte = percentile(g.firstBytes, 0.5)
+	g.P95FirstByte = percentile(g.firstBytes, 0.95)
+}
+
+// TransferFailureReason 按状态码和错误信息统计的失败次数
+type TransferFailureReason struct {
+	Key        string `json:"key"`
+	StatusCode int64  `json:"status_code"`
+	Message    string `json:"message"`
+	Count      int64  `json:"count"`
+}
+
+// TransferAnalyticsReport 传输分析结果, Truncated 为 true 时只统计了最近的部分记录
+type TransferAnalyticsReport struct {
+	GroupBy   string                    `json:"group_by"`
+	Start     time.Time                 `json:"start"`
+	End       time.Time                 `json:"end"`
+	Summary   *TransferAnalyticsGroup   `json:"summary"`
+	Groups    []*TransferAnalyticsGroup `json:"groups"`
+	Reasons   []*TransferFailureReason  `json:"reasons"`
+	Truncated bool                      `json:"truncated"`
+}
+
+func transferGroupKey(groupBy, area, nodeID, country, transferType string, createdAt time.Time) string {
+	switch groupBy {
+	case transferGroupArea:
+		return area
+	case transferGroupNode:
+		return nodeID
+	case transferGroupCountry:
+		return country
+	case transferGroupType:
+		return transferType
+	case transferGroupHour:
+		return createdAt.Format("2006-01-02 15:00")
+	default:
+		return createdAt.Format(formatter.TimeFormatDateOnly)
+	}
+}
+
+// buildTransferAnalytics 按分组统计传输速率分位数, 首字节时间和失败原因
+func buildTransferAnalytics(ctx context.Context, filter *dao.TransferAnalyticsFilter, groupBy string) (*TransferAnalyticsReport, error) {
+	records, truncated, err := dao.GetTransferAnalyticsRecords(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("get transfer records: %w", err)
+	}
+	failures, err := dao.GetTransferFailureRecords(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("get transfer failures: %w", err)
+	}
+
+	report := &TransferAnalyticsReport{
+		GroupBy:   groupBy,
+		Start:     filter.Start,
+		End:       filter.End,
+		Summary:   &TransferAnalyticsGroup{Key: "all"},
+		Groups:    make([]*TransferAnalyticsGroup, 0),
+		Reasons:   make([]*TransferFailureReason, 0),
+		Truncated: truncated,
+	}
+
+	groups := make(map[string]*TransferAnalyticsGroup)
+	for _, r := range records {
+		key := transferGroupKey(groupBy, r.Area, r.NodeID, r.Country, r.TransferType, r.CreatedAt)
+		g, ok := groups[key]
+		if !ok {
+			g = &TransferAnalyticsGroup{Key: key}
+			groups[key] = g
+			report.Groups = append(report.Groups, g)
+		}
+		g.add(r)
+		report.Summary.add(r)
+	}
+	report.Summary.finish()
+	for _, g := range report.Groups {
+		g.finish()
+	}
+	sort.Slice(report.Groups, func(i, j int) bool {
+		if groupBy == transferGroupDay || groupBy == transferGroupHour {
+			return report.Groups[i].Key < report.Groups[j].Key
+		}
+		return report.Groups[i].Total > report.Groups[j].Total
+	})
+
+	reasons := make(map[string]*TransferFailureReason)
+	for _, f := range failures {
+		var errs dao.AssetTransferDetailErrors
+		if err := json.Unmarshal([]byte(f.Errors), &errs); err != nil {
+			continue
+		}
+		key := transferGroupKey(groupBy, f.Area, f.NodeID, f.Country, f.TransferType, f.CreatedAt)
+		for _, e := range errs {
+			rk := fmt.Sprintf("%s|%d|%s", key, e.StatusCode, e.Message)
+			reason, ok := reasons[rk]
+			if !ok {
+				reason = &TransferFailureReason{Key: key, StatusCode: e.StatusCode, Message: e.Message}
+				reasons[rk] = reason
+				report.Reasons = append(report.Reasons, reason)
+			}
+			reason.Count++
+		}
+	}
+	sort.Slice(report.Reasons, func(i, j int) bool {
+		return report.Reasons[i].Count > report.Reasons[j].Count
+	})
+	if len(report.Reasons) > maxTransferFailureReasons {
+		report.Reasons = report.Reasons[:maxTransferFailureReasons]
+	}
+
+	return report, nil
+}
+
+// parseTransferAnalyticsFilter 解析过滤条件, 默认最近7天, 最多31天
+func parseTransferAnalyticsFilter(c *gin.Context) (*dao.TransferAnalyticsFilter, string, bool) {
+	filter := &dao.TransferAnalyticsFilter{
+		Area:         c.Query("area_id"),
+		NodeID:       c.Query("node_id"),
+		Country:      c.Query("country"),
+		TransferType: c.Query("transfer_type"),
+	}
+	if filter.TransferType != "" && filter.TransferType != dao.AssetTransferTypeUpload && filter.TransferType != dao.AssetTransferTypeDownload {
+		return nil, "", false
+	}
+
+	groupBy := c.DefaultQuery("group_by", transferGroupDay)
+	switch groupBy {
+	case transferGroupArea, transferGroupNode, transferGroupCountry, transferGroupType, transferGroupHour, transferGroupDay:
+	default:
+		return nil, "", false
+	}
+
+	start, end := carbon.Now().SubDays(6).StartOfDay(), carbon.Now().EndOfDay()
+	if v := c.Query("start_time"); v != "" {
+		start = carbon.Parse(v).StartOfDay()
+	}
+	if v := c.Query("end_time"); v != "" {
+		end = carbon.Parse(v).EndOfDay()
+	}
+	if start.Error != nil || end.Error != nil || start.Gt(end) || start.DiffInDays(end) >= maxTransferAnalyticsDays {
+		return nil, "", false
+	}
+	filter.Start, filter.End = start.StdTime(), end.StdTime()
+
+	return filter, groupBy, true
+}
+
+func respondTransferAnalytics(c *gin.Context, filter *dao.TransferAnalyticsFilter, groupBy string, export bool) {
+	report, err := buildTransferAnalytics(c.Request.Context(), filter, groupBy)
+	if err != nil {
+		log.Errorf("buildTransferAnalytics: %v", err)
+		c.JSON(http.StatusOK, respErrorCode(errors.InternalServer, c))
+		return
+	}
+
+	if !export {
+		c.JSON(http.StatusOK, respJSON(report))
+		return
+	}
+
+	filename := fmt.Sprintf("transfer-%s-%s.csv", groupBy, time.Now().Format(formatter.TimeFormatDateOnly))
+	c.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%s", filename))
+	c.Writer.Header().Set("Content-Type", "text/csv")
+	if err = writeTransferAnalyticsCSV(c, report); err != nil {
+		log.Errorf("write transfer analytics: %v", err)
+	}
+}
+
+// writeTransferAnalyticsCSV 先写分组统计, 空一行后写失败原因
+func writeTransferAnalyticsCSV(c *gin.Context, report *TransferAnalyticsReport) error {
+	w := csv.NewWriter(c.Writer)
+	if err := w.Write([]string{report.GroupBy, "total", "success", "failure", "fail_rate", "total_size", "avg_rate",
+		"p50_rate", "p95_rate", "p99_rate", "p50_first_byte", "p95_first_byte"}); err != nil {
+		return err
+	}
+
+	for _, g := range append([]*TransferAnalyticsGroup{report.Summary}, report.Groups...) {
+		if err := w.Write([]string{
+			g.Key,
+			strconv.FormatInt(g.Total, 10),
+			strconv.FormatInt(g.Success, 10),
+			strconv.FormatInt(g.Failure, 10),
+			strconv.FormatFloat(g.FailRate, 'f', 4, 64),
+			strconv.FormatInt(g.TotalSize, 10),
+			strconv.FormatInt(g.AvgRate, 10),
+			strconv.FormatInt(g.P50Rate, 10),
+			strconv.FormatInt(g.P95Rate, 10),
+			strconv.FormatInt(g.P99Rate, 10),
+			strconv.FormatInt(g.P50FirstByte, 10),
+			strconv.FormatInt(g.P95FirstByte, 10),
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := w.Write([]string{}); err != nil {
+		return err
+	}
+	if err := w.Write([]string{report.GroupBy, "status_code", "message", "count"}); err != nil {
+		return err
+	}
+	for _, r := range report.Reasons {
+		if err := w.Write([]string{r.Key, strconv.FormatInt(r.StatusCode, 10), r.Message, strconv.FormatInt(r.Count, 10)}); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// GetTransferAnalyticsHandler 获取用户的传输分析
+// @Summary 传输分析
+// @Security ApiKeyAuth
+// @Tags storage
+// @Param group_by query string false "分组: area, node, country, transfer_type, hour, day"
+// @Param area_id query string false "区域"
+// @Param node_id query string false "节点"
+// @Param country query string false "国家"
+// @Param transfer_type query string false "upload, download"
+// @Param start_time query string false "开始日期"
+// @Param end_time query string false "结束日期"
+// @Success 200 {object} TransferAnalyticsReport
+// @Router /api/v1/storage/transfer/analytics [get]
+func GetTransferAnalyticsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	filter, groupBy, ok := parseTransferAnalyticsFilter(c)
+	if !ok {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	filter.UserID = username
+
+	respondTransferAnalytics(c, filter, groupBy, false)
+}
+
+// ExportTransferAnalyticsHandler 导出用户的传输分析
+// @Summary 导出传输分析
+// @Security ApiKeyAuth
+// @Tags storage
+// @Router /api/v1/storage/transfer/analytics/export [get]
+func ExportTransferAnalyticsHandler(c *gin.Context) {
+	claims := jwt.ExtractClaims(c)
+	username := claims[identityKey].(string)
+
+	filter, groupBy, ok := parseTransferAnalyticsFilter(c)
+	if !ok {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	filter.UserID = username
+
+	respondTransferAnalytics(c, filter, groupBy, true)
+}
+
+// AdminGetTransferAnalyticsHandler 获取所有用户或指定用户的传输分析
+// @Summary 传输分析
+// @Security ApiKeyAuth
+// @Tags admin
+// @Param user_id query string false "用户"
+// @Success 200 {object} TransferAnalyticsReport
+// @Router /api/v1/admin/transfer/analytics [get]
+func AdminGetTransferAnalyticsHandler(c *gin.Context) {
+	filter, groupBy, ok := parseTransferAnalyticsFilter(c)
+	if !ok {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	filter.UserID = c.Query("user_id")
+
+	respondTransferAnalytics(c, filter, groupBy, false)
+}
+
+// AdminExportTransferAnalyticsHandler 导出传输分析
+// @Summary 导出传输分析
+// @Security ApiKeyAuth
+// @Tags admin
+// @Param user_id query string false "用户"
+// @Router /api/v1/admin/transfer/analytics/export [get]
+func AdminExportTransferAnalyticsHandler(c *gin.Context) {
+	filter, groupBy, ok := parseTransferAnalyticsFilter(c)
+	if !ok {
+		c.JSON(http.StatusOK, respErrorCode(errors.InvalidParams, c))
+		return
+	}
+	filter.UserID = c.Query("user_id")
+
+	respondTransferAnalytics(c, filter, groupBy, true)
+}
@@ -262,7 +262,7 @@ func GetNonceStringHandler(c *gin.Context) {
 			Username:         username,
 			CreatedAt:        time.Now(),
 			UpdatedAt:        time.Now(),
-			TotalStorageSize: 100 * 1024 * 1024,
+			TotalStorageSize: defaultUserStorageSize,
 			ReferralCode:     random.GenerateRandomString(6),
 		}
 		err = dao.CreateUser(c.Request.Context(), user)
@@ -272,7 +272,7 @@ func GetNonceStringHandler(c *gin.Context) {
 		}
 	case nil:
 		if info.TotalStorageSize == 0 {
-			err = dao.UpdateUserTotalSize(c.Request.Context(), info.Username, 100*1024*1024)
+			err = dao.UpdateUserTotalSize(c.Request.Context(), info.Username, defaultUserStorageSize)
 			if err != nil {
 				log.Errorf(err.Error())
 			}
@@ -482,6 +482,8 @@ func DeviceBindingHandler(c *gin.Context) {
 		return
 	}
 
+	addDeviceEvents(c.Request.Context(), dao.NewDeviceEvent(params.NodeId, sign.Username, dao.DeviceEventBind, sign.Username, "", sign.Username))
+
 	if err := dao.UpdateDeviceInfoDailyUser(c.Request.Context(), params.NodeId, sign.Username); err != nil {
 		log.Errorf("binding update device info daily: %v", err)
 	}
@@ -538,6 +540,8 @@ func DeviceUnBindingHandlerOld(c *gin.Context) {
 		return
 	}
 
+	addDeviceEvents(c.Request.Context(), dao.NewDeviceEvent(deviceInfo.DeviceID, old.UserID, dao.DeviceEventUnbind, UserID, old.UserID, ""))
+
 	c.JSON(http.StatusOK, respJSON(JsonObject{
 		"msg": "success",
 	}))
@@ -573,6 +577,8 @@ func DeviceUpdateHandler(c *gin.Context) {
 		return
 	}
 
+	addDeviceEvents(c.Request.Context(), dao.NewDeviceEvent(deviceId, username, dao.DeviceEventRename, username, old.DeviceName, deviceName))
+
 	c.JSON(http.StatusOK, respJSON(JsonObject{
 		"msg": "success",
 	}))
@@ -23,11 +23,75 @@ type Config struct {
 	Oss                      OssConfig
 	Locators                 []string
 	BaseURL                  string
+	// TrashRetentionDays 回收站的保留天数, 为 0 时默认 30 天
+	TrashRetentionDays int
+	// IPFSAPIs ipfs节点的 RPC 地址, 导入导出时按顺序切换, 为空时使用默认节点
+	IPFSAPIs []string
+	// CarExportDir 生成 CAR 文件的临时目录, 生成后上传到 oss, 为空时使用系统临时目录
+	CarExportDir string
+	// FilBackupAlertDays Filecoin 订单到期前多少天提醒, 为 0 时默认 30 天
+	FilBackupAlertDays int
+	// FilBackupRenewDays Filecoin 订单到期前多少天重新备份, 为 0 时默认 14 天
+	FilBackupRenewDays int
+	// ShareLinkHosts 分享链接允许跳转的域名, 子域名也允许, 为空时只允许 BaseURL 的域名
+	ShareLinkHosts []string
+	// TempStorage 未登录用户上传文件的限制
+	TempStorage TempStorageConfig
+	// Gateway 签名下载网关, 开启后下载地址指向 /d/:token
+	Gateway GatewayConfig
+	// Preview 文件预览的生成配置
+	Preview PreviewConfig
 
 	KubesphereAPI KubesphereAPIConfig
 	ChainAPI      ChainAPIConfig
 }
 
+// TempStorageConfig 未登录上传的配置, 为 0 的字段使用默认值
+type TempStorageConfig struct {
+	// TTLHours 临时文件的保存时间, 默认 24 小时
+	TTLHours int
+	// MaxSize 单个文件的最大字节数, 默认 100M
+	MaxSize int64
+	// DailyBytesPerIP 每个 ip 每天最多上传的字节数, 默认 1G
+	DailyBytesPerIP int64
+	// DailyBytesPerFingerprint 每个浏览器指纹每天最多上传的字节数, 默认 500M
+	DailyBytesPerFingerprint int64
+	// MaxDownloads 每个文件最多下载次数, 默认 20
+	MaxDownloads int64
+	// MaxShares 每个文件最多上传和分享次数, 默认 60
+	MaxShares int64
+}
+
+// GatewayConfig 下载网关的配置, 为 0 的字段使用默认值
+type GatewayConfig struct {
+	// Enable 是否开启下载网关
+	Enable bool
+	// Proxy 为 true 时由网关代理节点的数据, 否则重定向到节点
+	Proxy bool
+	// TokenTTLSeconds 下载链接的有效期, 默认 600 秒
+	TokenTTLSeconds int
+	// BindIP 下载链接是否只允许申请时的 ip 使用
+	BindIP bool
+	// MaxBytesPerSecond 代理模式下单个请求的限速, 为 0 时不限速
+	MaxBytesPerSecond int64
+}
+
+// PreviewConfig 文件预览的配置, 为 0 的字段使用默认值
+type PreviewConfig struct {
+	// Enable 是否在上传完成后生成预览
+	Enable bool
+	// MaxImageSize 生成缩略图的图片最大字节数, 默认 20M
+	MaxImageSize int64
+	// MaxImagePixels 生成缩略图的图片最大像素数, 避免解码超大尺寸的图片, 默认 5000 万
+	MaxImagePixels int
+	// ThumbnailSize 缩略图最长边的像素, 默认 320
+	ThumbnailSize int
+	// URLExpireSeconds 预览签名地址的有效期, 默认 3600 秒
+	URLExpireSeconds int64
+	// FFmpegPath 截取视频封面的 ffmpeg 路径, 为空时从 PATH 查找, 找不到时只读取视频信息
+	FFmpegPath string
+}
+
 type EmailConfig struct {
 	From     string
 	Nickname string
@@ -40,6 +104,10 @@ type EmailConfig struct {
 type StatisticsConfig struct {
 	Disable bool
 	Crontab string
+	// AnomalyCrontab 节点异常检测的执行时间, 为空时不执行
+	AnomalyCrontab string
+	// ExcludeFlaggedReward 统计用户收益时排除审核确认作弊的节点在被标记日期内的收益
+	ExcludeFlaggedReward bool
 }
 
 type AdminSchedulerConfig struct {
@@ -0,0 +1,177 @@
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Rule 异常检测规则
+type Rule string
+
+const (
+	RuleIPSharing   Rule = "ip_sharing"
+	RuleGeoJump     Rule = "geo_jump"
+	RuleFingerprint Rule = "duplicate_fingerprint"
+	RuleRewardSpike Rule = "reward_spike"
+)
+
+// earthRadiusKm 地球平均半径
+const earthRadiusKm = 6371.0
+
+// maxScore 单个节点的最高分
+const maxScore = 100
+
+// Thresholds 各条规则的阈值
+type Thresholds struct {
+	// MaxNodesPerIP 同一个公网IP下允许的节点数量
+	MaxNodesPerIP int
+	// MaxSpeedKmh 两次IP变化之间允许的最大移动速度
+	MaxSpeedKmh float64
+	// MaxFingerprintDevices 允许使用相同硬件指纹的节点数量
+	MaxFingerprintDevices int
+	// SpikeRatio 当天收益超过历史日均收益的倍数
+	SpikeRatio float64
+	// MinSpikeIncome 当天收益低于该值时不认为是收益突增
+	MinSpikeIncome float64
+	// FlagScore 得分达到该值的节点会被标记
+	FlagScore float64
+}
+
+// DefaultThresholds 默认阈值
+var DefaultThresholds = Thresholds{
+	MaxNodesPerIP:         5,
+	MaxSpeedKmh:           100,
+	MaxFingerprintDevices: 2,
+	SpikeRatio:            5,
+	MinSpikeIncome:        1,
+	FlagScore:             50,
+}
+
+// IPPoint 节点在某个时间使用的IP及其位置
+type IPPoint struct {
+	Time        time.Time
+	IP          string
+	Latitude    float64
+	Longitude   float64
+	HasLocation bool
+}
+
+// Signals 用于检测的节点数据
+type Signals struct {
+	DeviceID string
+	UserID   string
+	// NodesOnIP 与该节点使用同一个公网IP的节点数量(包含自己)
+	NodesOnIP int
+	// FingerprintDevices 与该节点硬件指纹相同的节点数量(包含自己)
+	FingerprintDevices int
+	// IPHistory 节点的IP变化记录
+	IPHistory []IPPoint
+	// TodayIncome 当天收益
+	TodayIncome float64
+	// AvgIncome 之前的日均收益
+	AvgIncome float64
+}
+
+// Evidence 命中规则的证据
+type Evidence struct {
+	Rule   Rule    `json:"rule"`
+	Score  float64 `json:"score"`
+	Detail string  `json:"detail"`
+}
+
+// Result 节点的检测结果
+type Result struct {
+	DeviceID string      `json:"device_id"`
+	UserID   string      `json:"user_id"`
+	Score    float64     `json:"score"`
+	Evidence []*Evidence `json:"evidence"`
+}
+
+// Flagged 是否需要标记
+func (r *Result) Flagged(t Thresholds) bool {
+	return r.Score >= t.FlagScore
+}
+
+// Evaluate 按规则给节点打分, 分数为命中规则的分数之和, 最高 100 分
+func Evaluate(s *Signals, t Thresholds) *Result {
+	result := &Result{DeviceID: s.DeviceID, UserID: s.UserID, Evidence: make([]*Evidence, 0)}
+
+	add := func(rule Rule, score float64, format string, args ...interface{}) {
+		result.Evidence = append(result.Evidence, &Evidence{Rule: rule, Score: score, Detail: fmt.Sprintf(format, args...)})
+		result.Score += score
+	}
+
+	if t.MaxNodesPerIP > 0 && s.NodesOnIP > t.MaxNodesPerIP {
+		add(RuleIPSharing, math.Min(20+5*float64(s.NodesOnIP-t.MaxNodesPerIP), 40),
+			"%d nodes share the same public ip, limit %d", s.NodesOnIP, t.MaxNodesPerIP)
+	}
+
+	if t.MaxFingerprintDevices > 0 && s.FingerprintDevices > t.MaxFingerprintDevices {
+		add(RuleFingerprint, math.Min(30+5*float64(s.FingerprintDevices-t.MaxFingerprintDevices), 50),
+			"%d nodes share the same cpu/mac fingerprint, limit %d", s.FingerprintDevices, t.MaxFingerprintDevices)
+	}
+
+	if t.MaxSpeedKmh > 0 {
+		for _, jump := range GeoJumps(s.IPHistory, t.MaxSpeedKmh) {
+			add(RuleGeoJump, 50, "%s", jump)
+			// 一次不可能的位置跳变已经足够标记
+			break
+		}
+	}
+
+	if t.SpikeRatio > 0 && s.TodayIncome >= t.MinSpikeIncome && s.TodayIncome > s.AvgIncome*t.SpikeRatio {
+		add(RuleRewardSpike, 30, "today income %.4f is more than %.1fx of daily average %.4f", s.TodayIncome, t.SpikeRatio, s.AvgIncome)
+	}
+
+	if result.Score > maxScore {
+		result.Score = maxScore
+	}
+
+	return result
+}
+
+// GeoJumps 找出移动速度超过 maxSpeedKmh 的IP变化, 没有位置信息的记录会被忽略
+func GeoJumps(history []IPPoint, maxSpeedKmh float64) []string {
+	points := make([]IPPoint, 0, len(history))
+	for _, p := range history {
+		if p.HasLocation {
+			points = append(points, p)
+		}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+
+	var out []string
+	for i := 1; i < len(points); i++ {
+		prev, cur := points[i-1], points[i]
+		if prev.IP == cur.IP {
+			continue
+		}
+
+		km := Distance(prev.Latitude, prev.Longitude, cur.Latitude, cur.Longitude)
+		hours := cur.Time.Sub(prev.Time).Hours()
+		if hours <= 0 {
+			hours = 1
+		}
+
+		if km/hours > maxSpeedKmh {
+			out = append(out, fmt.Sprintf("ip changed from %s to %s (%.0f km) within %.0f hours", prev.IP, cur.IP, km, hours))
+		}
+	}
+
+	return out
+}
+
+// Distance 计算两个经纬度之间的球面距离, 单位 km
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
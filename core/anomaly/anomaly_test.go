@@ -0,0 +1,70 @@
+package anomaly
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDistance(t *testing.T) {
+	// 北京 - 上海 约 1067 km
+	km := Distance(39.9042, 116.4074, 31.2304, 121.4737)
+	if math.Abs(km-1067) > 10 {
+		t.Fatalf("expect about 1067 km, got %.2f", km)
+	}
+
+	if Distance(10, 10, 10, 10) != 0 {
+		t.Fatal("expect zero distance")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	day := time.Date(2024, 12, 20, 0, 0, 0, 0, time.UTC)
+
+	normal := Evaluate(&Signals{
+		DeviceID:           "e_1",
+		NodesOnIP:          2,
+		FingerprintDevices: 1,
+		IPHistory: []IPPoint{
+			{Time: day, IP: "1.1.1.1", Latitude: 39.9, Longitude: 116.4, HasLocation: true},
+			{Time: day.Add(24 * time.Hour), IP: "1.1.1.2", Latitude: 39.8, Longitude: 116.3, HasLocation: true},
+		},
+		TodayIncome: 2,
+		AvgIncome:   1.5,
+	}, DefaultThresholds)
+
+	if normal.Score != 0 || normal.Flagged(DefaultThresholds) {
+		t.Fatalf("expect normal device not flagged, got %+v", normal)
+	}
+
+	cheat := Evaluate(&Signals{
+		DeviceID:           "e_2",
+		NodesOnIP:          20,
+		FingerprintDevices: 1,
+		IPHistory: []IPPoint{
+			{Time: day, IP: "1.1.1.1", Latitude: 39.9, Longitude: 116.4, HasLocation: true},
+			{Time: day.Add(24 * time.Hour), IP: "8.8.8.8", Latitude: 37.4, Longitude: -122.1, HasLocation: true},
+		},
+		TodayIncome: 10,
+		AvgIncome:   1,
+	}, DefaultThresholds)
+
+	if !cheat.Flagged(DefaultThresholds) {
+		t.Fatalf("expect device flagged, got %+v", cheat)
+	}
+
+	if cheat.Score != maxScore {
+		t.Fatalf("expect score capped at %d, got %.2f", maxScore, cheat.Score)
+	}
+
+	rules := make(map[Rule]bool)
+	for _, e := range cheat.Evidence {
+		rules[e.Rule] = true
+	}
+
+	for _, rule := range []Rule{RuleIPSharing, RuleGeoJump, RuleRewardSpike} {
+		if !rules[rule] {
+			t.Errorf("expect rule %s hit", rule)
+		}
+	}
+}
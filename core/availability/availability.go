@@ -0,0 +1,216 @@
+package availability
+
+import (
+	"sort"
+	"time"
+)
+
+// Period 统计周期
+type Period string
+
+const (
+	PeriodDaily   Period = "daily"
+	PeriodWeekly  Period = "weekly"
+	PeriodMonthly Period = "monthly"
+)
+
+// downThreshold 单个采样区间内在线时长占比低于该值时, 该区间视为离线
+const downThreshold = 0.5
+
+// Sample device_info_hour 中的一条采样, OnlineTime 为节点累计在线分钟数
+type Sample struct {
+	Time       time.Time `db:"time"`
+	OnlineTime float64   `db:"online_time"`
+}
+
+// Outage 一次连续的离线窗口
+type Outage struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration float64   `json:"duration"` // 分钟
+}
+
+// Report 单个节点在某一时间范围内的可用性统计
+type Report struct {
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Uptime        float64   `json:"uptime"` // 百分比
+	OnlineMinutes float64   `json:"online_minutes"`
+	TotalMinutes  float64   `json:"total_minutes"`
+	MTBF          float64   `json:"mtbf"` // 平均无故障时间, 分钟
+	MTTR          float64   `json:"mttr"` // 平均恢复时间, 分钟
+	Outages       []*Outage `json:"outages"`
+}
+
+// Bucket 按周期划分后的可用率
+type Bucket struct {
+	Start  time.Time `json:"start"`
+	Uptime float64   `json:"uptime"`
+}
+
+// Calculate 根据累计在线时长的采样计算 [start, end] 内的可用率、离线窗口以及 MTBF/MTTR.
+// 相邻两次采样之间的在线时长取累计值的差, 因此即使中间缺少采样也不会把在线时间算错.
+func Calculate(samples []Sample, start, end time.Time) *Report {
+	report := &Report{Start: start, End: end, Outages: make([]*Outage, 0)}
+
+	points := clip(samples, start, end)
+	if len(points) < 2 {
+		return report
+	}
+
+	var current *Outage
+	for i := 1; i < len(points); i++ {
+		prev, next := points[i-1], points[i]
+		elapsed := next.Time.Sub(prev.Time).Minutes()
+		if elapsed <= 0 {
+			continue
+		}
+
+		online := next.OnlineTime - prev.OnlineTime
+		if online < 0 {
+			online = 0
+		}
+		if online > elapsed {
+			online = elapsed
+		}
+
+		report.TotalMinutes += elapsed
+		report.OnlineMinutes += online
+
+		if online/elapsed < downThreshold {
+			if current == nil {
+				current = &Outage{Start: prev.Time}
+			}
+			current.End = next.Time
+			current.Duration += elapsed - online
+			continue
+		}
+
+		if current != nil {
+			report.Outages = append(report.Outages, current)
+			current = nil
+		}
+	}
+
+	if current != nil {
+		report.Outages = append(report.Outages, current)
+	}
+
+	if report.TotalMinutes > 0 {
+		report.Uptime = report.OnlineMinutes / report.TotalMinutes * 100
+	}
+
+	if n := float64(len(report.Outages)); n > 0 {
+		var downMinutes float64
+		for _, o := range report.Outages {
+			downMinutes += o.Duration
+		}
+		report.MTTR = downMinutes / n
+		report.MTBF = report.OnlineMinutes / n
+	} else {
+		report.MTBF = report.OnlineMinutes
+	}
+
+	return report
+}
+
+// Buckets 按 period 把 [start, end) 划分为多个区间, 分别计算每个区间的可用率
+func Buckets(samples []Sample, period Period, start, end time.Time) []*Bucket {
+	out := make([]*Bucket, 0)
+	for st := periodStart(start, period); st.Before(end); st = nextPeriod(st, period) {
+		ed := nextPeriod(st, period)
+		if ed.After(end) {
+			ed = end
+		}
+		out = append(out, &Bucket{Start: st, Uptime: Calculate(samples, st, ed).Uptime})
+	}
+	return out
+}
+
+// FleetSummary 多个节点可用性的汇总
+type FleetSummary struct {
+	Nodes       int     `json:"nodes"`
+	AvgUptime   float64 `json:"avg_uptime"`
+	MinUptime   float64 `json:"min_uptime"`
+	OutageCount int     `json:"outage_count"`
+	Target      float64 `json:"target"`
+	MeetTarget  int     `json:"meet_target"` // 可用率达到 Target 的节点数
+}
+
+// Summarize 汇总多个节点的可用性报告, target 为 SLA 目标可用率(百分比)
+func Summarize(reports []*Report, target float64) *FleetSummary {
+	summary := &FleetSummary{Nodes: len(reports), Target: target}
+	if len(reports) == 0 {
+		return summary
+	}
+
+	summary.MinUptime = 100
+	var total float64
+	for _, r := range reports {
+		total += r.Uptime
+		summary.OutageCount += len(r.Outages)
+		if r.Uptime < summary.MinUptime {
+			summary.MinUptime = r.Uptime
+		}
+		if r.Uptime >= target {
+			summary.MeetTarget++
+		}
+	}
+	summary.AvgUptime = total / float64(len(reports))
+
+	return summary
+}
+
+// Range 返回 period 对应的默认统计范围: 日报为最近 30 天, 周报为最近 12 周, 月报为最近 6 个月
+func Range(period Period, now time.Time) (time.Time, time.Time) {
+	end := periodStart(now, PeriodDaily).AddDate(0, 0, 1)
+	switch period {
+	case PeriodWeekly:
+		return periodStart(now, PeriodWeekly).AddDate(0, 0, -7*11), end
+	case PeriodMonthly:
+		return periodStart(now, PeriodMonthly).AddDate(0, -5, 0), end
+	default:
+		return end.AddDate(0, 0, -30), end
+	}
+}
+
+// clip 对采样排序并截取 [start, end] 范围内的采样
+func clip(samples []Sample, start, end time.Time) []Sample {
+	var out []Sample
+	for _, s := range samples {
+		if s.Time.Before(start) || s.Time.After(end) {
+			continue
+		}
+		out = append(out, s)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Time.Before(out[j].Time)
+	})
+	return out
+}
+
+func periodStart(t time.Time, period Period) time.Time {
+	y, m, d := t.Date()
+	switch period {
+	case PeriodWeekly:
+		day := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+		offset := (int(day.Weekday()) + 6) % 7
+		return day.AddDate(0, 0, -offset)
+	case PeriodMonthly:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	}
+}
+
+func nextPeriod(t time.Time, period Period) time.Time {
+	switch period {
+	case PeriodWeekly:
+		return t.AddDate(0, 0, 7)
+	case PeriodMonthly:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
@@ -0,0 +1,61 @@
+package availability
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalculate(t *testing.T) {
+	start := time.Date(2024, 12, 1, 0, 0, 0, 0, time.Local)
+
+	// 0-3 点在线, 3-5 点离线, 5-6 点在线
+	samples := []Sample{
+		{Time: start, OnlineTime: 100},
+		{Time: start.Add(time.Hour), OnlineTime: 160},
+		{Time: start.Add(2 * time.Hour), OnlineTime: 220},
+		{Time: start.Add(3 * time.Hour), OnlineTime: 280},
+		{Time: start.Add(4 * time.Hour), OnlineTime: 280},
+		{Time: start.Add(5 * time.Hour), OnlineTime: 285},
+		{Time: start.Add(6 * time.Hour), OnlineTime: 345},
+	}
+
+	report := Calculate(samples, start, start.Add(6*time.Hour))
+	if report.TotalMinutes != 360 {
+		t.Fatalf("total minutes: got %v", report.TotalMinutes)
+	}
+	if report.OnlineMinutes != 245 {
+		t.Fatalf("online minutes: got %v", report.OnlineMinutes)
+	}
+	if len(report.Outages) != 1 {
+		t.Fatalf("outages: got %d", len(report.Outages))
+	}
+
+	outage := report.Outages[0]
+	if !outage.Start.Equal(start.Add(3*time.Hour)) || !outage.End.Equal(start.Add(5*time.Hour)) {
+		t.Fatalf("outage window: %v - %v", outage.Start, outage.End)
+	}
+	if report.MTTR != 115 {
+		t.Fatalf("mttr: got %v", report.MTTR)
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	start := time.Date(2024, 12, 2, 0, 0, 0, 0, time.Local)
+
+	var samples []Sample
+	for i := 0; i <= 48; i++ {
+		online := float64(i * 60)
+		if i > 24 {
+			online = 24 * 60
+		}
+		samples = append(samples, Sample{Time: start.Add(time.Duration(i) * time.Hour), OnlineTime: online})
+	}
+
+	buckets := Buckets(samples, PeriodDaily, start, start.AddDate(0, 0, 2))
+	if len(buckets) != 2 {
+		t.Fatalf("buckets: got %d", len(buckets))
+	}
+	if buckets[0].Uptime != 100 || buckets[1].Uptime != 0 {
+		t.Fatalf("uptime: got %v %v", buckets[0].Uptime, buckets[1].Uptime)
+	}
+}
@@ -0,0 +1,138 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const tableNameAreaTransferStats = "area_transfer_stats"
+
+// AreaTransferSample 统计区域传输表现用到的传输记录
+type AreaTransferSample struct {
+	Area         string `db:"area"`
+	Country      string `db:"country"`
+	Isp          string `db:"isp"`
+	TransferType string `db:"transfer_type"`
+	State        int64  `db:"state"`
+	Rate         int64  `db:"rate"`
+}
+
+// GetAreaTransferSamples 获取某个时间之后已完成的传输记录
+func GetAreaTransferSamples(ctx context.Context, since time.Time) ([]*AreaTransferSample, error) {
+	out := make([]*AreaTransferSample, 0)
+	err := DB.SelectContext(ctx, &out, `SELECT area, country, isp, transfer_type, state, rate FROM asset_transfer_log
+		WHERE created_at >= ? AND area <> '' AND state IN (?, ?)`, since, AssetTransferStateSuccess, AssetTransferStateFailure)
+	return out, err
+}
+
+// SaveAreaTransferStats 保存区域的传输表现, 并删除本次没有统计到的旧记录
+func SaveAreaTransferStats(ctx context.Context, list []*model.AreaTransferStats, updatedAt time.Time) error {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := 0; i < len(list); i += 500 {
+		end := i + 500
+		if end > len(list) {
+			end = len(list)
+		}
+
+		ib := squirrel.Insert(tableNameAreaTransferStats).Columns("area_id,country,isp,transfer_type,samples,p50_rate,p95_rate,fail_rate,updated_at")
+		for _, s := range list[i:end] {
+			ib = ib.Values(s.AreaID, s.Country, s.Isp, s.TransferType, s.Samples, s.P50Rate, s.P95Rate, s.FailRate, updatedAt)
+		}
+		query, args, err := ib.Suffix(`ON DUPLICATE KEY UPDATE samples = VALUES(samples), p50_rate = VALUES(p50_rate), p95_rate = VALUES(p95_rate),
+			fail_rate = VALUES(fail_rate), updated_at = VALUES(updated_at)`).ToSql()
+		if err != nil {
+			return fmt.Errorf("generate insert area transfer stats sql error:%w", err)
+		}
+		if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE updated_at < ?`, tableNameAreaTransferStats), updatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAreaTransferStats 获取区域对某个国家和运营商的传输表现, 包括国家级和全局的汇总
+func GetAreaTransferStats(ctx context.Context, areaIDs []string, transferType, country, isp string) ([]*model.AreaTransferStats, error) {
+	out := make([]*model.AreaTransferStats, 0)
+	if len(areaIDs) == 0 {
+		return out, nil
+	}
+
+	query, args, err := sqlx.In(fmt.Sprintf(`SELECT * FROM %s WHERE area_id IN (?) AND transfer_type = ? AND country IN (?, '') AND isp IN (?, '')`,
+		tableNameAreaTransferStats), areaIDs, transferType, country, isp)
+	if err != nil {
+		return nil, err
+	}
+	err = DB.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+// ListAreaTransferStats 获取区域的传输表现
+func ListAreaTransferStats(ctx context.Context, areaID, country string, option QueryOption) (int64, []*model.AreaTransferStats, error) {
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	sb := squirrel.Select().From(tableNameAreaTransferStats)
+	if areaID != "" {
+		sb = sb.Where("area_id = ?", areaID)
+	}
+	if country != "" {
+		sb = sb.Where("country = ?", country)
+	}
+
+	query, args, err := sb.Column("COUNT(1)").ToSql()
+	if err != nil {
+		return 0, nil, fmt.Errorf("generate count area transfer stats sql error:%w", err)
+	}
+	var total int64
+	if err = DB.GetContext(ctx, &total, query, args...); err != nil {
+		return 0, nil, err
+	}
+
+	query, args, err = sb.Column("*").OrderBy("samples DESC").Limit(uint64(limit)).Offset(uint64(offset)).ToSql()
+	if err != nil {
+		return 0, nil, fmt.Errorf("generate list area transfer stats sql error:%w", err)
+	}
+	out := make([]*model.AreaTransferStats, 0)
+	if err = DB.SelectContext(ctx, &out, query, args...); err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
+
+// AreaCapacity 区域的在线节点数和上行带宽
+type AreaCapacity struct {
+	AreaID      string  `db:"area_id" json:"area_id"`
+	OnlineNodes int64   `db:"online_nodes" json:"online_nodes"`
+	BandwidthUp float64 `db:"bandwidth_up" json:"bandwidth_up"`
+}
+
+// GetAreaCapacities 统计各区域的在线节点数和上行带宽
+func GetAreaCapacities(ctx context.Context) ([]*AreaCapacity, error) {
+	out := make([]*AreaCapacity, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT area_id, COUNT(1) AS online_nodes, IFNULL(SUM(bandwidth_up), 0) AS bandwidth_up
+		FROM %s WHERE device_status_code = 1 AND area_id <> '' GROUP BY area_id`, tableNameDeviceInfo))
+	return out, err
+}
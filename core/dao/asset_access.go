@@ -0,0 +1,176 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+)
+
+const (
+	tableAssetAccessDaily     = "asset_access_daily"
+	tableAssetAccessDimension = "asset_access_dimension"
+)
+
+// 文件访问的统计维度
+const (
+	AssetAccessDimensionCountry  = "country"
+	AssetAccessDimensionReferrer = "referrer"
+)
+
+// AddAssetAccessDimensions 记录一次访问的国家和来源, 值为空的维度不记录
+func AddAssetAccessDimensions(ctx context.Context, userID, hash string, date time.Time, dims map[string]string) error {
+	ib := squirrel.Insert(tableAssetAccessDimension).Columns("user_id,hash,date,dimension,value,count")
+	n := 0
+	for dim, value := range dims {
+		if value == "" {
+			continue
+		}
+		if len(value) > 128 {
+			value = value[:128]
+		}
+		ib = ib.Values(userID, hash, date.Format(time.DateOnly), dim, value, 1)
+		n++
+	}
+	if n == 0 {
+		return nil
+	}
+
+	query, args, err := ib.Suffix("ON DUPLICATE KEY UPDATE count = count + 1").ToSql()
+	if err != nil {
+		return fmt.Errorf("generate insert asset access dimension sql error:%w", err)
+	}
+
+	_, err = DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// RollupAssetAccessDaily 把时间范围内的小时数据汇总到每天的访问记录, start 应为某天的零点
+func RollupAssetAccessDaily(ctx context.Context, start, end time.Time) error {
+	// asset_storage_hour 的 timestamp 是小时结束的时间
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (user_id, hash, date, download_count, total_traffic, peak_bandwidth)
+		SELECT user_id, hash, DATE(FROM_UNIXTIME(timestamp - 1)) AS d, SUM(download_count), SUM(total_traffic), MAX(peak_bandwidth)
+		FROM %s WHERE timestamp > ? AND timestamp <= ? AND user_id <> '' GROUP BY user_id, hash, d
+		ON DUPLICATE KEY UPDATE download_count = VALUES(download_count), total_traffic = VALUES(total_traffic), peak_bandwidth = VALUES(peak_bandwidth)`,
+		tableAssetAccessDaily, tableAssetStorageHour), start.Unix(), end.Unix())
+	return err
+}
+
+// AssetAccessFilter 访问统计的查询条件, GroupIDs 不为空时统计文件组内的所有文件
+type AssetAccessFilter struct {
+	UserID   string
+	Hash     string
+	GroupIDs []int64
+	Start    time.Time
+	End      time.Time
+}
+
+func (f *AssetAccessFilter) where(sb squirrel.SelectBuilder) squirrel.SelectBuilder {
+	sb = sb.Where("user_id = ?", f.UserID)
+	if len(f.GroupIDs) > 0 {
+		return sb.Where(squirrel.Expr("hash IN (?)", squirrel.Select("hash").From(tableUserAsset).Where(squirrel.Eq{
+			"user_id":  f.UserID,
+			"group_id": f.GroupIDs,
+		})))
+	}
+	return sb.Where("hash = ?", f.Hash)
+}
+
+// AssetAccessPoint 某个时间点的访问数据, Time 为小时结束或当天零点的时间戳
+type AssetAccessPoint struct {
+	Time          int64 `db:"time" json:"time"`
+	DownloadCount int64 `db:"download_count" json:"download_count"`
+	TotalTraffic  int64 `db:"total_traffic" json:"total_traffic"`
+	PeakBandwidth int64 `db:"peak_bandwidth" json:"peak_bandwidth"`
+}
+
+// GetAssetAccessHourly 按小时获取访问数据
+func GetAssetAccessHourly(ctx context.Context, filter *AssetAccessFilter) ([]*AssetAccessPoint, error) {
+	sb := squirrel.Select("timestamp AS time, SUM(download_count) AS download_count, SUM(total_traffic) AS total_traffic, MAX(peak_bandwidth) AS peak_bandwidth").
+		From(tableAssetStorageHour).Where("timestamp > ? AND timestamp <= ?", filter.Start.Unix(), filter.End.Unix())
+	query, args, err := filter.where(sb).GroupBy("timestamp").OrderBy("timestamp").ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate get asset access hourly sql error:%w", err)
+	}
+
+	out := make([]*AssetAccessPoint, 0)
+	err = DB.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+// GetAssetAccessDaily 按天获取访问数据
+func GetAssetAccessDaily(ctx context.Context, filter *AssetAccessFilter) ([]*AssetAccessPoint, error) {
+	sb := squirrel.Select("UNIX_TIMESTAMP(date) AS time, SUM(download_count) AS download_count, SUM(total_traffic) AS total_traffic, MAX(peak_bandwidth) AS peak_bandwidth").
+		From(tableAssetAccessDaily).Where("date >= ? AND date <= ?", filter.Start.Format(time.DateOnly), filter.End.Format(time.DateOnly))
+	query, args, err := filter.where(sb).GroupBy("date").OrderBy("date").ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate get asset access daily sql error:%w", err)
+	}
+
+	out := make([]*AssetAccessPoint, 0)
+	err = DB.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+// AssetAccessTopItem 访问最多的国家或来源
+type AssetAccessTopItem struct {
+	Value string `db:"value" json:"value"`
+	Count int64  `db:"count" json:"count"`
+}
+
+// GetAssetAccessTop 获取访问次数最多的国家或来源
+func GetAssetAccessTop(ctx context.Context, filter *AssetAccessFilter, dimension string, limit uint64) ([]*AssetAccessTopItem, error) {
+	sb := squirrel.Select("value, SUM(count) AS count").From(tableAssetAccessDimension).
+		Where("dimension = ? AND date >= ? AND date <= ?", dimension, filter.Start.Format(time.DateOnly), filter.End.Format(time.DateOnly))
+	query, args, err := filter.where(sb).GroupBy("value").OrderBy("count DESC").Limit(limit).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate get asset access top sql error:%w", err)
+	}
+
+	out := make([]*AssetAccessTopItem, 0)
+	err = DB.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+// HotAsset 热门文件, Score 按天衰减, 越近的访问权重越高
+type HotAsset struct {
+	Hash          string  `db:"hash" json:"hash"`
+	Cid           string  `db:"cid" json:"cid"`
+	AssetName     string  `db:"asset_name" json:"asset_name"`
+	DownloadCount int64   `db:"download_count" json:"download_count"`
+	TotalTraffic  int64   `db:"total_traffic" json:"total_traffic"`
+	PeakBandwidth int64   `db:"peak_bandwidth" json:"peak_bandwidth"`
+	Score         float64 `db:"score" json:"score"`
+}
+
+// GetHotAssets 获取用户一段时间内的热门文件, halfLifeDays 是访问权重减半的天数
+func GetHotAssets(ctx context.Context, userID string, since, now time.Time, halfLifeDays float64, limit uint64) ([]*HotAsset, error) {
+	query, args, err := squirrel.Select("d.hash, a.cid, a.asset_name, SUM(d.download_count) AS download_count, SUM(d.total_traffic) AS total_traffic",
+		"MAX(d.peak_bandwidth) AS peak_bandwidth").
+		Column("SUM(d.download_count * POW(0.5, DATEDIFF(?, d.date) / ?)) AS score", now.Format(time.DateOnly), halfLifeDays).
+		From(tableAssetAccessDaily+" d").Join(tableUserAsset+" a ON a.user_id = d.user_id AND a.hash = d.hash").
+		Where("d.user_id = ? AND d.date >= ?", userID, since.Format(time.DateOnly)).
+		GroupBy("d.hash", "a.cid", "a.asset_name").OrderBy("score DESC", "total_traffic DESC").Limit(limit).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate get hot assets sql error:%w", err)
+	}
+
+	out := make([]*HotAsset, 0)
+	err = DB.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+// GetAssetGroupTreeIDs 获取文件组及其所有子文件组的 id
+func GetAssetGroupTreeIDs(ctx context.Context, userID string, gid int64) ([]int64, error) {
+	groups, err := getAssetGroupTree(ctx, userID, gid)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0, len(groups))
+	for _, g := range groups {
+		ids = append(ids, g.ID)
+	}
+	return ids, nil
+}
@@ -0,0 +1,144 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+const tableNameAssetCarExport = "user_asset_car_export"
+
+// CAR 导出的处理状态
+const (
+	CarExportStateQueued  = "queued"
+	CarExportStateRunning = "running"
+	CarExportStateDone    = "done"
+	CarExportStateFailed  = "failed"
+)
+
+// GetAssetGroupTreeAssets 获取文件组及其所有子文件组和其中的文件, gid 为 0 时从根目录开始
+func GetAssetGroupTreeAssets(ctx context.Context, userID string, gid int64) ([]*AssetGroup, []*model.UserAsset, error) {
+	var groups []*AssetGroup
+	if gid == 0 {
+		groups = []*AssetGroup{{ID: 0, UserID: userID, Name: "root"}}
+	} else {
+		var root AssetGroup
+		query, args, err := squirrel.Select("id,user_id,name,parent").From(tableNameAssetGroup).Where("user_id = ? AND id = ?", userID, gid).ToSql()
+		if err != nil {
+			return nil, nil, fmt.Errorf("generate get asset's group sql error:%w", err)
+		}
+		if err = DB.GetContext(ctx, &root, query, args...); err != nil {
+			return nil, nil, err
+		}
+		groups = []*AssetGroup{&root}
+	}
+
+	ids := []int64{gid}
+	pids := []int64{gid}
+	for len(pids) > 0 {
+		var children []*AssetGroup
+		query, args, err := squirrel.Select("id,user_id,name,parent").From(tableNameAssetGroup).Where(squirrel.Eq{
+			"user_id": userID,
+			"parent":  pids,
+		}).ToSql()
+		if err != nil {
+			return nil, nil, fmt.Errorf("generate get asset's group sql error:%w", err)
+		}
+		if err = DB.SelectContext(ctx, &children, query, args...); err != nil {
+			return nil, nil, err
+		}
+
+		pids = pids[:0]
+		for _, child := range children {
+			groups = append(groups, child)
+			pids = append(pids, child.ID)
+			ids = append(ids, child.ID)
+		}
+	}
+
+	var assets []*model.UserAsset
+	query, args, err := squirrel.Select("*").From(tableUserAsset).Where(squirrel.Eq{
+		"user_id":  userID,
+		"group_id": ids,
+	}).ToSql()
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate get asset sql error:%w", err)
+	}
+	if err = DB.SelectContext(ctx, &assets, query, args...); err != nil {
+		return nil, nil, err
+	}
+
+	return groups, assets, nil
+}
+
+// AddAssetCarExport 添加 CAR 导出任务
+func AddAssetCarExport(ctx context.Context, e *model.AssetCarExport) (int64, error) {
+	res, err := DB.NamedExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (user_id, group_id, name, state, created_at, expire_at)
+		VALUES (:user_id, :group_id, :name, :state, :created_at, :expire_at)`, tableNameAssetCarExport), e)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetAssetCarExport 获取 CAR 导出任务
+func GetAssetCarExport(ctx context.Context, id int64) (*model.AssetCarExport, error) {
+	var out model.AssetCarExport
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE id = ?`, tableNameAssetCarExport), id)
+	return &out, err
+}
+
+// ListUserAssetCarExports 获取用户的 CAR 导出任务
+func ListUserAssetCarExports(ctx context.Context, userID string, option QueryOption) (int64, []*model.AssetCarExport, error) {
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	var total int64
+	err := DB.GetContext(ctx, &total, fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE user_id = ?`, tableNameAssetCarExport), userID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	out := make([]*model.AssetCarExport, 0)
+	err = DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE user_id = ? ORDER BY id DESC LIMIT %d OFFSET %d`, tableNameAssetCarExport, limit, offset), userID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
+
+// UpdateAssetCarExportState 更新 CAR 导出任务的状态, 失败时记录原因
+func UpdateAssetCarExportState(ctx context.Context, id int64, state, reason string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = ?, reason = ? WHERE id = ?`, tableNameAssetCarExport), state, reason, id)
+	return err
+}
+
+// FinishAssetCarExport 记录导出完成的 CAR 文件
+func FinishAssetCarExport(ctx context.Context, e *model.AssetCarExport) error {
+	_, err := DB.NamedExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = :state, reason = '', root_cid = :root_cid, asset_count = :asset_count, size = :size, object_key = :object_key
+		WHERE id = :id`, tableNameAssetCarExport), e)
+	return err
+}
+
+// GetExpiredAssetCarExports 获取已过期的 CAR 导出任务
+func GetExpiredAssetCarExports(ctx context.Context, limit int) ([]*model.AssetCarExport, error) {
+	out := make([]*model.AssetCarExport, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE expire_at < ? LIMIT %d`, tableNameAssetCarExport, limit), time.Now())
+	return out, err
+}
+
+// DeleteAssetCarExport 删除 CAR 导出任务
+func DeleteAssetCarExport(ctx context.Context, id int64) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, tableNameAssetCarExport), id)
+	return err
+}
@@ -0,0 +1,174 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+const (
+	tableNameAssetDedupArea    = "asset_dedup_area"
+	tableNameAssetDedupTopHash = "asset_dedup_top_hash"
+	tableNameAssetDedupHit     = "asset_dedup_hit"
+)
+
+// AssetDedupUserHit 用户的秒传统计
+type AssetDedupUserHit struct {
+	UserID    string `db:"user_id" json:"user_id"`
+	Hits      int64  `db:"hits" json:"hits"`
+	SavedSize int64  `db:"saved_size" json:"saved_size"`
+}
+
+// ComputeAssetDedupAreas 按区域统计所有用户文件的逻辑大小和去重后的实际大小
+// 同一用户在多个文件夹下的同一文件只计一次, 回收站和历史版本不计入
+func ComputeAssetDedupAreas(ctx context.Context) ([]*model.AssetDedupArea, error) {
+	query := fmt.Sprintf(`SELECT area_id, SUM(logical_size) AS logical_size, SUM(physical_size) AS physical_size, COUNT(1) AS hash_count, SUM(refs) AS ref_count
+		FROM (
+			SELECT uaa.area_id, ua.hash, SUM(ua.total_size) AS logical_size, MAX(ua.total_size) AS physical_size, COUNT(1) AS refs
+			FROM (SELECT user_id, hash, MAX(total_size) AS total_size FROM %s WHERE group_id >= 0 GROUP BY user_id, hash) AS ua
+			JOIN %s AS uaa ON uaa.hash = ua.hash AND uaa.user_id = ua.user_id
+			GROUP BY uaa.area_id, ua.hash
+		) t GROUP BY area_id`, tableUserAsset, tableUserAssetArea)
+
+	out := make([]*model.AssetDedupArea, 0)
+	err := DB.SelectContext(ctx, &out, query)
+	return out, err
+}
+
+// ComputeAssetDedupTopHashes 统计被最多用户共享的文件, 回收站和历史版本不计入
+func ComputeAssetDedupTopHashes(ctx context.Context, limit int) ([]*model.AssetDedupTopHash, error) {
+	query := fmt.Sprintf(`SELECT hash, MAX(cid) AS cid, MAX(total_size) AS total_size, COUNT(DISTINCT user_id) AS user_count, MAX(total_size) * (COUNT(DISTINCT user_id) - 1) AS saved_size
+		FROM %s WHERE group_id >= 0 GROUP BY hash HAVING user_count > 1 ORDER BY user_count DESC, saved_size DESC LIMIT %d`, tableUserAsset, limit)
+
+	out := make([]*model.AssetDedupTopHash, 0)
+	err := DB.SelectContext(ctx, &out, query)
+	return out, err
+}
+
+// SaveAssetDedupStats 用最新的统计结果替换旧数据
+func SaveAssetDedupStats(ctx context.Context, areas []*model.AssetDedupArea, top []*model.AssetDedupTopHash) error {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s`, tableNameAssetDedupArea)); err != nil {
+		return err
+	}
+	if len(areas) > 0 {
+		ib := squirrel.Insert(tableNameAssetDedupArea).Columns("area_id,logical_size,physical_size,hash_count,ref_count,updated_at")
+		for _, a := range areas {
+			ib = ib.Values(a.AreaID, a.LogicalSize, a.PhysicalSize, a.HashCount, a.RefCount, now)
+		}
+		query, args, err := ib.ToSql()
+		if err != nil {
+			return fmt.Errorf("generate insert asset dedup area sql error:%w", err)
+		}
+		if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s`, tableNameAssetDedupTopHash)); err != nil {
+		return err
+	}
+	if len(top) > 0 {
+		ib := squirrel.Insert(tableNameAssetDedupTopHash).Columns("hash,cid,total_size,user_count,saved_size,updated_at")
+		for _, h := range top {
+			ib = ib.Values(h.Hash, h.Cid, h.TotalSize, h.UserCount, h.SavedSize, now)
+		}
+		query, args, err := ib.ToSql()
+		if err != nil {
+			return fmt.Errorf("generate insert asset dedup top hash sql error:%w", err)
+		}
+		if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetAssetDedupAreas 获取最近一次的区域去重统计
+func GetAssetDedupAreas(ctx context.Context) ([]*model.AssetDedupArea, error) {
+	out := make([]*model.AssetDedupArea, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s ORDER BY area_id`, tableNameAssetDedupArea))
+	return out, err
+}
+
+// GetAssetDedupTopHashes 获取最近一次统计的共享最多的文件
+func GetAssetDedupTopHashes(ctx context.Context, limit int) ([]*model.AssetDedupTopHash, error) {
+	out := make([]*model.AssetDedupTopHash, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s ORDER BY user_count DESC, saved_size DESC LIMIT %d`, tableNameAssetDedupTopHash, limit))
+	return out, err
+}
+
+// AddAssetDedupHit 记录秒传
+func AddAssetDedupHit(ctx context.Context, hit *model.AssetDedupHit) error {
+	_, err := DB.NamedExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (user_id, hash, area_id, total_size, created_at) VALUES (:user_id, :hash, :area_id, :total_size, :created_at)`,
+		tableNameAssetDedupHit), hit)
+	return err
+}
+
+func assetDedupHitPeriod(sb squirrel.SelectBuilder, start, end time.Time) squirrel.SelectBuilder {
+	if !start.IsZero() {
+		sb = sb.Where("created_at >= ?", start)
+	}
+	if !end.IsZero() {
+		sb = sb.Where("created_at <= ?", end)
+	}
+	return sb
+}
+
+// GetAssetDedupHitTotal 统计时间段内的秒传次数和节省的大小, 时间为零值时不限制
+func GetAssetDedupHitTotal(ctx context.Context, start, end time.Time) (*AssetDedupUserHit, error) {
+	query, args, err := assetDedupHitPeriod(squirrel.Select("COUNT(1) AS hits, IFNULL(SUM(total_size), 0) AS saved_size").From(tableNameAssetDedupHit), start, end).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate get asset dedup hit sql error:%w", err)
+	}
+
+	var out AssetDedupUserHit
+	err = DB.GetContext(ctx, &out, query, args...)
+	return &out, err
+}
+
+// ListAssetDedupUserHits 按用户统计时间段内的秒传, 秒传次数多的在前
+func ListAssetDedupUserHits(ctx context.Context, start, end time.Time, option QueryOption) (int64, []*AssetDedupUserHit, error) {
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	query, args, err := assetDedupHitPeriod(squirrel.Select("COUNT(DISTINCT user_id)").From(tableNameAssetDedupHit), start, end).ToSql()
+	if err != nil {
+		return 0, nil, fmt.Errorf("generate count asset dedup hit sql error:%w", err)
+	}
+
+	var total int64
+	if err = DB.GetContext(ctx, &total, query, args...); err != nil {
+		return 0, nil, err
+	}
+
+	query, args, err = assetDedupHitPeriod(squirrel.Select("user_id, COUNT(1) AS hits, SUM(total_size) AS saved_size").From(tableNameAssetDedupHit), start, end).
+		GroupBy("user_id").OrderBy("hits DESC", "saved_size DESC").Limit(uint64(limit)).Offset(uint64(offset)).ToSql()
+	if err != nil {
+		return 0, nil, fmt.Errorf("generate list asset dedup hit sql error:%w", err)
+	}
+
+	out := make([]*AssetDedupUserHit, 0)
+	if err = DB.SelectContext(ctx, &out, query, args...); err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
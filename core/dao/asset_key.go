@@ -0,0 +1,174 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+const (
+	tableNameUserEncryptionKey = "user_encryption_key"
+	tableNameUserAssetKey      = "user_asset_key"
+)
+
+// 主密钥私钥的加密方式
+const (
+	KeyWrapMethodPassphrase = "passphrase"
+	KeyWrapMethodWallet     = "wallet"
+)
+
+var (
+	// ErrKeyVersionMismatch 主密钥已被轮换
+	ErrKeyVersionMismatch = errors.New("encryption key version mismatch")
+	// ErrAssetKeysIncomplete 轮换时提交的数据密钥与已有的不一致
+	ErrAssetKeysIncomplete = errors.New("asset keys incomplete")
+)
+
+// GetUserEncryptionKey 获取用户主密钥
+func GetUserEncryptionKey(ctx context.Context, userID string) (*model.UserEncryptionKey, error) {
+	var out model.UserEncryptionKey
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE user_id = ?`, tableNameUserEncryptionKey), userID)
+	return &out, err
+}
+
+// AddUserEncryptionKey 添加用户主密钥
+func AddUserEncryptionKey(ctx context.Context, k *model.UserEncryptionKey) error {
+	_, err := DB.NamedExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (user_id, version, wrap_method, kdf_params, public_key, wrapped_private_key, created_at, updated_at)
+		VALUES (:user_id, :version, :wrap_method, :kdf_params, :public_key, :wrapped_private_key, :created_at, :updated_at)`, tableNameUserEncryptionKey), k)
+	return err
+}
+
+// RotateUserEncryptionKey 轮换主密钥, keys 为用新公钥重新加密的数据密钥 (hash -> wrapped_key)
+// 公钥不变时只更新私钥的加密方式, keys 需要为空
+func RotateUserEncryptionKey(ctx context.Context, k *model.UserEncryptionKey, version int64, keys map[string]string) error {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current model.UserEncryptionKey
+	err = tx.GetContext(ctx, &current, fmt.Sprintf(`SELECT * FROM %s WHERE user_id = ? FOR UPDATE`, tableNameUserEncryptionKey), k.UserID)
+	if err != nil {
+		return err
+	}
+	if current.Version != version {
+		return ErrKeyVersionMismatch
+	}
+
+	k.Version = current.Version
+	if k.PublicKey != current.PublicKey {
+		k.Version++
+
+		var hashes []string
+		err = tx.SelectContext(ctx, &hashes, fmt.Sprintf(`SELECT hash FROM %s WHERE user_id = ?`, tableNameUserAssetKey), k.UserID)
+		if err != nil {
+			return err
+		}
+		if len(hashes) != len(keys) {
+			return ErrAssetKeysIncomplete
+		}
+		for _, hash := range hashes {
+			wrapped, ok := keys[hash]
+			if !ok {
+				return ErrAssetKeysIncomplete
+			}
+			_, err = tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET wrapped_key = ?, key_version = ? WHERE user_id = ? AND hash = ?`, tableNameUserAssetKey),
+				wrapped, k.Version, k.UserID, hash)
+			if err != nil {
+				return err
+			}
+		}
+	} else if len(keys) > 0 {
+		return ErrAssetKeysIncomplete
+	}
+
+	_, err = tx.NamedExecContext(ctx, fmt.Sprintf(`UPDATE %s SET version = :version, wrap_method = :wrap_method, kdf_params = :kdf_params, public_key = :public_key,
+		wrapped_private_key = :wrapped_private_key, updated_at = :updated_at WHERE user_id = :user_id`, tableNameUserEncryptionKey), k)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SaveUserAssetKey 保存用户上传的文件的数据密钥
+func SaveUserAssetKey(ctx context.Context, k *model.UserAssetKey) error {
+	_, err := DB.NamedExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (user_id, hash, cid, key_version, wrapped_key, shared_by, created_at)
+		VALUES (:user_id, :hash, :cid, :key_version, :wrapped_key, '', :created_at)
+		ON DUPLICATE KEY UPDATE cid = VALUES(cid), key_version = VALUES(key_version), wrapped_key = VALUES(wrapped_key), shared_by = ''`, tableNameUserAssetKey), k)
+	return err
+}
+
+// ShareUserAssetKey 保存分享给接收者的数据密钥, 接收者自己上传过的文件不会被覆盖
+func ShareUserAssetKey(ctx context.Context, k *model.UserAssetKey) error {
+	_, err := DB.NamedExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (user_id, hash, cid, key_version, wrapped_key, shared_by, created_at)
+		VALUES (:user_id, :hash, :cid, :key_version, :wrapped_key, :shared_by, :created_at)
+		ON DUPLICATE KEY UPDATE
+			key_version = IF(shared_by = '', key_version, VALUES(key_version)),
+			wrapped_key = IF(shared_by = '', wrapped_key, VALUES(wrapped_key)),
+			shared_by = IF(shared_by = '', shared_by, VALUES(shared_by))`, tableNameUserAssetKey), k)
+	return err
+}
+
+// GetUserAssetKey 获取用户持有的文件数据密钥
+func GetUserAssetKey(ctx context.Context, userID, hash string) (*model.UserAssetKey, error) {
+	var out model.UserAssetKey
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE user_id = ? AND hash = ?`, tableNameUserAssetKey), userID, hash)
+	return &out, err
+}
+
+// ListUserAssetKeys 获取用户持有的数据密钥, sharedOnly 时只返回别人分享的
+func ListUserAssetKeys(ctx context.Context, userID string, sharedOnly bool, option QueryOption) (int64, []*model.UserAssetKey, error) {
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	where := "user_id = ?"
+	if sharedOnly {
+		where += " AND shared_by <> ''"
+	}
+
+	var total int64
+	err := DB.GetContext(ctx, &total, fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE %s`, tableNameUserAssetKey, where), userID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	out := make([]*model.UserAssetKey, 0)
+	err = DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE %s ORDER BY id LIMIT %d OFFSET %d`, tableNameUserAssetKey, where, limit, offset), userID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
+
+// ListAssetKeyRecipients 获取文件数据密钥的接收者
+func ListAssetKeyRecipients(ctx context.Context, owner, hash string) ([]string, error) {
+	out := make([]string, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT user_id FROM %s WHERE shared_by = ? AND hash = ?`, tableNameUserAssetKey), owner, hash)
+	return out, err
+}
+
+// DeleteSharedAssetKey 撤销分享给接收者的数据密钥
+func DeleteSharedAssetKey(ctx context.Context, owner, recipient, hash string) (int64, error) {
+	res, err := DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE user_id = ? AND hash = ? AND shared_by = ?`, tableNameUserAssetKey), recipient, hash, owner)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteUserAssetKeys 删除文件时删除用户的数据密钥以及分享给别人的数据密钥
+func DeleteUserAssetKeys(ctx context.Context, userID, hash string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE hash = ? AND (user_id = ? OR shared_by = ?)`, tableNameUserAssetKey), hash, userID, userID)
+	return err
+}
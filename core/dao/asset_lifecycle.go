@@ -0,0 +1,190 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+const (
+	tableNameAssetLifecycleRule = "asset_lifecycle_rule"
+	tableNameAssetLifecycleLog  = "asset_lifecycle_log"
+	tableNameAssetBackupRequest = "asset_backup_request"
+)
+
+// 生命周期规则的处理方式
+const (
+	LifecycleActionDelete        = "delete"
+	LifecycleActionUnshare       = "unshare"
+	LifecycleActionArchive       = "archive"
+	LifecycleActionReduceReplica = "reduce_replica"
+	// LifecycleActionExpire 文件到期, 只用于日志
+	LifecycleActionExpire = "expire"
+)
+
+// SaveAssetLifecycleRule 保存生命周期规则, 同一个文件夹的同一种处理方式只有一条规则
+func SaveAssetLifecycleRule(ctx context.Context, r *model.AssetLifecycleRule) error {
+	_, err := DB.NamedExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (user_id, group_id, action, days, keep_areas, enabled, created_at, updated_at)
+		VALUES (:user_id, :group_id, :action, :days, :keep_areas, :enabled, :created_at, :updated_at)
+		ON DUPLICATE KEY UPDATE days = VALUES(days), keep_areas = VALUES(keep_areas), enabled = VALUES(enabled), updated_at = VALUES(updated_at)`, tableNameAssetLifecycleRule), r)
+	return err
+}
+
+// ListAssetLifecycleRules 获取用户的生命周期规则
+func ListAssetLifecycleRules(ctx context.Context, userID string) ([]*model.AssetLifecycleRule, error) {
+	out := make([]*model.AssetLifecycleRule, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE user_id = ? ORDER BY group_id, action`, tableNameAssetLifecycleRule), userID)
+	return out, err
+}
+
+// DeleteAssetLifecycleRule 删除生命周期规则
+func DeleteAssetLifecycleRule(ctx context.Context, userID string, id int64) (int64, error) {
+	res, err := DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ? AND user_id = ?`, tableNameAssetLifecycleRule), id, userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetAssetLifecycleUserIDs 获取需要处理生命周期的用户: 有启用的规则或者有到期的文件
+func GetAssetLifecycleUserIDs(ctx context.Context, now time.Time) ([]string, error) {
+	out := make([]string, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT DISTINCT user_id FROM %s WHERE enabled = 1
+		UNION SELECT DISTINCT user_id FROM %s WHERE expiration > '2000-01-01' AND expiration < ? AND group_id >= 0`, tableNameAssetLifecycleRule, tableUserAsset), now)
+	return out, err
+}
+
+// GetExpiredUserAssets 获取用户已到期的文件, 不包括回收站和历史版本
+func GetExpiredUserAssets(ctx context.Context, userID string, now time.Time) ([]*model.UserAsset, error) {
+	out := make([]*model.UserAsset, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE user_id = ? AND expiration > '2000-01-01' AND expiration < ? AND group_id >= 0`, tableUserAsset), userID, now)
+	return out, err
+}
+
+// GetDownloadedAssetHashes 获取 since 之后有下载的文件
+func GetDownloadedAssetHashes(ctx context.Context, userID string, hashes []string, since time.Time) (map[string]bool, error) {
+	out := make(map[string]bool)
+	if len(hashes) == 0 {
+		return out, nil
+	}
+
+	query, args, err := squirrel.Select("hash").From(tableAssetStorageHour).Where(squirrel.Eq{
+		"user_id": userID,
+		"hash":    hashes,
+	}).Where("timestamp >= ?", since.Unix()).GroupBy("hash").Having("SUM(download_count) > 0").ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate get asset download sql error:%w", err)
+	}
+
+	var list []string
+	if err = DB.SelectContext(ctx, &list, query, args...); err != nil {
+		return nil, err
+	}
+	for _, hash := range list {
+		out[hash] = true
+	}
+	return out, nil
+}
+
+// UnshareUserAssets 取消文件的分享, 并撤销文件的分享链接
+func UnshareUserAssets(ctx context.Context, userID string, hashes, cids []string) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	tx, err := DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query, args, err := squirrel.Update(tableUserAsset).Set("share_status", 0).Where(squirrel.Eq{
+		"user_id": userID,
+		"hash":    hashes,
+	}).ToSql()
+	if err != nil {
+		return fmt.Errorf("generate update asset sql error:%w", err)
+	}
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+
+	query, args, err = squirrel.Update(tableNameShareLink).Set("revoked", 1).Set("updated_at", time.Now()).Where(squirrel.Eq{
+		"user_id": userID,
+		"cid":     cids,
+		"revoked": 0,
+	}).ToSql()
+	if err != nil {
+		return fmt.Errorf("generate update share link sql error:%w", err)
+	}
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddAssetBackupRequests 添加需要备份到 Filecoin 的文件, 返回新加入的文件, 已有请求的文件不重复添加
+func AddAssetBackupRequests(ctx context.Context, userID string, assets []*model.UserAsset) ([]*model.UserAsset, error) {
+	var added []*model.UserAsset
+	now := time.Now()
+	for _, a := range assets {
+		res, err := DB.ExecContext(ctx, fmt.Sprintf(`INSERT IGNORE INTO %s (cid, hash, user_id, created_at) VALUES (?, ?, ?, ?)`, tableNameAssetBackupRequest),
+			a.Cid, a.Hash, userID, now)
+		if err != nil {
+			return added, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			added = append(added, a)
+		}
+	}
+	return added, nil
+}
+
+// AddAssetLifecycleLogs 添加生命周期处理日志
+func AddAssetLifecycleLogs(ctx context.Context, logs []*model.AssetLifecycleLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	ib := squirrel.Insert(tableNameAssetLifecycleLog).Columns("user_id,rule_id,action,hash,cid,asset_name,detail,created_at")
+	for _, l := range logs {
+		ib = ib.Values(l.UserID, l.RuleID, l.Action, l.Hash, l.Cid, l.AssetName, l.Detail, l.CreatedAt)
+	}
+
+	query, args, err := ib.ToSql()
+	if err != nil {
+		return fmt.Errorf("generate insert lifecycle log sql error:%w", err)
+	}
+	_, err = DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ListAssetLifecycleLogs 获取用户的生命周期处理日志
+func ListAssetLifecycleLogs(ctx context.Context, userID string, option QueryOption) (int64, []*model.AssetLifecycleLog, error) {
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	var total int64
+	err := DB.GetContext(ctx, &total, fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE user_id = ?`, tableNameAssetLifecycleLog), userID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	out := make([]*model.AssetLifecycleLog, 0)
+	err = DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE user_id = ? ORDER BY id DESC LIMIT %d OFFSET %d`, tableNameAssetLifecycleLog, limit, offset), userID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
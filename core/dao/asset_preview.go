@@ -0,0 +1,66 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const tableAssetPreview = "asset_preview"
+
+// 文件预览的状态
+const (
+	AssetPreviewStatePending     = "pending"
+	AssetPreviewStateDone        = "done"
+	AssetPreviewStateFailed      = "failed"
+	AssetPreviewStateUnsupported = "unsupported"
+)
+
+// AddAssetPreview 添加待生成的预览, 已存在时只把失败的预览重新置为待生成
+func AddAssetPreview(ctx context.Context, p *model.AssetPreview) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (hash, cid, kind, state) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE state = IF(state = ?, VALUES(state), state)`, tableAssetPreview),
+		p.Hash, p.Cid, p.Kind, AssetPreviewStatePending, AssetPreviewStateFailed)
+	return err
+}
+
+// GetAssetPreview 获取文件的预览
+func GetAssetPreview(ctx context.Context, hash string) (*model.AssetPreview, error) {
+	var out model.AssetPreview
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE hash = ?`, tableAssetPreview), hash)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateAssetPreview 保存预览的生成结果
+func UpdateAssetPreview(ctx context.Context, p *model.AssetPreview) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = ?, width = ?, height = ?, duration = ?, thumbnail_key = ?, poster_key = ?, reason = ? WHERE hash = ?`, tableAssetPreview),
+		p.State, p.Width, p.Height, p.Duration, p.ThumbnailKey, p.PosterKey, p.Reason, p.Hash)
+	return err
+}
+
+// GetAssetPreviews 批量获取文件的预览, key 为文件 hash
+func GetAssetPreviews(ctx context.Context, hashes []string) (map[string]*model.AssetPreview, error) {
+	out := make(map[string]*model.AssetPreview)
+	if len(hashes) == 0 {
+		return out, nil
+	}
+
+	query, args, err := sqlx.In(fmt.Sprintf(`SELECT * FROM %s WHERE hash IN (?)`, tableAssetPreview), hashes)
+	if err != nil {
+		return nil, err
+	}
+	var list []*model.AssetPreview
+	if err = DB.SelectContext(ctx, &list, query, args...); err != nil {
+		return nil, err
+	}
+
+	for _, p := range list {
+		out[p.Hash] = p
+	}
+	return out, nil
+}
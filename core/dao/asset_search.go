@@ -0,0 +1,308 @@
+package dao
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+)
+
+const maxAssetSearchLimit = 100
+
+// ErrInvalidCursor 翻页游标无法解析
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// AssetSearchOption 搜索用户文件和文件组的条件, 为空的条件不生效
+type AssetSearchOption struct {
+	Keyword     string
+	AssetType   string
+	MinSize     int64
+	MaxSize     int64
+	StartTime   string
+	EndTime     string
+	CidPrefix   string
+	ShareStatus *int64
+	AreaID      string
+	// Sort 排序字段: created_time, total_size, name
+	Sort   string
+	Order  string
+	Cursor string
+	Limit  int
+}
+
+// AssetSearchResult 搜索结果, 文件和文件组共用
+type AssetSearchResult struct {
+	Cid         string    `db:"cid" json:"cid,omitempty"`
+	GroupID     int64     `db:"group_id" json:"group_id"`
+	Name        string    `db:"name" json:"name"`
+	AssetType   string    `db:"asset_type" json:"asset_type,omitempty"`
+	TotalSize   int64     `db:"total_size" json:"total_size"`
+	ShareStatus int64     `db:"share_status" json:"share_status"`
+	CreatedTime time.Time `db:"created_time" json:"created_time"`
+	Path        string    `db:"-" json:"path"`
+	SortKey     string    `db:"sort_key" json:"-"`
+	TieKey      string    `db:"tie_key" json:"-"`
+}
+
+// assetSearchCursor 游标记录上一页最后一条的排序值, 保证翻页稳定
+type assetSearchCursor struct {
+	SortKey string `json:"s"`
+	TieKey  string `json:"t"`
+}
+
+func encodeAssetSearchCursor(r *AssetSearchResult) string {
+	b, _ := json.Marshal(assetSearchCursor{SortKey: r.SortKey, TieKey: r.TieKey})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeAssetSearchCursor(s string) (*assetSearchCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var cursor assetSearchCursor
+	if err = json.Unmarshal(b, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// searchTokens 按空白拆分关键字, 每个词都需要匹配
+func searchTokens(keyword string) []string {
+	var tokens []string
+	for _, t := range strings.Fields(keyword) {
+		tokens = append(tokens, "%"+escapeLike(t)+"%")
+	}
+	return tokens
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// assetGroupPaths 返回用户所有未放入回收站的文件组的路径, 根目录为 0
+func assetGroupPaths(ctx context.Context, userID string) (map[int64]string, error) {
+	var groups []*AssetGroup
+	query, args, err := squirrel.Select("id,name,parent").From(tableNameAssetGroup).Where("user_id = ?", userID).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate get asset's group sql error:%w", err)
+	}
+	if err = DB.SelectContext(ctx, &groups, query, args...); err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]*AssetGroup, len(groups))
+	for _, g := range groups {
+		byID[g.ID] = g
+	}
+
+	paths := map[int64]string{0: "/"}
+	var resolve func(id int64, depth int) (string, bool)
+	resolve = func(id int64, depth int) (string, bool) {
+		if p, ok := paths[id]; ok {
+			return p, true
+		}
+		g, ok := byID[id]
+		if !ok || depth > len(groups) {
+			return "", false
+		}
+		parent, ok := resolve(g.Parent, depth+1)
+		if !ok {
+			return "", false
+		}
+		p := strings.TrimSuffix(parent, "/") + "/" + g.Name
+		paths[id] = p
+		return p, true
+	}
+
+	for _, g := range groups {
+		resolve(g.ID, 0)
+	}
+	return paths, nil
+}
+
+func assetSearchOrder(option *AssetSearchOption, columns map[string]string) (string, string) {
+	col, ok := columns[option.Sort]
+	if !ok {
+		col = columns["created_time"]
+	}
+	dir := "DESC"
+	if strings.EqualFold(option.Order, "asc") {
+		dir = "ASC"
+	}
+	return col, dir
+}
+
+func applyAssetSearchCursor(sb squirrel.SelectBuilder, option *AssetSearchOption, sortCol, tieCol, dir string) (squirrel.SelectBuilder, error) {
+	if option.Cursor == "" {
+		return sb, nil
+	}
+	cursor, err := decodeAssetSearchCursor(option.Cursor)
+	if err != nil {
+		return sb, ErrInvalidCursor
+	}
+	op := "<"
+	if dir == "ASC" {
+		op = ">"
+	}
+	return sb.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))", sortCol, op, sortCol, tieCol, op),
+		cursor.SortKey, cursor.SortKey, cursor.TieKey), nil
+}
+
+func assetSearchLimit(option *AssetSearchOption) int {
+	if option.Limit <= 0 || option.Limit > maxAssetSearchLimit {
+		return 50
+	}
+	return option.Limit
+}
+
+// SearchUserAssets 搜索用户的文件, 返回结果和下一页的游标, 游标为空表示没有更多数据
+func SearchUserAssets(ctx context.Context, userID string, option *AssetSearchOption) ([]*AssetSearchResult, string, error) {
+	paths, err := assetGroupPaths(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	visible := make([]int64, 0, len(paths))
+	for id := range paths {
+		visible = append(visible, id)
+	}
+
+	// 排序值统一转成可比较的字符串, 数字补齐位数
+	sortCol, dir := assetSearchOrder(option, map[string]string{
+		"created_time": "DATE_FORMAT(ua.created_time, '%Y-%m-%d %H:%i:%s')",
+		"total_size":   "LPAD(ua.total_size, 20, '0')",
+		"name":         "ua.asset_name",
+	})
+
+	sb := squirrel.Select(fmt.Sprintf("ua.cid,ua.group_id,ua.asset_name AS name,ua.asset_type,ua.total_size,ua.share_status,ua.created_time,%s AS sort_key,ua.hash AS tie_key", sortCol)).
+		From(fmt.Sprintf("%s AS ua", tableUserAsset)).
+		Where("ua.user_id = ?", userID).
+		Where(squirrel.Eq{"ua.group_id": visible})
+
+	for _, t := range searchTokens(option.Keyword) {
+		sb = sb.Where("ua.asset_name LIKE ?", t)
+	}
+	if option.AssetType != "" {
+		sb = sb.Where("ua.asset_type = ?", option.AssetType)
+	}
+	if option.MinSize > 0 {
+		sb = sb.Where("ua.total_size >= ?", option.MinSize)
+	}
+	if option.MaxSize > 0 {
+		sb = sb.Where("ua.total_size <= ?", option.MaxSize)
+	}
+	if option.StartTime != "" {
+		sb = sb.Where("ua.created_time >= ?", option.StartTime)
+	}
+	if option.EndTime != "" {
+		sb = sb.Where("ua.created_time <= ?", option.EndTime)
+	}
+	if option.CidPrefix != "" {
+		sb = sb.Where("ua.cid LIKE ?", escapeLike(option.CidPrefix)+"%")
+	}
+	if option.ShareStatus != nil {
+		sb = sb.Where("ua.share_status = ?", *option.ShareStatus)
+	}
+	if option.AreaID != "" {
+		sb = sb.Where(fmt.Sprintf("EXISTS (SELECT 1 FROM %s AS uaa WHERE uaa.hash = ua.hash AND uaa.user_id = ua.user_id AND uaa.area_id = ?)", tableUserAssetArea), option.AreaID)
+	}
+
+	sb, err = applyAssetSearchCursor(sb, option, sortCol, "ua.hash", dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	limit := assetSearchLimit(option)
+	query, args, err := sb.OrderBy(fmt.Sprintf("sort_key %s, tie_key %s", dir, dir)).Limit(uint64(limit + 1)).ToSql()
+	if err != nil {
+		return nil, "", fmt.Errorf("generate search asset sql error:%w", err)
+	}
+
+	out := make([]*AssetSearchResult, 0)
+	if err = DB.SelectContext(ctx, &out, query, args...); err != nil {
+		return nil, "", err
+	}
+
+	return finishAssetSearch(out, paths, limit)
+}
+
+// SearchUserAssetGroups 按名称搜索用户的文件组
+func SearchUserAssetGroups(ctx context.Context, userID string, option *AssetSearchOption) ([]*AssetSearchResult, string, error) {
+	paths, err := assetGroupPaths(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	visible := make([]int64, 0, len(paths))
+	for id := range paths {
+		if id != 0 {
+			visible = append(visible, id)
+		}
+	}
+	if len(visible) == 0 {
+		return []*AssetSearchResult{}, "", nil
+	}
+
+	sortCol, dir := assetSearchOrder(option, map[string]string{
+		"created_time": "DATE_FORMAT(ag.created_time, '%Y-%m-%d %H:%i:%s')",
+		"name":         "ag.name",
+	})
+
+	sb := squirrel.Select(fmt.Sprintf("ag.id AS group_id,ag.name,ag.share_status,ag.created_time,%s AS sort_key,LPAD(ag.id, 20, '0') AS tie_key", sortCol)).
+		From(fmt.Sprintf("%s AS ag", tableNameAssetGroup)).
+		Where("ag.user_id = ?", userID).
+		Where(squirrel.Eq{"ag.id": visible})
+
+	for _, t := range searchTokens(option.Keyword) {
+		sb = sb.Where("ag.name LIKE ?", t)
+	}
+	if option.StartTime != "" {
+		sb = sb.Where("ag.created_time >= ?", option.StartTime)
+	}
+	if option.EndTime != "" {
+		sb = sb.Where("ag.created_time <= ?", option.EndTime)
+	}
+	if option.ShareStatus != nil {
+		sb = sb.Where("ag.share_status = ?", *option.ShareStatus)
+	}
+
+	sb, err = applyAssetSearchCursor(sb, option, sortCol, "LPAD(ag.id, 20, '0')", dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	limit := assetSearchLimit(option)
+	query, args, err := sb.OrderBy(fmt.Sprintf("sort_key %s, tie_key %s", dir, dir)).Limit(uint64(limit + 1)).ToSql()
+	if err != nil {
+		return nil, "", fmt.Errorf("generate search asset's group sql error:%w", err)
+	}
+
+	out := make([]*AssetSearchResult, 0)
+	if err = DB.SelectContext(ctx, &out, query, args...); err != nil {
+		return nil, "", err
+	}
+
+	// 文件组返回其自身的路径
+	for _, r := range out {
+		r.Path = paths[r.GroupID]
+	}
+	return finishAssetSearch(out, nil, limit)
+}
+
+func finishAssetSearch(out []*AssetSearchResult, paths map[int64]string, limit int) ([]*AssetSearchResult, string, error) {
+	var next string
+	if len(out) > limit {
+		out = out[:limit]
+		next = encodeAssetSearchCursor(out[limit-1])
+	}
+	if paths != nil {
+		for _, r := range out {
+			r.Path = paths[r.GroupID]
+		}
+	}
+	return out, next, nil
+}
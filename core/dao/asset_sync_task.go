@@ -0,0 +1,172 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+const tableNameAssetSyncTask = "asset_sync_task"
+
+// 文件同步任务的类型
+const (
+	AssetSyncKindUser = "user"
+	AssetSyncKindTemp = "temp"
+)
+
+// 文件同步任务的状态
+const (
+	AssetSyncStatePending   = "pending"
+	AssetSyncStatePulling   = "pulling"
+	AssetSyncStateServicing = "servicing"
+	AssetSyncStateFailed    = "failed"
+)
+
+// CreateAssetSyncTasks 添加同步任务, 已完成或失败的任务重新开始, 进行中的任务不变
+// 返回需要塞入队列的任务
+func CreateAssetSyncTasks(ctx context.Context, tasks []*model.AssetSyncTask) ([]*model.AssetSyncTask, error) {
+	out := make([]*model.AssetSyncTask, 0)
+	if len(tasks) == 0 {
+		return out, nil
+	}
+
+	now := time.Now()
+	ib := squirrel.Insert(tableNameAssetSyncTask).Columns("kind,user_id,owner,cid,hash,source_area_id,area_id,state,next_run_at,created_at")
+	for _, t := range tasks {
+		ib = ib.Values(t.Kind, t.UserID, t.Owner, t.Cid, t.Hash, t.SourceAreaID, t.AreaID, AssetSyncStatePending, now, now)
+	}
+	query, args, err := ib.Suffix(fmt.Sprintf(`ON DUPLICATE KEY UPDATE
+		owner = IF(state IN ('%[1]s', '%[2]s'), VALUES(owner), owner),
+		source_area_id = IF(state IN ('%[1]s', '%[2]s'), VALUES(source_area_id), source_area_id),
+		attempts = IF(state IN ('%[1]s', '%[2]s'), 0, attempts),
+		last_error = IF(state IN ('%[1]s', '%[2]s'), '', last_error),
+		next_run_at = IF(state IN ('%[1]s', '%[2]s'), VALUES(next_run_at), next_run_at),
+		state = IF(state IN ('%[1]s', '%[2]s'), VALUES(state), state)`, AssetSyncStateServicing, AssetSyncStateFailed)).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate insert asset sync task sql error:%w", err)
+	}
+	if _, err = DB.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+
+	t := tasks[0]
+	areaIds := make([]string, 0, len(tasks))
+	for _, v := range tasks {
+		areaIds = append(areaIds, v.AreaID)
+	}
+	query, args, err = squirrel.Select("*").From(tableNameAssetSyncTask).Where(squirrel.Eq{
+		"kind":     t.Kind,
+		"user_id":  t.UserID,
+		"hash":     t.Hash,
+		"area_id":  areaIds,
+		"state":    AssetSyncStatePending,
+		"attempts": 0,
+	}).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate get asset sync task sql error:%w", err)
+	}
+	err = DB.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+// GetAssetSyncTask 获取同步任务
+func GetAssetSyncTask(ctx context.Context, id int64) (*model.AssetSyncTask, error) {
+	var out model.AssetSyncTask
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE id = ?`, tableNameAssetSyncTask), id)
+	return &out, err
+}
+
+// UpdateAssetSyncTask 保存同步任务的处理结果, 只有 attempts 没有被其他处理更新时才保存
+func UpdateAssetSyncTask(ctx context.Context, t *model.AssetSyncTask, prevAttempts int64) (bool, error) {
+	res, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = ?, attempts = ?, last_error = ?, next_run_at = ? WHERE id = ? AND attempts = ?`, tableNameAssetSyncTask),
+		t.State, t.Attempts, t.LastError, t.NextRunAt, t.ID, prevAttempts)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// GetOverdueAssetSyncTasks 获取超过执行时间仍未处理的任务, 队列中的任务丢失时重新塞入
+func GetOverdueAssetSyncTasks(ctx context.Context, before time.Time, limit int) ([]*model.AssetSyncTask, error) {
+	out := make([]*model.AssetSyncTask, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE state IN (?, ?) AND next_run_at < ? ORDER BY next_run_at LIMIT %d`, tableNameAssetSyncTask, limit),
+		AssetSyncStatePending, AssetSyncStatePulling, before)
+	return out, err
+}
+
+// RetryAssetSyncTasks 失败的任务重新开始
+func RetryAssetSyncTasks(ctx context.Context, ids []int64) ([]*model.AssetSyncTask, error) {
+	query, args, err := squirrel.Update(tableNameAssetSyncTask).Set("state", AssetSyncStatePending).Set("attempts", 0).Set("last_error", "").
+		Set("next_run_at", time.Now()).Where(squirrel.Eq{"id": ids, "state": AssetSyncStateFailed}).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate retry asset sync task sql error:%w", err)
+	}
+	if _, err = DB.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+
+	query, args, err = squirrel.Select("*").From(tableNameAssetSyncTask).Where(squirrel.Eq{"id": ids, "state": AssetSyncStatePending, "attempts": 0}).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate get asset sync task sql error:%w", err)
+	}
+	out := make([]*model.AssetSyncTask, 0)
+	err = DB.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+// ListAssetSyncTasks 获取同步任务, stuckBefore 不为空时只获取在此之前创建且未完成的任务
+func ListAssetSyncTasks(ctx context.Context, state string, stuckBefore time.Time, option QueryOption) (int64, []*model.AssetSyncTask, error) {
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	sb := squirrel.Select().From(tableNameAssetSyncTask)
+	if state != "" {
+		sb = sb.Where("state = ?", state)
+	}
+	if !stuckBefore.IsZero() {
+		sb = sb.Where(squirrel.NotEq{"state": AssetSyncStateServicing}).Where("created_at < ?", stuckBefore)
+	}
+
+	query, args, err := sb.Column("COUNT(1)").ToSql()
+	if err != nil {
+		return 0, nil, fmt.Errorf("generate count asset sync task sql error:%w", err)
+	}
+	var total int64
+	if err = DB.GetContext(ctx, &total, query, args...); err != nil {
+		return 0, nil, err
+	}
+
+	query, args, err = sb.Column("*").OrderBy("id DESC").Limit(uint64(limit)).Offset(uint64(offset)).ToSql()
+	if err != nil {
+		return 0, nil, fmt.Errorf("generate list asset sync task sql error:%w", err)
+	}
+	out := make([]*model.AssetSyncTask, 0)
+	if err = DB.SelectContext(ctx, &out, query, args...); err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
+
+// AssetSyncStateCount 各状态的同步任务数
+type AssetSyncStateCount struct {
+	State string `db:"state" json:"state"`
+	Count int64  `db:"count" json:"count"`
+}
+
+// CountAssetSyncTasksByState 统计各状态的同步任务数
+func CountAssetSyncTasksByState(ctx context.Context) ([]*AssetSyncStateCount, error) {
+	out := make([]*AssetSyncStateCount, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT state, COUNT(1) AS count FROM %s GROUP BY state`, tableNameAssetSyncTask))
+	return out, err
+}
@@ -47,12 +47,12 @@ func InsertOrUpdateAssetTransferLog(ctx context.Context, record *model.AssetTran
 		}
 	}()
 
-	recordStatement := `INSERT INTO asset_transfer_log(trace_id, user_id, cid, hash, node_id, rate, cost_ms, total_size, state, transfer_type, log, area, created_at, ip, first_byte_time, available_bandwidth)
-	VALUES(:trace_id, :user_id, :cid, :hash, :node_id, :rate, :cost_ms, :total_size, :state, :transfer_type, :log, :area, :created_at, :ip, :first_byte_time, :available_bandwidth)
+	recordStatement := `INSERT INTO asset_transfer_log(trace_id, user_id, cid, hash, node_id, rate, cost_ms, total_size, state, transfer_type, log, area, created_at, ip, first_byte_time, available_bandwidth, country, isp)
+	VALUES(:trace_id, :user_id, :cid, :hash, :node_id, :rate, :cost_ms, :total_size, :state, :transfer_type, :log, :area, :created_at, :ip, :first_byte_time, :available_bandwidth, :country, :isp)
 	ON DUPLICATE KEY UPDATE 
 	user_id=VALUES(user_id), cid=VALUES(cid), hash=VALUES(hash), node_id=VALUES(node_id), rate=VALUES(rate), cost_ms=VALUES(cost_ms), 
 	total_size=VALUES(total_size), state=VALUES(state), transfer_type=VALUES(transfer_type), log=VALUES(log), area=VALUES(area), ip=VALUES(ip), 
-	first_byte_time=VALUES(first_byte_time), available_bandwidth=VALUES(available_bandwidth)`
+	first_byte_time=VALUES(first_byte_time), available_bandwidth=VALUES(available_bandwidth), country=VALUES(country), isp=VALUES(isp)`
 	_, err = tx.NamedExecContext(ctx, recordStatement, record)
 	if err != nil {
 		return err
@@ -0,0 +1,345 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const tableNameAssetTrash = "user_asset_trash"
+
+// AssetTrashGroupID 放入回收站的文件的 group_id 和文件组的 parent, 正常的列表查询不会返回这些数据
+const AssetTrashGroupID = -1
+
+// 回收站的数据类型
+const (
+	AssetTrashTypeAsset = "asset"
+	AssetTrashTypeGroup = "group"
+)
+
+// MoveAssetsToTrash 把文件放入回收站, 文件在各个文件组中的副本都会放入, 历史版本不放入
+func MoveAssetsToTrash(ctx context.Context, userID string, hashes []string, expireAt time.Time) error {
+	return moveAssetsToTrash(ctx, userID, hashes, squirrel.GtOrEq{"group_id": 0}, expireAt)
+}
+
+// MoveAssetCopiesToTrash 只把文件组 groupID 中的文件副本放入回收站
+func MoveAssetCopiesToTrash(ctx context.Context, userID string, hashes []string, groupID int64, expireAt time.Time) error {
+	return moveAssetsToTrash(ctx, userID, hashes, squirrel.Eq{"group_id": groupID}, expireAt)
+}
+
+// moveAssetsToTrash 回收站中同一个文件只保留一条 group_id 为 -1 的记录, 其余副本的记录删除, 由回收站数据记住原来的文件组
+func moveAssetsToTrash(ctx context.Context, userID string, hashes []string, cond squirrel.Sqlizer, expireAt time.Time) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	var assets []*model.UserAsset
+	query, args, err := squirrel.Select("*").From(tableUserAsset).Where(squirrel.Eq{
+		"user_id": userID,
+		"hash":    hashes,
+	}).Where(cond).Where("group_id >= 0").ToSql()
+	if err != nil {
+		return fmt.Errorf("generate get asset sql error:%w", err)
+	}
+	err = DB.SelectContext(ctx, &assets, query, args...)
+	if err != nil {
+		return err
+	}
+	if len(assets) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	ib := squirrel.Insert(tableNameAssetTrash).Columns("user_id,item_type,hash,cid,name,origin_group_id,size,deleted_at,expire_at")
+	trashed := make(squirrel.Or, 0, len(assets))
+	for _, a := range assets {
+		ib = ib.Values(userID, AssetTrashTypeAsset, a.Hash, a.Cid, a.AssetName, a.GroupID, a.TotalSize, now, expireAt)
+		trashed = append(trashed, squirrel.Eq{"hash": a.Hash, "group_id": a.GroupID})
+	}
+
+	tx, err := DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query, args, err = ib.ToSql()
+	if err != nil {
+		return fmt.Errorf("generate insert asset trash sql error:%w", err)
+	}
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+
+	where, args, err := squirrel.And{squirrel.Eq{"user_id": userID}, trashed}.ToSql()
+	if err != nil {
+		return fmt.Errorf("generate update asset sql error:%w", err)
+	}
+	// 回收站中已经有该文件时更新会冲突被忽略, 剩下的副本直接删除
+	query = fmt.Sprintf(`UPDATE IGNORE %s SET group_id = %d WHERE %s`, tableUserAsset, AssetTrashGroupID, where)
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+	query = fmt.Sprintf(`DELETE FROM %s WHERE %s`, tableUserAsset, where)
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MoveGroupsToTrash 把文件组放入回收站, 子文件组和文件跟随父级一起隐藏
+func MoveGroupsToTrash(ctx context.Context, userID string, gids []int64, expireAt time.Time) error {
+	if len(gids) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	ib := squirrel.Insert(tableNameAssetTrash).Columns("user_id,item_type,group_id,name,origin_group_id,size,deleted_at,expire_at")
+	trashed := make([]int64, 0, len(gids))
+	for _, gid := range gids {
+		groups, err := getAssetGroupTree(ctx, userID, gid)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if groups[0].Parent == AssetTrashGroupID {
+			continue
+		}
+
+		ids := make([]int64, 0, len(groups))
+		for _, g := range groups {
+			ids = append(ids, g.ID)
+		}
+
+		var size int64
+		query, args, err := squirrel.Select("IFNULL(SUM(total_size),0)").From(tableUserAsset).Where(squirrel.Eq{
+			"user_id":  userID,
+			"group_id": ids,
+		}).ToSql()
+		if err != nil {
+			return fmt.Errorf("generate get total_size of asset error:%w", err)
+		}
+		if err = DB.GetContext(ctx, &size, query, args...); err != nil {
+			return err
+		}
+
+		ib = ib.Values(userID, AssetTrashTypeGroup, gid, groups[0].Name, groups[0].Parent, size, now, expireAt)
+		trashed = append(trashed, gid)
+	}
+	if len(trashed) == 0 {
+		return nil
+	}
+
+	tx, err := DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query, args, err := ib.ToSql()
+	if err != nil {
+		return fmt.Errorf("generate insert asset trash sql error:%w", err)
+	}
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+
+	query, args, err = squirrel.Update(tableNameAssetGroup).Set("parent", AssetTrashGroupID).Where(squirrel.Eq{
+		"user_id": userID,
+		"id":      trashed,
+	}).ToSql()
+	if err != nil {
+		return fmt.Errorf("generate update asset's group sql error:%w", err)
+	}
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListAssetTrash 分页获取用户回收站
+func ListAssetTrash(ctx context.Context, userID string, option QueryOption) (int64, []*model.AssetTrash, error) {
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	var total int64
+	err := DB.GetContext(ctx, &total, fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE user_id = ?`, tableNameAssetTrash), userID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	out := make([]*model.AssetTrash, 0)
+	err = DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE user_id = ? ORDER BY id DESC LIMIT %d OFFSET %d`, tableNameAssetTrash, limit, offset), userID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
+
+// GetAssetTrashItems 获取回收站中的指定数据, ids 为空时返回用户回收站的所有数据
+func GetAssetTrashItems(ctx context.Context, userID string, ids []int64) ([]*model.AssetTrash, error) {
+	sb := squirrel.Select("*").From(tableNameAssetTrash).Where("user_id = ?", userID)
+	if len(ids) > 0 {
+		sb = sb.Where(squirrel.Eq{"id": ids})
+	}
+
+	query, args, err := sb.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate get asset trash sql error:%w", err)
+	}
+
+	out := make([]*model.AssetTrash, 0)
+	err = DB.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+// GetExpiredAssetTrash 获取已经过了保留期的回收站数据
+func GetExpiredAssetTrash(ctx context.Context, limit int) ([]*model.AssetTrash, error) {
+	out := make([]*model.AssetTrash, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE expire_at < ? ORDER BY id LIMIT %d`, tableNameAssetTrash, limit), time.Now())
+	return out, err
+}
+
+// DeleteAssetTrash 删除回收站记录
+func DeleteAssetTrash(ctx context.Context, id int64) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, tableNameAssetTrash), id)
+	return err
+}
+
+// DeleteAssetTrashByHash 删除回收站中该文件的所有副本记录
+func DeleteAssetTrashByHash(ctx context.Context, userID, hash string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE user_id = ? AND item_type = ? AND hash = ?`, tableNameAssetTrash), userID, AssetTrashTypeAsset, hash)
+	return err
+}
+
+// assetGroupVisible 文件组存在且不在回收站中
+func assetGroupVisible(ctx context.Context, userID string, gid int64) (bool, error) {
+	for gid > 0 {
+		var parent int64
+		query := fmt.Sprintf("SELECT parent FROM %s WHERE user_id = ? AND id = ?", tableNameAssetGroup)
+		err := DB.GetContext(ctx, &parent, query, userID, gid)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		gid = parent
+	}
+	return gid == 0, nil
+}
+
+// RestoreAssetTrash 从回收站恢复, 原来的文件组已经不存在或者也在回收站中时恢复到根目录
+func RestoreAssetTrash(ctx context.Context, item *model.AssetTrash) error {
+	target := item.OriginGroupID
+	visible, err := assetGroupVisible(ctx, item.UserID, target)
+	if err != nil {
+		return err
+	}
+	if !visible {
+		target = 0
+	}
+
+	tx, err := DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	switch item.ItemType {
+	case AssetTrashTypeAsset:
+		others, err := countOtherAssetTrash(ctx, tx, item)
+		if err != nil {
+			return err
+		}
+		if others > 0 {
+			// 回收站中还有该文件的其他副本, 保留 -1 的记录, 复制一条到目标文件组
+			query := fmt.Sprintf(`INSERT IGNORE INTO %s (%s,group_id) SELECT %s,? FROM %s WHERE user_id = ? AND hash = ? AND group_id = ?`,
+				tableUserAsset, userAssetCopyColumns, userAssetCopyColumns, tableUserAsset)
+			if _, err = tx.ExecContext(ctx, query, target, item.UserID, item.Hash, AssetTrashGroupID); err != nil {
+				return err
+			}
+			break
+		}
+		// 目标文件组中已经有该文件时更新被忽略, 删除回收站中的记录即可
+		query := fmt.Sprintf(`UPDATE IGNORE %s SET group_id = ? WHERE user_id = ? AND hash = ? AND group_id = ?`, tableUserAsset)
+		if _, err = tx.ExecContext(ctx, query, target, item.UserID, item.Hash, AssetTrashGroupID); err != nil {
+			return err
+		}
+		query = fmt.Sprintf(`DELETE FROM %s WHERE user_id = ? AND hash = ? AND group_id = ?`, tableUserAsset)
+		if _, err = tx.ExecContext(ctx, query, item.UserID, item.Hash, AssetTrashGroupID); err != nil {
+			return err
+		}
+	case AssetTrashTypeGroup:
+		query := fmt.Sprintf(`UPDATE %s SET parent = ? WHERE user_id = ? AND id = ?`, tableNameAssetGroup)
+		if _, err = tx.ExecContext(ctx, query, target, item.UserID, item.GroupID); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown trash item type: %s", item.ItemType)
+	}
+
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, tableNameAssetTrash), item.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReleaseAssetTrashCopy 彻底删除回收站中的一个文件副本, 用户还有该文件的其他副本时只删除这一份引用并返回 true,
+// 返回 false 时调用方需要删除文件并更新已使用空间
+func ReleaseAssetTrashCopy(ctx context.Context, item *model.AssetTrash) (bool, error) {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	others, err := countOtherAssetTrash(ctx, tx, item)
+	if err != nil {
+		return false, err
+	}
+	if others > 0 {
+		return true, nil
+	}
+
+	var live int64
+	query := fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE user_id = ? AND hash = ? AND group_id <> ?`, tableUserAsset)
+	if err = tx.GetContext(ctx, &live, query, item.UserID, item.Hash, AssetTrashGroupID); err != nil {
+		return false, err
+	}
+	if live == 0 {
+		return false, nil
+	}
+
+	query = fmt.Sprintf(`DELETE FROM %s WHERE user_id = ? AND hash = ? AND group_id = ?`, tableUserAsset)
+	if _, err = tx.ExecContext(ctx, query, item.UserID, item.Hash, AssetTrashGroupID); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// countOtherAssetTrash 回收站中同一个文件的其他副本数量
+func countOtherAssetTrash(ctx context.Context, tx *sqlx.Tx, item *model.AssetTrash) (int64, error) {
+	var count int64
+	query := fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE user_id = ? AND item_type = ? AND hash = ? AND id <> ?`, tableNameAssetTrash)
+	err := tx.GetContext(ctx, &count, query, item.UserID, AssetTrashTypeAsset, item.Hash, item.ID)
+	return count, err
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/Filecoin-Titan/titan/api"
+	"github.com/Filecoin-Titan/titan/api/terrors"
 	"github.com/Masterminds/squirrel"
 )
 
@@ -105,3 +107,124 @@ func getAssetGroupParent(ctx context.Context, gid int) (int, error) {
 
 	return parent, nil
 }
+
+// getAssetGroupTree 获取文件组及其所有子文件组, 父级在前
+func getAssetGroupTree(ctx context.Context, userID string, gid int64) ([]*AssetGroup, error) {
+	var root AssetGroup
+	query, args, err := squirrel.Select("id,user_id,name,parent").From(tableNameAssetGroup).Where("user_id = ? AND id = ?", userID, gid).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate get asset's group sql error:%w", err)
+	}
+	err = DB.GetContext(ctx, &root, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := []*AssetGroup{&root}
+	pids := []int64{gid}
+	for len(pids) > 0 {
+		var children []*AssetGroup
+		query, args, err = squirrel.Select("id,user_id,name,parent").From(tableNameAssetGroup).Where(squirrel.Eq{
+			"user_id": userID,
+			"parent":  pids,
+		}).ToSql()
+		if err != nil {
+			return nil, fmt.Errorf("generate get asset's group sql error:%w", err)
+		}
+		err = DB.SelectContext(ctx, &children, query, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		pids = pids[:0]
+		for _, child := range children {
+			groups = append(groups, child)
+			pids = append(pids, child.ID)
+		}
+	}
+
+	return groups, nil
+}
+
+// userAssetCopyColumns 复制文件记录时需要复制的列, group_id 由复制的位置决定
+const userAssetCopyColumns = "user_id,hash,cid,asset_name,asset_type,share_status,expiration,created_time,total_size,password,md5,extra_id,client_ip"
+
+// CopyAssetGroup 递归复制文件组和其中的文件, 返回新文件组的 id
+// 复制的文件按 hash 引用原来的文件, 不重复占用存储空间
+func CopyAssetGroup(ctx context.Context, userID string, groupID, targetGroupID int) (int64, error) {
+	if groupID == 0 {
+		return 0, &api.ErrWeb{Code: terrors.RootGroupCannotMoved.Int(), Message: "the root group cannot be copied"}
+	}
+
+	visible, err := assetGroupVisible(ctx, userID, int64(targetGroupID))
+	if err != nil {
+		return 0, err
+	}
+	if !visible {
+		return 0, &api.ErrWeb{Code: terrors.GroupNotExist.Int(), Message: fmt.Sprintf("CopyAssetGroup failed, group [%d] is not exist ", targetGroupID)}
+	}
+
+	groups, err := getAssetGroupTree(ctx, userID, int64(groupID))
+	if err == sql.ErrNoRows || (err == nil && groups[0].Parent == AssetTrashGroupID) {
+		return 0, &api.ErrWeb{Code: terrors.GroupNotExist.Int(), Message: fmt.Sprintf("CopyAssetGroup failed, group [%d] is not exist ", groupID)}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, g := range groups {
+		if g.ID == int64(targetGroupID) {
+			return 0, &api.ErrWeb{Code: terrors.CannotMoveToSubgroup.Int(), Message: "cannot copy to subgroup"}
+		}
+	}
+
+	var count int64
+	query, args, err := squirrel.Select("count(id)").From(tableNameAssetGroup).Where("user_id = ?", userID).ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("generate get asset's group sql error:%w", err)
+	}
+	err = DB.GetContext(ctx, &count, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	if count+int64(len(groups)) > 20 {
+		return 0, &api.ErrWeb{Code: terrors.GroupLimit.Int(), Message: fmt.Sprintf("CopyAssetGroup failed, Exceed the limit %d", 20)}
+	}
+
+	tx, err := DB.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// 旧文件组 id 到新文件组 id 的映射, 父级先插入
+	newIDs := make(map[int64]int64, len(groups))
+	createdTime := time.Now()
+	for _, g := range groups {
+		parent := newIDs[g.Parent]
+		if g.ID == int64(groupID) {
+			parent = int64(targetGroupID)
+		}
+		query, args, err = squirrel.Insert(tableNameAssetGroup).Columns("user_id,name,parent,created_time").Values(userID, g.Name, parent, createdTime).ToSql()
+		if err != nil {
+			return 0, fmt.Errorf("generate insert asset's group sql error:%w", err)
+		}
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return 0, err
+		}
+		newIDs[g.ID], _ = res.LastInsertId()
+
+		query = fmt.Sprintf(`INSERT IGNORE INTO %s (%s,group_id) SELECT %s,? FROM %s WHERE user_id = ? AND group_id = ?`,
+			tableUserAsset, userAssetCopyColumns, userAssetCopyColumns, tableUserAsset)
+		if _, err = tx.ExecContext(ctx, query, newIDs[g.ID], userID, g.ID); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newIDs[int64(groupID)], nil
+}
@@ -0,0 +1,246 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	tableNameAssetVersioning = "user_asset_versioning"
+	tableNameAssetVersion    = "user_asset_version"
+)
+
+// AssetVersionGroupID 历史版本的 group_id, 正常的列表查询不会返回历史版本
+const AssetVersionGroupID = -2
+
+// SetAssetVersioning 开启文件夹的版本管理, 已开启时更新保留的版本数
+func SetAssetVersioning(ctx context.Context, userID string, groupID, keepVersions int64) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO %s (user_id, group_id, keep_versions, created_at) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE keep_versions = VALUES(keep_versions)`,
+		tableNameAssetVersioning), userID, groupID, keepVersions, time.Now())
+	return err
+}
+
+// DisableAssetVersioning 关闭文件夹的版本管理, 已有的历史版本保留
+func DisableAssetVersioning(ctx context.Context, userID string, groupID int64) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE user_id = ? AND group_id = ?`, tableNameAssetVersioning), userID, groupID)
+	return err
+}
+
+// GetAssetVersioning 获取文件夹的版本管理设置, 未开启时返回 sql.ErrNoRows
+func GetAssetVersioning(ctx context.Context, userID string, groupID int64) (*model.AssetVersioning, error) {
+	var out model.AssetVersioning
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE user_id = ? AND group_id = ?`, tableNameAssetVersioning), userID, groupID)
+	return &out, err
+}
+
+// AddAssetVersion 上传文件后记录版本, 文件夹开启了版本管理且存在同名的文件时, 旧文件成为历史版本
+func AddAssetVersion(ctx context.Context, userID, hash string) error {
+	asset, err := GetUserAsset(ctx, hash, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = GetAssetVersioning(ctx, userID, asset.GroupID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// 相同内容重复上传不产生新版本, 重新上传了历史版本的内容时把该版本恢复为当前版本
+	v, err := GetAssetVersionByHash(ctx, userID, hash)
+	if err == nil {
+		if v.IsCurrent || v.GroupID != asset.GroupID || v.AssetName != asset.AssetName {
+			return nil
+		}
+		return RestoreAssetVersion(ctx, v)
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	var previous []*model.UserAsset
+	query, args, err := squirrel.Select("*").From(tableUserAsset).
+		Where("user_id = ? AND group_id = ? AND asset_name = ? AND hash <> ?", userID, asset.GroupID, asset.AssetName, hash).ToSql()
+	if err != nil {
+		return fmt.Errorf("generate get asset sql error:%w", err)
+	}
+	if err = DB.SelectContext(ctx, &previous, query, args...); err != nil {
+		return err
+	}
+	if len(previous) == 0 {
+		return nil
+	}
+
+	tx, err := DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int64
+	err = tx.GetContext(ctx, &version, fmt.Sprintf(`SELECT IFNULL(MAX(version), 0) FROM %s WHERE user_id = ? AND group_id = ? AND asset_name = ?`, tableNameAssetVersion),
+		userID, asset.GroupID, asset.AssetName)
+	if err != nil {
+		return err
+	}
+
+	query, args, err = squirrel.Update(tableNameAssetVersion).Set("is_current", false).
+		Where("user_id = ? AND group_id = ? AND asset_name = ?", userID, asset.GroupID, asset.AssetName).ToSql()
+	if err != nil {
+		return fmt.Errorf("generate update asset version sql error:%w", err)
+	}
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+
+	// 已经记录过的旧文件会被忽略, 只有实际插入的记录才占用版本号
+	insertVersion := fmt.Sprintf(`INSERT IGNORE INTO %s (user_id,group_id,asset_name,hash,cid,total_size,version,is_current,created_at) VALUES (?,?,?,?,?,?,?,?,?)`, tableNameAssetVersion)
+	hashes := make([]string, 0, len(previous))
+	for _, p := range previous {
+		res, err := tx.ExecContext(ctx, insertVersion, userID, asset.GroupID, asset.AssetName, p.Hash, p.Cid, p.TotalSize, version+1, false, p.CreatedTime)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			version++
+		}
+		hashes = append(hashes, p.Hash)
+	}
+	if _, err = tx.ExecContext(ctx, insertVersion, userID, asset.GroupID, asset.AssetName, asset.Hash, asset.Cid, asset.TotalSize, version+1, true, asset.CreatedTime); err != nil {
+		return err
+	}
+
+	if err = moveAssetsToVersions(ctx, tx, userID, hashes, asset.GroupID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// moveAssetsToVersions 把文件组 groupID 中的文件记录改为历史版本, 已经有历史版本记录的文件直接删除这一条
+func moveAssetsToVersions(ctx context.Context, tx *sqlx.Tx, userID string, hashes []string, groupID int64) error {
+	where, args, err := squirrel.Eq{
+		"user_id":  userID,
+		"hash":     hashes,
+		"group_id": groupID,
+	}.ToSql()
+	if err != nil {
+		return fmt.Errorf("generate update asset sql error:%w", err)
+	}
+
+	query := fmt.Sprintf(`UPDATE IGNORE %s SET group_id = %d WHERE %s`, tableUserAsset, AssetVersionGroupID, where)
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+	query = fmt.Sprintf(`DELETE FROM %s WHERE %s`, tableUserAsset, where)
+	_, err = tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ListAssetVersions 获取逻辑文件的所有版本, 新版本在前
+func ListAssetVersions(ctx context.Context, userID string, groupID int64, assetName string) ([]*model.AssetVersion, error) {
+	out := make([]*model.AssetVersion, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE user_id = ? AND group_id = ? AND asset_name = ? ORDER BY version DESC`, tableNameAssetVersion),
+		userID, groupID, assetName)
+	return out, err
+}
+
+// GetAssetVersion 获取用户的某个版本
+func GetAssetVersion(ctx context.Context, userID string, id int64) (*model.AssetVersion, error) {
+	var out model.AssetVersion
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE id = ? AND user_id = ?`, tableNameAssetVersion), id, userID)
+	return &out, err
+}
+
+// RestoreAssetVersion 把历史版本恢复为当前版本, 原来的当前版本成为历史版本
+func RestoreAssetVersion(ctx context.Context, v *model.AssetVersion) error {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current []string
+	err = tx.SelectContext(ctx, &current, fmt.Sprintf(`SELECT hash FROM %s WHERE user_id = ? AND group_id = ? AND asset_name = ? AND is_current = 1`, tableNameAssetVersion),
+		v.UserID, v.GroupID, v.AssetName)
+	if err != nil {
+		return err
+	}
+
+	if len(current) > 0 {
+		if err = moveAssetsToVersions(ctx, tx, v.UserID, current, v.GroupID); err != nil {
+			return err
+		}
+	}
+
+	// 文件组中已经有该文件 (重新上传了历史版本的内容) 时更新被忽略, 删除历史版本的记录即可
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`UPDATE IGNORE %s SET group_id = ? WHERE user_id = ? AND hash = ? AND group_id = ?`, tableUserAsset),
+		v.GroupID, v.UserID, v.Hash, AssetVersionGroupID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE user_id = ? AND hash = ? AND group_id = ?`, tableUserAsset),
+		v.UserID, v.Hash, AssetVersionGroupID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET is_current = (id = ?) WHERE user_id = ? AND group_id = ? AND asset_name = ?`, tableNameAssetVersion),
+		v.ID, v.UserID, v.GroupID, v.AssetName)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetPrunableAssetVersions 获取需要清理的历史版本, 保留最新的 keep 个历史版本, 当前版本不会被清理
+func GetPrunableAssetVersions(ctx context.Context, userID string, groupID int64, assetName string, keep int64) ([]*model.AssetVersion, error) {
+	versions, err := ListAssetVersions(ctx, userID, groupID, assetName)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*model.AssetVersion
+	var kept int64
+	for _, v := range versions {
+		if v.IsCurrent {
+			continue
+		}
+		if kept < keep {
+			kept++
+			continue
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// GetAssetVersionByHash 获取文件对应的版本记录, 没有记录时返回 sql.ErrNoRows
+func GetAssetVersionByHash(ctx context.Context, userID, hash string) (*model.AssetVersion, error) {
+	var out model.AssetVersion
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE user_id = ? AND hash = ?`, tableNameAssetVersion), userID, hash)
+	return &out, err
+}
+
+// DeleteAssetVersions 删除逻辑文件的所有版本记录
+func DeleteAssetVersions(ctx context.Context, userID string, groupID int64, assetName string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE user_id = ? AND group_id = ? AND asset_name = ?`, tableNameAssetVersion),
+		userID, groupID, assetName)
+	return err
+}
+
+// DeleteAssetVersion 删除版本记录
+func DeleteAssetVersion(ctx context.Context, id int64) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ?`, tableNameAssetVersion), id)
+	return err
+}
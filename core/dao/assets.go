@@ -61,7 +61,8 @@ func CountAssets(ctx context.Context) (int64, error) {
 func GetAssetsByEmptyPath(ctx context.Context) ([]*model.Asset, int64, error) {
 	l1CompletedState := []string{"EdgesSelect", "EdgesPulling", "Servicing", "EdgesFailed"}
 
-	query := fmt.Sprintf(`SELECT * FROM %s WHERE backup_result = 1 AND path = '' and state in (?)`, tableNameAsset)
+	// 生命周期规则归档的文件也需要备份
+	query := fmt.Sprintf(`SELECT * FROM %s WHERE (backup_result = 1 OR cid IN (SELECT cid FROM %s)) AND path = '' and state in (?)`, tableNameAsset, tableNameAssetBackupRequest)
 	queryIn, queryArgs, err := sqlx.In(query, l1CompletedState)
 	if err != nil {
 		return nil, 0, err
@@ -74,7 +75,7 @@ func GetAssetsByEmptyPath(ctx context.Context) ([]*model.Asset, int64, error) {
 		return nil, 0, err
 	}
 
-	count := fmt.Sprintf(`SELECT count(*) FROM %s WHERE backup_result = 1 AND path = '' and state in (?)`, tableNameAsset)
+	count := fmt.Sprintf(`SELECT count(*) FROM %s WHERE (backup_result = 1 OR cid IN (SELECT cid FROM %s)) AND path = '' and state in (?)`, tableNameAsset, tableNameAssetBackupRequest)
 	countIn, countArgs, err := sqlx.In(count, l1CompletedState)
 	if err != nil {
 		return nil, 0, err
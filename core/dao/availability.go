@@ -0,0 +1,61 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gnasnik/titan-explorer/core/availability"
+	"github.com/jmoiron/sqlx"
+)
+
+// maxSLADevices 单次生成 SLA 报告的节点数上限
+const maxSLADevices = 1000
+
+type deviceOnlineSample struct {
+	DeviceID string `db:"device_id"`
+	availability.Sample
+}
+
+// GetUserDeviceIDs 获取用户绑定的所有节点ID
+func GetUserDeviceIDs(ctx context.Context, userId string) ([]string, error) {
+	var out []string
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(
+		`SELECT device_id FROM %s WHERE user_id = ? AND active_status = 1 ORDER BY device_id LIMIT %d`, tableNameDeviceInfo, maxSLADevices), userId)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetDeviceOnlineSamples 按小时聚合 device_info_hour 中的累计在线时长, 每小时取最后一次采样
+func GetDeviceOnlineSamples(ctx context.Context, deviceIds []string, start, end time.Time) (map[string][]availability.Sample, error) {
+	out := make(map[string][]availability.Sample)
+	if len(deviceIds) == 0 {
+		return out, nil
+	}
+
+	query, args, err := sqlx.In(fmt.Sprintf(
+		`SELECT device_id, max(time) as time, max(online_time) as online_time FROM %s
+		WHERE device_id IN (?) AND time >= ? AND time <= ? GROUP BY device_id, FLOOR(UNIX_TIMESTAMP(time)/3600)`, tableNameDeviceInfoHour), deviceIds, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sample deviceOnlineSample
+		if err := rows.StructScan(&sample); err != nil {
+			log.Errorf("struct scan: %v", err)
+			continue
+		}
+		out[sample.DeviceID] = append(out[sample.DeviceID], sample.Sample)
+	}
+
+	return out, nil
+}
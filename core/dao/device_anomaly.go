@@ -0,0 +1,168 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+const tableNameDeviceAnomaly = "device_anomaly"
+
+const (
+	// DeviceAnomalyStatusPending 待审核
+	DeviceAnomalyStatusPending = iota
+	// DeviceAnomalyStatusConfirmed 确认作弊
+	DeviceAnomalyStatusConfirmed
+	// DeviceAnomalyStatusDismissed 审核后排除
+	DeviceAnomalyStatusDismissed
+)
+
+// flaggedRewardQuery 审核确认作弊的节点在被标记日期内的收益, 待审核的节点不排除
+// flagged_income 为所有标记日期的收益合计, flagged_today 表示今天是否被标记
+var flaggedRewardQuery = fmt.Sprintf(`SELECT a.device_id, IFNULL(SUM(d.income), 0) AS flagged_income, MAX(a.date = CURDATE()) AS flagged_today
+	FROM %s a LEFT JOIN %s d ON d.device_id = a.device_id AND d.time >= a.date AND d.time < a.date + INTERVAL 1 DAY
+	WHERE a.status = %d GROUP BY a.device_id`, tableNameDeviceAnomaly, tableNameDeviceInfoDaily, DeviceAnomalyStatusConfirmed)
+
+// DeviceIPRecord 节点每天使用的公网IP
+type DeviceIPRecord struct {
+	DeviceID   string    `db:"device_id"`
+	Time       time.Time `db:"time"`
+	ExternalIP string    `db:"external_ip"`
+}
+
+// GetAnomalyCandidates 获取需要检测的节点, 只检测已绑定用户的激活节点
+func GetAnomalyCandidates(ctx context.Context) ([]*model.AnomalyCandidate, error) {
+	out := make([]*model.AnomalyCandidate, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(
+		`SELECT device_id, user_id, external_ip, cpu_info, mac_location, today_profit FROM %s WHERE active_status = 1 AND user_id <> ''`, tableNameDeviceInfo))
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetDevicesIPHistory 获取 since 之后所有节点每天的公网IP
+func GetDevicesIPHistory(ctx context.Context, since time.Time) (map[string][]*DeviceIPRecord, error) {
+	rows, err := DB.QueryxContext(ctx, fmt.Sprintf(
+		`SELECT device_id, time, external_ip FROM %s WHERE time >= ? AND external_ip <> '' ORDER BY device_id, time`, tableNameDeviceInfoDaily), since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string][]*DeviceIPRecord)
+	for rows.Next() {
+		var record DeviceIPRecord
+		if err := rows.StructScan(&record); err != nil {
+			return nil, err
+		}
+		out[record.DeviceID] = append(out[record.DeviceID], &record)
+	}
+
+	return out, rows.Err()
+}
+
+// GetDevicesAvgIncome 获取所有节点在 [start, end) 之间的日均收益
+func GetDevicesAvgIncome(ctx context.Context, start, end time.Time) (map[string]float64, error) {
+	rows, err := DB.QueryxContext(ctx, fmt.Sprintf(
+		`SELECT device_id, AVG(income) FROM %s WHERE time >= ? AND time < ? GROUP BY device_id`, tableNameDeviceInfoDaily), start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]float64)
+	for rows.Next() {
+		var (
+			deviceId string
+			income   float64
+		)
+		if err := rows.Scan(&deviceId, &income); err != nil {
+			return nil, err
+		}
+		out[deviceId] = income
+	}
+
+	return out, rows.Err()
+}
+
+// BulkUpsertDeviceAnomaly 写入检测结果, 同一天重复检测时只更新分数和证据, 保留审核状态
+func BulkUpsertDeviceAnomaly(ctx context.Context, anomalies []*model.DeviceAnomaly) error {
+	if len(anomalies) == 0 {
+		return nil
+	}
+
+	_, err := DB.NamedExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO %s (device_id, user_id, date, score, rules, evidence, created_at, updated_at)
+		VALUES (:device_id, :user_id, :date, :score, :rules, :evidence, :created_at, :updated_at)
+		ON DUPLICATE KEY UPDATE user_id = VALUES(user_id), score = VALUES(score), rules = VALUES(rules), evidence = VALUES(evidence), updated_at = VALUES(updated_at)`,
+		tableNameDeviceAnomaly), anomalies)
+	return err
+}
+
+// ListDeviceAnomalies 分页获取检测结果, status 小于 0 时返回所有状态
+func ListDeviceAnomalies(ctx context.Context, status int, deviceId, userId string, option QueryOption) (int64, []*model.DeviceAnomaly, error) {
+	sb := squirrel.Select().From(tableNameDeviceAnomaly)
+	if status >= 0 {
+		sb = sb.Where("status = ?", status)
+	}
+	if deviceId != "" {
+		sb = sb.Where("device_id = ?", deviceId)
+	}
+	if userId != "" {
+		sb = sb.Where("user_id = ?", userId)
+	}
+
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	var total int64
+	query, args, err := sb.Column("COUNT(1)").ToSql()
+	if err != nil {
+		return 0, nil, err
+	}
+	if err = DB.GetContext(ctx, &total, query, args...); err != nil {
+		return 0, nil, err
+	}
+
+	out := make([]*model.DeviceAnomaly, 0)
+	query, args, err = sb.Column("*").OrderBy("date DESC", "score DESC").Limit(uint64(limit)).Offset(uint64(offset)).ToSql()
+	if err != nil {
+		return 0, nil, err
+	}
+	if err = DB.SelectContext(ctx, &out, query, args...); err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
+
+// GetDeviceAnomaly 获取单条检测结果
+func GetDeviceAnomaly(ctx context.Context, id int64) (*model.DeviceAnomaly, error) {
+	var out model.DeviceAnomaly
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE id = ?`, tableNameDeviceAnomaly), id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoRow
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ReviewDeviceAnomaly 审核检测结果
+func ReviewDeviceAnomaly(ctx context.Context, id int64, status int, reviewer, remark string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(
+		`UPDATE %s SET status = ?, reviewer = ?, remark = ?, reviewed_at = now() WHERE id = ?`, tableNameDeviceAnomaly), status, reviewer, remark, id)
+	return err
+}
@@ -0,0 +1,121 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const tableNameDeviceEvents = "device_events"
+
+// 节点生命周期事件类型
+const (
+	DeviceEventBind         = "bind"
+	DeviceEventUnbind       = "unbind"
+	DeviceEventRename       = "rename"
+	DeviceEventDeactivate   = "deactivate"
+	DeviceEventReactivate   = "reactivate"
+	DeviceEventMoveArea     = "move_area"
+	DeviceEventIPChange     = "ip_change"
+	DeviceEventStatusChange = "status_change"
+	DeviceEventAreaChange   = "area_change"
+	DeviceEventNATChange    = "nat_change"
+)
+
+// DeviceEventOperatorSystem 由系统自动检测到的事件
+const DeviceEventOperatorSystem = "system"
+
+// DeviceEventSnapshot 用于对比节点变化的字段
+type DeviceEventSnapshot struct {
+	DeviceID     string `db:"device_id"`
+	UserID       string `db:"user_id"`
+	DeviceStatus string `db:"device_status"`
+	ExternalIP   string `db:"external_ip"`
+	AreaID       string `db:"area_id"`
+	NATType      string `db:"nat_type"`
+}
+
+// NewDeviceEvent 创建一个事件
+func NewDeviceEvent(deviceId, userId, event, operator, oldValue, newValue string) *model.DeviceEvent {
+	return &model.DeviceEvent{
+		DeviceID:  deviceId,
+		UserID:    userId,
+		Event:     event,
+		Operator:  operator,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+		CreatedAt: time.Now(),
+	}
+}
+
+// AddDeviceEvents 追加节点事件
+func AddDeviceEvents(ctx context.Context, events ...*model.DeviceEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	_, err := DB.NamedExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO %s (device_id, user_id, event, operator, old_value, new_value, created_at)
+		VALUES (:device_id, :user_id, :event, :operator, :old_value, :new_value, :created_at)`, tableNameDeviceEvents), events)
+	return err
+}
+
+// GetDeviceEventSnapshots 获取节点当前的状态, IP, 区域和 NAT 类型, 用于和最新拉取的数据对比
+func GetDeviceEventSnapshots(ctx context.Context, deviceIds []string) (map[string]*DeviceEventSnapshot, error) {
+	out := make(map[string]*DeviceEventSnapshot)
+	if len(deviceIds) == 0 {
+		return out, nil
+	}
+
+	query, args, err := sqlx.In(fmt.Sprintf(
+		`SELECT device_id, user_id, device_status, external_ip, area_id, nat_type FROM %s WHERE device_id IN (?)`, tableNameDeviceInfo), deviceIds)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []*DeviceEventSnapshot
+	if err = DB.SelectContext(ctx, &snapshots, query, args...); err != nil {
+		return nil, err
+	}
+
+	for _, s := range snapshots {
+		out[s.DeviceID] = s
+	}
+	return out, nil
+}
+
+// GetDeviceEvents 按时间倒序分页获取节点事件, event 为空时返回所有类型
+func GetDeviceEvents(ctx context.Context, deviceId, event string, option QueryOption) (int64, []*model.DeviceEvent, error) {
+	where := `WHERE device_id = ?`
+	args := []interface{}{deviceId}
+	if event != "" {
+		where += ` AND event = ?`
+		args = append(args, event)
+	}
+
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	var total int64
+	err := DB.GetContext(ctx, &total, fmt.Sprintf(`SELECT COUNT(1) FROM %s %s`, tableNameDeviceEvents, where), args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	out := make([]*model.DeviceEvent, 0)
+	err = DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s %s ORDER BY id DESC LIMIT %d OFFSET %d`, tableNameDeviceEvents, where, limit, offset), args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
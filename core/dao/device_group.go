@@ -0,0 +1,298 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	tableNameDeviceGroup       = "device_group"
+	tableNameDeviceGroupMember = "device_group_member"
+	tableNameDeviceTag         = "device_tag"
+)
+
+// CreateDeviceGroup 创建节点分组
+func CreateDeviceGroup(ctx context.Context, group *model.DeviceGroup) (int64, error) {
+	res, err := DB.NamedExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO %s (user_id, name, created_at, updated_at) VALUES (:user_id, :name, :created_at, :updated_at)`, tableNameDeviceGroup), group)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetDeviceGroup 获取用户的节点分组
+func GetDeviceGroup(ctx context.Context, userId string, groupId int64) (*model.DeviceGroup, error) {
+	var out model.DeviceGroup
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(
+		`SELECT id, user_id, name, created_at, updated_at FROM %s WHERE id = ? AND user_id = ?`, tableNameDeviceGroup), groupId, userId)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoRow
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetDeviceGroupByName 根据名称获取用户的节点分组
+func GetDeviceGroupByName(ctx context.Context, userId, name string) (*model.DeviceGroup, error) {
+	var out model.DeviceGroup
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(
+		`SELECT id, user_id, name, created_at, updated_at FROM %s WHERE user_id = ? AND name = ?`, tableNameDeviceGroup), userId, name)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoRow
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ListDeviceGroups 获取用户所有的节点分组以及每个分组的节点数量, 只统计仍绑定在该用户下的节点
+func ListDeviceGroups(ctx context.Context, userId string) ([]*model.DeviceGroup, error) {
+	out := make([]*model.DeviceGroup, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(
+		`SELECT g.id, g.user_id, g.name, g.created_at, g.updated_at, COUNT(d.device_id) AS device_count FROM %s g
+		LEFT JOIN %s m ON m.group_id = g.id LEFT JOIN %s d ON d.device_id = m.device_id AND d.user_id = g.user_id
+		WHERE g.user_id = ? GROUP BY g.id ORDER BY g.id`, tableNameDeviceGroup, tableNameDeviceGroupMember, tableNameDeviceInfo), userId)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RenameDeviceGroup 修改分组名称
+func RenameDeviceGroup(ctx context.Context, userId string, groupId int64, name string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(
+		`UPDATE %s SET name = ?, updated_at = now() WHERE id = ? AND user_id = ?`, tableNameDeviceGroup), name, groupId, userId)
+	return err
+}
+
+// DeleteDeviceGroup 删除分组, 分组内的节点变为未分组
+func DeleteDeviceGroup(ctx context.Context, userId string, groupId int64) error {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE id = ? AND user_id = ?`, tableNameDeviceGroup), groupId, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE group_id = ? AND user_id = ?`, tableNameDeviceGroupMember), groupId, userId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AddDevicesToGroup 把用户绑定的节点加入分组, 节点原来所在的分组会被替换, 不属于该用户的节点会被忽略
+func AddDevicesToGroup(ctx context.Context, userId string, groupId int64, deviceIds []string) (int64, error) {
+	query, args, err := sqlx.In(fmt.Sprintf(
+		`INSERT INTO %s (device_id, group_id, user_id, created_at) SELECT device_id, ?, user_id, now() FROM %s WHERE user_id = ? AND device_id IN (?)
+		ON DUPLICATE KEY UPDATE group_id = VALUES(group_id), user_id = VALUES(user_id), created_at = VALUES(created_at)`, tableNameDeviceGroupMember, tableNameDeviceInfo),
+		groupId, userId, deviceIds)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RemoveDevicesFromGroup 把节点移出分组
+func RemoveDevicesFromGroup(ctx context.Context, userId string, groupId int64, deviceIds []string) error {
+	query, args, err := sqlx.In(fmt.Sprintf(
+		`DELETE FROM %s WHERE group_id = ? AND user_id = ? AND device_id IN (?)`, tableNameDeviceGroupMember), groupId, userId, deviceIds)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// deviceFilterBuilder 按分组或标签筛选用户绑定的节点, groupId 为 0 或 tag 为空时不做对应的筛选
+func deviceFilterBuilder(columns string, userId string, groupId int64, tag string) squirrel.SelectBuilder {
+	sb := squirrel.Select(columns).From(fmt.Sprintf("%s d", tableNameDeviceInfo)).Where("d.user_id = ?", userId)
+	if groupId > 0 {
+		sb = sb.Join(fmt.Sprintf("%s m ON m.device_id = d.device_id", tableNameDeviceGroupMember)).Where("m.group_id = ? AND m.user_id = d.user_id", groupId)
+	}
+	if tag != "" {
+		sb = sb.Join(fmt.Sprintf("%s t ON t.device_id = d.device_id", tableNameDeviceTag)).Where("t.tag = ? AND t.user_id = d.user_id", tag)
+	}
+	return sb
+}
+
+// GetUserDevicesByFilter 按分组或标签分页获取用户的节点
+func GetUserDevicesByFilter(ctx context.Context, userId string, groupId int64, tag string, option QueryOption) ([]*model.DeviceInfo, int64, error) {
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	var total int64
+	query, args, err := deviceFilterBuilder("COUNT(d.device_id)", userId, groupId, tag).ToSql()
+	if err != nil {
+		return nil, 0, err
+	}
+	if err = DB.GetContext(ctx, &total, query, args...); err != nil {
+		return nil, 0, err
+	}
+
+	out := make([]*model.DeviceInfo, 0)
+	query, args, err = deviceFilterBuilder("d.*", userId, groupId, tag).OrderBy("d.device_id").Limit(uint64(limit)).Offset(uint64(offset)).ToSql()
+	if err != nil {
+		return nil, 0, err
+	}
+	if err = DB.SelectContext(ctx, &out, query, args...); err != nil {
+		return nil, 0, err
+	}
+
+	return out, total, nil
+}
+
+// GetUserDeviceListByFilter 按分组或标签获取用户的节点, 最多 limit 个, 用于批量操作
+func GetUserDeviceListByFilter(ctx context.Context, userId string, groupId int64, tag string, limit uint64) ([]*model.DeviceInfo, error) {
+	query, args, err := deviceFilterBuilder("d.device_id, d.device_name, d.area_id, d.node_type, d.device_status_code", userId, groupId, tag).
+		OrderBy("d.device_id").Limit(limit).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*model.DeviceInfo, 0)
+	if err = DB.SelectContext(ctx, &out, query, args...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SumDeviceGroupStats 统计分组内节点的收益、带宽以及在线情况
+func SumDeviceGroupStats(ctx context.Context, userId string, groupId int64, tag string) (*model.DeviceGroupStats, error) {
+	columns := `COUNT(d.device_id) AS device_count, COUNT(IF(d.device_status = 'online', 1, NULL)) AS online_count,
+	COUNT(IF(d.device_status = 'offline', 1, NULL)) AS offline_count, COUNT(IF(d.device_status = 'abnormal', 1, NULL)) AS abnormal_count,
+	COALESCE(SUM(d.cumulative_profit),0) AS cumulative_profit, COALESCE(SUM(d.today_profit),0) AS today_profit, COALESCE(SUM(d.yesterday_profit),0) AS yesterday_profit,
+	COALESCE(SUM(d.seven_days_profit),0) AS seven_days_profit, COALESCE(SUM(d.month_profit),0) AS month_profit,
+	COALESCE(SUM(d.bandwidth_up),0) AS bandwidth_up, COALESCE(SUM(d.bandwidth_down),0) AS bandwidth_down`
+
+	query, args, err := deviceFilterBuilder(columns, userId, groupId, tag).ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var out model.DeviceGroupStats
+	if err = DB.GetContext(ctx, &out, query, args...); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AddDeviceTags 给用户绑定的节点添加标签
+func AddDeviceTags(ctx context.Context, userId string, deviceIds []string, tags []string) error {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, tag := range tags {
+		query, args, err := sqlx.In(fmt.Sprintf(
+			`INSERT IGNORE INTO %s (device_id, tag, user_id, created_at) SELECT device_id, ?, user_id, now() FROM %s WHERE user_id = ? AND device_id IN (?)`,
+			tableNameDeviceTag, tableNameDeviceInfo), tag, userId, deviceIds)
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RemoveDeviceTags 删除节点的标签
+func RemoveDeviceTags(ctx context.Context, userId string, deviceIds []string, tags []string) error {
+	query, args, err := sqlx.In(fmt.Sprintf(
+		`DELETE FROM %s WHERE user_id = ? AND device_id IN (?) AND tag IN (?)`, tableNameDeviceTag), userId, deviceIds, tags)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ListUserDeviceTags 获取用户所有的标签以及每个标签的节点数量
+func ListUserDeviceTags(ctx context.Context, userId string) ([]*model.DeviceTagCount, error) {
+	out := make([]*model.DeviceTagCount, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(
+		`SELECT t.tag, COUNT(d.device_id) AS count FROM %s t JOIN %s d ON d.device_id = t.device_id AND d.user_id = t.user_id
+		WHERE t.user_id = ? GROUP BY t.tag ORDER BY t.tag`, tableNameDeviceTag, tableNameDeviceInfo), userId)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetDeviceTags 获取节点的标签
+func GetDeviceTags(ctx context.Context, userId string, deviceIds []string) (map[string][]string, error) {
+	out := make(map[string][]string)
+	if len(deviceIds) == 0 {
+		return out, nil
+	}
+
+	query, args, err := sqlx.In(fmt.Sprintf(
+		`SELECT device_id, tag FROM %s WHERE user_id = ? AND device_id IN (?) ORDER BY tag`, tableNameDeviceTag), userId, deviceIds)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []struct {
+		DeviceID string `db:"device_id"`
+		Tag      string `db:"tag"`
+	}
+	if err = DB.SelectContext(ctx, &tags, query, args...); err != nil {
+		return nil, err
+	}
+
+	for _, t := range tags {
+		out[t.DeviceID] = append(out[t.DeviceID], t.Tag)
+	}
+	return out, nil
+}
+
+// BulkUpdateDeviceName 批量修改节点名称, names 的 key 为节点ID
+func BulkUpdateDeviceName(ctx context.Context, userId string, names map[string]string) error {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for deviceId, name := range names {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(
+			`UPDATE %s SET updated_at = now(), device_name = ? WHERE device_id = ? AND user_id = ?`, tableNameDeviceInfo), name, deviceId, userId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -710,12 +711,9 @@ func GetOnlineIPCountsFromCache(ctx context.Context, ip string) (int64, error) {
 	return count, nil
 }
 
+// GetNodesInfo 从用户节点数排行榜分页获取用户的节点统计, 排行榜由节点数据拉取完成后重建
+// 只支持按节点数排序, option.Order 为 asc 时升序; 排行榜未生成或已过期时从数据库重建
 func GetNodesInfo(ctx context.Context, option QueryOption) (int64, []model.NodesInfo, error) {
-	where := `WHERE device_id <> '' AND active_status = 1`
-	if option.Order != "" && option.OrderField != "" {
-		where += fmt.Sprintf(` ORDER BY %s %s`, option.OrderField, option.Order)
-	}
-
 	limit := option.PageSize
 	offset := option.Page
 	if option.PageSize <= 0 {
@@ -724,22 +722,59 @@ func GetNodesInfo(ctx context.Context, option QueryOption) (int64, []model.Nodes
 	if option.Page > 0 {
 		offset = limit * (option.Page - 1)
 	}
-	var total int64
-	err := DB.GetContext(ctx, &total, fmt.Sprintf(
-		`SELECT count(distinct(user_id)) FROM %s %s`, tableNameDeviceInfo, where,
-	))
-	if err != nil {
-		return 0, nil, err
+	if offset < 0 {
+		offset = 0
 	}
-	var nodeInfo []model.NodesInfo
-	query := fmt.Sprintf("SELECT node_type,user_id,COUNT(device_id) AS node_count,ROUND(sum(disk_space) ,2) as disk_space,ROUND(SUM(bandwidth_up),2) as bandwidth_up FROM %s %s GROUP BY user_id ORDER BY node_count DESC LIMIT %d OFFSET %d",
-		tableNameDeviceInfo, where, limit, offset)
-	err = DB.SelectContext(ctx, &nodeInfo, query)
+
+	asc := strings.EqualFold(option.Order, "asc")
+	total, out, err := GetUserNodesLeaderboardFromCache(ctx, int64(offset), int64(limit), asc)
+	if err != nil || total > 0 {
+		return total, out, err
+	}
+
+	infos, err := GetUserNodesScores(ctx)
 	if err != nil {
-		log.Errorf("GetNodesInfo %v", err)
 		return 0, nil, err
 	}
-	return total, nodeInfo, nil
+	if len(infos) == 0 {
+		return 0, nil, nil
+	}
+	if err = SetUserNodesLeaderboardToCache(ctx, infos); err == nil {
+		return GetUserNodesLeaderboardFromCache(ctx, int64(offset), int64(limit), asc)
+	}
+	log.Errorf("SetUserNodesLeaderboardToCache: %v", err)
+
+	// 写入缓存失败时直接从数据库结果分页
+	sort.SliceStable(infos, func(i, j int) bool {
+		if asc {
+			return infos[i].NodeCount < infos[j].NodeCount
+		}
+		return infos[i].NodeCount > infos[j].NodeCount
+	})
+	total = int64(len(infos))
+	if offset >= len(infos) {
+		return total, nil, nil
+	}
+	end := offset + limit
+	if end > len(infos) {
+		end = len(infos)
+	}
+	out = infos[offset:end]
+	for i := range out {
+		out[i].Rank = strconv.Itoa(offset + i + 1)
+	}
+	return total, out, nil
+}
+
+// GetUserNodesScores 统计每个用户的激活节点数, 磁盘空间和上行带宽, 用于重建用户节点数排行榜
+func GetUserNodesScores(ctx context.Context) ([]model.NodesInfo, error) {
+	var out []model.NodesInfo
+	query := fmt.Sprintf("SELECT node_type,user_id,COUNT(device_id) AS node_count,ROUND(sum(disk_space) ,2) as disk_space,ROUND(SUM(bandwidth_up),2) as bandwidth_up FROM %s WHERE device_id <> '' AND active_status = 1 GROUP BY user_id",
+		tableNameDeviceInfo)
+	if err := DB.SelectContext(ctx, &out, query); err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
 func SetDeviceProfileFromCache(ctx context.Context, deviceId string, data map[string]string) error {
@@ -768,15 +803,23 @@ func GetDeviceProfileFromCache(ctx context.Context, deviceId string) (map[string
 	return out, nil
 }
 
-func SumAllUsersReward(ctx context.Context, eligibleOnlineMinutes int) ([]*model.UserReward, error) {
-	query := `select user_id,
-      sum(if(node_type = 2, cumulative_profit, 0)) as l1_reward,
-      sum(if(node_type = 1, cumulative_profit, 0)) as l2_reward,
-      sum(if(node_type = 1, today_profit,0)) as reward,
-      sum(if(node_type = 1, online_incentive_profit, 0)) as online_incentive_reward,
-      count(if(online_time >= ?, true, null)) as eligible_device_count,
-      count(device_id) as device_count
-		from device_info  where user_id <> '' GROUP BY user_id`
+// SumAllUsersReward 统计所有用户的收益, excludeFlagged 为 true 时不统计确认作弊的节点在被标记日期内的收益
+func SumAllUsersReward(ctx context.Context, eligibleOnlineMinutes int, excludeFlagged bool) ([]*model.UserReward, error) {
+	from := `device_info di`
+	flaggedIncome, flaggedToday := "0", "0"
+	if excludeFlagged {
+		from += fmt.Sprintf(` LEFT JOIN (%s) f ON f.device_id = di.device_id`, flaggedRewardQuery)
+		flaggedIncome, flaggedToday = "IFNULL(f.flagged_income, 0)", "IFNULL(f.flagged_today, 0)"
+	}
+
+	query := fmt.Sprintf(`select di.user_id,
+      sum(if(di.node_type = 2, greatest(di.cumulative_profit - %[1]s, 0), 0)) as l1_reward,
+      sum(if(di.node_type = 1, greatest(di.cumulative_profit - %[1]s, 0), 0)) as l2_reward,
+      sum(if(di.node_type = 1 and %[2]s = 0, di.today_profit, 0)) as reward,
+      sum(if(di.node_type = 1, di.online_incentive_profit, 0)) as online_incentive_reward,
+      count(if(di.online_time >= ? and %[2]s = 0, true, null)) as eligible_device_count,
+      count(di.device_id) as device_count
+		from %[3]s where di.user_id <> '' GROUP BY di.user_id`, flaggedIncome, flaggedToday, from)
 
 	var out []*model.UserReward
 	err := DB.SelectContext(ctx, &out, query, eligibleOnlineMinutes)
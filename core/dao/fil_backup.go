@@ -0,0 +1,376 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+const (
+	tableNameFilBackupJob      = "fil_backup_job"
+	tableNameFilRestoreRequest = "fil_restore_request"
+)
+
+// Filecoin 备份任务的状态
+const (
+	FilBackupStatePending  = "pending"
+	FilBackupStatePacking  = "packing"
+	FilBackupStateDealing  = "dealing"
+	FilBackupStateActive   = "active"
+	FilBackupStateExpiring = "expiring"
+	FilBackupStateRenewing = "renewing"
+	FilBackupStateExpired  = "expired"
+	FilBackupStateFailed   = "failed"
+)
+
+// Filecoin 恢复请求的状态, 导入 IPFS 之后的状态见 sync_ipfs_record
+const (
+	FilRestoreStatePending    = "pending"
+	FilRestoreStateRetrieving = "retrieving"
+	FilRestoreStateImporting  = "importing"
+	FilRestoreStateFailed     = "failed"
+)
+
+// FilBackupDealStates 已经交给备份程序并需要跟踪订单的状态
+var FilBackupDealStates = []string{FilBackupStateDealing, FilBackupStateActive, FilBackupStateExpiring, FilBackupStateRenewing}
+
+// CreateFilBackupJobs 为需要备份的文件创建备份任务, 已存在的不变
+func CreateFilBackupJobs(ctx context.Context, assets []*model.Asset) error {
+	if len(assets) == 0 {
+		return nil
+	}
+
+	ib := squirrel.Insert(tableNameFilBackupJob).Options("IGNORE").Columns("cid,hash,state,created_at")
+	now := time.Now()
+	for _, a := range assets {
+		ib = ib.Values(a.Cid, a.Hash, FilBackupStatePending, now)
+	}
+
+	query, args, err := ib.ToSql()
+	if err != nil {
+		return fmt.Errorf("generate insert backup job sql error:%w", err)
+	}
+	_, err = DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ClaimFilBackupJobs 领取待备份和待续期的任务交给备份程序, 状态改为 packing
+func ClaimFilBackupJobs(ctx context.Context, limit int) ([]*model.FilBackupJob, error) {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	out := make([]*model.FilBackupJob, 0)
+	err = tx.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE state IN (?, ?) ORDER BY id LIMIT %d FOR UPDATE`, tableNameFilBackupJob, limit),
+		FilBackupStatePending, FilBackupStateRenewing)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return out, nil
+	}
+
+	ids := make([]int64, 0, len(out))
+	for _, j := range out {
+		ids = append(ids, j.ID)
+	}
+	query, args, err := squirrel.Update(tableNameFilBackupJob).Set("state", FilBackupStatePacking).Set("dispatched_at", time.Now()).
+		Where(squirrel.Eq{"id": ids}).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate update backup job sql error:%w", err)
+	}
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+
+	return out, tx.Commit()
+}
+
+// ResetStaleFilBackupJobs 备份程序超时未返回结果的任务重新排队
+func ResetStaleFilBackupJobs(ctx context.Context, before time.Time) (int64, error) {
+	res, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = IF(path = '', ?, ?) WHERE state = ? AND dispatched_at < ?`, tableNameFilBackupJob),
+		FilBackupStatePending, FilBackupStateRenewing, FilBackupStatePacking, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// UpdateFilBackupJobPath 备份程序打包完成, 等待订单上链
+func UpdateFilBackupJobPath(ctx context.Context, cid, path string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET path = ?, state = ?, reason = '' WHERE cid = ?`, tableNameFilBackupJob), path, FilBackupStateDealing, cid)
+	return err
+}
+
+// RequeueFilBackupJob 任务重新排队
+func RequeueFilBackupJob(ctx context.Context, cid string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = IF(path = '', ?, ?) WHERE cid = ?`, tableNameFilBackupJob),
+		FilBackupStatePending, FilBackupStateRenewing, cid)
+	return err
+}
+
+// FailFilBackupJob 备份失败, 记录原因
+func FailFilBackupJob(ctx context.Context, cid, reason string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = ?, reason = ?, retry_count = retry_count + 1 WHERE cid = ?`, tableNameFilBackupJob),
+		FilBackupStateFailed, reason, cid)
+	return err
+}
+
+// RetryFailedFilBackupJobs 失败次数未超过 maxRetry 的任务重新排队
+func RetryFailedFilBackupJobs(ctx context.Context, maxRetry int) (int64, error) {
+	res, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = IF(path = '', ?, ?) WHERE state = ? AND retry_count < ?`, tableNameFilBackupJob),
+		FilBackupStatePending, FilBackupStateRenewing, FilBackupStateFailed, maxRetry)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RefreshFilBackupJobDeals 根据 fil_storage 更新任务的有效订单数和最晚结束时间, paths 为空时更新所有需要跟踪订单的任务
+func RefreshFilBackupJobDeals(ctx context.Context, paths []string, now time.Time) error {
+	sb := squirrel.Update(tableNameFilBackupJob+" j").
+		Set("deal_count", squirrel.Expr(fmt.Sprintf(`(SELECT COUNT(1) FROM %s f WHERE f.path = j.path AND f.end_time > ?)`, tableNameFilStorage), now)).
+		Set("deal_end_time", squirrel.Expr(fmt.Sprintf(`IFNULL((SELECT MAX(f.end_time) FROM %s f WHERE f.path = j.path), j.deal_end_time)`, tableNameFilStorage))).
+		Where("j.path <> ''").
+		Where(squirrel.Eq{"j.state": FilBackupDealStates})
+	if len(paths) > 0 {
+		sb = sb.Where(squirrel.Eq{"j.path": paths})
+	}
+
+	query, args, err := sb.ToSql()
+	if err != nil {
+		return fmt.Errorf("generate refresh backup job sql error:%w", err)
+	}
+	_, err = DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ListFilBackupJobsByStates 获取指定状态的任务, paths 不为空时只获取对应路径的任务
+func ListFilBackupJobsByStates(ctx context.Context, states, paths []string) ([]*model.FilBackupJob, error) {
+	sb := squirrel.Select("*").From(tableNameFilBackupJob).Where(squirrel.Eq{"state": states})
+	if len(paths) > 0 {
+		sb = sb.Where(squirrel.Eq{"path": paths})
+	}
+
+	query, args, err := sb.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate list backup job sql error:%w", err)
+	}
+
+	out := make([]*model.FilBackupJob, 0)
+	err = DB.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+// SetFilBackupJobState 更新任务状态, alerted 时记录提醒时间
+func SetFilBackupJobState(ctx context.Context, id int64, state string, alerted bool) error {
+	sb := squirrel.Update(tableNameFilBackupJob).Set("state", state).Where("id = ?", id)
+	if alerted {
+		sb = sb.Set("alerted_at", time.Now())
+	}
+
+	query, args, err := sb.ToSql()
+	if err != nil {
+		return fmt.Errorf("generate update backup job sql error:%w", err)
+	}
+	_, err = DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// RenewFilBackupJobs 管理员手动续期, 已打包过的任务重新交给备份程序
+func RenewFilBackupJobs(ctx context.Context, cids []string) (int64, error) {
+	query, args, err := squirrel.Update(tableNameFilBackupJob).Set("state", FilBackupStateRenewing).Set("retry_count", 0).
+		Where(squirrel.Eq{"cid": cids}).Where("path <> ''").
+		Where(squirrel.NotEq{"state": []string{FilBackupStatePacking, FilBackupStateRenewing}}).ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("generate renew backup job sql error:%w", err)
+	}
+
+	res, err := DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ListFilBackupJobs 获取备份任务列表, state 为空时获取所有
+func ListFilBackupJobs(ctx context.Context, state string, option QueryOption) (int64, []*model.FilBackupJob, error) {
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	where := "1 = 1"
+	var args []interface{}
+	if state != "" {
+		where = "state = ?"
+		args = append(args, state)
+	}
+
+	var total int64
+	err := DB.GetContext(ctx, &total, fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE %s`, tableNameFilBackupJob, where), args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	out := make([]*model.FilBackupJob, 0)
+	err = DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE %s ORDER BY deal_end_time, id LIMIT %d OFFSET %d`, tableNameFilBackupJob, where, limit, offset), args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
+
+// GetFilBackupJobByCID 获取文件的备份任务
+func GetFilBackupJobByCID(ctx context.Context, cid string) (*model.FilBackupJob, error) {
+	var out model.FilBackupJob
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE cid = ?`, tableNameFilBackupJob), cid)
+	return &out, err
+}
+
+// FilBackupStateCount 各状态的任务数
+type FilBackupStateCount struct {
+	State string `db:"state"`
+	Count int64  `db:"count"`
+}
+
+// CountFilBackupJobsByState 统计各状态的任务数
+func CountFilBackupJobsByState(ctx context.Context) ([]*FilBackupStateCount, error) {
+	out := make([]*FilBackupStateCount, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT state, COUNT(1) AS count FROM %s GROUP BY state`, tableNameFilBackupJob))
+	return out, err
+}
+
+// GetFilDealsByPath 获取路径下仍然有效的订单
+func GetFilDealsByPath(ctx context.Context, path string, now time.Time) ([]*model.FilStorage, error) {
+	out := make([]*model.FilStorage, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE path = ? AND end_time > ? ORDER BY end_time DESC`, tableNameFilStorage), path, now)
+	return out, err
+}
+
+// IsAssetBackupRequester 用户是否通过生命周期规则归档过该文件
+func IsAssetBackupRequester(ctx context.Context, userID, cid string) (bool, error) {
+	var count int64
+	err := DB.GetContext(ctx, &count, fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE user_id = ? AND cid = ?`, tableNameAssetBackupRequest), userID, cid)
+	return count > 0, err
+}
+
+// AddFilRestoreRequest 添加恢复请求, 同一个文件在同一区域只能有一条未完成的请求
+func AddFilRestoreRequest(ctx context.Context, r *model.FilRestoreRequest) (bool, error) {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var count int64
+	err = tx.GetContext(ctx, &count, fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE user_id = ? AND cid = ? AND area_id = ? AND state IN (?, ?) FOR UPDATE`, tableNameFilRestoreRequest),
+		r.UserID, r.Cid, r.AreaID, FilRestoreStatePending, FilRestoreStateRetrieving)
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return false, nil
+	}
+
+	res, err := tx.NamedExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (user_id, cid, hash, area_id, group_id, name, state, created_at)
+		VALUES (:user_id, :cid, :hash, :area_id, :group_id, :name, :state, :created_at)`, tableNameFilRestoreRequest), r)
+	if err != nil {
+		return false, err
+	}
+	if r.ID, err = res.LastInsertId(); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// ClaimFilRestoreRequests 领取待恢复的请求交给备份程序, 状态改为 retrieving
+func ClaimFilRestoreRequests(ctx context.Context, limit int) ([]*model.FilRestoreRequest, error) {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	out := make([]*model.FilRestoreRequest, 0)
+	err = tx.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE state = ? ORDER BY id LIMIT %d FOR UPDATE`, tableNameFilRestoreRequest, limit), FilRestoreStatePending)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return out, nil
+	}
+
+	ids := make([]int64, 0, len(out))
+	for _, r := range out {
+		ids = append(ids, r.ID)
+	}
+	query, args, err := squirrel.Update(tableNameFilRestoreRequest).Set("state", FilRestoreStateRetrieving).Where(squirrel.Eq{"id": ids}).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate update restore request sql error:%w", err)
+	}
+	if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+
+	return out, tx.Commit()
+}
+
+// GetFilRestoreRequest 获取恢复请求
+func GetFilRestoreRequest(ctx context.Context, id int64) (*model.FilRestoreRequest, error) {
+	var out model.FilRestoreRequest
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE id = ?`, tableNameFilRestoreRequest), id)
+	return &out, err
+}
+
+// UpdateFilRestoreRequestState 更新恢复请求的状态, 失败时记录原因
+func UpdateFilRestoreRequestState(ctx context.Context, id int64, state, reason string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = ?, reason = ? WHERE id = ?`, tableNameFilRestoreRequest), state, reason, id)
+	return err
+}
+
+// FilRestoreRequestInfo 恢复请求及导入 IPFS 的状态
+type FilRestoreRequestInfo struct {
+	model.FilRestoreRequest
+	ImportState  string `db:"import_state" json:"import_state"`
+	ImportReason string `db:"import_reason" json:"import_reason"`
+}
+
+// ListUserFilRestoreRequests 获取用户的恢复请求
+func ListUserFilRestoreRequests(ctx context.Context, userID string, option QueryOption) (int64, []*FilRestoreRequestInfo, error) {
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	var total int64
+	err := DB.GetContext(ctx, &total, fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE user_id = ?`, tableNameFilRestoreRequest), userID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	out := make([]*FilRestoreRequestInfo, 0)
+	err = DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT r.*, IFNULL(i.state, '') AS import_state, IFNULL(i.reason, '') AS import_reason FROM %s r
+		LEFT JOIN %s i ON r.state = ? AND i.username = r.user_id AND i.cid = r.cid
+		WHERE r.user_id = ? ORDER BY r.id DESC LIMIT %d OFFSET %d`, tableNameFilRestoreRequest, tableIPFSRecords, limit, offset), FilRestoreStateImporting, userID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
@@ -0,0 +1,346 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/go-redis/redis/v9"
+)
+
+type (
+	// LeaderboardMetric 排行榜的排序指标
+	LeaderboardMetric string
+	// LeaderboardPeriod 排行榜的统计周期
+	LeaderboardPeriod string
+)
+
+const (
+	LeaderboardMetricIncome    LeaderboardMetric = "income"
+	LeaderboardMetricUptime    LeaderboardMetric = "uptime"
+	LeaderboardMetricBandwidth LeaderboardMetric = "bandwidth"
+	LeaderboardMetricCacheHits LeaderboardMetric = "cache_hits"
+	LeaderboardMetricRetrieval LeaderboardMetric = "retrievals"
+
+	LeaderboardPeriodDay   LeaderboardPeriod = "day"
+	LeaderboardPeriodWeek  LeaderboardPeriod = "week"
+	LeaderboardPeriodMonth LeaderboardPeriod = "month"
+	LeaderboardPeriodAll   LeaderboardPeriod = "all"
+
+	// LeaderboardScopeGlobal 全局排行, 国家和区域的排行分别为 country:<国家> 和 area:<区域ID>
+	LeaderboardScopeGlobal = "global"
+)
+
+var (
+	LeaderboardMetrics = []LeaderboardMetric{LeaderboardMetricIncome, LeaderboardMetricUptime, LeaderboardMetricBandwidth, LeaderboardMetricCacheHits, LeaderboardMetricRetrieval}
+	LeaderboardPeriods = []LeaderboardPeriod{LeaderboardPeriodDay, LeaderboardPeriodWeek, LeaderboardPeriodMonth, LeaderboardPeriodAll}
+
+	// leaderboardDailyColumns 按天统计时使用 device_info_daily 表的字段
+	leaderboardDailyColumns = map[LeaderboardMetric]string{
+		LeaderboardMetricIncome:    "income",
+		LeaderboardMetricUptime:    "online_time",
+		LeaderboardMetricBandwidth: "upstream_traffic",
+		LeaderboardMetricCacheHits: "block_count",
+		LeaderboardMetricRetrieval: "retrieval_count",
+	}
+	// leaderboardTotalColumns 总榜使用 device_info 表的累计字段
+	leaderboardTotalColumns = map[LeaderboardMetric]string{
+		LeaderboardMetricIncome:    "cumulative_profit",
+		LeaderboardMetricUptime:    "online_time",
+		LeaderboardMetricBandwidth: "upload_traffic",
+		LeaderboardMetricCacheHits: "cache_count",
+		LeaderboardMetricRetrieval: "retrieval_count",
+	}
+)
+
+// leaderboardTTL 排行榜缓存的过期时间, 每次拉取节点数据后都会刷新, 不再出现的国家或区域排行会自然过期
+const leaderboardTTL = 2 * time.Hour
+
+// LeaderboardScore 节点在某个指标下的得分
+type LeaderboardScore struct {
+	DeviceID  string  `db:"device_id"`
+	IpCountry string  `db:"ip_country"`
+	AreaID    string  `db:"area_id"`
+	Score     float64 `db:"score"`
+}
+
+// LeaderboardEntry 排行榜中的一条记录, Rank 从 1 开始
+type LeaderboardEntry struct {
+	Rank     int64   `json:"rank"`
+	DeviceID string  `json:"device_id"`
+	Score    float64 `json:"score"`
+}
+
+// ValidLeaderboard 校验指标和周期是否支持
+func ValidLeaderboard(metric LeaderboardMetric, period LeaderboardPeriod) bool {
+	if _, ok := leaderboardDailyColumns[metric]; !ok {
+		return false
+	}
+	for _, p := range LeaderboardPeriods {
+		if p == period {
+			return true
+		}
+	}
+	return false
+}
+
+// LeaderboardCountryScope 国家排行的范围
+func LeaderboardCountryScope(country string) string {
+	return "country:" + country
+}
+
+// LeaderboardAreaScope 区域排行的范围
+func LeaderboardAreaScope(areaId string) string {
+	return "area:" + areaId
+}
+
+// userNodesLeaderboardKey 用户按激活节点数排行, 成员为 user_id, 每个用户的统计保存在同名的 hash 中
+const userNodesLeaderboardKey = "TITAN::LEADERBOARD::USER_NODES"
+
+func leaderboardKey(metric LeaderboardMetric, period LeaderboardPeriod, scope string) string {
+	return fmt.Sprintf("TITAN::LEADERBOARD::%s::%s::%s", metric, period, scope)
+}
+
+// GetLeaderboardScores 统计所有激活节点的得分, since 为空时统计累计值, 否则统计 since 之后每日数据的总和
+func GetLeaderboardScores(ctx context.Context, metric LeaderboardMetric, since *time.Time) ([]*LeaderboardScore, error) {
+	var (
+		query string
+		args  []interface{}
+	)
+
+	if since == nil {
+		query = fmt.Sprintf(`SELECT device_id, ip_country, area_id, %s AS score FROM %s WHERE active_status = 1 AND %s > 0`,
+			leaderboardTotalColumns[metric], tableNameDeviceInfo, leaderboardTotalColumns[metric])
+	} else {
+		query = fmt.Sprintf(`SELECT i.device_id, i.ip_country, i.area_id, SUM(d.%s) AS score FROM %s d JOIN %s i ON d.device_id = i.device_id
+			WHERE i.active_status = 1 AND d.time >= ? GROUP BY i.device_id HAVING score > 0`, leaderboardDailyColumns[metric], tableNameDeviceInfoDaily, tableNameDeviceInfo)
+		args = append(args, *since)
+	}
+
+	out := make([]*LeaderboardScore, 0)
+	if err := DB.SelectContext(ctx, &out, query, args...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SetLeaderboardToCache 重建排行榜, 先写入临时 key 再 RENAME, 保证读取时不会看到写了一半的数据
+func SetLeaderboardToCache(ctx context.Context, metric LeaderboardMetric, period LeaderboardPeriod, scope string, scores []redis.Z) error {
+	key := leaderboardKey(metric, period, scope)
+	if len(scores) == 0 {
+		return RedisCache.Del(ctx, key).Err()
+	}
+
+	tmpKey := key + "::TMP"
+	if err := RedisCache.Del(ctx, tmpKey).Err(); err != nil {
+		return err
+	}
+
+	const batchSize = 1000
+	for start := 0; start < len(scores); start += batchSize {
+		end := start + batchSize
+		if end > len(scores) {
+			end = len(scores)
+		}
+		if err := RedisCache.ZAdd(ctx, tmpKey, scores[start:end]...).Err(); err != nil {
+			return err
+		}
+	}
+
+	_, err := RedisCache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Rename(ctx, tmpKey, key)
+		pipe.Expire(ctx, key, leaderboardTTL)
+		return nil
+	})
+	return err
+}
+
+// GetLeaderboardFromCache 按排名分页获取排行榜, 同时返回排行榜的总数
+func GetLeaderboardFromCache(ctx context.Context, metric LeaderboardMetric, period LeaderboardPeriod, scope string, offset, limit int64) ([]*LeaderboardEntry, int64, error) {
+	key := leaderboardKey(metric, period, scope)
+
+	total, err := RedisCache.ZCard(ctx, key).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries, err := getLeaderboardRange(ctx, key, offset, offset+limit-1)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return entries, total, nil
+}
+
+// GetLeaderboardNeighbours 获取节点的排名以及前后 n 名的节点, 节点不在排行榜中时返回 nil
+func GetLeaderboardNeighbours(ctx context.Context, metric LeaderboardMetric, period LeaderboardPeriod, scope string, deviceId string, n int64) (*LeaderboardEntry, []*LeaderboardEntry, error) {
+	key := leaderboardKey(metric, period, scope)
+
+	rank, err := RedisCache.ZRevRank(ctx, key, deviceId).Result()
+	if err == redis.Nil {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	start := rank - n
+	if start < 0 {
+		start = 0
+	}
+
+	entries, err := getLeaderboardRange(ctx, key, start, rank+n)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.DeviceID == deviceId {
+			return entry, entries, nil
+		}
+	}
+
+	// 两次查询之间排行榜刚好被重建
+	return nil, entries, nil
+}
+
+// GetLeaderboardRanks 批量获取节点的排名, 不在排行榜中的节点不会返回
+func GetLeaderboardRanks(ctx context.Context, metric LeaderboardMetric, period LeaderboardPeriod, scope string, deviceIds []string) ([]*LeaderboardEntry, error) {
+	key := leaderboardKey(metric, period, scope)
+
+	pipe := RedisCache.Pipeline()
+	ranks := make([]*redis.IntCmd, len(deviceIds))
+	scores := make([]*redis.FloatCmd, len(deviceIds))
+	for i, deviceId := range deviceIds {
+		ranks[i] = pipe.ZRevRank(ctx, key, deviceId)
+		scores[i] = pipe.ZScore(ctx, key, deviceId)
+	}
+
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	out := make([]*LeaderboardEntry, 0)
+	for i, deviceId := range deviceIds {
+		rank, err := ranks[i].Result()
+		if err != nil {
+			continue
+		}
+		out = append(out, &LeaderboardEntry{
+			Rank:     rank + 1,
+			DeviceID: deviceId,
+			Score:    scores[i].Val(),
+		})
+	}
+
+	return out, nil
+}
+
+func getLeaderboardRange(ctx context.Context, key string, start, stop int64) ([]*LeaderboardEntry, error) {
+	result, err := RedisCache.ZRevRangeWithScores(ctx, key, start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*LeaderboardEntry, 0, len(result))
+	for i, z := range result {
+		member, _ := z.Member.(string)
+		out = append(out, &LeaderboardEntry{
+			Rank:     start + int64(i) + 1,
+			DeviceID: member,
+			Score:    z.Score,
+		})
+	}
+	return out, nil
+}
+
+// SetUserNodesLeaderboardToCache 重建用户节点数排行榜, 和节点排行榜一样先写临时 key 再 RENAME
+func SetUserNodesLeaderboardToCache(ctx context.Context, infos []model.NodesInfo) error {
+	infoKey := userNodesLeaderboardKey + "::INFO"
+	if len(infos) == 0 {
+		return RedisCache.Del(ctx, userNodesLeaderboardKey, infoKey).Err()
+	}
+
+	tmpKey, tmpInfoKey := userNodesLeaderboardKey+"::TMP", infoKey+"::TMP"
+	if err := RedisCache.Del(ctx, tmpKey, tmpInfoKey).Err(); err != nil {
+		return err
+	}
+
+	const batchSize = 1000
+	for start := 0; start < len(infos); start += batchSize {
+		end := start + batchSize
+		if end > len(infos) {
+			end = len(infos)
+		}
+
+		zs := make([]redis.Z, 0, end-start)
+		values := make(map[string]interface{}, end-start)
+		for _, info := range infos[start:end] {
+			data, err := json.Marshal(info)
+			if err != nil {
+				return err
+			}
+			zs = append(zs, redis.Z{Score: float64(info.NodeCount), Member: info.UserId})
+			values[info.UserId] = data
+		}
+
+		_, err := RedisCache.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZAdd(ctx, tmpKey, zs...)
+			pipe.HSet(ctx, tmpInfoKey, values)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := RedisCache.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Rename(ctx, tmpKey, userNodesLeaderboardKey)
+		pipe.Rename(ctx, tmpInfoKey, infoKey)
+		pipe.Expire(ctx, userNodesLeaderboardKey, leaderboardTTL)
+		pipe.Expire(ctx, infoKey, leaderboardTTL)
+		return nil
+	})
+	return err
+}
+
+// GetUserNodesLeaderboardFromCache 按排名分页获取用户节点数排行榜, 同时返回排行榜的总数, asc 为 true 时按节点数升序
+func GetUserNodesLeaderboardFromCache(ctx context.Context, offset, limit int64, asc bool) (int64, []model.NodesInfo, error) {
+	total, err := RedisCache.ZCard(ctx, userNodesLeaderboardKey).Result()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	zrange := RedisCache.ZRevRange
+	if asc {
+		zrange = RedisCache.ZRange
+	}
+	userIds, err := zrange(ctx, userNodesLeaderboardKey, offset, offset+limit-1).Result()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(userIds) == 0 {
+		return total, nil, nil
+	}
+
+	values, err := RedisCache.HMGet(ctx, userNodesLeaderboardKey+"::INFO", userIds...).Result()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	out := make([]model.NodesInfo, 0, len(userIds))
+	for i, userId := range userIds {
+		info := model.NodesInfo{UserId: userId}
+		if data, ok := values[i].(string); ok {
+			if err = json.Unmarshal([]byte(data), &info); err != nil {
+				log.Errorf("unmarshal user nodes info %s: %v", userId, err)
+			}
+		}
+		info.Rank = strconv.FormatInt(offset+int64(i)+1, 10)
+		out = append(out, info)
+	}
+	return total, out, nil
+}
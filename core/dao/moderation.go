@@ -0,0 +1,288 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	tableModerationCase   = "moderation_case"
+	tableModerationReport = "moderation_report"
+	tableModerationNotice = "moderation_notice"
+	tableContentBlock     = "content_block"
+	tableShareRestriction = "share_restriction"
+)
+
+// 审核记录的状态
+const (
+	ModerationStatePending    = "pending"
+	ModerationStateActioned   = "actioned"
+	ModerationStateDismissed  = "dismissed"
+	ModerationStateAppealed   = "appealed"
+	ModerationStateReinstated = "reinstated"
+)
+
+// 审核的处理方式
+const (
+	ModerationActionBlockHash      = "block_hash"
+	ModerationActionDisableSharing = "disable_sharing"
+	ModerationActionDelete         = "delete"
+	ModerationActionDismiss        = "dismiss"
+	ModerationActionReinstate      = "reinstate"
+)
+
+// AddModerationReport 保存举报, 同一个文件的举报归到同一条审核记录, 已驳回或已恢复的记录重新进入待审核, 返回审核记录 id
+func AddModerationReport(ctx context.Context, mc *model.ModerationCase, report *model.ModerationReport) (int64, error) {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (hash, cid, user_id, state, report_count, last_reason) VALUES (?, ?, ?, ?, 1, ?)
+		ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), report_count = report_count + 1, last_reason = VALUES(last_reason),
+		user_id = IF(user_id = '', VALUES(user_id), user_id), state = IF(state IN (?, ?), ?, state)`, tableModerationCase),
+		mc.Hash, mc.Cid, mc.UserID, ModerationStatePending, mc.LastReason,
+		ModerationStateDismissed, ModerationStateReinstated, ModerationStatePending)
+	if err != nil {
+		return 0, err
+	}
+	caseID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (case_id, target, reporter, category, description) VALUES (?, ?, ?, ?, ?)`, tableModerationReport),
+		caseID, report.Target, report.Reporter, report.Category, report.Description)
+	if err != nil {
+		return 0, err
+	}
+
+	return caseID, tx.Commit()
+}
+
+// GetModerationCase 获取审核记录
+func GetModerationCase(ctx context.Context, id int64) (*model.ModerationCase, error) {
+	var out model.ModerationCase
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE id = ?`, tableModerationCase), id)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// UpdateModerationCase 更新审核记录的状态和处理信息
+func UpdateModerationCase(ctx context.Context, mc *model.ModerationCase) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = ?, action = ?, admin_note = ?, appeal_reason = ?, handled_by = ? WHERE id = ?`, tableModerationCase),
+		mc.State, mc.Action, mc.AdminNote, mc.AppealReason, mc.HandledBy, mc.ID)
+	return err
+}
+
+// ListModerationCases 获取审核记录, 按举报次数排序
+func ListModerationCases(ctx context.Context, state string, option QueryOption) (int64, []*model.ModerationCase, error) {
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	sb := squirrel.Select().From(tableModerationCase)
+	if state != "" {
+		sb = sb.Where("state = ?", state)
+	}
+
+	query, args, err := sb.Column("COUNT(1)").ToSql()
+	if err != nil {
+		return 0, nil, fmt.Errorf("generate count moderation cases sql error:%w", err)
+	}
+	var total int64
+	if err = DB.GetContext(ctx, &total, query, args...); err != nil {
+		return 0, nil, err
+	}
+
+	query, args, err = sb.Column("*").OrderBy("report_count DESC", "updated_at DESC").Limit(uint64(limit)).Offset(uint64(offset)).ToSql()
+	if err != nil {
+		return 0, nil, fmt.Errorf("generate list moderation cases sql error:%w", err)
+	}
+	out := make([]*model.ModerationCase, 0)
+	if err = DB.SelectContext(ctx, &out, query, args...); err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
+
+// ListModerationReports 获取审核记录的举报, 最新的在前
+func ListModerationReports(ctx context.Context, caseID int64, option QueryOption) (int64, []*model.ModerationReport, error) {
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	var total int64
+	err := DB.GetContext(ctx, &total, fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE case_id = ?`, tableModerationReport), caseID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	out := make([]*model.ModerationReport, 0)
+	err = DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE case_id = ? ORDER BY id DESC LIMIT ? OFFSET ?`, tableModerationReport),
+		caseID, limit, offset)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
+
+// AddContentBlock 屏蔽文件
+func AddContentBlock(ctx context.Context, hash string, caseID int64, reason string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (hash, case_id, reason) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE case_id = VALUES(case_id), reason = VALUES(reason)`, tableContentBlock), hash, caseID, reason)
+	return err
+}
+
+// DeleteContentBlock 取消屏蔽文件
+func DeleteContentBlock(ctx context.Context, hash string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE hash = ?`, tableContentBlock), hash)
+	return err
+}
+
+// AddShareRestriction 禁止用户分享
+func AddShareRestriction(ctx context.Context, userID string, caseID int64, reason string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (user_id, case_id, reason) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE case_id = VALUES(case_id), reason = VALUES(reason)`, tableShareRestriction), userID, caseID, reason)
+	return err
+}
+
+// DeleteShareRestriction 取消由某条审核记录产生的分享限制
+func DeleteShareRestriction(ctx context.Context, userID string, caseID int64) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE user_id = ? AND case_id = ?`, tableShareRestriction), userID, caseID)
+	return err
+}
+
+// ContentRestriction 文件是否被屏蔽, 分享者是否被禁止分享
+type ContentRestriction struct {
+	Blocked    bool `db:"blocked"`
+	Restricted bool `db:"restricted"`
+}
+
+// GetContentRestriction 查询文件和分享者的限制, userID 为空时只查询文件
+func GetContentRestriction(ctx context.Context, hash, userID string) (*ContentRestriction, error) {
+	var out ContentRestriction
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM %s WHERE hash = ?) AS blocked,
+		EXISTS(SELECT 1 FROM %s WHERE user_id = ? AND user_id <> '') AS restricted`, tableContentBlock, tableShareRestriction), hash, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetBlockedHashes 获取 hashes 中被屏蔽的文件
+func GetBlockedHashes(ctx context.Context, hashes []string) (map[string]bool, error) {
+	out := make(map[string]bool)
+	if len(hashes) == 0 {
+		return out, nil
+	}
+
+	query, args, err := squirrel.Select("hash").From(tableContentBlock).Where(squirrel.Eq{"hash": hashes}).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate get content block sql error:%w", err)
+	}
+	var blocked []string
+	if err = DB.SelectContext(ctx, &blocked, query, args...); err != nil {
+		return nil, err
+	}
+	for _, h := range blocked {
+		out[h] = true
+	}
+	return out, nil
+}
+
+// AddModerationNotices 给上传者发送审核通知
+func AddModerationNotices(ctx context.Context, notices []*model.ModerationNotice) error {
+	if len(notices) == 0 {
+		return nil
+	}
+
+	ib := squirrel.Insert(tableModerationNotice).Columns("user_id,case_id,hash,cid,action,reason")
+	for _, n := range notices {
+		ib = ib.Values(n.UserID, n.CaseID, n.Hash, n.Cid, n.Action, n.Reason)
+	}
+	query, args, err := ib.ToSql()
+	if err != nil {
+		return fmt.Errorf("generate insert moderation notices sql error:%w", err)
+	}
+
+	_, err = DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ListModerationNotices 获取用户的审核通知
+func ListModerationNotices(ctx context.Context, userID string, option QueryOption) (int64, []*model.ModerationNotice, error) {
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	var total int64
+	err := DB.GetContext(ctx, &total, fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE user_id = ?`, tableModerationNotice), userID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	out := make([]*model.ModerationNotice, 0)
+	err = DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE user_id = ? ORDER BY id DESC LIMIT ? OFFSET ?`, tableModerationNotice),
+		userID, limit, offset)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
+
+// ReadModerationNotices 标记用户的审核通知为已读
+func ReadModerationNotices(ctx context.Context, userID string, ids []int64) error {
+	query, args, err := sqlx.In(fmt.Sprintf(`UPDATE %s SET is_read = 1 WHERE user_id = ? AND id IN (?)`, tableModerationNotice), userID, ids)
+	if err != nil {
+		return err
+	}
+	_, err = DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// HasModerationNotice 用户是否收到过某条审核记录的通知, 收到过的用户可以申诉
+func HasModerationNotice(ctx context.Context, userID string, caseID int64) (bool, error) {
+	var exists bool
+	err := DB.GetContext(ctx, &exists, fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM %s WHERE user_id = ? AND case_id = ?)`, tableModerationNotice), userID, caseID)
+	return exists, err
+}
+
+// GetAssetOwners 获取保存了文件的所有用户
+func GetAssetOwners(ctx context.Context, hash string) ([]string, error) {
+	out := make([]string, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT DISTINCT(user_id) FROM %s WHERE hash = ?`, tableUserAsset), hash)
+	return out, err
+}
+
+// GetAssetAllAreaIDs 获取文件所在的所有区域, 包括未同步完成的区域
+func GetAssetAllAreaIDs(ctx context.Context, hash string) ([]string, error) {
+	out := make([]string, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT DISTINCT(area_id) FROM %s WHERE hash = ?`, tableUserAssetArea), hash)
+	return out, err
+}
@@ -0,0 +1,217 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+const (
+	tableNameReplicationPolicy      = "replication_policy"
+	tableNameAssetReplicationStatus = "asset_replication_status"
+)
+
+// 副本策略的所属
+const (
+	ReplicationOwnerUser   = "user"
+	ReplicationOwnerTenant = "tenant"
+)
+
+// 副本策略的方式
+const (
+	// ReplicationModeFixed 在指定的国家或区域保留副本
+	ReplicationModeFixed = "fixed"
+	// ReplicationModeNearest 保留离用户的下载请求最近的区域
+	ReplicationModeNearest = "nearest"
+)
+
+// SaveReplicationPolicy 保存副本策略, 每个用户或租户只有一条
+func SaveReplicationPolicy(ctx context.Context, p *model.ReplicationPolicy) error {
+	_, err := DB.NamedExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (owner_type, owner_id, mode, replicas, countries, area_ids, prune, enabled, created_at, updated_at)
+		VALUES (:owner_type, :owner_id, :mode, :replicas, :countries, :area_ids, :prune, :enabled, :created_at, :updated_at)
+		ON DUPLICATE KEY UPDATE mode = VALUES(mode), replicas = VALUES(replicas), countries = VALUES(countries), area_ids = VALUES(area_ids),
+		prune = VALUES(prune), enabled = VALUES(enabled), updated_at = VALUES(updated_at)`, tableNameReplicationPolicy), p)
+	return err
+}
+
+// GetReplicationPolicy 获取用户或租户自己的副本策略
+func GetReplicationPolicy(ctx context.Context, ownerType, ownerID string) (*model.ReplicationPolicy, error) {
+	var out model.ReplicationPolicy
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE owner_type = ? AND owner_id = ?`, tableNameReplicationPolicy), ownerType, ownerID)
+	return &out, err
+}
+
+// DeleteReplicationPolicy 删除副本策略
+func DeleteReplicationPolicy(ctx context.Context, ownerType, ownerID string) (int64, error) {
+	res, err := DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE owner_type = ? AND owner_id = ?`, tableNameReplicationPolicy), ownerType, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// GetEffectiveReplicationPolicy 获取对用户生效的副本策略, 用户自己的策略优先, 其次是所属租户的策略
+func GetEffectiveReplicationPolicy(ctx context.Context, userID string) (*model.ReplicationPolicy, error) {
+	var out model.ReplicationPolicy
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT p.* FROM %[1]s p WHERE p.owner_type = ? AND p.owner_id = ? AND p.enabled = 1
+		UNION ALL
+		SELECT p.* FROM %[1]s p JOIN %[2]s u ON p.owner_id = u.tenant_id WHERE p.owner_type = ? AND u.username = ? AND u.tenant_id <> '' AND p.enabled = 1
+		ORDER BY owner_type = 'user' DESC LIMIT 1`, tableNameReplicationPolicy, tableNameUser), ReplicationOwnerUser, userID, ReplicationOwnerTenant, userID)
+	return &out, err
+}
+
+// GetReplicationUserIDs 获取有生效的副本策略并且有文件的用户
+func GetReplicationUserIDs(ctx context.Context) ([]string, error) {
+	out := make([]string, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT p.owner_id FROM %[1]s p WHERE p.owner_type = ? AND p.enabled = 1
+			AND EXISTS (SELECT 1 FROM %[3]s a WHERE a.user_id = p.owner_id)
+		UNION
+		SELECT u.username FROM %[1]s p JOIN %[2]s u ON p.owner_id = u.tenant_id WHERE p.owner_type = ? AND p.enabled = 1 AND u.tenant_id <> ''
+			AND EXISTS (SELECT 1 FROM %[3]s a WHERE a.user_id = u.username)`,
+		tableNameReplicationPolicy, tableNameUser, tableUserAsset), ReplicationOwnerUser, ReplicationOwnerTenant)
+	return out, err
+}
+
+// GetUserActiveAssets 获取用户的文件, 不包括回收站和历史版本
+func GetUserActiveAssets(ctx context.Context, userID string) ([]*model.UserAsset, error) {
+	out := make([]*model.UserAsset, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE user_id = ? AND group_id >= 0`, tableUserAsset), userID)
+	return out, err
+}
+
+// GetUserAssetAreaMap 获取用户所有文件的区域记录, 包括未同步完成的
+func GetUserAssetAreaMap(ctx context.Context, userID string) (map[string][]model.UserAssetArea, error) {
+	var list []model.UserAssetArea
+	err := DB.SelectContext(ctx, &list, fmt.Sprintf(`SELECT hash, user_id, area_id, is_sync FROM %s WHERE user_id = ?`, tableUserAssetArea), userID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string][]model.UserAssetArea)
+	for _, v := range list {
+		out[v.Hash] = append(out[v.Hash], v)
+	}
+	return out, nil
+}
+
+// AddUserAssetSyncAreas 添加需要同步的区域, 同步完成后由同步任务更新状态
+func AddUserAssetSyncAreas(ctx context.Context, userID, hash string, areaIDs []string) error {
+	if len(areaIDs) == 0 {
+		return nil
+	}
+
+	ib := squirrel.Insert(tableUserAssetArea).Options("IGNORE").Columns("hash,user_id,area_id,is_sync")
+	for _, v := range areaIDs {
+		ib = ib.Values(hash, userID, v, false)
+	}
+
+	query, args, err := ib.ToSql()
+	if err != nil {
+		return fmt.Errorf("generate insert asset's area sql error:%w", err)
+	}
+	_, err = DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetUserDownloadIPs 获取用户文件的下载请求 ip, 按请求次数排序
+func GetUserDownloadIPs(ctx context.Context, userID string, since time.Time, limit int) ([]string, error) {
+	out := make([]string, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT ip FROM asset_transfer_log WHERE user_id = ? AND transfer_type = ? AND created_at >= ? AND ip <> ''
+		GROUP BY ip ORDER BY COUNT(1) DESC LIMIT %d`, limit), userID, AssetTransferTypeDownload, since)
+	return out, err
+}
+
+// SaveAssetReplicationStatuses 保存文件的副本合规状态, 并删除本次没有检查到的文件的旧记录
+func SaveAssetReplicationStatuses(ctx context.Context, userID string, list []*model.AssetReplicationStatus, checkedAt time.Time) error {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := 0; i < len(list); i += 500 {
+		end := i + 500
+		if end > len(list) {
+			end = len(list)
+		}
+
+		ib := squirrel.Insert(tableNameAssetReplicationStatus).
+			Columns("user_id,hash,cid,policy_id,desired_areas,actual_areas,missing_areas,extra_areas,compliant,checked_at")
+		for _, s := range list[i:end] {
+			ib = ib.Values(s.UserID, s.Hash, s.Cid, s.PolicyID, s.DesiredAreas, s.ActualAreas, s.MissingAreas, s.ExtraAreas, s.Compliant, s.CheckedAt)
+		}
+		query, args, err := ib.Suffix(`ON DUPLICATE KEY UPDATE cid = VALUES(cid), policy_id = VALUES(policy_id), desired_areas = VALUES(desired_areas),
+			actual_areas = VALUES(actual_areas), missing_areas = VALUES(missing_areas), extra_areas = VALUES(extra_areas),
+			compliant = VALUES(compliant), checked_at = VALUES(checked_at)`).ToSql()
+		if err != nil {
+			return fmt.Errorf("generate insert replication status sql error:%w", err)
+		}
+		if _, err = tx.ExecContext(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE user_id = ? AND checked_at < ?`, tableNameAssetReplicationStatus), userID, checkedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteAssetReplicationStatuses 删除用户的副本合规状态, 策略删除时使用
+func DeleteAssetReplicationStatuses(ctx context.Context, userID string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE user_id = ?`, tableNameAssetReplicationStatus), userID)
+	return err
+}
+
+// ReplicationCompliance 用户文件的副本合规统计
+type ReplicationCompliance struct {
+	Total     int64 `db:"total" json:"total"`
+	Compliant int64 `db:"compliant" json:"compliant"`
+}
+
+// GetReplicationCompliance 统计用户文件的副本合规情况
+func GetReplicationCompliance(ctx context.Context, userID string) (*ReplicationCompliance, error) {
+	var out ReplicationCompliance
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT COUNT(1) AS total, IFNULL(SUM(compliant), 0) AS compliant FROM %s WHERE user_id = ?`,
+		tableNameAssetReplicationStatus), userID)
+	return &out, err
+}
+
+// ListAssetReplicationStatuses 获取用户文件的副本合规状态, compliant 为空时获取所有
+func ListAssetReplicationStatuses(ctx context.Context, userID string, compliant *bool, option QueryOption) (int64, []*model.AssetReplicationStatus, error) {
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	where := "user_id = ?"
+	args := []interface{}{userID}
+	if compliant != nil {
+		where += " AND compliant = ?"
+		args = append(args, *compliant)
+	}
+
+	var total int64
+	err := DB.GetContext(ctx, &total, fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE %s`, tableNameAssetReplicationStatus, where), args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	out := make([]*model.AssetReplicationStatus, 0)
+	err = DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE %s ORDER BY compliant, hash LIMIT %d OFFSET %d`,
+		tableNameAssetReplicationStatus, where, limit, offset), args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
@@ -0,0 +1,182 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+const (
+	tableNameShareLink        = "share_link"
+	tableNameShareLinkVisitor = "share_link_visitor"
+	tableNameShareLinkAccess  = "share_link_access"
+)
+
+// 分享链接访问日志的类型
+const (
+	ShareLinkActionView     = "view"
+	ShareLinkActionDownload = "download"
+	ShareLinkActionDenied   = "denied"
+)
+
+// CreateShareLink 创建分享链接, 返回链接 id
+func CreateShareLink(ctx context.Context, link *model.ShareLink) (int64, error) {
+	res, err := DB.NamedExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO %s (token, user_id, cid, name, long_link, size, password_hash, expire_at, max_downloads, max_visitors, allowed_referrers, created_at, updated_at)
+		VALUES (:token, :user_id, :cid, :name, :long_link, :size, :password_hash, :expire_at, :max_downloads, :max_visitors, :allowed_referrers, :created_at, :updated_at)`, tableNameShareLink), link)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetShareLinkByToken 根据 token 获取分享链接
+func GetShareLinkByToken(ctx context.Context, token string) (*model.ShareLink, error) {
+	var out model.ShareLink
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE token = ?`, tableNameShareLink), token)
+	return &out, err
+}
+
+// GetUserShareLink 获取用户自己的分享链接
+func GetUserShareLink(ctx context.Context, userId string, id int64) (*model.ShareLink, error) {
+	var out model.ShareLink
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE id = ? AND user_id = ?`, tableNameShareLink), id, userId)
+	return &out, err
+}
+
+// ListUserShareLinks 分页获取用户的分享链接, cid 为空时返回所有
+func ListUserShareLinks(ctx context.Context, userId, cid string, option QueryOption) (int64, []*model.ShareLink, error) {
+	where := `WHERE user_id = ?`
+	args := []interface{}{userId}
+	if cid != "" {
+		where += ` AND cid = ?`
+		args = append(args, cid)
+	}
+
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	var total int64
+	err := DB.GetContext(ctx, &total, fmt.Sprintf(`SELECT COUNT(1) FROM %s %s`, tableNameShareLink, where), args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	out := make([]*model.ShareLink, 0)
+	err = DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s %s ORDER BY id DESC LIMIT %d OFFSET %d`, tableNameShareLink, where, limit, offset), args...)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
+
+// UpdateShareLink 修改分享链接的密码, 过期时间, 次数限制和来源限制
+func UpdateShareLink(ctx context.Context, link *model.ShareLink) error {
+	_, err := DB.NamedExecContext(ctx, fmt.Sprintf(
+		`UPDATE %s SET password_hash = :password_hash, expire_at = :expire_at, max_downloads = :max_downloads, max_visitors = :max_visitors,
+		allowed_referrers = :allowed_referrers, updated_at = now() WHERE id = :id AND user_id = :user_id`, tableNameShareLink), link)
+	return err
+}
+
+// RevokeShareLink 撤销分享链接, 撤销后链接不可再访问
+func RevokeShareLink(ctx context.Context, userId string, id int64) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(
+		`UPDATE %s SET revoked = 1, updated_at = now() WHERE id = ? AND user_id = ?`, tableNameShareLink), id, userId)
+	return err
+}
+
+// IncrShareLinkDownload 增加下载次数, 达到上限时返回 false
+func IncrShareLinkDownload(ctx context.Context, id int64) (bool, error) {
+	res, err := DB.ExecContext(ctx, fmt.Sprintf(
+		`UPDATE %s SET download_count = download_count + 1 WHERE id = ? AND (max_downloads = 0 OR download_count < max_downloads)`, tableNameShareLink), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// AddShareLinkVisitor 记录独立访客, 已访问过的访客直接放行, 新访客超过上限时返回 false
+func AddShareLinkVisitor(ctx context.Context, id int64, visitor string) (bool, error) {
+	res, err := DB.ExecContext(ctx, fmt.Sprintf(
+		`INSERT IGNORE INTO %s (link_id, visitor) VALUES (?, ?)`, tableNameShareLinkVisitor), id, visitor)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return true, nil
+	}
+
+	res, err = DB.ExecContext(ctx, fmt.Sprintf(
+		`UPDATE %s SET visitor_count = visitor_count + 1 WHERE id = ? AND (max_visitors = 0 OR visitor_count < max_visitors)`, tableNameShareLink), id)
+	if err != nil {
+		return false, err
+	}
+	n, err = res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return true, nil
+	}
+
+	// 超过上限, 回滚访客记录
+	_, err = DB.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s WHERE link_id = ? AND visitor = ?`, tableNameShareLinkVisitor), id, visitor)
+	return false, err
+}
+
+// AddShareLinkAccess 记录分享链接访问日志
+func AddShareLinkAccess(ctx context.Context, access *model.ShareLinkAccess) error {
+	_, err := DB.NamedExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO %s (link_id, action, ip, country, user_agent, referrer, bytes, reason, created_at)
+		VALUES (:link_id, :action, :ip, :country, :user_agent, :referrer, :bytes, :reason, :created_at)`, tableNameShareLinkAccess), access)
+	return err
+}
+
+func shareLinkAccessStatColumns(key string) string {
+	return fmt.Sprintf(`%s AS k, SUM(IF(action = '%s', 1, 0)) AS views, SUM(IF(action = '%s', 1, 0)) AS downloads,
+		SUM(IF(action = '%s', 1, 0)) AS denied, COALESCE(SUM(bytes), 0) AS bytes`, key, ShareLinkActionView, ShareLinkActionDownload, ShareLinkActionDenied)
+}
+
+// GetShareLinkAccessTotal 分享链接在时间范围内的访问汇总
+func GetShareLinkAccessTotal(ctx context.Context, id int64, option QueryOption) (*model.ShareLinkAccessStat, error) {
+	var out model.ShareLinkAccessStat
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT %s FROM %s WHERE link_id = ? AND created_at BETWEEN ? AND ?`,
+		shareLinkAccessStatColumns("'total'"), tableNameShareLinkAccess), id, option.StartTime, option.EndTime)
+	return &out, err
+}
+
+// GetShareLinkAccessByCountry 按国家统计分享链接的访问
+func GetShareLinkAccessByCountry(ctx context.Context, id int64, option QueryOption) ([]*model.ShareLinkAccessStat, error) {
+	out := make([]*model.ShareLinkAccessStat, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT %s FROM %s WHERE link_id = ? AND created_at BETWEEN ? AND ? GROUP BY country ORDER BY views DESC`,
+		shareLinkAccessStatColumns("country"), tableNameShareLinkAccess), id, option.StartTime, option.EndTime)
+	return out, err
+}
+
+// GetShareLinkAccessDaily 按天统计分享链接的访问
+func GetShareLinkAccessDaily(ctx context.Context, id int64, option QueryOption) ([]*model.ShareLinkAccessStat, error) {
+	out := make([]*model.ShareLinkAccessStat, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT %s FROM %s WHERE link_id = ? AND created_at BETWEEN ? AND ? GROUP BY k ORDER BY k`,
+		shareLinkAccessStatColumns("DATE_FORMAT(created_at, '%Y-%m-%d')"), tableNameShareLinkAccess), id, option.StartTime, option.EndTime)
+	return out, err
+}
+
+// GetShareLinkRecentAccesses 最近的访问记录
+func GetShareLinkRecentAccesses(ctx context.Context, id int64, limit int) ([]*model.ShareLinkAccess, error) {
+	out := make([]*model.ShareLinkAccess, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE link_id = ? ORDER BY id DESC LIMIT %d`, tableNameShareLinkAccess, limit), id)
+	return out, err
+}
@@ -0,0 +1,312 @@
+package dao
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+const (
+	tableStoragePlan       = "storage_plan"
+	tableUserSubscription  = "user_subscription"
+	tableSubscriptionOrder = "subscription_order"
+)
+
+// 用户套餐的状态
+const (
+	SubscriptionStateActive  = "active"
+	SubscriptionStateExpired = "expired"
+)
+
+// 套餐订单的类型
+const (
+	SubscriptionOrderKindNew       = "new"
+	SubscriptionOrderKindRenew     = "renew"
+	SubscriptionOrderKindUpgrade   = "upgrade"
+	SubscriptionOrderKindDowngrade = "downgrade"
+)
+
+// 套餐订单的支付方式和状态
+const (
+	SubscriptionPayMethodChain = "chain"
+	SubscriptionPayMethodAdmin = "admin"
+
+	SubscriptionOrderStateCreated   = "created"
+	SubscriptionOrderStatePaid      = "paid"
+	SubscriptionOrderStateCancelled = "cancelled"
+	// SubscriptionOrderStateRefund 已经支付但套餐在支付前发生了变化, 需要人工退款
+	SubscriptionOrderStateRefund = "refund_pending"
+)
+
+var (
+	// ErrSubscriptionOrderHandled 订单已经支付或取消
+	ErrSubscriptionOrderHandled = errors.New("subscription order has been handled")
+	// ErrSubscriptionOrderStale 下单后用户的套餐已经变化, 订单的折算不再有效
+	ErrSubscriptionOrderStale = errors.New("subscription changed since the order was created")
+)
+
+// ListStoragePlans 获取套餐列表, onlyEnabled 为 true 时只返回上架的套餐
+func ListStoragePlans(ctx context.Context, onlyEnabled bool) ([]*model.StoragePlan, error) {
+	sb := squirrel.Select("*").From(tableStoragePlan)
+	if onlyEnabled {
+		sb = sb.Where("enabled = ?", true)
+	}
+	query, args, err := sb.OrderBy("price", "id").ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate list storage plans sql error:%w", err)
+	}
+
+	out := make([]*model.StoragePlan, 0)
+	err = DB.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+// GetStoragePlan 获取套餐
+func GetStoragePlan(ctx context.Context, id int64) (*model.StoragePlan, error) {
+	var out model.StoragePlan
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE id = ?`, tableStoragePlan), id)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AddStoragePlan 添加套餐
+func AddStoragePlan(ctx context.Context, p *model.StoragePlan) error {
+	res, err := DB.NamedExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (name, storage_size, traffic, max_file_size, replica_areas, price, duration_days, enabled)
+		VALUES (:name, :storage_size, :traffic, :max_file_size, :replica_areas, :price, :duration_days, :enabled)`, tableStoragePlan), p)
+	if err != nil {
+		return err
+	}
+	p.ID, err = res.LastInsertId()
+	return err
+}
+
+// UpdateStoragePlan 修改套餐, 已订阅的用户在下次生效时使用新的配置
+func UpdateStoragePlan(ctx context.Context, p *model.StoragePlan) error {
+	_, err := DB.NamedExecContext(ctx, fmt.Sprintf(`UPDATE %s SET name = :name, storage_size = :storage_size, traffic = :traffic, max_file_size = :max_file_size,
+		replica_areas = :replica_areas, price = :price, duration_days = :duration_days, enabled = :enabled WHERE id = :id`, tableStoragePlan), p)
+	return err
+}
+
+// GetUserSubscription 获取用户的套餐
+func GetUserSubscription(ctx context.Context, userID string) (*model.UserSubscription, error) {
+	var out model.UserSubscription
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE user_id = ?`, tableUserSubscription), userID)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetUserActivePlan 获取用户生效中的套餐
+func GetUserActivePlan(ctx context.Context, userID string) (*model.StoragePlan, error) {
+	var out model.StoragePlan
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT p.* FROM %s p JOIN %s s ON s.plan_id = p.id
+		WHERE s.user_id = ? AND s.state = ? AND s.expires_at > NOW()`, tableStoragePlan, tableUserSubscription), userID, SubscriptionStateActive)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetExpiredSubscriptions 获取已到期但还未降级的用户套餐
+func GetExpiredSubscriptions(ctx context.Context, limit uint64) ([]*model.UserSubscription, error) {
+	query, args, err := squirrel.Select("*").From(tableUserSubscription).Where("state = ? AND expires_at <= NOW()", SubscriptionStateActive).
+		OrderBy("expires_at").Limit(limit).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate get expired subscriptions sql error:%w", err)
+	}
+
+	out := make([]*model.UserSubscription, 0)
+	err = DB.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+// ExpireUserSubscription 把到期的套餐置为过期, 并把用户的存储空间恢复为 freeSize, 套餐已续费时返回 false
+func ExpireUserSubscription(ctx context.Context, userID string, freeSize int64) (bool, error) {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = ? WHERE user_id = ? AND state = ? AND expires_at <= NOW()`, tableUserSubscription),
+		SubscriptionStateExpired, userID, SubscriptionStateActive)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET total_storage_size = ?, enable_vip = ? WHERE username = ?`, tableNameUser), freeSize, false, userID)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// CreateSubscriptionOrder 创建套餐订单
+func CreateSubscriptionOrder(ctx context.Context, o *model.SubscriptionOrder) error {
+	if o.SourceExpiresAt.IsZero() {
+		o.SourceExpiresAt = time.Unix(0, 0)
+	}
+	_, err := DB.NamedExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (order_id, user_id, plan_id, kind, days, amount, credit, source_plan_id, source_expires_at, pay_method, state, operator, note)
+		VALUES (:order_id, :user_id, :plan_id, :kind, :days, :amount, :credit, :source_plan_id, :source_expires_at, :pay_method, :state, :operator, :note)`, tableSubscriptionOrder), o)
+	return err
+}
+
+// GetSubscriptionOrder 获取套餐订单
+func GetSubscriptionOrder(ctx context.Context, orderID string) (*model.SubscriptionOrder, error) {
+	var out model.SubscriptionOrder
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE order_id = ?`, tableSubscriptionOrder), orderID)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// GetCreatedSubscriptionOrders 获取等待支付的订单
+func GetCreatedSubscriptionOrders(ctx context.Context, payMethod string) ([]*model.SubscriptionOrder, error) {
+	out := make([]*model.SubscriptionOrder, 0)
+	err := DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE state = ? AND pay_method = ? ORDER BY id`, tableSubscriptionOrder),
+		SubscriptionOrderStateCreated, payMethod)
+	return out, err
+}
+
+// CancelSubscriptionOrders 取消 before 之前创建还未支付的订单
+func CancelSubscriptionOrders(ctx context.Context, before time.Time) (int64, error) {
+	res, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = ? WHERE state = ? AND created_at < ?`, tableSubscriptionOrder),
+		SubscriptionOrderStateCancelled, SubscriptionOrderStateCreated, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ListSubscriptionOrders 获取套餐订单, userID 为空时获取所有用户的订单
+func ListSubscriptionOrders(ctx context.Context, userID, state string, option QueryOption) (int64, []*model.SubscriptionOrder, error) {
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	sb := squirrel.Select().From(tableSubscriptionOrder)
+	if userID != "" {
+		sb = sb.Where("user_id = ?", userID)
+	}
+	if state != "" {
+		sb = sb.Where("state = ?", state)
+	}
+
+	query, args, err := sb.Column("COUNT(1)").ToSql()
+	if err != nil {
+		return 0, nil, fmt.Errorf("generate count subscription orders sql error:%w", err)
+	}
+	var total int64
+	if err = DB.GetContext(ctx, &total, query, args...); err != nil {
+		return 0, nil, err
+	}
+
+	query, args, err = sb.Column("*").OrderBy("id DESC").Limit(uint64(limit)).Offset(uint64(offset)).ToSql()
+	if err != nil {
+		return 0, nil, fmt.Errorf("generate list subscription orders sql error:%w", err)
+	}
+	out := make([]*model.SubscriptionOrder, 0)
+	if err = DB.SelectContext(ctx, &out, query, args...); err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
+
+// ActivateSubscriptionOrder 订单支付后更新用户的套餐和存储空间, paid 表示用户已经在链上支付
+// 续费同一个套餐时从原到期时间顺延, 其他情况从现在开始计算
+// 更换套餐的订单按下单时的套餐折算, 套餐已经变化时返回 ErrSubscriptionOrderStale, 未支付的订单取消,
+// 已支付的订单标记为需要退款
+// 用户其他未支付的订单不在这里取消, 它们可能已经在链上支付, 生效时同样会检查套餐是否变化
+func ActivateSubscriptionOrder(ctx context.Context, o *model.SubscriptionOrder, plan *model.StoragePlan, paid bool) error {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var sub model.UserSubscription
+	err = tx.GetContext(ctx, &sub, fmt.Sprintf(`SELECT * FROM %s WHERE user_id = ? FOR UPDATE`, tableUserSubscription), o.UserID)
+	hasSub := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	active := hasSub && sub.State == SubscriptionStateActive && sub.ExpiresAt.After(now)
+
+	if o.Kind == SubscriptionOrderKindUpgrade || o.Kind == SubscriptionOrderKindDowngrade {
+		if !active || sub.PlanID != o.SourcePlanID || !sub.ExpiresAt.Equal(o.SourceExpiresAt) {
+			state, note := SubscriptionOrderStateCancelled, "subscription changed"
+			if paid {
+				state, note = SubscriptionOrderStateRefund, "subscription changed before payment was confirmed"
+			}
+			_, err = tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = ?, note = ? WHERE order_id = ? AND state = ?`, tableSubscriptionOrder),
+				state, note, o.OrderID, SubscriptionOrderStateCreated)
+			if err != nil {
+				return err
+			}
+			if err = tx.Commit(); err != nil {
+				return err
+			}
+			return ErrSubscriptionOrderStale
+		}
+	}
+
+	// 管理员赠送的天数不参与折算
+	var grantedDays int
+	if o.PayMethod == SubscriptionPayMethodAdmin {
+		grantedDays = o.Days
+	}
+	startedAt, expiresAt := now, now.AddDate(0, 0, o.Days)
+	if o.Kind == SubscriptionOrderKindRenew && active && sub.PlanID == o.PlanID {
+		startedAt, expiresAt = sub.StartedAt, sub.ExpiresAt.AddDate(0, 0, o.Days)
+		grantedDays += sub.GrantedDays
+	}
+
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = ?, expires_at = ?, paid_at = ? WHERE order_id = ? AND state = ?`, tableSubscriptionOrder),
+		SubscriptionOrderStatePaid, expiresAt, now, o.OrderID, SubscriptionOrderStateCreated)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrSubscriptionOrderHandled
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (user_id, plan_id, state, started_at, expires_at, granted_days) VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE plan_id = VALUES(plan_id), state = VALUES(state), started_at = VALUES(started_at), expires_at = VALUES(expires_at),
+		granted_days = VALUES(granted_days)`, tableUserSubscription),
+		o.UserID, o.PlanID, SubscriptionStateActive, startedAt, expiresAt, grantedDays)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET total_storage_size = ?, enable_vip = ? WHERE username = ?`, tableNameUser),
+		plan.StorageSize, plan.Price > 0, o.UserID)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	o.State, o.ExpiresAt, o.PaidAt = SubscriptionOrderStatePaid, expiresAt, now
+	return nil
+}
@@ -10,18 +10,34 @@ import (
 )
 
 const (
-	tableIPFSRecords = "sync_ipfs_record"
+	tableIPFSRecords       = "sync_ipfs_record"
+	tableIPFSExportRecords = "ipfs_export_record"
 )
 
-// AddIPFSRecords 添加ipfs同步记录
+// ipfs导入导出的处理状态
+const (
+	IPFSStateQueued    = "queued"
+	IPFSStateResolving = "resolving"
+	IPFSStatePinning   = "pinning"
+	IPFSStateSyncing   = "syncing"
+	IPFSStateDone      = "done"
+	IPFSStateFailed    = "failed"
+)
+
+// AddIPFSRecords 添加ipfs同步记录, 已存在的记录中失败的重新排队
 func AddIPFSRecords(ctx context.Context, irs []model.SyncIPFSRecord) error {
-	sq := squirrel.Insert(tableIPFSRecords).Columns("username,cid,timestamp")
+	sq := squirrel.Insert(tableIPFSRecords).Columns("username,name,cid,group_id,size,area_id,timestamp,state")
 
 	for _, v := range irs {
-		sq = sq.Values(v.Username, v.CID, v.Timestamp)
+		sq = sq.Values(v.Username, v.Name, v.CID, v.GroupID, v.Size, v.AreaID, v.Timestamp, IPFSStateQueued)
 	}
 
-	query, args, err := sq.Options("IGNORE").ToSql()
+	query, args, err := sq.Suffix(fmt.Sprintf(`ON DUPLICATE KEY UPDATE
+		group_id = IF(state = '%[1]s', VALUES(group_id), group_id),
+		area_id = IF(state = '%[1]s', VALUES(area_id), area_id),
+		timestamp = IF(state = '%[1]s', VALUES(timestamp), timestamp),
+		reason = IF(state = '%[1]s', '', reason),
+		state = IF(state = '%[1]s', VALUES(state), state)`, IPFSStateFailed)).ToSql()
 	if err != nil {
 		return fmt.Errorf("generate sql of add sync_ipfs_records error:%w", err)
 	}
@@ -34,11 +50,11 @@ func AddIPFSRecords(ctx context.Context, irs []model.SyncIPFSRecord) error {
 	return nil
 }
 
-// GetUnSyncIPFSRecords 获取未同步成功的ipfs文件列表且时间不超过一个小时
+// GetUnSyncIPFSRecords 获取正在同步到区域的ipfs文件列表且时间不超过一个小时
 func GetUnSyncIPFSRecords(ctx context.Context) ([]model.SyncIPFSRecord, error) {
 	var irs []model.SyncIPFSRecord
 
-	query, args, err := squirrel.Select("*").From(tableIPFSRecords).Where("status = 0 AND timestamp >= ?", time.Now().Unix()-3600).ToSql()
+	query, args, err := squirrel.Select("*").From(tableIPFSRecords).Where("state = ? AND timestamp >= ?", IPFSStateSyncing, time.Now().Unix()-3600).ToSql()
 	if err != nil {
 		return nil, fmt.Errorf("generate sql of get unsync ipfs records error:%w", err)
 	}
@@ -53,7 +69,8 @@ func GetUnSyncIPFSRecords(ctx context.Context) ([]model.SyncIPFSRecord, error) {
 
 // UpdateIPFSRecordStatus 更新ipfs文件同步状态
 func UpdateIPFSRecordStatus(ctx context.Context, cids []string, areaID string) error {
-	query, args, err := squirrel.Update(tableIPFSRecords).Set("status", 1).Where(squirrel.Eq{"cid": cids, "area_id": areaID}).ToSql()
+	query, args, err := squirrel.Update(tableIPFSRecords).Set("status", 1).Set("state", IPFSStateDone).
+		Where(squirrel.Eq{"cid": cids, "area_id": areaID, "state": IPFSStateSyncing}).ToSql()
 	if err != nil {
 		return fmt.Errorf("generate sql of update status of ipfs's record error:%w", err)
 	}
@@ -66,11 +83,11 @@ func UpdateIPFSRecordStatus(ctx context.Context, cids []string, areaID string) e
 	return nil
 }
 
-// GetIPFSRecordsByCIDs 根据cid获取ipfs记录列表
-func GetIPFSRecordsByCIDs(ctx context.Context, cids []string) ([]model.SyncIPFSRecord, error) {
+// GetIPFSRecordsByCIDs 根据cid获取区域内正在同步的ipfs记录列表
+func GetIPFSRecordsByCIDs(ctx context.Context, cids []string, areaID string) ([]model.SyncIPFSRecord, error) {
 	var rs []model.SyncIPFSRecord
 
-	query, args, err := squirrel.Select("*").From(tableIPFSRecords).Where(squirrel.Eq{"cid": cids}).ToSql()
+	query, args, err := squirrel.Select("*").From(tableIPFSRecords).Where(squirrel.Eq{"cid": cids, "area_id": areaID, "state": IPFSStateSyncing}).ToSql()
 	if err != nil {
 		return nil, fmt.Errorf("generate sql of get ipfs's record error:%w", err)
 	}
@@ -111,3 +128,146 @@ func GetIPFSRecordsByUsername(ctx context.Context, un string, page, size int) (i
 
 	return total, rs, nil
 }
+
+// GetQueuedIPFSRecords 获取用户排队中的ipfs记录
+func GetQueuedIPFSRecords(ctx context.Context, un string, cids []string) ([]model.SyncIPFSRecord, error) {
+	var rs []model.SyncIPFSRecord
+
+	query, args, err := squirrel.Select("*").From(tableIPFSRecords).Where(squirrel.Eq{"username": un, "cid": cids, "state": IPFSStateQueued}).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate sql of get ipfs's record error:%w", err)
+	}
+
+	err = DB.SelectContext(ctx, &rs, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("get ipfs's record error:%w", err)
+	}
+
+	return rs, nil
+}
+
+// GetIPFSRecordByID 根据id获取ipfs记录
+func GetIPFSRecordByID(ctx context.Context, id int64) (*model.SyncIPFSRecord, error) {
+	var out model.SyncIPFSRecord
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE id = ?`, tableIPFSRecords), id)
+	return &out, err
+}
+
+// UpdateIPFSRecordState 更新ipfs记录的处理状态, 失败时记录原因
+func UpdateIPFSRecordState(ctx context.Context, id int64, state, reason string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = ?, reason = ? WHERE id = ?`, tableIPFSRecords), state, reason, id)
+	return err
+}
+
+// UpdateIPFSRecordSyncing 调度器开始拉取文件, 从此时开始计算同步超时
+func UpdateIPFSRecordSyncing(ctx context.Context, id int64) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = ?, reason = '', timestamp = ? WHERE id = ?`, tableIPFSRecords), IPFSStateSyncing, time.Now().Unix(), id)
+	return err
+}
+
+// UpdateIPFSRecordResolved 记录解析 DAG 得到的文件名和实际大小
+func UpdateIPFSRecordResolved(ctx context.Context, id int64, name string, size int64) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET name = ?, size = ? WHERE id = ?`, tableIPFSRecords), name, size, id)
+	return err
+}
+
+// GetPendingIPFSImportSize 获取用户正在导入但还未计入已用空间的文件大小
+func GetPendingIPFSImportSize(ctx context.Context, un string, excludeID int64) (int64, error) {
+	var size int64
+	query, args, err := squirrel.Select("IFNULL(SUM(size), 0)").From(tableIPFSRecords).Where(squirrel.Eq{
+		"username": un,
+		"state":    []string{IPFSStatePinning, IPFSStateSyncing},
+	}).Where("id <> ?", excludeID).ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("generate sql of get pending ipfs size error:%w", err)
+	}
+
+	err = DB.GetContext(ctx, &size, query, args...)
+	return size, err
+}
+
+// FailTimeoutIPFSRecords 同步超过一个小时仍未完成的记录标记为失败
+func FailTimeoutIPFSRecords(ctx context.Context) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = ?, reason = ? WHERE state = ? AND timestamp < ?`, tableIPFSRecords),
+		IPFSStateFailed, "sync to area timeout", IPFSStateSyncing, time.Now().Unix()-3600)
+	return err
+}
+
+// FailStaleIPFSRecords 导入/导出任务最长执行30分钟, 超过一个小时仍停留在解析或pin阶段的记录说明任务已中断, 标记为失败以便重新导入
+func FailStaleIPFSRecords(ctx context.Context) error {
+	before := time.Now().Add(-time.Hour)
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = ?, reason = ? WHERE state IN (?, ?) AND updated_at < ?`, tableIPFSRecords),
+		IPFSStateFailed, "import interrupted, please import again", IPFSStateResolving, IPFSStatePinning, before)
+	if err != nil {
+		return err
+	}
+	_, err = DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = ?, reason = ? WHERE state = ? AND updated_at < ?`, tableIPFSExportRecords),
+		IPFSStateFailed, "export interrupted, please export again", IPFSStatePinning, before)
+	return err
+}
+
+// AddIPFSExportRecord 添加导出记录, 已存在时重新排队, 返回记录id
+func AddIPFSExportRecord(ctx context.Context, un, cid string) (int64, error) {
+	res, err := DB.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (username, cid, state, created_at) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), state = VALUES(state), reason = ''`, tableIPFSExportRecords), un, cid, IPFSStateQueued, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetIPFSExportRecordByID 根据id获取导出记录
+func GetIPFSExportRecordByID(ctx context.Context, id int64) (*model.IPFSExportRecord, error) {
+	var out model.IPFSExportRecord
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE id = ?`, tableIPFSExportRecords), id)
+	return &out, err
+}
+
+// UpdateIPFSExportRecordState 更新导出记录的处理状态
+func UpdateIPFSExportRecordState(ctx context.Context, id int64, state, reason string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET state = ?, reason = ? WHERE id = ?`, tableIPFSExportRecords), state, reason, id)
+	return err
+}
+
+// GetIPFSExportRecordsByUsername 根据用户名获取导出记录
+func GetIPFSExportRecordsByUsername(ctx context.Context, un string, page, size int) (int64, []*model.IPFSExportRecord, error) {
+	var total int64
+	err := DB.GetContext(ctx, &total, fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE username = ?`, tableIPFSExportRecords), un)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	out := make([]*model.IPFSExportRecord, 0)
+	err = DB.SelectContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE username = ? ORDER BY id DESC LIMIT %d OFFSET %d`, tableIPFSExportRecords, size, (page-1)*size), un)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
+
+// RequeueIPFSRecord 添加或重新排队一条ipfs同步记录, 已完成或失败的记录也会重新排队, 返回排队中的记录
+// 记录正在处理时返回 sql.ErrNoRows
+func RequeueIPFSRecord(ctx context.Context, ir model.SyncIPFSRecord) (*model.SyncIPFSRecord, error) {
+	query, args, err := squirrel.Insert(tableIPFSRecords).Columns("username,name,cid,group_id,size,area_id,timestamp,state").
+		Values(ir.Username, ir.Name, ir.CID, ir.GroupID, ir.Size, ir.AreaID, ir.Timestamp, IPFSStateQueued).
+		Suffix(fmt.Sprintf(`ON DUPLICATE KEY UPDATE
+		group_id = IF(state IN ('%[1]s', '%[2]s'), VALUES(group_id), group_id),
+		area_id = IF(state IN ('%[1]s', '%[2]s'), VALUES(area_id), area_id),
+		name = IF(state IN ('%[1]s', '%[2]s'), VALUES(name), name),
+		timestamp = IF(state IN ('%[1]s', '%[2]s'), VALUES(timestamp), timestamp),
+		reason = IF(state IN ('%[1]s', '%[2]s'), '', reason),
+		status = IF(state IN ('%[1]s', '%[2]s'), 0, status),
+		state = IF(state IN ('%[1]s', '%[2]s'), VALUES(state), state)`, IPFSStateFailed, IPFSStateDone)).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate sql of requeue sync_ipfs_record error:%w", err)
+	}
+
+	if _, err = DB.ExecContext(ctx, query, args...); err != nil {
+		return nil, fmt.Errorf("requeue sync_ipfs_record error:%w", err)
+	}
+
+	var out model.SyncIPFSRecord
+	err = DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE username = ? AND cid = ? AND state = ?`, tableIPFSRecords), ir.Username, ir.CID, IPFSStateQueued)
+	return &out, err
+}
@@ -0,0 +1,138 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+const tableTempAssetUpload = "temp_asset_upload"
+
+// 未登录上传文件的状态
+const (
+	TempAssetStateActive  = "active"
+	TempAssetStateExpired = "expired"
+	TempAssetStateRemoved = "removed"
+)
+
+// SaveTempAsset 保存新上传的临时文件, 已过期的记录重新计数
+func SaveTempAsset(ctx context.Context, ta *model.TempAsset) error {
+	// 先按旧的状态和有效期计算次数, 再更新状态
+	query, args, err := squirrel.Insert(tableTempAsset).Columns("hash,cid,size,area_ids,state,expires_at,share_count").
+		Values(ta.Hash, ta.Cid, ta.Size, ta.AreaIDs, TempAssetStateActive, ta.ExpiresAt, 1).
+		Suffix(`ON DUPLICATE KEY UPDATE share_count = IF(state <> ? OR expires_at < NOW(), 1, share_count + 1),
+			download_count = IF(state <> ? OR expires_at < NOW(), 0, download_count),
+			cid = VALUES(cid), size = VALUES(size), area_ids = VALUES(area_ids), expires_at = VALUES(expires_at), state = VALUES(state), reason = ''`,
+			TempAssetStateActive, TempAssetStateActive).ToSql()
+	if err != nil {
+		return fmt.Errorf("generate save temp asset sql error:%w", err)
+	}
+
+	_, err = DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// UpdateTempAssetState 更新临时文件的状态
+func UpdateTempAssetState(ctx context.Context, hash, state, reason string) error {
+	query, args, err := squirrel.Update(tableTempAsset).Set("state", state).Set("reason", reason).Where("hash = ?", hash).ToSql()
+	if err != nil {
+		return fmt.Errorf("generate update temp asset sql error:%w", err)
+	}
+
+	_, err = DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetExpiredTempAssets 获取已过期但还未删除的临时文件
+func GetExpiredTempAssets(ctx context.Context, before time.Time, limit uint64) ([]*model.TempAsset, error) {
+	query, args, err := squirrel.Select("*").From(tableTempAsset).Where("state = ? AND expires_at < ?", TempAssetStateActive, before).
+		OrderBy("expires_at").Limit(limit).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate get expired temp assets sql error:%w", err)
+	}
+
+	out := make([]*model.TempAsset, 0)
+	err = DB.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
+
+// ListTempAssets 获取临时文件
+func ListTempAssets(ctx context.Context, state, hash string, option QueryOption) (int64, []*model.TempAsset, error) {
+	limit := option.PageSize
+	offset := option.Page
+	if option.PageSize <= 0 {
+		limit = 50
+	}
+	if option.Page > 0 {
+		offset = limit * (option.Page - 1)
+	}
+
+	sb := squirrel.Select().From(tableTempAsset)
+	if state != "" {
+		sb = sb.Where("state = ?", state)
+	}
+	if hash != "" {
+		sb = sb.Where("hash = ?", hash)
+	}
+
+	query, args, err := sb.Column("COUNT(1)").ToSql()
+	if err != nil {
+		return 0, nil, fmt.Errorf("generate count temp assets sql error:%w", err)
+	}
+	var total int64
+	if err = DB.GetContext(ctx, &total, query, args...); err != nil {
+		return 0, nil, err
+	}
+
+	query, args, err = sb.Column("*").OrderBy("created_at DESC").Limit(uint64(limit)).Offset(uint64(offset)).ToSql()
+	if err != nil {
+		return 0, nil, fmt.Errorf("generate list temp assets sql error:%w", err)
+	}
+	out := make([]*model.TempAsset, 0)
+	if err = DB.SelectContext(ctx, &out, query, args...); err != nil {
+		return 0, nil, err
+	}
+
+	return total, out, nil
+}
+
+// CreateTempAssetUpload 记录未登录用户的一次上传
+func CreateTempAssetUpload(ctx context.Context, up *model.TempAssetUpload) error {
+	query, args, err := squirrel.Insert(tableTempAssetUpload).Columns("hash,cid,asset_name,claim_token,ip,fingerprint,size").
+		Values(up.Hash, up.Cid, up.AssetName, up.ClaimToken, up.IP, up.Fingerprint, up.Size).ToSql()
+	if err != nil {
+		return fmt.Errorf("generate insert temp asset upload sql error:%w", err)
+	}
+
+	_, err = DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetTempAssetUploadByToken 通过认领凭证获取上传记录
+func GetTempAssetUploadByToken(ctx context.Context, token string) (*model.TempAssetUpload, error) {
+	var out model.TempAssetUpload
+	err := DB.GetContext(ctx, &out, fmt.Sprintf(`SELECT * FROM %s WHERE claim_token = ?`, tableTempAssetUpload), token)
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ClaimTempAssetUpload 标记上传记录已被认领, 已经被认领过时返回 false
+func ClaimTempAssetUpload(ctx context.Context, id int64, username string) (bool, error) {
+	res, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET claimed_by = ?, claimed_at = NOW() WHERE id = ? AND claimed_by = ''`, tableTempAssetUpload), username, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// UnclaimTempAssetUpload 认领失败时撤销认领标记
+func UnclaimTempAssetUpload(ctx context.Context, id int64, username string) error {
+	_, err := DB.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET claimed_by = '', claimed_at = '1970-01-01 00:00:00' WHERE id = ? AND claimed_by = ?`, tableTempAssetUpload), id, username)
+	return err
+}
@@ -0,0 +1,101 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+)
+
+// maxTransferAnalyticsRows 一次分析最多读取的传输记录数
+const maxTransferAnalyticsRows = 200000
+
+// TransferAnalyticsFilter 传输分析的过滤条件
+type TransferAnalyticsFilter struct {
+	UserID       string
+	Area         string
+	NodeID       string
+	Country      string
+	TransferType string
+	Start        time.Time
+	End          time.Time
+}
+
+// where 添加过滤条件, nodeColumn 是过滤节点用的字段
+func (f *TransferAnalyticsFilter) where(sb squirrel.SelectBuilder, prefix, nodeColumn string) squirrel.SelectBuilder {
+	sb = sb.Where(prefix+"created_at >= ? AND "+prefix+"created_at <= ?", f.Start, f.End).
+		Where(squirrel.Eq{prefix + "state": []int{AssetTransferStateSuccess, AssetTransferStateFailure}})
+	if f.UserID != "" {
+		sb = sb.Where(prefix+"user_id = ?", f.UserID)
+	}
+	if f.Area != "" {
+		sb = sb.Where(prefix+"area = ?", f.Area)
+	}
+	if f.NodeID != "" {
+		sb = sb.Where(nodeColumn+" = ?", f.NodeID)
+	}
+	if f.Country != "" {
+		sb = sb.Where(prefix+"country = ?", f.Country)
+	}
+	if f.TransferType != "" {
+		sb = sb.Where(prefix+"transfer_type = ?", f.TransferType)
+	}
+	return sb
+}
+
+// TransferAnalyticsRecord 传输分析用到的传输记录
+type TransferAnalyticsRecord struct {
+	Area          string    `db:"area"`
+	NodeID        string    `db:"node_id"`
+	Country       string    `db:"country"`
+	TransferType  string    `db:"transfer_type"`
+	State         int64     `db:"state"`
+	Rate          int64     `db:"rate"`
+	FirstByteTime int64     `db:"first_byte_time"`
+	TotalSize     int64     `db:"total_size"`
+	CreatedAt     time.Time `db:"created_at"`
+}
+
+// GetTransferAnalyticsRecords 获取时间范围内已完成的传输记录, 返回的记录数超过上限时 truncated 为 true
+func GetTransferAnalyticsRecords(ctx context.Context, filter *TransferAnalyticsFilter) (out []*TransferAnalyticsRecord, truncated bool, err error) {
+	sb := squirrel.Select("area, node_id, country, transfer_type, state, rate, first_byte_time, total_size, created_at").From("asset_transfer_log")
+	query, args, err := filter.where(sb, "", "node_id").OrderBy("created_at DESC").Limit(maxTransferAnalyticsRows + 1).ToSql()
+	if err != nil {
+		return nil, false, fmt.Errorf("generate get transfer records sql error:%w", err)
+	}
+
+	out = make([]*TransferAnalyticsRecord, 0)
+	if err = DB.SelectContext(ctx, &out, query, args...); err != nil {
+		return nil, false, err
+	}
+	if len(out) > maxTransferAnalyticsRows {
+		return out[:maxTransferAnalyticsRows], true, nil
+	}
+	return out, false, nil
+}
+
+// TransferFailureRecord 失败的传输明细, Errors 是 AssetTransferDetailErrors 的 json
+type TransferFailureRecord struct {
+	Area         string    `db:"area"`
+	NodeID       string    `db:"node_id"`
+	Country      string    `db:"country"`
+	TransferType string    `db:"transfer_type"`
+	Errors       string    `db:"errors"`
+	CreatedAt    time.Time `db:"created_at"`
+}
+
+// GetTransferFailureRecords 获取时间范围内失败的节点传输明细
+func GetTransferFailureRecords(ctx context.Context, filter *TransferAnalyticsFilter) ([]*TransferFailureRecord, error) {
+	sb := squirrel.Select("l.area, d.node_id, l.country, l.transfer_type, d.errors, l.created_at").
+		From("asset_transfer_detail d").Join("asset_transfer_log l ON d.trace_id = l.trace_id").
+		Where("d.state = ? AND d.errors <> '' AND d.errors <> '[]'", AssetTransferStateFailure)
+	query, args, err := filter.where(sb, "l.", "d.node_id").Limit(maxTransferAnalyticsRows).ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("generate get transfer failures sql error:%w", err)
+	}
+
+	out := make([]*TransferFailureRecord, 0)
+	err = DB.SelectContext(ctx, &out, query, args...)
+	return out, err
+}
@@ -89,8 +89,18 @@ func AddAssetAndUpdateSize(ctx context.Context, asset *model.UserAsset, areaIDs
 		return errors.New("area id can not be empty")
 	}
 
-	// 查询文件记录是否存在
-	ua, err := GetUserAsset(ctx, asset.Hash, asset.UserID)
+	// 用户已有该文件的记录 (包括回收站和历史版本中的) 时不重复计算已使用空间
+	var owned int64
+	query, args, err := squirrel.Select("COUNT(1)").From(tableUserAsset).Where("hash = ? AND user_id = ?", asset.Hash, asset.UserID).ToSql()
+	if err != nil {
+		return fmt.Errorf("generate count asset sql error:%w", err)
+	}
+	if err = tx.GetContext(ctx, &owned, query, args...); err != nil {
+		return err
+	}
+
+	// 查询文件记录是否存在, 文件只在回收站或历史版本中时需要新增
+	_, err = GetUserAsset(ctx, asset.Hash, asset.UserID)
 	if err != nil && err != sql.ErrNoRows {
 		log.Error(err)
 		return fmt.Errorf("generate insert asset sql error:%w", err)
@@ -109,7 +119,7 @@ func AddAssetAndUpdateSize(ctx context.Context, asset *model.UserAsset, areaIDs
 		}
 	}
 	// 添加文件区域,只有第一个为插入时才更新，后续不变
-	query, args, err := squirrel.Insert(tableUserAssetArea).Columns("hash,user_id,area_id,is_sync").Values(asset.Hash, asset.UserID, syncArea, true).Suffix("ON DUPLICATE KEY UPDATE is_sync = VALUES(is_sync)").ToSql()
+	query, args, err = squirrel.Insert(tableUserAssetArea).Columns("hash,user_id,area_id,is_sync").Values(asset.Hash, asset.UserID, syncArea, true).Suffix("ON DUPLICATE KEY UPDATE is_sync = VALUES(is_sync)").ToSql()
 	if err != nil {
 		log.Error(err)
 		return fmt.Errorf("generate insert asset's area sql error:%w", err)
@@ -147,7 +157,7 @@ func AddAssetAndUpdateSize(ctx context.Context, asset *model.UserAsset, areaIDs
 		return fmt.Errorf("insert user_asset_map error:%w", err)
 	}
 	// 修改用户storage已使用记录
-	if ua == nil || ua.UserID == "" {
+	if owned == 0 {
 		query, args, err = squirrel.Update(tableNameUser).Set("used_storage_size", squirrel.Expr("used_storage_size + ?", asset.TotalSize)).Where("username = ?", asset.UserID).ToSql()
 		if err != nil {
 			log.Error(err)
@@ -163,7 +173,8 @@ func AddAssetAndUpdateSize(ctx context.Context, asset *model.UserAsset, areaIDs
 	return tx.Commit()
 }
 
-// DelAssetAndUpdateSize 删除文件信息并修改使用的storage存储空间
+// DelAssetAndUpdateSize 删除文件信息并修改使用的storage存储空间, isNeedDel 时删除用户该文件的所有记录,
+// 只删除某个文件组中的副本使用 DeleteUserAssetCopy
 func DelAssetAndUpdateSize(ctx context.Context, hash, userID string, areaID []string, isNeedDel bool) error {
 	tx, err := DB.Beginx()
 	if err != nil {
@@ -483,14 +494,31 @@ func MoveAssetGroup(ctx context.Context, userID string, groupID, targetGroupID i
 }
 
 // UpdateAssetGroup update user asset group
-func UpdateAssetGroup(ctx context.Context, userID, hash string, groupID int) error {
-	query, args, err := squirrel.Update(tableUserAsset).Set("group_id", groupID).Where("user_id=? AND hash=?", userID, hash).ToSql()
+// 只移动文件组 fromGroupID 中的副本, 目标文件组中已经有该文件时删除原来的副本
+func UpdateAssetGroup(ctx context.Context, userID, hash string, fromGroupID int64, groupID int) error {
+	if fromGroupID < 0 || fromGroupID == int64(groupID) {
+		return nil
+	}
+
+	tx, err := DB.Beginx()
 	if err != nil {
-		return fmt.Errorf("generate update asset sql error:%w", err)
+		return err
 	}
-	_, err = DB.ExecContext(ctx, query, args...)
+	defer tx.Rollback()
 
-	return err
+	query := fmt.Sprintf(`UPDATE IGNORE %s SET group_id = ? WHERE user_id = ? AND hash = ? AND group_id = ?`, tableUserAsset)
+	res, err := tx.ExecContext(ctx, query, groupID, userID, hash, fromGroupID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		query = fmt.Sprintf(`DELETE FROM %s WHERE user_id = ? AND hash = ? AND group_id = ?`, tableUserAsset)
+		if _, err = tx.ExecContext(ctx, query, userID, hash, fromGroupID); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 // GetUserAssetDetail 获取用户文件信息
@@ -499,7 +527,7 @@ func GetUserAssetDetail(ctx context.Context, hash, uid string) (*UserAssetDetail
 
 	query, args, err := squirrel.Select("ua.user_id,ua.hash,ua.asset_name,ua.asset_type,ua.share_status,ua.expiration,ua.created_time,ua.total_size,ua.password,ua.group_id,IFNULL(uav.count,0) AS visit_count").
 		From(fmt.Sprintf("%s AS ua", tableUserAsset)).LeftJoin(fmt.Sprintf("%s AS uav ON ua.hash=uav.hash and ua.user_id = uav.user_id", tableUserAssetVisit)).
-		Where("ua.user_id = ? AND ua.hash = ?", uid, hash).ToSql()
+		Where("ua.user_id = ? AND ua.hash = ? AND ua.group_id >= 0", uid, hash).ToSql()
 	if err != nil {
 		return nil, fmt.Errorf("generate get asset sql error:%w", err)
 	}
@@ -510,14 +538,51 @@ func GetUserAssetDetail(ctx context.Context, hash, uid string) (*UserAssetDetail
 	return &asset, err
 }
 
-// GetUserAsset 获取用户文件信息
+// GetUserAsset 获取用户文件信息, 不包括回收站和历史版本中的记录, 文件有多个副本时返回其中一个
 func GetUserAsset(ctx context.Context, hash, uid string) (*model.UserAsset, error) {
 	var out model.UserAsset
-	query := "SELECT * FROM user_asset where hash = ? and user_id = ?"
+	query := "SELECT * FROM user_asset where hash = ? and user_id = ? and group_id >= 0 ORDER BY group_id LIMIT 1"
 	err := DB.GetContext(ctx, &out, query, hash, uid)
 	return &out, err
 }
 
+// AddUserAssetRecord 文件的区域都已经存在 (文件在回收站或历史版本中) 时直接添加文件记录, 已使用空间已经计算过
+func AddUserAssetRecord(ctx context.Context, asset *model.UserAsset) error {
+	query, args, err := squirrel.Insert(tableUserAsset).Options("IGNORE").Columns("user_id,asset_name,asset_type,total_size,group_id,hash,created_time,expiration,password,cid,md5,extra_id,client_ip").
+		Values(asset.UserID, asset.AssetName, asset.AssetType, asset.TotalSize, asset.GroupID, asset.Hash, asset.CreatedTime, asset.Expiration, asset.Password, asset.Cid, asset.MD5, asset.ExtraID, asset.ClientIP).ToSql()
+	if err != nil {
+		return fmt.Errorf("generate insert asset sql error:%w", err)
+	}
+	_, err = DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// DeleteUserAssetCopy 删除文件在文件组 groupID 中的记录, 用户还有该文件的其他记录时只删除这一条并返回 true,
+// 返回 false 时调用方需要删除文件并更新已使用空间
+func DeleteUserAssetCopy(ctx context.Context, userID, hash string, groupID int64) (bool, error) {
+	tx, err := DB.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var others int64
+	query := fmt.Sprintf(`SELECT COUNT(1) FROM %s WHERE user_id = ? AND hash = ? AND group_id <> ?`, tableUserAsset)
+	if err = tx.GetContext(ctx, &others, query, userID, hash, groupID); err != nil {
+		return false, err
+	}
+	if others == 0 {
+		return false, nil
+	}
+
+	query = fmt.Sprintf(`DELETE FROM %s WHERE user_id = ? AND hash = ? AND group_id = ?`, tableUserAsset)
+	if _, err = tx.ExecContext(ctx, query, userID, hash, groupID); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
 func GetUserAssetByBuilder(ctx context.Context, sb squirrel.SelectBuilder) (*model.UserAsset, error) {
 	var out model.UserAsset
 	query, args, err := sb.From(tableUserAsset).Limit(1).ToSql()
@@ -1045,11 +1110,13 @@ func DeleteAssetGroupAndUpdateSize(ctx context.Context, userID string, gid int)
 		}
 		gids = append(gids, ids...)
 	}
-	// 获取要删除的所有文件大小
-	query, args, err := squirrel.Select("IFNULL(SUM(total_size),0)").From(tableUserAsset).Where(squirrel.Eq{
+	// 获取要删除的所有文件大小, 同一个文件只算一次, 其他文件组中还有副本的文件不释放空间
+	others, oargs, _ := squirrel.Select("`hash`").From(tableUserAsset).Where("user_id = ?", userID).Where(squirrel.NotEq{"group_id": gids}).ToSql()
+	sb := squirrel.Select("`hash`,MAX(total_size) AS total_size").From(tableUserAsset).Where(squirrel.Eq{
 		"user_id":  userID,
 		"group_id": gids,
-	}).ToSql()
+	}).Where(fmt.Sprintf("`hash` NOT IN (%s)", others), oargs...).GroupBy("`hash`")
+	query, args, err := squirrel.Select("IFNULL(SUM(total_size),0)").FromSelect(sb, "t").ToSql()
 	if err != nil {
 		return fmt.Errorf("generate get total_size of asset error:%w", err)
 	}
@@ -1107,7 +1174,7 @@ func GetOnlyAssetsByUIDAndGroupID(ctx context.Context, userID string, gids []int
 		"user_id":  userID,
 		"group_id": gids,
 	}).ToSql()
-	query, args, err := squirrel.Select("ua.cid,COUNT(ua.cid) AS num,uaa.area_id").From(fmt.Sprintf("%s AS uaa", tableUserAssetArea)).
+	query, args, err := squirrel.Select("ua.cid,COUNT(DISTINCT ua.user_id) AS num,uaa.area_id").From(fmt.Sprintf("%s AS uaa", tableUserAssetArea)).
 		LeftJoin(fmt.Sprintf("%s AS ua ON uaa.`hash` = ua.`hash` AND ua.user_id = uaa.user_id", tableUserAsset)).
 		Where(fmt.Sprintf("uaa.`hash` IN (%s)", sb), sa...).
 		Where("ua.cid <> ''").GroupBy("ua.cid").Having("num = 1").ToSql()
@@ -1135,11 +1202,12 @@ func DeleteUserGroupAsset(ctx context.Context, userID string, gids []int64) erro
 
 	defer tx.Rollback()
 
-	// 先通过user_asset的hash删除user_asset_area的数据
+	// 先通过user_asset的hash删除user_asset_area的数据, 其他文件组中还有副本的文件保留
+	others, oargs, _ := squirrel.Select("`hash`").From(tableUserAsset).Where("user_id = ?", userID).Where(squirrel.NotEq{"group_id": gids}).ToSql()
 	sb, sa, _ := squirrel.Select("`hash`").From(tableUserAsset).Where(squirrel.Eq{
 		"user_id":  userID,
 		"group_id": gids,
-	}).ToSql()
+	}).Where(fmt.Sprintf("`hash` NOT IN (%s)", others), oargs...).ToSql()
 	query, args, err := squirrel.Delete(tableUserAssetArea).Where(fmt.Sprintf("`hash` IN (%s)", sb), sa...).
 		Where("user_id = ?", userID).ToSql()
 	if err != nil {
@@ -1261,18 +1329,30 @@ func CheckAssetByMd5AndAreaExists(ctx context.Context, md5, areaID string) (stri
 
 // GetNoExistCIDs 获取用户不存在的cid信息
 func GetNoExistCIDs(ctx context.Context, uid string, cids []string) ([]string, error) {
-	var ncids []string
+	var ecids []string
 
-	query, args, err := squirrel.Select("cid").From(tableUserAsset).Where(squirrel.NotEq{"cid": cids}).ToSql()
+	query, args, err := squirrel.Select("cid").From(tableUserAsset).Where(squirrel.Eq{"user_id": uid, "cid": cids}).ToSql()
 	if err != nil {
 		return nil, fmt.Errorf("generate sql of get not exists cid error:%w", err)
 	}
 
-	err = DB.SelectContext(ctx, &ncids, query, args...)
+	err = DB.SelectContext(ctx, &ecids, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("get not exists cid error:%w", err)
 	}
 
+	exists := make(map[string]struct{}, len(ecids))
+	for _, v := range ecids {
+		exists[v] = struct{}{}
+	}
+
+	var ncids []string
+	for _, v := range cids {
+		if _, ok := exists[v]; !ok {
+			ncids = append(ncids, v)
+		}
+	}
+
 	return ncids, nil
 }
 
@@ -87,6 +87,44 @@ const (
 	OrderStatus
 	NeedBindKeplr
 
+	DeviceGroupExists
+	DeviceGroupNotExists
+	ShareLinkRevoked
+	ShareLinkDownloadLimit
+	ShareLinkVisitorLimit
+	ShareLinkRefererNotAllowed
+
+	EncryptionKeyExists
+	EncryptionKeyNotFound
+	EncryptionKeyVersionMismatch
+	EncryptionKeyVerifyRequired
+
+	FilBackupNotFound
+	FilRestoreExists
+
+	TempAssetExpired
+	TempAssetTakenDown
+	TempAssetQuotaExceeded
+	TempAssetClaimInvalid
+
+	ContentBlocked
+	UserSharingDisabled
+	ModerationAppealNotAllowed
+	ModerationReportTooFrequent
+
+	GatewayDisabled
+	GatewayTokenInvalid
+	GatewayTokenExpired
+	GatewayRangeNotAllowed
+
+	StoragePlanNotFound
+	StoragePlanFileSizeExceeded
+	StoragePlanStorageNotEnough
+	SubscriptionOrderNotAllowed
+
+	GatewayProxyRequired
+	BulkDevicesExceeded
+
 	Unknown     = -1
 	Success     = 0
 	GenericCode = 1
@@ -185,6 +223,36 @@ var ErrMap = map[int]string{
 	OutTotalFlow:                             "out total flow:总流量超过使用限制",
 	OrderStatus:                              "Status does not match: 状态不匹配",
 	NeedBindKeplr:                            "need bind keplr:需要绑定keplr钱包地址",
+	DeviceGroupExists:                        "device group already exists:分组已存在",
+	DeviceGroupNotExists:                     "device group not exists:分组不存在",
+	ShareLinkRevoked:                         "share link was revoked:分享链接已撤销",
+	ShareLinkDownloadLimit:                   "share link download limit reached:分享链接下载次数已达上限",
+	ShareLinkVisitorLimit:                    "share link visitor limit reached:分享链接访客数已达上限",
+	ShareLinkRefererNotAllowed:               "share link referer not allowed:分享链接不允许从该来源访问",
+	EncryptionKeyExists:                      "encryption key already exists:密钥已存在",
+	EncryptionKeyNotFound:                    "encryption key not found:密钥不存在",
+	EncryptionKeyVersionMismatch:             "encryption key version mismatch:密钥版本不一致",
+	EncryptionKeyVerifyRequired:              "wallet signature verification required:需要先验证钱包签名",
+	FilBackupNotFound:                        "no active filecoin deal for the file:文件没有有效的 Filecoin 备份",
+	FilRestoreExists:                         "restore already requested:已经在恢复中",
+	TempAssetExpired:                         "temporary file has expired:临时文件已过期",
+	TempAssetTakenDown:                       "file has been taken down:文件已被下架",
+	TempAssetQuotaExceeded:                   "daily upload quota exceeded:超出每日上传限额",
+	TempAssetClaimInvalid:                    "invalid claim token:认领凭证无效",
+	ContentBlocked:                           "content has been blocked:内容已被屏蔽",
+	UserSharingDisabled:                      "sharing has been disabled for this user:该用户已被禁止分享",
+	ModerationAppealNotAllowed:               "appeal is not allowed:不能申诉",
+	ModerationReportTooFrequent:              "too many reports, please try again later:举报过于频繁, 请稍后再试",
+	GatewayDisabled:                          "download gateway is disabled:下载网关未开启",
+	GatewayTokenInvalid:                      "invalid download link:下载链接无效",
+	GatewayTokenExpired:                      "download link has expired:下载链接已过期",
+	GatewayRangeNotAllowed:                   "requested range is not allowed:请求的范围不允许",
+	StoragePlanNotFound:                      "storage plan not found:套餐不存在",
+	StoragePlanFileSizeExceeded:              "file size exceeds the limit of your plan:文件大小超出套餐限制",
+	StoragePlanStorageNotEnough:              "used storage exceeds the plan:已用空间超出该套餐的空间",
+	SubscriptionOrderNotAllowed:              "order is not allowed for the current plan:当前套餐不能购买该订单",
+	GatewayProxyRequired:                     "range limited or ip bound links require the gateway proxy mode:限制范围或绑定ip的下载链接需要开启网关代理",
+	BulkDevicesExceeded:                      "too many devices in the group or tag for a bulk operation:分组或标签内的节点数超出批量操作上限",
 }
 
 type GenericError struct {
@@ -342,6 +342,8 @@ type AssetTransferLog struct {
 	Ip                 string    `json:"ip" db:"ip"`
 	FirstByteTime      int64     `json:"first_byte_time" db:"first_byte_time"`
 	AvailableBandwidth int64     `json:"available_bandwidth" db:"available_bandwidth"`
+	Country            string    `json:"country" db:"country"`
+	Isp                string    `json:"isp" db:"isp"`
 	CreatedAt          time.Time `json:"created_at" db:"created_at"`
 }
 
@@ -404,3 +406,462 @@ type Tenant struct {
 	DeleteNotifyUrl string    `json:"delete_notify_url" db:"delete_notify_url"`
 	CreatedAt       time.Time `json:"created_at" db:"created_at"`
 }
+
+type DeviceGroup struct {
+	ID          int64     `db:"id" json:"id"`
+	UserID      string    `db:"user_id" json:"-"`
+	Name        string    `db:"name" json:"name"`
+	DeviceCount int64     `db:"device_count" json:"device_count"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type DeviceGroupStats struct {
+	DeviceCount      int64   `db:"device_count" json:"device_count"`
+	OnlineCount      int64   `db:"online_count" json:"online_count"`
+	OfflineCount     int64   `db:"offline_count" json:"offline_count"`
+	AbnormalCount    int64   `db:"abnormal_count" json:"abnormal_count"`
+	CumulativeProfit float64 `db:"cumulative_profit" json:"cumulative_profit"`
+	TodayProfit      float64 `db:"today_profit" json:"today_profit"`
+	YesterdayProfit  float64 `db:"yesterday_profit" json:"yesterday_profit"`
+	SevenDaysProfit  float64 `db:"seven_days_profit" json:"seven_days_profit"`
+	MonthProfit      float64 `db:"month_profit" json:"month_profit"`
+	BandwidthUp      float64 `db:"bandwidth_up" json:"bandwidth_up"`
+	BandwidthDown    float64 `db:"bandwidth_down" json:"bandwidth_down"`
+}
+
+type DeviceTagCount struct {
+	Tag   string `db:"tag" json:"tag"`
+	Count int64  `db:"count" json:"count"`
+}
+
+type DeviceAnomaly struct {
+	ID         int64     `db:"id" json:"id"`
+	DeviceID   string    `db:"device_id" json:"device_id"`
+	UserID     string    `db:"user_id" json:"user_id"`
+	Date       time.Time `db:"date" json:"date"`
+	Score      float64   `db:"score" json:"score"`
+	Rules      string    `db:"rules" json:"rules"`
+	Evidence   string    `db:"evidence" json:"evidence"`
+	Status     int       `db:"status" json:"status"`
+	Reviewer   string    `db:"reviewer" json:"reviewer"`
+	Remark     string    `db:"remark" json:"remark"`
+	ReviewedAt time.Time `db:"reviewed_at" json:"reviewed_at"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt  time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type AnomalyCandidate struct {
+	DeviceID    string  `db:"device_id"`
+	UserID      string  `db:"user_id"`
+	ExternalIP  string  `db:"external_ip"`
+	CpuInfo     string  `db:"cpu_info"`
+	MacLocation string  `db:"mac_location"`
+	TodayProfit float64 `db:"today_profit"`
+}
+
+type DeviceEvent struct {
+	ID        int64     `db:"id" json:"id"`
+	DeviceID  string    `db:"device_id" json:"device_id"`
+	UserID    string    `db:"user_id" json:"user_id"`
+	Event     string    `db:"event" json:"event"`
+	Operator  string    `db:"operator" json:"operator"`
+	OldValue  string    `db:"old_value" json:"old_value"`
+	NewValue  string    `db:"new_value" json:"new_value"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+type ShareLink struct {
+	ID               int64     `db:"id" json:"id"`
+	Token            string    `db:"token" json:"token"`
+	UserID           string    `db:"user_id" json:"-"`
+	Cid              string    `db:"cid" json:"cid"`
+	Name             string    `db:"name" json:"name"`
+	LongLink         string    `db:"long_link" json:"-"`
+	Size             int64     `db:"size" json:"size"`
+	PasswordHash     string    `db:"password_hash" json:"-"`
+	ExpireAt         time.Time `db:"expire_at" json:"expire_at"`
+	MaxDownloads     int64     `db:"max_downloads" json:"max_downloads"`
+	MaxVisitors      int64     `db:"max_visitors" json:"max_visitors"`
+	DownloadCount    int64     `db:"download_count" json:"download_count"`
+	VisitorCount     int64     `db:"visitor_count" json:"visitor_count"`
+	AllowedReferrers string    `db:"allowed_referrers" json:"allowed_referrers"`
+	Revoked          bool      `db:"revoked" json:"revoked"`
+	CreatedAt        time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt        time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type ShareLinkAccess struct {
+	ID        int64     `db:"id" json:"id"`
+	LinkID    int64     `db:"link_id" json:"link_id"`
+	Action    string    `db:"action" json:"action"`
+	IP        string    `db:"ip" json:"ip"`
+	Country   string    `db:"country" json:"country"`
+	UserAgent string    `db:"user_agent" json:"user_agent"`
+	Referrer  string    `db:"referrer" json:"referrer"`
+	Bytes     int64     `db:"bytes" json:"bytes"`
+	Reason    string    `db:"reason" json:"reason"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+type ShareLinkAccessStat struct {
+	Key       string `db:"k" json:"key"`
+	Views     int64  `db:"views" json:"views"`
+	Downloads int64  `db:"downloads" json:"downloads"`
+	Denied    int64  `db:"denied" json:"denied"`
+	Bytes     int64  `db:"bytes" json:"bytes"`
+}
+
+type AssetTrash struct {
+	ID            int64     `db:"id" json:"id"`
+	UserID        string    `db:"user_id" json:"-"`
+	ItemType      string    `db:"item_type" json:"item_type"`
+	Hash          string    `db:"hash" json:"-"`
+	Cid           string    `db:"cid" json:"cid"`
+	GroupID       int64     `db:"group_id" json:"group_id"`
+	Name          string    `db:"name" json:"name"`
+	OriginGroupID int64     `db:"origin_group_id" json:"origin_group_id"`
+	Size          int64     `db:"size" json:"size"`
+	DeletedAt     time.Time `db:"deleted_at" json:"deleted_at"`
+	ExpireAt      time.Time `db:"expire_at" json:"expire_at"`
+}
+
+type AssetVersioning struct {
+	UserID       string    `db:"user_id" json:"-"`
+	GroupID      int64     `db:"group_id" json:"group_id"`
+	KeepVersions int64     `db:"keep_versions" json:"keep_versions"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+}
+
+type AssetVersion struct {
+	ID        int64     `db:"id" json:"id"`
+	UserID    string    `db:"user_id" json:"-"`
+	GroupID   int64     `db:"group_id" json:"group_id"`
+	AssetName string    `db:"asset_name" json:"asset_name"`
+	Hash      string    `db:"hash" json:"-"`
+	Cid       string    `db:"cid" json:"cid"`
+	TotalSize int64     `db:"total_size" json:"total_size"`
+	Version   int64     `db:"version" json:"version"`
+	IsCurrent bool      `db:"is_current" json:"is_current"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+type AssetDedupArea struct {
+	AreaID       string    `db:"area_id" json:"area_id"`
+	LogicalSize  int64     `db:"logical_size" json:"logical_size"`
+	PhysicalSize int64     `db:"physical_size" json:"physical_size"`
+	HashCount    int64     `db:"hash_count" json:"hash_count"`
+	RefCount     int64     `db:"ref_count" json:"ref_count"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type AssetDedupTopHash struct {
+	Hash      string    `db:"hash" json:"hash"`
+	Cid       string    `db:"cid" json:"cid"`
+	TotalSize int64     `db:"total_size" json:"total_size"`
+	UserCount int64     `db:"user_count" json:"user_count"`
+	SavedSize int64     `db:"saved_size" json:"saved_size"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type AssetDedupHit struct {
+	ID        int64     `db:"id" json:"id"`
+	UserID    string    `db:"user_id" json:"user_id"`
+	Hash      string    `db:"hash" json:"hash"`
+	AreaID    string    `db:"area_id" json:"area_id"`
+	TotalSize int64     `db:"total_size" json:"total_size"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+type IPFSExportRecord struct {
+	ID        int64     `db:"id" json:"id"`
+	Username  string    `db:"username" json:"-"`
+	CID       string    `db:"cid" json:"cid"`
+	State     string    `db:"state" json:"state"`
+	Reason    string    `db:"reason" json:"reason"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type AssetCarExport struct {
+	ID         int64     `db:"id" json:"id"`
+	UserID     string    `db:"user_id" json:"-"`
+	GroupID    int64     `db:"group_id" json:"group_id"`
+	Name       string    `db:"name" json:"name"`
+	State      string    `db:"state" json:"state"`
+	Reason     string    `db:"reason" json:"reason"`
+	RootCid    string    `db:"root_cid" json:"root_cid"`
+	AssetCount int64     `db:"asset_count" json:"asset_count"`
+	Size       int64     `db:"size" json:"size"`
+	ObjectKey  string    `db:"object_key" json:"-"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+	ExpireAt   time.Time `db:"expire_at" json:"expire_at"`
+}
+
+type UserEncryptionKey struct {
+	UserID            string    `db:"user_id" json:"user_id"`
+	Version           int64     `db:"version" json:"version"`
+	WrapMethod        string    `db:"wrap_method" json:"wrap_method"`
+	KdfParams         string    `db:"kdf_params" json:"kdf_params"`
+	PublicKey         string    `db:"public_key" json:"public_key"`
+	WrappedPrivateKey string    `db:"wrapped_private_key" json:"wrapped_private_key"`
+	CreatedAt         time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt         time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type UserAssetKey struct {
+	ID         int64     `db:"id" json:"id"`
+	UserID     string    `db:"user_id" json:"-"`
+	Hash       string    `db:"hash" json:"-"`
+	Cid        string    `db:"cid" json:"cid"`
+	KeyVersion int64     `db:"key_version" json:"key_version"`
+	WrappedKey string    `db:"wrapped_key" json:"wrapped_key"`
+	SharedBy   string    `db:"shared_by" json:"shared_by"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+}
+
+type AssetLifecycleRule struct {
+	ID        int64     `db:"id" json:"id"`
+	UserID    string    `db:"user_id" json:"-"`
+	GroupID   int64     `db:"group_id" json:"group_id"`
+	Action    string    `db:"action" json:"action"`
+	Days      int64     `db:"days" json:"days"`
+	KeepAreas int64     `db:"keep_areas" json:"keep_areas"`
+	Enabled   bool      `db:"enabled" json:"enabled"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type AssetLifecycleLog struct {
+	ID        int64     `db:"id" json:"id"`
+	UserID    string    `db:"user_id" json:"-"`
+	RuleID    int64     `db:"rule_id" json:"rule_id"`
+	Action    string    `db:"action" json:"action"`
+	Hash      string    `db:"hash" json:"-"`
+	Cid       string    `db:"cid" json:"cid"`
+	AssetName string    `db:"asset_name" json:"asset_name"`
+	Detail    string    `db:"detail" json:"detail"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+type FilBackupJob struct {
+	ID           int64     `db:"id" json:"id"`
+	Cid          string    `db:"cid" json:"cid"`
+	Hash         string    `db:"hash" json:"-"`
+	State        string    `db:"state" json:"state"`
+	Path         string    `db:"path" json:"path"`
+	DealCount    int64     `db:"deal_count" json:"deal_count"`
+	DealEndTime  time.Time `db:"deal_end_time" json:"deal_end_time"`
+	RetryCount   int64     `db:"retry_count" json:"retry_count"`
+	Reason       string    `db:"reason" json:"reason"`
+	DispatchedAt time.Time `db:"dispatched_at" json:"dispatched_at"`
+	AlertedAt    time.Time `db:"alerted_at" json:"alerted_at"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type FilRestoreRequest struct {
+	ID        int64     `db:"id" json:"id"`
+	UserID    string    `db:"user_id" json:"-"`
+	Cid       string    `db:"cid" json:"cid"`
+	Hash      string    `db:"hash" json:"-"`
+	AreaID    string    `db:"area_id" json:"area_id"`
+	GroupID   int64     `db:"group_id" json:"group_id"`
+	Name      string    `db:"name" json:"name"`
+	State     string    `db:"state" json:"state"`
+	Reason    string    `db:"reason" json:"reason"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type ReplicationPolicy struct {
+	ID        int64     `db:"id" json:"id"`
+	OwnerType string    `db:"owner_type" json:"owner_type"`
+	OwnerID   string    `db:"owner_id" json:"-"`
+	Mode      string    `db:"mode" json:"mode"`
+	Replicas  int64     `db:"replicas" json:"replicas"`
+	Countries string    `db:"countries" json:"countries"`
+	AreaIDs   string    `db:"area_ids" json:"area_ids"`
+	Prune     bool      `db:"prune" json:"prune"`
+	Enabled   bool      `db:"enabled" json:"enabled"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+type AssetReplicationStatus struct {
+	UserID       string    `db:"user_id" json:"-"`
+	Hash         string    `db:"hash" json:"hash"`
+	Cid          string    `db:"cid" json:"cid"`
+	PolicyID     int64     `db:"policy_id" json:"policy_id"`
+	DesiredAreas string    `db:"desired_areas" json:"desired_areas"`
+	ActualAreas  string    `db:"actual_areas" json:"actual_areas"`
+	MissingAreas string    `db:"missing_areas" json:"missing_areas"`
+	ExtraAreas   string    `db:"extra_areas" json:"extra_areas"`
+	Compliant    bool      `db:"compliant" json:"compliant"`
+	CheckedAt    time.Time `db:"checked_at" json:"checked_at"`
+}
+
+type AssetSyncTask struct {
+	ID           int64     `db:"id" json:"id"`
+	Kind         string    `db:"kind" json:"kind"`
+	UserID       string    `db:"user_id" json:"user_id"`
+	Owner        string    `db:"owner" json:"owner"`
+	Cid          string    `db:"cid" json:"cid"`
+	Hash         string    `db:"hash" json:"hash"`
+	SourceAreaID string    `db:"source_area_id" json:"source_area_id"`
+	AreaID       string    `db:"area_id" json:"area_id"`
+	State        string    `db:"state" json:"state"`
+	Attempts     int64     `db:"attempts" json:"attempts"`
+	LastError    string    `db:"last_error" json:"last_error"`
+	NextRunAt    time.Time `db:"next_run_at" json:"next_run_at"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// AreaTransferStats 区域的传输表现统计
+type AreaTransferStats struct {
+	ID           int64     `db:"id" json:"-"`
+	AreaID       string    `db:"area_id" json:"area_id"`
+	Country      string    `db:"country" json:"country"`
+	Isp          string    `db:"isp" json:"isp"`
+	TransferType string    `db:"transfer_type" json:"transfer_type"`
+	Samples      int64     `db:"samples" json:"samples"`
+	P50Rate      int64     `db:"p50_rate" json:"p50_rate"`
+	P95Rate      int64     `db:"p95_rate" json:"p95_rate"`
+	FailRate     float64   `db:"fail_rate" json:"fail_rate"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// TempAssetUpload 未登录用户的上传记录
+type TempAssetUpload struct {
+	ID          int64     `db:"id" json:"id"`
+	Hash        string    `db:"hash" json:"hash"`
+	Cid         string    `db:"cid" json:"cid"`
+	AssetName   string    `db:"asset_name" json:"asset_name"`
+	ClaimToken  string    `db:"claim_token" json:"-"`
+	IP          string    `db:"ip" json:"ip"`
+	Fingerprint string    `db:"fingerprint" json:"fingerprint"`
+	Size        int64     `db:"size" json:"size"`
+	ClaimedBy   string    `db:"claimed_by" json:"claimed_by"`
+	ClaimedAt   time.Time `db:"claimed_at" json:"claimed_at"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
+
+// ModerationCase 内容审核记录
+type ModerationCase struct {
+	ID           int64     `db:"id" json:"id"`
+	Hash         string    `db:"hash" json:"hash"`
+	Cid          string    `db:"cid" json:"cid"`
+	UserID       string    `db:"user_id" json:"user_id"`
+	State        string    `db:"state" json:"state"`
+	Action       string    `db:"action" json:"action"`
+	ReportCount  int64     `db:"report_count" json:"report_count"`
+	LastReason   string    `db:"last_reason" json:"last_reason"`
+	AdminNote    string    `db:"admin_note" json:"admin_note"`
+	AppealReason string    `db:"appeal_reason" json:"appeal_reason"`
+	HandledBy    string    `db:"handled_by" json:"handled_by"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// ModerationReport 内容举报
+type ModerationReport struct {
+	ID          int64     `db:"id" json:"id"`
+	CaseID      int64     `db:"case_id" json:"case_id"`
+	Target      string    `db:"target" json:"target"`
+	Reporter    string    `db:"reporter" json:"reporter"`
+	Category    string    `db:"category" json:"category"`
+	Description string    `db:"description" json:"description"`
+	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+}
+
+// ModerationNotice 内容审核通知
+type ModerationNotice struct {
+	ID        int64     `db:"id" json:"id"`
+	UserID    string    `db:"user_id" json:"-"`
+	CaseID    int64     `db:"case_id" json:"case_id"`
+	Hash      string    `db:"hash" json:"hash"`
+	Cid       string    `db:"cid" json:"cid"`
+	Action    string    `db:"action" json:"action"`
+	Reason    string    `db:"reason" json:"reason"`
+	IsRead    bool      `db:"is_read" json:"is_read"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// AssetAccessDaily 文件每天的访问汇总
+type AssetAccessDaily struct {
+	ID            int64     `db:"id" json:"-"`
+	UserID        string    `db:"user_id" json:"-"`
+	Hash          string    `db:"hash" json:"hash"`
+	Date          time.Time `db:"date" json:"date"`
+	DownloadCount int64     `db:"download_count" json:"download_count"`
+	TotalTraffic  int64     `db:"total_traffic" json:"total_traffic"`
+	PeakBandwidth int64     `db:"peak_bandwidth" json:"peak_bandwidth"`
+	UpdatedAt     time.Time `db:"updated_at" json:"-"`
+}
+
+// AssetPreview 文件的预览图和视频信息, 按文件 hash 保存
+type AssetPreview struct {
+	ID           int64     `db:"id" json:"-"`
+	Hash         string    `db:"hash" json:"hash"`
+	Cid          string    `db:"cid" json:"cid"`
+	Kind         string    `db:"kind" json:"kind"`
+	State        string    `db:"state" json:"state"`
+	Width        int64     `db:"width" json:"width"`
+	Height       int64     `db:"height" json:"height"`
+	Duration     int64     `db:"duration" json:"duration"`
+	ThumbnailKey string    `db:"thumbnail_key" json:"-"`
+	PosterKey    string    `db:"poster_key" json:"-"`
+	Reason       string    `db:"reason" json:"reason"`
+	CreatedAt    time.Time `db:"created_at" json:"-"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// StoragePlan 存储套餐, 价格为每个周期的链上代币数量
+type StoragePlan struct {
+	ID           int64     `db:"id" json:"id"`
+	Name         string    `db:"name" json:"name"`
+	StorageSize  int64     `db:"storage_size" json:"storage_size"`
+	Traffic      int64     `db:"traffic" json:"traffic"`
+	MaxFileSize  int64     `db:"max_file_size" json:"max_file_size"`
+	ReplicaAreas int       `db:"replica_areas" json:"replica_areas"`
+	Price        int64     `db:"price" json:"price"`
+	DurationDays int       `db:"duration_days" json:"duration_days"`
+	Enabled      bool      `db:"enabled" json:"enabled"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt    time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// UserSubscription 用户当前的套餐
+type UserSubscription struct {
+	ID          int64     `db:"id" json:"-"`
+	UserID      string    `db:"user_id" json:"user_id"`
+	PlanID      int64     `db:"plan_id" json:"plan_id"`
+	State       string    `db:"state" json:"state"`
+	StartedAt   time.Time `db:"started_at" json:"started_at"`
+	ExpiresAt   time.Time `db:"expires_at" json:"expires_at"`
+	GrantedDays int       `db:"granted_days" json:"granted_days"`
+	CreatedAt   time.Time `db:"created_at" json:"-"`
+	UpdatedAt   time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// SubscriptionOrder 套餐订单, source 为下单时生效中的套餐, 用来校验折算是否还有效
+type SubscriptionOrder struct {
+	ID              int64     `db:"id" json:"-"`
+	OrderID         string    `db:"order_id" json:"order_id"`
+	UserID          string    `db:"user_id" json:"user_id"`
+	PlanID          int64     `db:"plan_id" json:"plan_id"`
+	Kind            string    `db:"kind" json:"kind"`
+	Days            int       `db:"days" json:"days"`
+	Amount          int64     `db:"amount" json:"amount"`
+	Credit          int64     `db:"credit" json:"credit"`
+	SourcePlanID    int64     `db:"source_plan_id" json:"source_plan_id"`
+	SourceExpiresAt time.Time `db:"source_expires_at" json:"source_expires_at"`
+	PayMethod       string    `db:"pay_method" json:"pay_method"`
+	State           string    `db:"state" json:"state"`
+	Operator        string    `db:"operator" json:"operator"`
+	Note            string    `db:"note" json:"note"`
+	ExpiresAt       time.Time `db:"expires_at" json:"expires_at"`
+	PaidAt          time.Time `db:"paid_at" json:"paid_at"`
+	CreatedAt       time.Time `db:"created_at" json:"created_at"`
+}
@@ -688,10 +688,16 @@ type UserAssetArea struct {
 }
 
 type TempAsset struct {
-	Hash          string `db:"hash"`
-	DownloadCount int64  `db:"download_count"`
-	ShareCount    int64  `db:"share_count"`
-	Size int64 `db:"size"`
+	Hash          string    `db:"hash" json:"hash"`
+	DownloadCount int64     `db:"download_count" json:"download_count"`
+	ShareCount    int64     `db:"share_count" json:"share_count"`
+	Size          int64     `db:"size" json:"size"`
+	Cid           string    `db:"cid" json:"cid"`
+	AreaIDs       string    `db:"area_ids" json:"area_ids"`
+	State         string    `db:"state" json:"state"`
+	Reason        string    `db:"reason" json:"reason"`
+	ExpiresAt     time.Time `db:"expires_at" json:"expires_at"`
+	CreatedAt     time.Time `db:"created_at" json:"created_at"`
 }
 
 type AreaMap struct {
@@ -726,4 +732,7 @@ type SyncIPFSRecord struct {
 	AreaID string `db:"area_id"`
 	Timestamp int64 `db:"timestamp"`
 	Status int8 `db:"status"`
+	State string `db:"state"`
+	Reason string `db:"reason"`
+	UpdatedAt time.Time `db:"updated_at"`
 }
@@ -3,6 +3,7 @@ package opasynq
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
@@ -115,10 +116,116 @@ func (c *Client) EnqueueIPFSRecord(ctx context.Context, irp IPFSRecordPayload) e
 
 	task := asynq.NewTask(TypeSyncIPFSRecord, payload, asynq.MaxRetry(3))
 
-	_, err = c.cli.EnqueueContext(ctx, task)
+	_, err = c.cli.EnqueueContext(ctx, task, asynq.Queue(TaskQueueExplorer))
 	if err != nil {
 		return fmt.Errorf("could not enqueue task of sync ipfs record error:%w", err)
 	}
 
 	return nil
 }
+
+// EnqueueIPFSImport 塞入ipfs导入任务
+func (c *Client) EnqueueIPFSImport(ctx context.Context, p IPFSTaskPayload) error {
+	return c.enqueueRecordTask(ctx, TypeIPFSImport, p)
+}
+
+// EnqueueIPFSExport 塞入ipfs导出任务
+func (c *Client) EnqueueIPFSExport(ctx context.Context, p IPFSTaskPayload) error {
+	return c.enqueueRecordTask(ctx, TypeIPFSExport, p)
+}
+
+// EnqueueCarExport 塞入文件组导出 CAR 的任务
+func (c *Client) EnqueueCarExport(ctx context.Context, p CarExportPayload) error {
+	return c.enqueueRecordTask(ctx, TypeCarExport, p)
+}
+
+// EnqueueAssetLifecycle 塞入用户文件生命周期的处理任务, 每个用户每天只处理一次
+func (c *Client) EnqueueAssetLifecycle(ctx context.Context, p AssetLifecyclePayload) error {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("json unmarshal payload of asset lifecycle error:%w", err)
+	}
+
+	taskID := fmt.Sprintf("%s:%s:%s", TypeAssetLifecycle, p.UserID, time.Now().Format("20060102"))
+	task := asynq.NewTask(TypeAssetLifecycle, payload, asynq.MaxRetry(3), asynq.TaskID(taskID), asynq.Retention(24*time.Hour))
+
+	_, err = c.cli.EnqueueContext(ctx, task, asynq.Queue(TaskQueueExplorer))
+	if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return fmt.Errorf("could not enqueue task of asset lifecycle error:%w", err)
+	}
+
+	return nil
+}
+
+// EnqueueReplicationReconcile 塞入用户文件副本的调整任务, 每个用户每小时最多处理一次
+func (c *Client) EnqueueReplicationReconcile(ctx context.Context, p ReplicationReconcilePayload) error {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("json unmarshal payload of replication reconcile error:%w", err)
+	}
+
+	taskID := fmt.Sprintf("%s:%s:%s", TypeReplicationReconcile, p.UserID, time.Now().Format("2006010215"))
+	task := asynq.NewTask(TypeReplicationReconcile, payload, asynq.MaxRetry(3), asynq.TaskID(taskID), asynq.Retention(time.Hour))
+
+	_, err = c.cli.EnqueueContext(ctx, task, asynq.Queue(TaskQueueExplorer))
+	if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return fmt.Errorf("could not enqueue task of replication reconcile error:%w", err)
+	}
+
+	return nil
+}
+
+// EnqueueAssetSync 塞入文件同步任务, 在 delay 之后处理; 重试由任务自己重新塞入, 同一次处理只会塞入一次
+func (c *Client) EnqueueAssetSync(ctx context.Context, p AssetSyncPayload, delay time.Duration) error {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("json unmarshal payload of asset sync error:%w", err)
+	}
+
+	taskID := fmt.Sprintf("%s:%d:%d", TypeAssetSync, p.ID, p.Attempt)
+	task := asynq.NewTask(TypeAssetSync, payload, asynq.MaxRetry(0), asynq.TaskID(taskID), asynq.ProcessIn(delay),
+		asynq.Timeout(2*time.Minute))
+
+	_, err = c.cli.EnqueueContext(ctx, task, asynq.Queue(TaskQueueExplorer))
+	if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return fmt.Errorf("could not enqueue task of asset sync error:%w", err)
+	}
+
+	return nil
+}
+
+// EnqueueAssetPreview 塞入文件预览的生成任务, 同一个文件同时只有一个任务
+func (c *Client) EnqueueAssetPreview(ctx context.Context, p AssetPreviewPayload) error {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("json unmarshal payload of asset preview error:%w", err)
+	}
+
+	taskID := fmt.Sprintf("%s:%s", TypeAssetPreview, p.Hash)
+	task := asynq.NewTask(TypeAssetPreview, payload, asynq.MaxRetry(5), asynq.TaskID(taskID), asynq.Timeout(5*time.Minute))
+
+	_, err = c.cli.EnqueueContext(ctx, task, asynq.Queue(TaskQueueExplorer))
+	if err != nil && !errors.Is(err, asynq.ErrTaskIDConflict) {
+		return fmt.Errorf("could not enqueue task of asset preview error:%w", err)
+	}
+
+	return nil
+}
+
+// enqueueRecordTask 塞入处理数据库记录的任务, 处理状态记录在数据库中
+func (c *Client) enqueueRecordTask(ctx context.Context, typename string, p interface{}) error {
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("json unmarshal payload of %s error:%w", typename, err)
+	}
+
+	// 每个步骤的失败都会记录到数据库中, 任务本身不重试
+	task := asynq.NewTask(typename, payload, asynq.MaxRetry(0), asynq.Timeout(30*time.Minute))
+
+	_, err = c.cli.EnqueueContext(ctx, task, asynq.Queue(TaskQueueExplorer))
+	if err != nil {
+		return fmt.Errorf("could not enqueue task of %s error:%w", typename, err)
+	}
+
+	return nil
+}
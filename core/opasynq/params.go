@@ -21,6 +21,27 @@ const (
 
 	// TypeSyncIPFSRecord 同步ipfs文件记录
 	TypeSyncIPFSRecord = "sync:ipfs"
+
+	// TypeIPFSImport 导入ipfs文件
+	TypeIPFSImport = "ipfs:import"
+
+	// TypeIPFSExport 导出文件到ipfs节点
+	TypeIPFSExport = "ipfs:export"
+
+	// TypeCarExport 文件组导出为 CAR
+	TypeCarExport = "car:export"
+
+	// TypeAssetLifecycle 处理用户文件的生命周期规则
+	TypeAssetLifecycle = "asset:lifecycle"
+
+	// TypeReplicationReconcile 按副本策略调整用户文件的区域
+	TypeReplicationReconcile = "replication:reconcile"
+
+	// TypeAssetSync 同步文件到区域
+	TypeAssetSync = "asset:sync"
+
+	// TypeAssetPreview 生成文件预览
+	TypeAssetPreview = "asset:preview"
 )
 
 const (
@@ -74,4 +95,36 @@ type (
 		AreaID string          `json:"area_id"`
 		Info   model.UserAsset `json:"info"`
 	}
+
+	// IPFSTaskPayload ipfs导入或导出记录的id
+	IPFSTaskPayload struct {
+		ID int64 `json:"id"`
+	}
+
+	// CarExportPayload CAR 导出任务的id
+	CarExportPayload struct {
+		ID int64 `json:"id"`
+	}
+
+	// AssetLifecyclePayload 需要处理生命周期的用户
+	AssetLifecyclePayload struct {
+		UserID string `json:"user_id"`
+	}
+
+	// ReplicationReconcilePayload 需要调整副本的用户
+	ReplicationReconcilePayload struct {
+		UserID string `json:"user_id"`
+	}
+
+	// AssetSyncPayload 同步任务的id和第几次处理
+	AssetSyncPayload struct {
+		ID      int64 `json:"id"`
+		Attempt int64 `json:"attempt"`
+	}
+
+	// AssetPreviewPayload 需要生成预览的文件, 从该用户的文件所在区域下载
+	AssetPreviewPayload struct {
+		UserID string `json:"user_id"`
+		Hash   string `json:"hash"`
+	}
 )
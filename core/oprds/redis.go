@@ -19,6 +19,7 @@ const (
 	preStorageFlow = "storage_flow"
 	preDownload    = "asset_download"
 	preTempFile    = "temp_file_uid"
+	preTempUpload  = "temp_upload_bytes"
 )
 
 var cli *Client
@@ -187,15 +188,22 @@ func (c *Client) DelAreaIDs(ctx context.Context, payload *AreaIDPayload) error {
 	return nil
 }
 
-// SetUnloginAssetInfo 塞入未登陆文件的hash和区域
-func (c *Client) SetUnloginAssetInfo(ctx context.Context, hash string, payload *UnLoginSyncArea) error {
+// SetUnloginAssetInfo 塞入未登陆文件的hash和区域, ttl 是文件的有效期
+func (c *Client) SetUnloginAssetInfo(ctx context.Context, hash string, payload *UnLoginSyncArea, ttl time.Duration) error {
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("json marshal scheduler's info error:%w", err)
 	}
 	key := fmt.Sprintf("%s_%s", preUnlogin, hash)
 
-	return c.rds.SetEx(ctx, key, string(body), 24*time.Hour).Err()
+	return c.rds.SetEx(ctx, key, string(body), ttl).Err()
+}
+
+// DelUnloginAssetInfo 删除未登陆文件的区域信息
+func (c *Client) DelUnloginAssetInfo(ctx context.Context, hash string) error {
+	key := fmt.Sprintf("%s_%s", preUnlogin, hash)
+
+	return c.rds.Del(ctx, key).Err()
 }
 
 // GetUnloginAssetAreaIDs 获取未登陆文件的区域
@@ -225,6 +233,43 @@ func (c *Client) GetUnloginAssetAreaIDs(ctx context.Context, hash string) ([]str
 	}
 }
 
+// SetUnloginAssetAreaSynced 标记未登陆文件的区域同步完成
+func (c *Client) SetUnloginAssetAreaSynced(ctx context.Context, hash, areaID string) error {
+	var payload UnLoginSyncArea
+
+	key := fmt.Sprintf("%s_%s", preUnlogin, hash)
+	value, err := c.rds.Get(ctx, key).Result()
+	switch err {
+	case redis.Nil:
+		// 文件已经过期或被删除
+		return nil
+	case nil:
+		if err = json.Unmarshal([]byte(value), &payload); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	found := false
+	for i, v := range payload.List {
+		if v.AreaID == areaID {
+			payload.List[i].IsSync = true
+			found = true
+		}
+	}
+	if !found {
+		payload.List = append(payload.List, UnloginSyncAreaDetail{AreaID: areaID, IsSync: true})
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("json marshal scheduler's info error:%w", err)
+	}
+
+	return c.rds.Set(ctx, key, string(body), redis.KeepTTL).Err()
+}
+
 // IncrUnSyncNodeID 增加未同步的节点次数
 func (c *Client) IncrUnSyncNodeID(ctx context.Context, nodeID string) error {
 	key := fmt.Sprintf("%s_%s", preNodeID, nodeID)
@@ -298,3 +343,29 @@ func (c *Client) GetUIDTempFileTime(ctx context.Context, uid string) (int64, err
 
 	return c.rds.Get(ctx, key).Int64()
 }
+
+// IncrTempUploadBytes 增加当天未登录上传的字节数, 超过 limit 时不计入并返回 false
+func (c *Client) IncrTempUploadBytes(ctx context.Context, kind, id string, size, limit int64) (bool, error) {
+	key := fmt.Sprintf("%s_%s_%s_%s", preTempUpload, time.Now().Format("20060102"), kind, id)
+
+	total, err := c.rds.IncrBy(ctx, key, size).Result()
+	if err != nil {
+		return false, fmt.Errorf("incr key(%v) error:%w", key, err)
+	}
+	if total == size {
+		c.rds.Expire(ctx, key, 25*time.Hour)
+	}
+	if total > limit {
+		c.rds.DecrBy(ctx, key, size)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// DecrTempUploadBytes 上传失败时退回当天未登录上传的字节数
+func (c *Client) DecrTempUploadBytes(ctx context.Context, kind, id string, size int64) error {
+	key := fmt.Sprintf("%s_%s_%s_%s", preTempUpload, time.Now().Format("20060102"), kind, id)
+
+	return c.rds.DecrBy(ctx, key, size).Err()
+}
@@ -0,0 +1,183 @@
+package statistics
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gnasnik/titan-explorer/core/anomaly"
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+	"github.com/gnasnik/titan-explorer/core/geo"
+	"github.com/golang-module/carbon/v2"
+)
+
+const (
+	// anomalyHistoryDays IP 变化检测回溯的天数
+	anomalyHistoryDays = 7
+	// anomalyIncomeDays 计算日均收益的天数
+	anomalyIncomeDays = 7
+)
+
+// DetectAnomalies 按规则检测所有节点, 得分超过阈值的节点写入 device_anomaly 表等待审核
+func DetectAnomalies(ctx context.Context) error {
+	log.Infof("start detect anomalies")
+	start := time.Now()
+	defer func() {
+		log.Infof("detect anomalies cost: %v", time.Since(start))
+	}()
+
+	candidates, err := dao.GetAnomalyCandidates(ctx)
+	if err != nil {
+		return err
+	}
+
+	today := carbon.Now().StartOfDay().StdTime()
+
+	history, err := dao.GetDevicesIPHistory(ctx, today.AddDate(0, 0, -anomalyHistoryDays))
+	if err != nil {
+		return err
+	}
+
+	avgIncome, err := dao.GetDevicesAvgIncome(ctx, today.AddDate(0, 0, -anomalyIncomeDays), today)
+	if err != nil {
+		return err
+	}
+
+	ipCount := make(map[string]int)
+	fingerprintCount := make(map[string]int)
+	for _, c := range candidates {
+		if c.ExternalIP != "" {
+			ipCount[c.ExternalIP]++
+		}
+		if fp := deviceFingerprint(c); fp != "" {
+			fingerprintCount[fp]++
+		}
+	}
+
+	locator := newIPLocator()
+	thresholds := anomaly.DefaultThresholds
+
+	var flagged []*model.DeviceAnomaly
+	for _, c := range candidates {
+		signals := &anomaly.Signals{
+			DeviceID:           c.DeviceID,
+			UserID:             c.UserID,
+			NodesOnIP:          ipCount[c.ExternalIP],
+			FingerprintDevices: fingerprintCount[deviceFingerprint(c)],
+			IPHistory:          locator.points(ctx, history[c.DeviceID]),
+			TodayIncome:        c.TodayProfit,
+			AvgIncome:          avgIncome[c.DeviceID],
+		}
+
+		result := anomaly.Evaluate(signals, thresholds)
+		if !result.Flagged(thresholds) {
+			continue
+		}
+
+		evidence, err := json.Marshal(result.Evidence)
+		if err != nil {
+			log.Errorf("marshal evidence: %v", err)
+			continue
+		}
+
+		rules := make([]string, 0, len(result.Evidence))
+		for _, e := range result.Evidence {
+			rules = append(rules, string(e.Rule))
+		}
+
+		flagged = append(flagged, &model.DeviceAnomaly{
+			DeviceID:  c.DeviceID,
+			UserID:    c.UserID,
+			Date:      today,
+			Score:     result.Score,
+			Rules:     strings.Join(rules, ","),
+			Evidence:  string(evidence),
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		})
+	}
+
+	log.Infof("detect anomalies: %d devices checked, %d flagged", len(candidates), len(flagged))
+
+	for i := 0; i < len(flagged); i += maxPageSize {
+		end := i + maxPageSize
+		if end > len(flagged) {
+			end = len(flagged)
+		}
+		if err := dao.BulkUpsertDeviceAnomaly(ctx, flagged[i:end]); err != nil {
+			log.Errorf("BulkUpsertDeviceAnomaly: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// deviceFingerprint 节点的硬件指纹, 没有 MAC 地址的节点不参与比较
+func deviceFingerprint(c *model.AnomalyCandidate) string {
+	if c.MacLocation == "" {
+		return ""
+	}
+	return c.CpuInfo + "|" + c.MacLocation
+}
+
+// ipLocator 缓存一次检测过程中查询过的IP位置
+type ipLocator struct {
+	cache map[string]*anomaly.IPPoint
+}
+
+func newIPLocator() *ipLocator {
+	return &ipLocator{cache: make(map[string]*anomaly.IPPoint)}
+}
+
+// points 把IP记录转换为带位置的点, 只有IP发生过变化的节点才需要查询位置
+func (l *ipLocator) points(ctx context.Context, records []*dao.DeviceIPRecord) []anomaly.IPPoint {
+	changed := false
+	for i := 1; i < len(records); i++ {
+		if records[i].ExternalIP != records[i-1].ExternalIP {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	out := make([]anomaly.IPPoint, 0, len(records))
+	for _, record := range records {
+		point := l.locate(ctx, record.ExternalIP)
+		out = append(out, anomaly.IPPoint{
+			Time:        record.Time,
+			IP:          record.ExternalIP,
+			Latitude:    point.Latitude,
+			Longitude:   point.Longitude,
+			HasLocation: point.HasLocation,
+		})
+	}
+	return out
+}
+
+func (l *ipLocator) locate(ctx context.Context, ip string) *anomaly.IPPoint {
+	if p, ok := l.cache[ip]; ok {
+		return p
+	}
+
+	point := &anomaly.IPPoint{IP: ip}
+	l.cache[ip] = point
+
+	loc, err := geo.GetIpLocation(ctx, ip)
+	if err != nil || loc == nil {
+		return point
+	}
+
+	lat, latErr := strconv.ParseFloat(loc.Latitude, 64)
+	lng, lngErr := strconv.ParseFloat(loc.Longitude, 64)
+	if latErr != nil || lngErr != nil {
+		return point
+	}
+
+	point.Latitude, point.Longitude, point.HasLocation = lat, lng, true
+	return point
+}
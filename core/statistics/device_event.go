@@ -0,0 +1,55 @@
+package statistics
+
+import (
+	"context"
+
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/gnasnik/titan-explorer/core/generated/model"
+)
+
+// AddDeviceChangedEvents 对比拉取到的节点数据和 device_info 中的数据, 把状态, IP, 区域和 NAT 类型的变化记录为节点事件
+func AddDeviceChangedEvents(ctx context.Context, nodes []*model.DeviceInfo) error {
+	deviceIds := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		deviceIds = append(deviceIds, node.DeviceID)
+	}
+
+	snapshots, err := dao.GetDeviceEventSnapshots(ctx, deviceIds)
+	if err != nil {
+		return err
+	}
+
+	var events []*model.DeviceEvent
+	for _, node := range nodes {
+		old, ok := snapshots[node.DeviceID]
+		if !ok {
+			continue
+		}
+
+		add := func(event, oldValue, newValue string) {
+			if oldValue == newValue {
+				return
+			}
+			events = append(events, dao.NewDeviceEvent(node.DeviceID, old.UserID, event, dao.DeviceEventOperatorSystem, oldValue, newValue))
+		}
+
+		add(dao.DeviceEventStatusChange, old.DeviceStatus, node.DeviceStatus)
+
+		// 离线节点只会更新在线状态
+		if node.DeviceStatus == DeviceStatusOffline {
+			continue
+		}
+
+		if node.ExternalIp != "" && old.ExternalIP != "" {
+			add(dao.DeviceEventIPChange, old.ExternalIP, node.ExternalIp)
+		}
+		if old.AreaID != "" {
+			add(dao.DeviceEventAreaChange, old.AreaID, node.AreaID)
+		}
+		if node.NATType != "" && old.NATType != "" {
+			add(dao.DeviceEventNATChange, old.NATType, node.NATType)
+		}
+	}
+
+	return dao.AddDeviceEvents(ctx, events...)
+}
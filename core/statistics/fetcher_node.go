@@ -49,13 +49,15 @@ func newNodeFetcher() Fetcher {
 // 流程如下:
 // 1. 遍历拉取节点的数据, 每次上限为 1000 个(调度器那边设置上限也是1000)
 // 2. 区分在线和离线的节点, 创建一个任务, 任务步骤:
-// 2.1 更新 device_info表, 使用的是 INSERT INTO ... ON DUPLICATE KEY UPDATE ... , 在线的需要更新多个字段, 离线的只更新在线状态为离线
-// 2.2 写入 device_info_hour 表, 每次拉取都会记录到这个表, 5分钟一条记录
-// 2.3 统计每个节点当天的 收益,在线等数据, 并写到 device_info_daily 表, 唯一主键为 device_id  和 time, 每个节点每天增加一条记录
+// 2.1 对比节点的状态, IP, 区域和 NAT 类型, 有变化的写入 device_events 表
+// 2.2 更新 device_info表, 使用的是 INSERT INTO ... ON DUPLICATE KEY UPDATE ... , 在线的需要更新多个字段, 离线的只更新在线状态为离线
+// 2.3 写入 device_info_hour 表, 每次拉取都会记录到这个表, 5分钟一条记录
+// 2.4 统计每个节点当天的 收益,在线等数据, 并写到 device_info_daily 表, 唯一主键为 device_id  和 time, 每个节点每天增加一条记录
 // 3. 把任务 Push 到队列等待执行
 // 4. Finalize 任务, 执行以下统计
 // 4.1 统计每个节点的每日收益,昨日收益,七天收益和月收益等, 更新到 device_info 表
 // 4.2 统计所有节点的总收益,总内存和总的存储等总览页面数据的统计
+// 4.3 重建 Redis 中的节点排行榜
 func (n *NodeFetcher) Fetch(ctx context.Context, scheduler *Scheduler) error {
 	log.Infof("start fetching all nodes from scheduler: %s", scheduler.AreaId)
 	start := time.Now()
@@ -122,6 +124,11 @@ loop:
 	log.Infof("handling %s %d/%d nodes, online: %d offline: %d", scheduler.AreaId, total, resp.Total, len(onlineNodes), len(offlineNodes))
 
 	n.Push(ctx, func() error {
+		// 需要在更新 device_info 之前对比节点的变化
+		if err := AddDeviceChangedEvents(ctx, allNodes); err != nil {
+			log.Errorf("add device changed events: %v", err)
+		}
+
 		if len(onlineNodes) > 0 {
 			err := dao.BulkUpsertDeviceInfo(ctx, onlineNodes)
 			if err != nil {
@@ -189,6 +196,10 @@ func (n *NodeFetcher) Finalize() error {
 		log.Errorf("runGenOnlineIncentive: %v", err)
 	}
 
+	if err := RefreshLeaderboards(context.Background()); err != nil {
+		log.Errorf("RefreshLeaderboards: %v", err)
+	}
+
 	return nil
 }
 
@@ -0,0 +1,78 @@
+package statistics
+
+import (
+	"context"
+	"time"
+
+	"github.com/gnasnik/titan-explorer/core/dao"
+	"github.com/go-redis/redis/v9"
+	"github.com/golang-module/carbon/v2"
+)
+
+// leaderboardSince 排行榜统计周期的开始时间, 总榜返回 nil
+func leaderboardSince(period dao.LeaderboardPeriod) *time.Time {
+	var since time.Time
+	switch period {
+	case dao.LeaderboardPeriodDay:
+		since = carbon.Now().StartOfDay().StdTime()
+	case dao.LeaderboardPeriodWeek:
+		since = carbon.Now().SubDays(6).StartOfDay().StdTime()
+	case dao.LeaderboardPeriodMonth:
+		since = carbon.Now().SubDays(29).StartOfDay().StdTime()
+	default:
+		return nil
+	}
+	return &since
+}
+
+// RefreshLeaderboards 重建所有指标和周期的排行榜, 包括全局, 国家以及区域的排行
+func RefreshLeaderboards(ctx context.Context) error {
+	log.Infof("start refresh leaderboards")
+	start := time.Now()
+	defer func() {
+		log.Infof("refresh leaderboards cost: %v", time.Since(start))
+	}()
+
+	for _, metric := range dao.LeaderboardMetrics {
+		for _, period := range dao.LeaderboardPeriods {
+			scores, err := dao.GetLeaderboardScores(ctx, metric, leaderboardSince(period))
+			if err != nil {
+				log.Errorf("GetLeaderboardScores %s %s: %v", metric, period, err)
+				continue
+			}
+
+			scopes := map[string][]redis.Z{
+				dao.LeaderboardScopeGlobal: make([]redis.Z, 0, len(scores)),
+			}
+			for _, score := range scores {
+				z := redis.Z{Score: score.Score, Member: score.DeviceID}
+				scopes[dao.LeaderboardScopeGlobal] = append(scopes[dao.LeaderboardScopeGlobal], z)
+				if score.IpCountry != "" {
+					scope := dao.LeaderboardCountryScope(score.IpCountry)
+					scopes[scope] = append(scopes[scope], z)
+				}
+				if score.AreaID != "" {
+					scope := dao.LeaderboardAreaScope(score.AreaID)
+					scopes[scope] = append(scopes[scope], z)
+				}
+			}
+
+			for scope, zs := range scopes {
+				if err := dao.SetLeaderboardToCache(ctx, metric, period, scope, zs); err != nil {
+					log.Errorf("SetLeaderboardToCache %s %s %s: %v", metric, period, scope, err)
+				}
+			}
+		}
+	}
+
+	infos, err := dao.GetUserNodesScores(ctx)
+	if err != nil {
+		log.Errorf("GetUserNodesScores: %v", err)
+		return nil
+	}
+	if err = dao.SetUserNodesLeaderboardToCache(ctx, infos); err != nil {
+		log.Errorf("SetUserNodesLeaderboardToCache: %v", err)
+	}
+
+	return nil
+}
@@ -81,6 +81,8 @@ func GetDeviceUserId(ctx context.Context, deviceId string) string {
 		BindStatus: "binding",
 	}); err != nil {
 		log.Errorf("update device binding status: %v", err)
+	} else if err = dao.AddDeviceEvents(ctx, dao.NewDeviceEvent(deviceId, signature.Username, dao.DeviceEventBind, dao.DeviceEventOperatorSystem, "", signature.Username)); err != nil {
+		log.Errorf("add device bind event: %v", err)
 	}
 
 	return signature.Username
@@ -276,7 +278,7 @@ func SumUserReward(ctx context.Context) error {
 	}()
 
 	// 计算所有用户的L2累计收益, 当天收益, 满足条件的节点数量
-	userRewardSum, err := dao.SumAllUsersReward(ctx, config.Cfg.EligibleOnlineMinutes)
+	userRewardSum, err := dao.SumAllUsersReward(ctx, config.Cfg.EligibleOnlineMinutes, config.Cfg.Statistic.ExcludeFlaggedReward)
 	if err != nil {
 		log.Errorf("SumAllUsersReward: %v", err)
 		return err
@@ -91,6 +91,11 @@ func (s *Statistic) Run() {
 	}
 
 	s.cron.AddFunc(s.cfg.Crontab, s.Once("FETCHER", s.runFetchers))
+	if s.cfg.AnomalyCrontab != "" {
+		s.cron.AddFunc(s.cfg.AnomalyCrontab, s.Once("ANOMALY", func() error {
+			return DetectAnomalies(s.ctx)
+		}))
+	}
 	s.cron.Start()
 	s.handleJobs()
 }
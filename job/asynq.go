@@ -32,6 +32,13 @@ func startExplorerServer() {
 	mux.HandleFunc(opasynq.TypeAssetGroupID, deleteAssetGroup)
 	mux.HandleFunc(opasynq.TypeDeleteAssetOperation, deleteAsset)
 	mux.HandleFunc(opasynq.TypeSyncIPFSRecord, operateSyncIPFSRecord)
+	mux.HandleFunc(opasynq.TypeIPFSImport, operateIPFSImport)
+	mux.HandleFunc(opasynq.TypeIPFSExport, operateIPFSExport)
+	mux.HandleFunc(opasynq.TypeCarExport, operateCarExport)
+	mux.HandleFunc(opasynq.TypeAssetLifecycle, operateAssetLifecycle)
+	mux.HandleFunc(opasynq.TypeReplicationReconcile, operateReplicationReconcile)
+	mux.HandleFunc(opasynq.TypeAssetSync, operateAssetSync)
+	mux.HandleFunc(opasynq.TypeAssetPreview, operateAssetPreview)
 
 	if err := srv.Run(mux); err != nil {
 		log.Fatalf("Explorer server encountered an error: %v", err)
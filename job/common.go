@@ -144,15 +144,6 @@ func getSyncSuccessHash(v string) ([]string, error) {
 	for _, vv := range records {
 		if checkSyncState(vv.State) {
 			syncHash = append(syncHash, vv.Hash)
-		} else {
-			// 如果5分钟后还没有同步完成，则删除该区域的同步，重新进行同步
-			if time.Now().After(vv.CreatedTime.Add(5*time.Second)) && dao.CheckAssetHashIsExist(ctx, vv.Hash) {
-				if err = scli.RemoveAssetRecord(ctx, vv.CID); err == nil {
-					if aid, err := dao.GetOneSyncSuccessArea(ctx, vv.Hash); err == nil {
-						oprds.GetClient().PushSchedulerInfo(ctx, &oprds.Payload{CID: vv.CID, Hash: vv.Hash, AreaID: aid})
-					}
-				}
-			}
 		}
 	}
 
@@ -14,6 +14,7 @@ import (
 	"github.com/gnasnik/titan-explorer/core/opasynq"
 	"github.com/gnasnik/titan-explorer/core/oprds"
 	"github.com/gnasnik/titan-explorer/core/storage"
+	"github.com/gnasnik/titan-explorer/pkg/oss"
 	"github.com/go-redsync/redsync/v4"
 	"github.com/go-redsync/redsync/v4/redis/goredis/v9"
 	logging "github.com/ipfs/go-log/v2"
@@ -53,15 +54,15 @@ func SyncShedulersAsset() {
 	// 初始化分布式锁
 	redsync := newRedSync()
 
-	c.AddFunc("@every 10s", func() {
+	c.AddFunc("@every 1m", func() {
 		// 防止同时竞争一把锁
 		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
-		mutex := redsync.NewMutex("syncUserScheduler-lock")
+		mutex := redsync.NewMutex("recoverAssetSyncTasks-lock")
 		if err := mutex.Lock(); err != nil {
-			log.Printf("syncUserScheduler is already running on another instance: %v", err)
+			log.Printf("recoverAssetSyncTasks is already running on another instance: %v", err)
 			return
 		}
-		syncUserScheduler()
+		recoverAssetSyncTasks()
 	})
 	c.AddFunc("@every 10s", func() {
 		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
@@ -72,15 +73,6 @@ func SyncShedulersAsset() {
 		}
 		GetSyncIPFSRecords()
 	})
-	c.AddFunc("@every 15s", func() {
-		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
-		mutex := redsync.NewMutex("syncUnLoginAsset-lock")
-		if err := mutex.Lock(); err != nil {
-			log.Printf("syncUnLoginAsset is already running on another instance: %v", err)
-			return
-		}
-		syncUnLoginAsset()
-	})
 	c.AddFunc("0,10,20,30,40,50 * * * *", func() {
 		// 防止同时竞争一把锁
 		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
@@ -91,7 +83,7 @@ func SyncShedulersAsset() {
 		}
 		syncDashboard()
 	})
-	c.AddFunc("@every 60s", func() {
+	c.AddFunc("@every 10m", func() {
 		// 防止同时竞争一把锁
 		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
 		mutex := redsync.NewMutex("getSyncSuccessAsset-lock")
@@ -101,102 +93,155 @@ func SyncShedulersAsset() {
 		}
 		getSyncSuccessAsset()
 	})
+	c.AddFunc("@every 10m", func() {
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
+		mutex := redsync.NewMutex("purgeExpiredTrash-lock")
+		if err := mutex.Lock(); err != nil {
+			log.Printf("purgeExpiredTrash is already running on another instance: %v", err)
+			return
+		}
+		purgeExpiredTrash()
+	})
+	c.AddFunc("@every 1h", func() {
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
+		mutex := redsync.NewMutex("syncAssetDedupStats-lock")
+		if err := mutex.Lock(); err != nil {
+			log.Printf("syncAssetDedupStats is already running on another instance: %v", err)
+			return
+		}
+		syncAssetDedupStats()
+	})
+	c.AddFunc("@every 1h", func() {
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
+		mutex := redsync.NewMutex("cleanExpiredCarExports-lock")
+		if err := mutex.Lock(); err != nil {
+			log.Printf("cleanExpiredCarExports is already running on another instance: %v", err)
+			return
+		}
+		cleanExpiredCarExports()
+	})
+	c.AddFunc("30 3 * * *", func() {
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
+		mutex := redsync.NewMutex("enqueueAssetLifecycle-lock")
+		if err := mutex.Lock(); err != nil {
+			log.Printf("enqueueAssetLifecycle is already running on another instance: %v", err)
+			return
+		}
+		enqueueAssetLifecycle()
+	})
+	c.AddFunc("20 * * * *", func() {
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
+		mutex := redsync.NewMutex("syncFilBackup-lock")
+		if err := mutex.Lock(); err != nil {
+			log.Printf("syncFilBackup is already running on another instance: %v", err)
+			return
+		}
+		syncFilBackup()
+	})
+	c.AddFunc("50 * * * *", func() {
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
+		mutex := redsync.NewMutex("syncAreaTransferStats-lock")
+		if err := mutex.Lock(); err != nil {
+			log.Printf("syncAreaTransferStats is already running on another instance: %v", err)
+			return
+		}
+		syncAreaTransferStats()
+	})
+	c.AddFunc("40 */6 * * *", func() {
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
+		mutex := redsync.NewMutex("enqueueReplicationReconcile-lock")
+		if err := mutex.Lock(); err != nil {
+			log.Printf("enqueueReplicationReconcile is already running on another instance: %v", err)
+			return
+		}
+		enqueueReplicationReconcile()
+	})
+	c.AddFunc("20 * * * *", func() {
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
+		mutex := redsync.NewMutex("purgeExpiredTempAssets-lock")
+		if err := mutex.Lock(); err != nil {
+			log.Printf("purgeExpiredTempAssets is already running on another instance: %v", err)
+			return
+		}
+		purgeExpiredTempAssets()
+	})
+	c.AddFunc("15 * * * *", func() {
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
+		mutex := redsync.NewMutex("syncAssetAccessDaily-lock")
+		if err := mutex.Lock(); err != nil {
+			log.Printf("syncAssetAccessDaily is already running on another instance: %v", err)
+			return
+		}
+		syncAssetAccessDaily()
+	})
+	c.AddFunc("@every 1m", func() {
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
+		mutex := redsync.NewMutex("checkSubscriptionOrders-lock")
+		if err := mutex.Lock(); err != nil {
+			log.Printf("checkSubscriptionOrders is already running on another instance: %v", err)
+			return
+		}
+		checkSubscriptionOrders()
+	})
+	c.AddFunc("5 * * * *", func() {
+		time.Sleep(time.Duration(rand.Intn(100)) * time.Millisecond)
+		mutex := redsync.NewMutex("expireSubscriptions-lock")
+		if err := mutex.Lock(); err != nil {
+			log.Printf("expireSubscriptions is already running on another instance: %v", err)
+			return
+		}
+		expireSubscriptions()
+	})
 
 	c.Start()
 }
 
-// syncUserScheduler 同步登陆后用户的调度器信息
-func syncUserScheduler() {
-	// 获取 schedulers
+// recoverAssetSyncTasks 把 redis 队列中遗留的同步信息转成同步任务, 并重新塞入超时未处理的任务
+func recoverAssetSyncTasks() {
 	payloads, err := oprds.GetClient().GetAllSchedulerInfos(ctx)
 	if err != nil {
 		cronLog.Errorf("get all scheduler infos error:%v", err)
-		return
 	}
-	wg := new(sync.WaitGroup)
 	for _, v := range payloads {
-		wg.Add(1)
-		go func(v *oprds.Payload) {
-			defer wg.Done()
+		unSyncAids, err := dao.GetUnSyncAreaIDs(ctx, v.UserID, v.Hash)
+		if err != nil {
+			cronLog.Errorf("GetUnSyncAreaIDs error:%v", err)
+			continue
+		}
+		owner := v.Owner
+		if owner == "" {
+			owner = v.UserID
+		}
+		if err = api.EnqueueUserAssetSync(ctx, v.UserID, owner, v.CID, v.Hash, v.AreaID, unSyncAids); err != nil {
+			cronLog.Errorf("EnqueueUserAssetSync error:%v", err)
+			continue
+		}
+		oprds.GetClient().DelSchedulerInfo(ctx, v)
+	}
 
-			scli, err := api.GetSchedulerClient(ctx, v.AreaID)
-			if err != nil {
-				cronLog.Errorf("get client of scheduler error:%v", err)
-				return
-			}
-			// 判断L1节点是否同步完成
-			rs, err := scli.GetAssetRecord(ctx, v.CID)
-			if err != nil {
-				cronLog.Errorf("GetAssetRecord error:%v", err)
-				return
-			}
-			if !checkSyncState(rs.State) {
-				return
-			}
-			unSyncAids, err := dao.GetUnSyncAreaIDs(ctx, v.UserID, v.Hash)
-			if err != nil {
-				cronLog.Errorf("GetUnSyncAreaIDs error:%v", err)
-				return
-			}
-			// 对于已经有的节点不再进行同步，并变更状态
-			aids, err := SyncShedulers(ctx, scli, v.CID, 0, v.Owner, unSyncAids)
-			if err != nil {
-				cronLog.Errorf("SyncShedulers error:%v", err)
-				return
-			}
-			err = dao.UpdateUnSyncAreaIDs(ctx, v.UserID, v.Hash, aids)
-			if err != nil {
-				log.Println(fmt.Errorf("UpdateUnSyncAreaIDs error:%w", err))
-				return
-			}
-			oprds.GetClient().DelSchedulerInfo(ctx, v)
-		}(v)
+	areaPayloads, err := oprds.GetClient().GetAllAreaIDs(ctx)
+	if err != nil {
+		cronLog.Errorf("get all area ids error:%v", err)
+	}
+	for _, v := range areaPayloads {
+		if err = api.EnqueueTempAssetSync(ctx, v.CID, v.Hash, v.AreaIDs); err != nil {
+			cronLog.Errorf("EnqueueTempAssetSync error:%v", err)
+			continue
+		}
+		oprds.GetClient().DelAreaIDs(ctx, v)
 	}
-	wg.Wait()
-}
 
-func syncUnLoginAsset() {
-	// 获取 schedulers
-	payloads, err := oprds.GetClient().GetAllAreaIDs(ctx)
+	// 队列中的任务丢失时 next_run_at 不会再更新
+	tasks, err := dao.GetOverdueAssetSyncTasks(ctx, time.Now().Add(-5*time.Minute), 1000)
 	if err != nil {
-		cronLog.Errorf("get all scheduler infos error:%v", err)
+		cronLog.Errorf("GetOverdueAssetSyncTasks error:%v", err)
 		return
 	}
-	wg := new(sync.WaitGroup)
-	for _, v := range payloads {
-		wg.Add(1)
-		go func(v *oprds.AreaIDPayload) {
-			defer wg.Done()
-
-			scli, err := api.GetSchedulerClient(ctx, v.AreaIDs[0])
-			if err != nil {
-				cronLog.Errorf("get client of scheduler error:%v", err)
-				return
-			}
-			// 判断L1节点是否同步完成
-			rs, err := scli.GetAssetRecord(ctx, v.CID)
-			if err != nil {
-				cronLog.Errorf("GetAssetRecord error:%v", err)
-				return
-			}
-			if len(rs.ReplicaInfos) == 0 {
-				return
-			}
-			aids, err := api.SyncAreaIDs(ctx, scli, "", v.CID, 0, v.AreaIDs[1:])
-			if err != nil {
-				cronLog.Errorf("SyncShedulers error:%v", err)
-				return
-			}
-			aids = append(aids, v.AreaIDs[0])
-			payload := oprds.UnLoginSyncArea{}
-			for _, v := range aids {
-				payload.List = append(payload.List, oprds.UnloginSyncAreaDetail{AreaID: v, IsSync: true})
-			}
-			oprds.GetClient().SetUnloginAssetInfo(ctx, v.Hash, &payload)
-			oprds.GetClient().DelAreaIDs(ctx, v)
-		}(v)
+	for _, t := range tasks {
+		if err = opasynq.DefaultCli.EnqueueAssetSync(ctx, opasynq.AssetSyncPayload{ID: t.ID, Attempt: t.Attempts}, 0); err != nil {
+			cronLog.Errorf("EnqueueAssetSync error:%v", err)
+		}
 	}
-	wg.Wait()
 }
 
 func syncDashboard() {
@@ -249,7 +294,7 @@ func syncDashboard() {
 	}
 }
 
-// getSyncSuccessAsset 更新
+// getSyncSuccessAsset 按调度器上的文件状态更新同步区域, 作为同步任务之外的兜底
 func getSyncSuccessAsset() {
 	var (
 		wg = new(sync.WaitGroup)
@@ -287,6 +332,13 @@ func GetSyncIPFSRecords() {
 		wg          = new(sync.WaitGroup)
 	)
 
+	if err := dao.FailTimeoutIPFSRecords(ctx); err != nil {
+		cronLog.Errorf("FailTimeoutIPFSRecords error:%v", err)
+	}
+	if err := dao.FailStaleIPFSRecords(ctx); err != nil {
+		cronLog.Errorf("FailStaleIPFSRecords error:%v", err)
+	}
+
 	// 获取未同步的ipfs记录，调用调度器去查询文件信息，判断是否上传成功
 	irs, err := dao.GetUnSyncIPFSRecords(ctx)
 	if err != nil {
@@ -319,8 +371,11 @@ func GetSyncIPFSRecords() {
 					cids = append(cids, v.CID)
 				}
 			}
+			if len(cids) == 0 {
+				return
+			}
 			// 获取ipfs文件信息，处理成用户文件表信息
-			ipfsRecords, err := dao.GetIPFSRecordsByCIDs(ctx, cids)
+			ipfsRecords, err := dao.GetIPFSRecordsByCIDs(ctx, cids, k)
 			if err != nil {
 				cronLog.Errorf("GetIPFSRecordsByCIDs error:%v", err)
 				return
@@ -338,3 +393,125 @@ func GetSyncIPFSRecords() {
 	}
 	wg.Wait()
 }
+
+// purgeExpiredTrash 彻底删除超过保留期的回收站数据
+func purgeExpiredTrash() {
+	items, err := dao.GetExpiredAssetTrash(ctx, 100)
+	if err != nil {
+		cronLog.Errorf("GetExpiredAssetTrash error:%v", err)
+		return
+	}
+	if err = api.PurgeAssetTrash(ctx, items); err != nil {
+		cronLog.Errorf("PurgeAssetTrash error:%v", err)
+	}
+}
+
+// syncAssetDedupStats 刷新去重统计
+func syncAssetDedupStats() {
+	areas, err := dao.ComputeAssetDedupAreas(ctx)
+	if err != nil {
+		cronLog.Errorf("ComputeAssetDedupAreas error:%v", err)
+		return
+	}
+	top, err := dao.ComputeAssetDedupTopHashes(ctx, 100)
+	if err != nil {
+		cronLog.Errorf("ComputeAssetDedupTopHashes error:%v", err)
+		return
+	}
+	if err = dao.SaveAssetDedupStats(ctx, areas, top); err != nil {
+		cronLog.Errorf("SaveAssetDedupStats error:%v", err)
+	}
+}
+
+// cleanExpiredCarExports 删除过期的 CAR 导出文件
+func cleanExpiredCarExports() {
+	exports, err := dao.GetExpiredAssetCarExports(ctx, 100)
+	if err != nil {
+		cronLog.Errorf("GetExpiredAssetCarExports error:%v", err)
+		return
+	}
+	for _, e := range exports {
+		if e.ObjectKey != "" && oss.OssInstance != nil {
+			if err = oss.OssInstance.Delete(config.Cfg.Oss.Bucket, e.ObjectKey); err != nil {
+				cronLog.Errorf("delete car export %s error:%v", e.ObjectKey, err)
+				continue
+			}
+		}
+		if err = dao.DeleteAssetCarExport(ctx, e.ID); err != nil {
+			cronLog.Errorf("DeleteAssetCarExport error:%v", err)
+		}
+	}
+}
+
+// enqueueAssetLifecycle 每天为有生命周期规则或到期文件的用户塞入处理任务
+func enqueueAssetLifecycle() {
+	userIds, err := dao.GetAssetLifecycleUserIDs(ctx, time.Now())
+	if err != nil {
+		cronLog.Errorf("GetAssetLifecycleUserIDs error:%v", err)
+		return
+	}
+	for _, userId := range userIds {
+		if err = opasynq.DefaultCli.EnqueueAssetLifecycle(ctx, opasynq.AssetLifecyclePayload{UserID: userId}); err != nil {
+			cronLog.Errorf("EnqueueAssetLifecycle error:%v", err)
+		}
+	}
+}
+
+// syncFilBackup 每小时创建 Filecoin 备份任务并检查订单到期情况
+func syncFilBackup() {
+	if err := api.SyncFilBackupJobs(ctx); err != nil {
+		cronLog.Errorf("SyncFilBackupJobs error:%v", err)
+	}
+	if err := api.CheckFilBackupDeals(ctx); err != nil {
+		cronLog.Errorf("CheckFilBackupDeals error:%v", err)
+	}
+}
+
+// enqueueReplicationReconcile 为有副本策略的用户塞入副本调整任务
+func enqueueReplicationReconcile() {
+	userIds, err := dao.GetReplicationUserIDs(ctx)
+	if err != nil {
+		cronLog.Errorf("GetReplicationUserIDs error:%v", err)
+		return
+	}
+	for _, userId := range userIds {
+		if err = opasynq.DefaultCli.EnqueueReplicationReconcile(ctx, opasynq.ReplicationReconcilePayload{UserID: userId}); err != nil {
+			cronLog.Errorf("EnqueueReplicationReconcile error:%v", err)
+		}
+	}
+}
+
+// syncAreaTransferStats 每小时统计区域的传输表现, 用于选择区域
+func syncAreaTransferStats() {
+	if err := api.SyncAreaTransferStats(ctx); err != nil {
+		cronLog.Errorf("SyncAreaTransferStats error:%v", err)
+	}
+}
+
+// purgeExpiredTempAssets 从调度器删除过期的未登录上传文件
+func purgeExpiredTempAssets() {
+	if err := api.PurgeExpiredTempAssets(ctx); err != nil {
+		cronLog.Errorf("PurgeExpiredTempAssets error:%v", err)
+	}
+}
+
+// syncAssetAccessDaily 汇总文件每天的访问数据
+func syncAssetAccessDaily() {
+	if err := api.SyncAssetAccessDaily(ctx); err != nil {
+		cronLog.Errorf("SyncAssetAccessDaily error:%v", err)
+	}
+}
+
+// checkSubscriptionOrders 检查链上支付的套餐订单
+func checkSubscriptionOrders() {
+	if err := api.CheckSubscriptionOrders(ctx); err != nil {
+		cronLog.Errorf("CheckSubscriptionOrders error:%v", err)
+	}
+}
+
+// expireSubscriptions 降级到期的套餐
+func expireSubscriptions() {
+	if err := api.ExpireSubscriptions(ctx); err != nil {
+		cronLog.Errorf("ExpireSubscriptions error:%v", err)
+	}
+}
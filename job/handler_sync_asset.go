@@ -59,3 +59,100 @@ func operateSyncIPFSRecord(ctx context.Context, t *asynq.Task) error {
 
 	return nil
 }
+
+// operateIPFSImport 处理ipfs导入任务
+func operateIPFSImport(ctx context.Context, t *asynq.Task) error {
+	var payload opasynq.IPFSTaskPayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return err
+	}
+
+	if err := api.RunIPFSImport(ctx, payload.ID); err != nil {
+		log.Println(fmt.Errorf("RunIPFSImport error:%w", err))
+		return err
+	}
+	return nil
+}
+
+// operateIPFSExport 处理导出文件到ipfs的任务
+func operateIPFSExport(ctx context.Context, t *asynq.Task) error {
+	var payload opasynq.IPFSTaskPayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return err
+	}
+
+	if err := api.RunIPFSExport(ctx, payload.ID); err != nil {
+		log.Println(fmt.Errorf("RunIPFSExport error:%w", err))
+		return err
+	}
+	return nil
+}
+
+// operateCarExport 处理文件组导出为 CAR 的任务
+func operateCarExport(ctx context.Context, t *asynq.Task) error {
+	var payload opasynq.CarExportPayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return err
+	}
+
+	if err := api.RunCarExport(ctx, payload.ID); err != nil {
+		log.Println(fmt.Errorf("RunCarExport error:%w", err))
+		return err
+	}
+	return nil
+}
+
+// operateAssetLifecycle 处理用户文件的生命周期规则
+func operateAssetLifecycle(ctx context.Context, t *asynq.Task) error {
+	var payload opasynq.AssetLifecyclePayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return err
+	}
+
+	if err := api.RunAssetLifecycle(ctx, payload.UserID); err != nil {
+		log.Println(fmt.Errorf("RunAssetLifecycle error:%w", err))
+		return err
+	}
+	return nil
+}
+
+// operateReplicationReconcile 按副本策略调整用户文件的区域
+func operateReplicationReconcile(ctx context.Context, t *asynq.Task) error {
+	var payload opasynq.ReplicationReconcilePayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return err
+	}
+
+	if err := api.RunReplicationReconcile(ctx, payload.UserID); err != nil {
+		log.Println(fmt.Errorf("RunReplicationReconcile error:%w", err))
+		return err
+	}
+	return nil
+}
+
+func operateAssetSync(ctx context.Context, t *asynq.Task) error {
+	var payload opasynq.AssetSyncPayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return err
+	}
+
+	if err := api.RunAssetSync(ctx, payload.ID, payload.Attempt); err != nil {
+		log.Println(fmt.Errorf("RunAssetSync error:%w", err))
+		return err
+	}
+	return nil
+}
+
+// operateAssetPreview 生成文件的缩略图或视频封面
+func operateAssetPreview(ctx context.Context, t *asynq.Task) error {
+	var payload opasynq.AssetPreviewPayload
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return err
+	}
+
+	if err := api.RunAssetPreview(ctx, payload.UserID, payload.Hash); err != nil {
+		log.Println(fmt.Errorf("RunAssetPreview error:%w", err))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package carutil
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ft "github.com/ipfs/boxo/ipld/unixfs"
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	car "github.com/ipld/go-car/v2"
+)
+
+// ErrTooManyBlocks CAR 的块数超过了上限
+var ErrTooManyBlocks = errors.New("too many blocks in car")
+
+// RootInfo CAR 中一个根的信息
+type RootInfo struct {
+	Cid cid.Cid
+	// Size 根可达的所有块的数据大小之和, 重复的块只计算一次
+	Size   uint64
+	Blocks int
+	// Complete 根可达的块都在 CAR 中
+	Complete bool
+}
+
+type blockInfo struct {
+	size  uint64
+	links []cid.Cid
+}
+
+// ReadRoots 读取 CAR 的所有根, 并根据 CAR 中的块计算每个根的大小, 块数超过 maxBlocks 时返回 ErrTooManyBlocks
+func ReadRoots(r io.Reader, maxBlocks int) ([]*RootInfo, error) {
+	br, err := car.NewBlockReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("read car header error:%w", err)
+	}
+
+	blocks := make(map[cid.Cid]*blockInfo)
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read car block error:%w", err)
+		}
+
+		links, err := blockLinks(blk.Cid(), blk.RawData())
+		if err != nil {
+			return nil, err
+		}
+		blocks[blk.Cid()] = &blockInfo{size: uint64(len(blk.RawData())), links: links}
+
+		if maxBlocks > 0 && len(blocks) > maxBlocks {
+			return nil, ErrTooManyBlocks
+		}
+	}
+
+	out := make([]*RootInfo, 0, len(br.Roots))
+	for _, root := range br.Roots {
+		info := &RootInfo{Cid: root, Complete: true}
+		visited := map[cid.Cid]struct{}{root: {}}
+		queue := []cid.Cid{root}
+		for len(queue) > 0 {
+			c := queue[0]
+			queue = queue[1:]
+
+			b, ok := blocks[c]
+			if !ok {
+				info.Complete = false
+				continue
+			}
+			info.Size += b.size
+			info.Blocks++
+			for _, l := range b.links {
+				if _, ok := visited[l]; ok {
+					continue
+				}
+				visited[l] = struct{}{}
+				queue = append(queue, l)
+			}
+		}
+		out = append(out, info)
+	}
+
+	return out, nil
+}
+
+// blockLinks 解析块中的链接, 只处理 dag-pb, 其他编码的块当作叶子节点
+func blockLinks(c cid.Cid, data []byte) ([]cid.Cid, error) {
+	if c.Prefix().Codec != cid.DagProtobuf {
+		return nil, nil
+	}
+
+	nd, err := merkledag.DecodeProtobuf(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode block %s error:%w", c, err)
+	}
+
+	links := make([]cid.Cid, 0, len(nd.Links()))
+	for _, l := range nd.Links() {
+		links = append(links, l.Cid)
+	}
+	return links, nil
+}
+
+// Writer 按 CARv1 格式写入块, 重复的块只写一次
+type Writer struct {
+	w       io.Writer
+	seen    map[cid.Cid]struct{}
+	written int64
+}
+
+// NewWriter 新建 CAR 写入器并写入头部
+func NewWriter(w io.Writer, roots ...cid.Cid) (*Writer, error) {
+	cw := &Writer{w: w, seen: make(map[cid.Cid]struct{})}
+	if err := cw.writeSection(encodeHeader(roots)); err != nil {
+		return nil, fmt.Errorf("write car header error:%w", err)
+	}
+	return cw, nil
+}
+
+// Put 写入一个块
+func (w *Writer) Put(c cid.Cid, data []byte) error {
+	if _, ok := w.seen[c]; ok {
+		return nil
+	}
+	w.seen[c] = struct{}{}
+	return w.writeSection(c.Bytes(), data)
+}
+
+// PutNode 写入一个 ipld 节点
+func (w *Writer) PutNode(nd format.Node) error {
+	return w.Put(nd.Cid(), nd.RawData())
+}
+
+// CopyFrom 把另一个 CAR 中的所有块写入, 返回其根
+func (w *Writer) CopyFrom(r io.Reader) ([]cid.Cid, error) {
+	br, err := car.NewBlockReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("read car header error:%w", err)
+	}
+
+	for {
+		blk, err := br.Next()
+		if err == io.EOF {
+			return br.Roots, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read car block error:%w", err)
+		}
+		if err = w.Put(blk.Cid(), blk.RawData()); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Size 已写入的字节数
+func (w *Writer) Size() int64 {
+	return w.written
+}
+
+func (w *Writer) writeSection(parts ...[]byte) error {
+	var n int
+	for _, p := range parts {
+		n += len(p)
+	}
+
+	buf := make([]byte, binary.MaxVarintLen64)
+	l := binary.PutUvarint(buf, uint64(n))
+	if _, err := w.w.Write(buf[:l]); err != nil {
+		return err
+	}
+	w.written += int64(l)
+
+	for _, p := range parts {
+		if _, err := w.w.Write(p); err != nil {
+			return err
+		}
+		w.written += int64(len(p))
+	}
+	return nil
+}
+
+// encodeHeader 按 dag-cbor 编码 CARv1 头部 {roots: [...], version: 1}
+func encodeHeader(roots []cid.Cid) []byte {
+	out := []byte{0xa2}
+	out = append(out, cborHead(3, 5)...)
+	out = append(out, "roots"...)
+	out = append(out, cborHead(4, uint64(len(roots)))...)
+	for _, r := range roots {
+		b := r.Bytes()
+		// cid 使用 tag 42, 内容为 0x00 加上 cid 的二进制
+		out = append(out, 0xd8, 0x2a)
+		out = append(out, cborHead(2, uint64(len(b)+1))...)
+		out = append(out, 0x00)
+		out = append(out, b...)
+	}
+	out = append(out, cborHead(3, 7)...)
+	out = append(out, "version"...)
+	return append(out, 0x01)
+}
+
+func cborHead(major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return []byte{major | byte(n)}
+	case n <= 0xff:
+		return []byte{major | 24, byte(n)}
+	case n <= 0xffff:
+		return []byte{major | 25, byte(n >> 8), byte(n)}
+	case n <= 0xffffffff:
+		return []byte{major | 26, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+	default:
+		b := make([]byte, 9)
+		b[0] = major | 27
+		binary.BigEndian.PutUint64(b[1:], n)
+		return b
+	}
+}
+
+// Link 目录中的一项
+type Link struct {
+	Name string
+	Cid  cid.Cid
+	Size uint64
+}
+
+// NewDirNode 用链接创建 unixfs 目录节点, 名称需要唯一
+func NewDirNode(links []Link) (*merkledag.ProtoNode, error) {
+	nd := ft.EmptyDirNode()
+	for _, l := range links {
+		if err := nd.AddRawLink(l.Name, &format.Link{Name: l.Name, Cid: l.Cid, Size: l.Size}); err != nil {
+			return nil, fmt.Errorf("add link %s error:%w", l.Name, err)
+		}
+	}
+	return nd, nil
+}
+
+// NewRawNode 用数据创建 raw 节点, 用于清单等小文件
+func NewRawNode(data []byte) format.Node {
+	return merkledag.NewRawNode(data)
+}
@@ -0,0 +1,128 @@
+package carutil
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	car "github.com/ipld/go-car/v2"
+)
+
+func TestWriteAndReadRoots(t *testing.T) {
+	leaf1 := merkledag.NewRawNode([]byte("hello"))
+	leaf2 := merkledag.NewRawNode([]byte("world!"))
+	file, err := NewDirNode([]Link{
+		{Name: "a", Cid: leaf1.Cid(), Size: 5},
+		{Name: "b", Cid: leaf2.Cid(), Size: 6},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := merkledag.NewRawNode([]byte("missing"))
+	partial, err := NewDirNode([]Link{
+		{Name: "b", Cid: leaf2.Cid(), Size: 6},
+		{Name: "c", Cid: missing.Cid(), Size: 7},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, file.Cid(), partial.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		w.PutNode(file), w.PutNode(partial), w.PutNode(leaf1), w.PutNode(leaf2), w.PutNode(leaf2),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w.Size() != int64(buf.Len()) {
+		t.Fatalf("size %d, written %d", w.Size(), buf.Len())
+	}
+
+	// 写出的 CAR 需要能被 go-car 读取, 重复的块只写一次
+	br, err := car.NewBlockReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	for {
+		_, err := br.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+	}
+	if count != 4 {
+		t.Fatalf("expected 4 blocks, got %d", count)
+	}
+
+	roots, err := ReadRoots(bytes.NewReader(buf.Bytes()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 2 {
+		t.Fatalf("expected 2 roots, got %d", len(roots))
+	}
+
+	if !roots[0].Cid.Equals(file.Cid()) || !roots[0].Complete || roots[0].Blocks != 3 ||
+		roots[0].Size != uint64(len(file.RawData())+5+6) {
+		t.Fatalf("unexpected root: %+v", roots[0])
+	}
+	if roots[1].Complete || roots[1].Blocks != 2 || roots[1].Size != uint64(len(partial.RawData())+6) {
+		t.Fatalf("unexpected root: %+v", roots[1])
+	}
+
+	if _, err = ReadRoots(bytes.NewReader(buf.Bytes()), 2); err != ErrTooManyBlocks {
+		t.Fatalf("expected ErrTooManyBlocks, got %v", err)
+	}
+}
+
+func TestCopyFrom(t *testing.T) {
+	leaf := merkledag.NewRawNode([]byte("data"))
+
+	var src bytes.Buffer
+	w, err := NewWriter(&src, leaf.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.PutNode(leaf); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := NewDirNode([]Link{{Name: "data", Cid: leaf.Cid(), Size: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dst bytes.Buffer
+	w, err = NewWriter(&dst, dir.Cid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.PutNode(dir); err != nil {
+		t.Fatal(err)
+	}
+	roots, err := w.CopyFrom(bytes.NewReader(src.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 1 || !roots[0].Equals(leaf.Cid()) {
+		t.Fatalf("unexpected roots: %v", roots)
+	}
+
+	infos, err := ReadRoots(bytes.NewReader(dst.Bytes()), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || !infos[0].Complete || infos[0].Blocks != 2 {
+		t.Fatalf("unexpected roots: %+v", infos)
+	}
+}
@@ -0,0 +1,144 @@
+package opfie
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// ErrTooManyBlocks DAG 的块数超过了遍历上限
+var ErrTooManyBlocks = errors.New("too many blocks in dag")
+
+// Node ipfs节点, 本地测试时可以用其他实现替代 IPFSClient
+type Node interface {
+	AddFileByCID(ctx context.Context, cid string) error
+	GetInfoByCID(ctx context.Context, cid string) ([]*format.Link, uint64, error)
+}
+
+// Pool 多个ipfs节点, 请求失败时依次切换到下一个节点
+type Pool struct {
+	lk      sync.Mutex
+	nodes   []Node
+	current int
+}
+
+// NewPool 通过节点地址新建ipfs节点池
+func NewPool(urls []string) (*Pool, error) {
+	var nodes []Node
+	for _, url := range urls {
+		cli, err := NewIPFSClient(url)
+		if err != nil {
+			return nil, fmt.Errorf("new ipfs client %s error:%w", url, err)
+		}
+		nodes = append(nodes, cli)
+	}
+	return NewPoolWithNodes(nodes...)
+}
+
+// NewPoolWithNodes 通过已有的节点新建ipfs节点池
+func NewPoolWithNodes(nodes ...Node) (*Pool, error) {
+	if len(nodes) == 0 {
+		return nil, errors.New("no ipfs node")
+	}
+	return &Pool{nodes: nodes}, nil
+}
+
+// do 从上次成功的节点开始依次尝试, 全部失败时返回最后一个错误
+func (p *Pool) do(fn func(Node) error) error {
+	p.lk.Lock()
+	start := p.current
+	p.lk.Unlock()
+
+	var err error
+	for i := 0; i < len(p.nodes); i++ {
+		idx := (start + i) % len(p.nodes)
+		if err = fn(p.nodes[idx]); err == nil {
+			p.lk.Lock()
+			p.current = idx
+			p.lk.Unlock()
+			return nil
+		}
+	}
+	return err
+}
+
+// AddFileByCID 通过cid在节点上pin文件
+func (p *Pool) AddFileByCID(ctx context.Context, cid string) error {
+	return p.do(func(n Node) error {
+		return n.AddFileByCID(ctx, cid)
+	})
+}
+
+// GetInfoByCID 通过cid获取文件信息
+func (p *Pool) GetInfoByCID(ctx context.Context, cid string) (links []*format.Link, size uint64, err error) {
+	err = p.do(func(n Node) error {
+		var e error
+		links, size, e = n.GetInfoByCID(ctx, cid)
+		return e
+	})
+	return links, size, err
+}
+
+// DagStat DAG 的统计信息
+type DagStat struct {
+	// Name 根节点的第一个链接名, 通常是目录中的文件名
+	Name string
+	// Size 去掉重复块后所有块的大小之和
+	Size uint64
+	// Blocks 不重复的块数
+	Blocks int
+}
+
+// StatDag 遍历 DAG 的所有链接计算实际大小, 块数超过 maxBlocks 时返回 ErrTooManyBlocks
+func (p *Pool) StatDag(ctx context.Context, root string, maxBlocks int) (*DagStat, error) {
+	rootCid, err := cid.Decode(root)
+	if err != nil {
+		return nil, fmt.Errorf("decode cid error:%w", err)
+	}
+
+	stat := &DagStat{}
+	visited := map[cid.Cid]struct{}{rootCid: {}}
+	queue := []cid.Cid{rootCid}
+
+	for len(queue) > 0 {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		c := queue[0]
+		queue = queue[1:]
+
+		links, size, err := p.GetInfoByCID(ctx, c.String())
+		if err != nil {
+			return nil, err
+		}
+		if c == rootCid && len(links) > 0 {
+			stat.Name = links[0].Name
+		}
+
+		// dag-pb 节点的大小包含了所有子节点, 减去子节点后得到块自身的大小
+		var linked uint64
+		for _, l := range links {
+			linked += l.Size
+			if _, ok := visited[l.Cid]; ok {
+				continue
+			}
+			visited[l.Cid] = struct{}{}
+			queue = append(queue, l.Cid)
+		}
+		if size > linked {
+			stat.Size += size - linked
+		}
+
+		stat.Blocks++
+		if maxBlocks > 0 && stat.Blocks+len(queue) > maxBlocks {
+			return nil, ErrTooManyBlocks
+		}
+	}
+
+	return stat, nil
+}
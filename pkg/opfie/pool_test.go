@@ -0,0 +1,115 @@
+package opfie
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	format "github.com/ipfs/go-ipld-format"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// localNode 本地的ipfs节点, 块之间的链接保存在内存中
+type localNode struct {
+	down   bool
+	calls  int
+	pinned []string
+	blocks map[string]localBlock
+}
+
+type localBlock struct {
+	size  uint64
+	links []*format.Link
+}
+
+func (n *localNode) AddFileByCID(ctx context.Context, c string) error {
+	n.calls++
+	if n.down {
+		return errors.New("connection refused")
+	}
+	n.pinned = append(n.pinned, c)
+	return nil
+}
+
+func (n *localNode) GetInfoByCID(ctx context.Context, c string) ([]*format.Link, uint64, error) {
+	n.calls++
+	if n.down {
+		return nil, 0, errors.New("connection refused")
+	}
+	b, ok := n.blocks[c]
+	if !ok {
+		return nil, 0, errors.New("not found")
+	}
+	return b.links, b.size, nil
+}
+
+func testCid(t *testing.T, data string) cid.Cid {
+	hash, err := mh.Sum([]byte(data), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cid.NewCidV1(cid.DagProtobuf, hash)
+}
+
+func TestPoolFailover(t *testing.T) {
+	down := &localNode{down: true}
+	up := &localNode{}
+
+	pool, err := NewPoolWithNodes(down, up)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = pool.AddFileByCID(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = pool.AddFileByCID(context.Background(), "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 切换到可用节点后不再请求失败的节点
+	if down.calls != 1 || len(up.pinned) != 2 {
+		t.Fatalf("unexpected calls: down=%d up=%v", down.calls, up.pinned)
+	}
+
+	up.down = true
+	if err = pool.AddFileByCID(context.Background(), "c"); err == nil {
+		t.Fatal("expected error when all nodes are down")
+	}
+}
+
+func TestPoolStatDag(t *testing.T) {
+	root, file, leaf1, leaf2 := testCid(t, "root"), testCid(t, "file"), testCid(t, "leaf1"), testCid(t, "leaf2")
+
+	// leaf2 被引用两次, 只计算一次
+	node := &localNode{blocks: map[string]localBlock{
+		leaf1.String(): {size: 100},
+		leaf2.String(): {size: 50},
+		file.String(): {size: 160, links: []*format.Link{
+			{Cid: leaf1, Size: 100},
+			{Cid: leaf2, Size: 50},
+		}},
+		root.String(): {size: 225, links: []*format.Link{
+			{Name: "a.txt", Cid: file, Size: 160},
+			{Name: "b.txt", Cid: leaf2, Size: 50},
+		}},
+	}}
+
+	pool, err := NewPoolWithNodes(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stat, err := pool.StatDag(context.Background(), root.String(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Name != "a.txt" || stat.Blocks != 4 || stat.Size != 15+10+100+50 {
+		t.Fatalf("unexpected stat: %+v", stat)
+	}
+
+	if _, err = pool.StatDag(context.Background(), root.String(), 2); err != ErrTooManyBlocks {
+		t.Fatalf("expected ErrTooManyBlocks, got %v", err)
+	}
+}
@@ -19,6 +19,7 @@ var (
 type OssAPI interface {
 	SignUrl(bucket, objectKey string, expire int64) (string, error)
 	Upload(bucket, obj string, buf io.Reader) error
+	Delete(bucket, obj string) error
 }
 
 type ossAPI struct {
@@ -66,6 +67,15 @@ func (o *ossAPI) Upload(bucket, obj string, buf io.Reader) error {
 	return bk.PutObject(obj, buf, oss.ObjectACL(oss.ACLPublicRead))
 }
 
+func (o *ossAPI) Delete(bucket, obj string) error {
+	bk, err := o.client.Bucket(bucket)
+	if err != nil {
+		return err
+	}
+
+	return bk.DeleteObject(obj)
+}
+
 func (o *ossAPI) SignUrl(bucket, objectKey string, expire int64) (string, error) {
 	bk, err := o.client.Bucket(bucket)
 	if err != nil {
@@ -0,0 +1,120 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	pageWidth    = 595 // A4, 单位 pt
+	pageHeight   = 842
+	marginLeft   = 50
+	marginTop    = 60
+	lineHeight   = 14
+	fontSize     = 10
+	linesPerPage = (pageHeight - 2*marginTop) / lineHeight
+)
+
+// Document 一个只包含等宽文本行的简单 PDF 文档, 用于导出报表, 只支持 ASCII 字符
+type Document struct {
+	lines []string
+}
+
+// New 创建一个空文档
+func New() *Document {
+	return &Document{}
+}
+
+// AddLine 追加一行文本
+func (d *Document) AddLine(format string, args ...interface{}) {
+	d.lines = append(d.lines, fmt.Sprintf(format, args...))
+}
+
+// WriteTo 把文档按 PDF 1.4 格式写入 w
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	pages := d.pages()
+
+	var (
+		buf     bytes.Buffer
+		offsets []int
+	)
+
+	addObject := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+
+	// 1: catalog, 2: pages, 3: font, 之后每页占用 page 和 content 两个对象
+	kids := make([]string, 0, len(pages))
+	for i := range pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 4+i*2))
+	}
+
+	addObject("<< /Type /Catalog /Pages 2 0 R >>")
+	addObject(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	addObject("<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>")
+
+	for i, lines := range pages {
+		content := pageContent(lines)
+		addObject(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 5+i*2))
+		addObject(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+func (d *Document) pages() [][]string {
+	var pages [][]string
+	for start := 0; start < len(d.lines); start += linesPerPage {
+		end := start + linesPerPage
+		if end > len(d.lines) {
+			end = len(d.lines)
+		}
+		pages = append(pages, d.lines[start:end])
+	}
+
+	if len(pages) == 0 {
+		pages = append(pages, []string{})
+	}
+
+	return pages
+}
+
+func pageContent(lines []string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "BT /F1 %d Tf %d TL %d %d Td", fontSize, lineHeight, marginLeft, pageHeight-marginTop)
+	for _, line := range lines {
+		fmt.Fprintf(&sb, " (%s) Tj T*", escape(line))
+	}
+	sb.WriteString(" ET")
+	return sb.String()
+}
+
+func escape(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r < 32 || r > 126:
+			sb.WriteByte('?')
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
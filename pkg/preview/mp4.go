@@ -0,0 +1,143 @@
+package preview
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// maxMoovSize moov 最多读取的字节数, 超过时不解析
+const maxMoovSize = 16 << 20
+
+// VideoInfo 视频的时长和画面尺寸
+type VideoInfo struct {
+	Duration time.Duration
+	Width    int
+	Height   int
+}
+
+// ReadMP4Info 从 mp4 的 moov 中读取时长和画面尺寸, 只读取 box 头和 moov, 适合按范围读取的远程文件
+func ReadMP4Info(r io.ReaderAt, size int64) (*VideoInfo, error) {
+	var offset int64
+	hdr := make([]byte, 16)
+	for offset+8 <= size {
+		if _, err := r.ReadAt(hdr[:8], offset); err != nil {
+			return nil, fmt.Errorf("read box header error:%w", err)
+		}
+		boxSize := int64(binary.BigEndian.Uint32(hdr[:4]))
+		boxType := string(hdr[4:8])
+		headerSize := int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - offset
+		case 1:
+			if _, err := r.ReadAt(hdr[8:16], offset+8); err != nil {
+				return nil, fmt.Errorf("read box header error:%w", err)
+			}
+			boxSize = int64(binary.BigEndian.Uint64(hdr[8:16]))
+			headerSize = 16
+		}
+		if boxSize < headerSize {
+			return nil, ErrUnsupported
+		}
+
+		if boxType == "moov" {
+			if boxSize-headerSize > maxMoovSize {
+				return nil, ErrUnsupported
+			}
+			moov := make([]byte, boxSize-headerSize)
+			if _, err := r.ReadAt(moov, offset+headerSize); err != nil {
+				return nil, fmt.Errorf("read moov error:%w", err)
+			}
+			return parseMoov(moov)
+		}
+		offset += boxSize
+	}
+	return nil, ErrUnsupported
+}
+
+// mp4Boxes 遍历 data 中的子 box
+func mp4Boxes(data []byte, fn func(typ string, body []byte)) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		typ := string(data[4:8])
+		header := uint64(8)
+		if size == 1 {
+			if len(data) < 16 {
+				return
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < header || size > uint64(len(data)) {
+			return
+		}
+		fn(typ, data[header:size])
+		data = data[size:]
+	}
+}
+
+func parseMoov(moov []byte) (*VideoInfo, error) {
+	info := &VideoInfo{}
+	var found bool
+	mp4Boxes(moov, func(typ string, body []byte) {
+		switch typ {
+		case "mvhd":
+			info.Duration = parseMvhd(body)
+		case "trak":
+			if found {
+				return
+			}
+			if w, h, ok := parseVideoTrak(body); ok {
+				info.Width, info.Height, found = w, h, true
+			}
+		}
+	})
+	if !found && info.Duration == 0 {
+		return nil, ErrUnsupported
+	}
+	return info, nil
+}
+
+func parseMvhd(body []byte) time.Duration {
+	var timescale, duration uint64
+	switch {
+	case len(body) >= 32 && body[0] == 1:
+		timescale = uint64(binary.BigEndian.Uint32(body[20:24]))
+		duration = binary.BigEndian.Uint64(body[24:32])
+	case len(body) >= 20:
+		timescale = uint64(binary.BigEndian.Uint32(body[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(body[16:20]))
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+}
+
+// parseVideoTrak 读取视频轨道 tkhd 中的宽高, 宽高是 16.16 定点数
+func parseVideoTrak(trak []byte) (int, int, bool) {
+	var (
+		w, h    int
+		isVideo bool
+	)
+	mp4Boxes(trak, func(typ string, body []byte) {
+		switch typ {
+		case "tkhd":
+			if len(body) >= 8 {
+				w = int(binary.BigEndian.Uint32(body[len(body)-8:]) >> 16)
+				h = int(binary.BigEndian.Uint32(body[len(body)-4:]) >> 16)
+			}
+		case "mdia":
+			mp4Boxes(body, func(typ string, body []byte) {
+				if typ == "hdlr" && len(body) >= 12 && string(body[8:12]) == "vide" {
+					isVideo = true
+				}
+			})
+		}
+	})
+	return w, h, isVideo && w > 0 && h > 0
+}
@@ -0,0 +1,133 @@
+package preview
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"os/exec"
+	"path"
+	"strings"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// 文件的预览类型
+const (
+	KindImage = "image"
+	KindVideo = "video"
+)
+
+// ErrUnsupported 不支持生成预览的格式
+var ErrUnsupported = errors.New("unsupported format")
+
+var (
+	imageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".bmp": true, ".tif": true, ".tiff": true}
+	videoExts = map[string]bool{".mp4": true, ".m4v": true, ".mov": true, ".webm": true, ".mkv": true, ".avi": true}
+	mp4Exts   = map[string]bool{".mp4": true, ".m4v": true, ".mov": true}
+)
+
+// Kind 根据文件名判断预览类型, 不支持时返回空
+func Kind(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	switch {
+	case imageExts[ext]:
+		return KindImage
+	case videoExts[ext]:
+		return KindVideo
+	}
+	return ""
+}
+
+// IsMP4 文件是否是 mp4 容器, 只有 mp4 容器可以直接读取视频信息
+func IsMP4(name string) bool {
+	return mp4Exts[strings.ToLower(path.Ext(name))]
+}
+
+// Image 生成的预览图和原图的尺寸
+type Image struct {
+	Data   []byte
+	Width  int
+	Height int
+}
+
+// Thumbnail 生成最长边不超过 maxSide 的 jpeg 缩略图, 小图不放大
+// 先读取图片头, 像素数超过 maxPixels 时不解码, 返回 ErrUnsupported
+func Thumbnail(r io.Reader, maxSide, maxPixels int) (*Image, error) {
+	var head bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, &head))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, ErrUnsupported
+		}
+		return nil, fmt.Errorf("decode image config error:%w", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > int64(maxPixels) {
+		return nil, ErrUnsupported
+	}
+
+	src, _, err := image.Decode(io.MultiReader(&head, r))
+	if err != nil {
+		if errors.Is(err, image.ErrFormat) {
+			return nil, ErrUnsupported
+		}
+		return nil, fmt.Errorf("decode image error:%w", err)
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return nil, ErrUnsupported
+	}
+
+	tw, th := w, h
+	if w > maxSide || h > maxSide {
+		if w >= h {
+			tw, th = maxSide, max(h*maxSide/w, 1)
+		} else {
+			tw, th = max(w*maxSide/h, 1), maxSide
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, fmt.Errorf("encode thumbnail error:%w", err)
+	}
+	return &Image{Data: buf.Bytes(), Width: w, Height: h}, nil
+}
+
+// Poster 用 ffmpeg 截取视频第 1 秒的画面作为封面, 没有 ffmpeg 时返回 ErrUnsupported
+func Poster(ctx context.Context, ffmpeg, url string, maxSide int) ([]byte, error) {
+	if ffmpeg == "" {
+		ffmpeg = "ffmpeg"
+	}
+	bin, err := exec.LookPath(ffmpeg)
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, bin, "-v", "error", "-ss", "1", "-i", url, "-frames:v", "1",
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':-2", maxSide), "-f", "image2", "-c:v", "mjpeg", "pipe:1")
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err = cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg error:%w, %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("ffmpeg output is empty")
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,91 @@
+package preview
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+	"time"
+)
+
+func TestThumbnail(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	for x := 0; x < 800; x++ {
+		src.Set(x, x%400, color.RGBA{R: 255, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+	img, err := Thumbnail(bytes.NewReader(data), 320, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Width != 800 || img.Height != 400 {
+		t.Fatalf("source size %dx%d", img.Width, img.Height)
+	}
+	thumb, err := jpeg.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := thumb.Bounds(); b.Dx() != 320 || b.Dy() != 160 {
+		t.Fatalf("thumbnail size %dx%d", b.Dx(), b.Dy())
+	}
+
+	if _, err = Thumbnail(bytes.NewReader([]byte("not an image")), 320, 1<<20); err != ErrUnsupported {
+		t.Fatalf("expect ErrUnsupported, got %v", err)
+	}
+	if _, err = Thumbnail(bytes.NewReader(data), 320, 800*400-1); err != ErrUnsupported {
+		t.Fatalf("expect ErrUnsupported for too many pixels, got %v", err)
+	}
+}
+
+func mp4Box(typ string, body ...[]byte) []byte {
+	data := bytes.Join(body, nil)
+	out := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint32(out, uint32(8+len(data)))
+	copy(out[4:], typ)
+	return append(out, data...)
+}
+
+func TestReadMP4Info(t *testing.T) {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 12500)
+
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:], 1280<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], 720<<16)
+	hdlr := make([]byte, 24)
+	copy(hdlr[8:], "vide")
+	soun := make([]byte, 24)
+	copy(soun[8:], "soun")
+
+	// 音频轨道在前, moov 在 mdat 之后
+	file := bytes.Join([][]byte{
+		mp4Box("ftyp", []byte("isom")),
+		mp4Box("mdat", make([]byte, 1024)),
+		mp4Box("moov",
+			mp4Box("mvhd", mvhd),
+			mp4Box("trak", mp4Box("tkhd", make([]byte, 84)), mp4Box("mdia", mp4Box("hdlr", soun))),
+			mp4Box("trak", mp4Box("tkhd", tkhd), mp4Box("mdia", mp4Box("hdlr", hdlr))),
+		),
+	}, nil)
+
+	info, err := ReadMP4Info(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Duration != 12500*time.Millisecond || info.Width != 1280 || info.Height != 720 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	if _, err = ReadMP4Info(bytes.NewReader(file[:len(file)/2]), int64(len(file)/2)); err != ErrUnsupported {
+		t.Fatalf("expect ErrUnsupported, got %v", err)
+	}
+}
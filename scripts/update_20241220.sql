@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS `device_group` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `name` varchar(128) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_user_name` (`user_id`, `name`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '用户节点分组';
+
+CREATE TABLE IF NOT EXISTS `device_group_member` (
+    `device_id` varchar(128) NOT NULL DEFAULT '',
+    `group_id` bigint(20) NOT NULL DEFAULT 0,
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`device_id`),
+    KEY `idx_group_id` (`group_id`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '节点所属分组, 每个节点只能属于一个分组';
+
+CREATE TABLE IF NOT EXISTS `device_tag` (
+    `device_id` varchar(128) NOT NULL DEFAULT '',
+    `tag` varchar(64) NOT NULL DEFAULT '',
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`device_id`, `tag`),
+    KEY `idx_user_tag` (`user_id`, `tag`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '节点标签';
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS `device_anomaly` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `device_id` varchar(128) NOT NULL DEFAULT '',
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `date` date NOT NULL,
+    `score` decimal(6,2) NOT NULL DEFAULT 0,
+    `rules` varchar(255) NOT NULL DEFAULT '' COMMENT '命中的规则, 逗号分隔',
+    `evidence` text COMMENT '命中规则的证据, json 格式',
+    `status` tinyint(4) NOT NULL DEFAULT 0 COMMENT '0: 待审核 1: 确认作弊 2: 已排除',
+    `reviewer` varchar(128) NOT NULL DEFAULT '',
+    `remark` varchar(255) NOT NULL DEFAULT '',
+    `reviewed_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_device_date` (`device_id`, `date`) USING BTREE,
+    KEY `idx_status` (`status`) USING BTREE,
+    KEY `idx_user_id` (`user_id`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '节点异常检测结果';
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `device_events` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `device_id` varchar(128) NOT NULL DEFAULT '',
+    `user_id` varchar(128) NOT NULL DEFAULT '' COMMENT '事件发生时节点的所属用户',
+    `event` varchar(32) NOT NULL DEFAULT '',
+    `operator` varchar(128) NOT NULL DEFAULT '' COMMENT '操作人, 系统自动检测的为 system',
+    `old_value` varchar(255) NOT NULL DEFAULT '',
+    `new_value` varchar(255) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    KEY `idx_device_id` (`device_id`, `id`) USING BTREE,
+    KEY `idx_created_at` (`created_at`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '节点生命周期事件, 只追加不修改';
@@ -0,0 +1,44 @@
+CREATE TABLE IF NOT EXISTS `share_link` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `token` varchar(64) NOT NULL DEFAULT '',
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `cid` varchar(128) NOT NULL DEFAULT '' COMMENT '分享文件时为文件cid, 分享文件组时为文件组id',
+    `name` varchar(255) NOT NULL DEFAULT '',
+    `long_link` varchar(1024) NOT NULL DEFAULT '',
+    `size` bigint(20) NOT NULL DEFAULT 0 COMMENT '分享内容的大小, 用于统计流量',
+    `password_hash` varchar(128) NOT NULL DEFAULT '',
+    `expire_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+    `max_downloads` bigint(20) NOT NULL DEFAULT 0 COMMENT '0 表示不限制',
+    `max_visitors` bigint(20) NOT NULL DEFAULT 0 COMMENT '0 表示不限制',
+    `download_count` bigint(20) NOT NULL DEFAULT 0,
+    `visitor_count` bigint(20) NOT NULL DEFAULT 0,
+    `allowed_referrers` varchar(1024) NOT NULL DEFAULT '' COMMENT '允许的来源域名, 逗号分隔, 为空不限制',
+    `revoked` tinyint(1) NOT NULL DEFAULT 0,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_token` (`token`) USING BTREE,
+    KEY `idx_user_cid` (`user_id`, `cid`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '分享链接';
+
+CREATE TABLE IF NOT EXISTS `share_link_visitor` (
+    `link_id` bigint(20) NOT NULL,
+    `visitor` varchar(64) NOT NULL DEFAULT '' COMMENT 'IP 和 UA 的哈希',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`link_id`, `visitor`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '分享链接的独立访客';
+
+CREATE TABLE IF NOT EXISTS `share_link_access` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `link_id` bigint(20) NOT NULL,
+    `action` varchar(16) NOT NULL DEFAULT '' COMMENT 'view / download / denied',
+    `ip` varchar(64) NOT NULL DEFAULT '',
+    `country` varchar(64) NOT NULL DEFAULT '',
+    `user_agent` varchar(512) NOT NULL DEFAULT '',
+    `referrer` varchar(512) NOT NULL DEFAULT '',
+    `bytes` bigint(20) NOT NULL DEFAULT 0,
+    `reason` varchar(64) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    KEY `idx_link_created` (`link_id`, `created_at`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '分享链接访问日志';
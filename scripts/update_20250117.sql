@@ -0,0 +1,17 @@
+-- 回收站, 删除的文件和文件组先移到回收站, 过期或者手动清空后才从调度器删除
+CREATE TABLE IF NOT EXISTS `user_asset_trash` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `item_type` varchar(16) NOT NULL DEFAULT '' COMMENT 'asset / group',
+    `hash` varchar(128) NOT NULL DEFAULT '' COMMENT '文件hash, 文件组时为空',
+    `cid` varchar(255) NOT NULL DEFAULT '',
+    `group_id` bigint(20) NOT NULL DEFAULT 0 COMMENT '文件组id, 文件时为0',
+    `name` varchar(255) NOT NULL DEFAULT '',
+    `origin_group_id` bigint(20) NOT NULL DEFAULT 0 COMMENT '删除前所在的文件组',
+    `size` bigint(20) NOT NULL DEFAULT 0,
+    `deleted_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `expire_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    KEY `idx_user_id` (`user_id`) USING BTREE,
+    KEY `idx_expire_at` (`expire_at`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '用户文件回收站';
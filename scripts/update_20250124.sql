@@ -0,0 +1,25 @@
+-- 开启了版本管理的文件夹, group_id 为 0 表示根目录
+CREATE TABLE IF NOT EXISTS `user_asset_versioning` (
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `group_id` bigint(20) NOT NULL DEFAULT 0,
+    `keep_versions` int(11) NOT NULL DEFAULT 0 COMMENT '保留的历史版本数, 0 表示不限制',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`user_id`, `group_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '文件夹版本管理设置';
+
+-- 文件版本, 同一文件夹下同名的文件为同一个逻辑文件
+CREATE TABLE IF NOT EXISTS `user_asset_version` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `group_id` bigint(20) NOT NULL DEFAULT 0,
+    `asset_name` varchar(255) NOT NULL DEFAULT '',
+    `hash` varchar(128) NOT NULL DEFAULT '',
+    `cid` varchar(255) NOT NULL DEFAULT '',
+    `total_size` bigint(20) NOT NULL DEFAULT 0,
+    `version` int(11) NOT NULL DEFAULT 1,
+    `is_current` tinyint(1) NOT NULL DEFAULT 0,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_user_hash` (`user_id`, `hash`) USING BTREE,
+    KEY `idx_logical_file` (`user_id`, `group_id`, `asset_name`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '用户文件版本';
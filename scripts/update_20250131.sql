@@ -0,0 +1,35 @@
+-- 去重统计, 每个区域一行, 由定时任务全量刷新
+CREATE TABLE IF NOT EXISTS `asset_dedup_area` (
+    `area_id` varchar(64) NOT NULL DEFAULT '',
+    `logical_size` bigint(20) NOT NULL DEFAULT 0 COMMENT '所有用户文件大小之和',
+    `physical_size` bigint(20) NOT NULL DEFAULT 0 COMMENT '去重后实际存储的大小',
+    `hash_count` bigint(20) NOT NULL DEFAULT 0,
+    `ref_count` bigint(20) NOT NULL DEFAULT 0,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`area_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '区域去重统计';
+
+-- 被最多用户共享的文件, 由定时任务全量刷新
+CREATE TABLE IF NOT EXISTS `asset_dedup_top_hash` (
+    `hash` varchar(128) NOT NULL DEFAULT '',
+    `cid` varchar(255) NOT NULL DEFAULT '',
+    `total_size` bigint(20) NOT NULL DEFAULT 0,
+    `user_count` bigint(20) NOT NULL DEFAULT 0,
+    `saved_size` bigint(20) NOT NULL DEFAULT 0,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`hash`),
+    KEY `idx_user_count` (`user_count`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '共享最多的文件';
+
+-- 秒传记录, 上传的文件在区域内已存在时记录
+CREATE TABLE IF NOT EXISTS `asset_dedup_hit` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `hash` varchar(128) NOT NULL DEFAULT '',
+    `area_id` varchar(64) NOT NULL DEFAULT '',
+    `total_size` bigint(20) NOT NULL DEFAULT 0,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    KEY `idx_user_id` (`user_id`, `created_at`) USING BTREE,
+    KEY `idx_created_at` (`created_at`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '秒传记录';
@@ -0,0 +1,23 @@
+-- ipfs导入记录增加每个cid的处理状态: queued, resolving, pinning, syncing, done, failed
+ALTER TABLE `sync_ipfs_record`
+    MODIFY COLUMN `size` bigint(20) NOT NULL DEFAULT '0' COMMENT '文件大小',
+    ADD COLUMN `state` varchar(32) NOT NULL DEFAULT 'queued' COMMENT '处理状态',
+    ADD COLUMN `reason` varchar(255) NOT NULL DEFAULT '' COMMENT '失败原因',
+    ADD COLUMN `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP;
+
+UPDATE `sync_ipfs_record` SET `state` = 'done' WHERE `status` = 1;
+-- 旧版本未完成的导入不会再被处理, 标记为失败由用户重新导入
+UPDATE `sync_ipfs_record` SET `state` = 'failed', `reason` = 'interrupted by upgrade, please import again' WHERE `status` = 0;
+
+-- 导出到ipfs节点的记录
+CREATE TABLE IF NOT EXISTS `ipfs_export_record` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `username` varchar(255) NOT NULL DEFAULT '',
+    `cid` varchar(255) NOT NULL DEFAULT '',
+    `state` varchar(32) NOT NULL DEFAULT 'queued' COMMENT '处理状态: queued, pinning, done, failed',
+    `reason` varchar(255) NOT NULL DEFAULT '' COMMENT '失败原因',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uq_uc` (`username`, `cid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT 'ipfs文件导出列表';
@@ -0,0 +1,18 @@
+-- 文件组导出为 CAR 的任务
+CREATE TABLE IF NOT EXISTS `user_asset_car_export` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `group_id` bigint(20) NOT NULL DEFAULT 0,
+    `name` varchar(255) NOT NULL DEFAULT '',
+    `state` varchar(32) NOT NULL DEFAULT 'queued' COMMENT '处理状态: queued, running, done, failed',
+    `reason` varchar(255) NOT NULL DEFAULT '' COMMENT '失败原因',
+    `root_cid` varchar(255) NOT NULL DEFAULT '',
+    `asset_count` int(11) NOT NULL DEFAULT 0,
+    `size` bigint(20) NOT NULL DEFAULT 0,
+    `file_path` varchar(512) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `expire_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '导出文件的删除时间',
+    PRIMARY KEY (`id`),
+    KEY `idx_user_id` (`user_id`) USING BTREE,
+    KEY `idx_expire_at` (`expire_at`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '文件组 CAR 导出';
@@ -0,0 +1,27 @@
+-- 用户主密钥, 私钥由客户端用口令或钱包签名派生的密钥加密后保存, 服务端不接触明文
+CREATE TABLE IF NOT EXISTS `user_encryption_key` (
+    `user_id` varchar(128) NOT NULL,
+    `version` bigint(20) NOT NULL DEFAULT 1 COMMENT '主密钥版本, 轮换时加 1',
+    `wrap_method` varchar(16) NOT NULL DEFAULT '' COMMENT '私钥的加密方式: passphrase, wallet',
+    `kdf_params` varchar(512) NOT NULL DEFAULT '' COMMENT '派生密钥的参数, 由客户端解释',
+    `public_key` varchar(128) NOT NULL DEFAULT '' COMMENT 'X25519 公钥 (base64)',
+    `wrapped_private_key` varchar(512) NOT NULL DEFAULT '' COMMENT '加密后的私钥 (base64)',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '用户主密钥';
+
+-- 文件数据密钥, 用持有者的公钥加密, 分享时为接收者单独保存一份
+CREATE TABLE IF NOT EXISTS `user_asset_key` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `hash` varchar(128) NOT NULL DEFAULT '',
+    `cid` varchar(255) NOT NULL DEFAULT '',
+    `key_version` bigint(20) NOT NULL DEFAULT 1 COMMENT '加密时持有者主密钥的版本',
+    `wrapped_key` varchar(512) NOT NULL DEFAULT '' COMMENT '加密后的数据密钥 (base64)',
+    `shared_by` varchar(128) NOT NULL DEFAULT '' COMMENT '分享者, 为空表示自己上传',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_user_hash` (`user_id`, `hash`),
+    KEY `idx_shared_by` (`shared_by`, `hash`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '文件数据密钥';
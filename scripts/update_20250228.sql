@@ -0,0 +1,39 @@
+-- 文件夹的生命周期规则
+CREATE TABLE IF NOT EXISTS `asset_lifecycle_rule` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `group_id` bigint(20) NOT NULL DEFAULT 0 COMMENT '文件夹id, 包含子文件夹, 0 表示根目录',
+    `action` varchar(32) NOT NULL DEFAULT '' COMMENT '处理方式: delete, unshare, archive, reduce_replica',
+    `days` int(11) NOT NULL DEFAULT 0 COMMENT 'delete, unshare 为上传后的天数, archive, reduce_replica 为没有下载的天数',
+    `keep_areas` int(11) NOT NULL DEFAULT 1 COMMENT 'reduce_replica 保留的区域数',
+    `enabled` tinyint(1) NOT NULL DEFAULT 1,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_user_group_action` (`user_id`, `group_id`, `action`),
+    KEY `idx_enabled` (`enabled`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '文件生命周期规则';
+
+-- 生命周期规则的处理日志
+CREATE TABLE IF NOT EXISTS `asset_lifecycle_log` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `rule_id` bigint(20) NOT NULL DEFAULT 0 COMMENT '0 表示文件到期',
+    `action` varchar(32) NOT NULL DEFAULT '',
+    `hash` varchar(128) NOT NULL DEFAULT '',
+    `cid` varchar(255) NOT NULL DEFAULT '',
+    `asset_name` varchar(255) NOT NULL DEFAULT '',
+    `detail` varchar(255) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    KEY `idx_user_created` (`user_id`, `created_at`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '文件生命周期处理日志';
+
+-- 需要备份到 Filecoin 的文件, 由生命周期规则的 archive 添加
+CREATE TABLE IF NOT EXISTS `asset_backup_request` (
+    `cid` varchar(255) NOT NULL,
+    `hash` varchar(128) NOT NULL DEFAULT '',
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`cid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '文件备份请求';
@@ -0,0 +1,38 @@
+-- Filecoin 备份任务, 每个文件一条, 记录备份状态和订单情况
+CREATE TABLE IF NOT EXISTS `fil_backup_job` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `cid` varchar(255) NOT NULL DEFAULT '',
+    `hash` varchar(128) NOT NULL DEFAULT '',
+    `state` varchar(32) NOT NULL DEFAULT 'pending' COMMENT '状态: pending, packing, dealing, active, expiring, renewing, expired, failed',
+    `path` varchar(255) NOT NULL DEFAULT '' COMMENT '打包后的路径, 对应 fil_storage.path',
+    `deal_count` int(11) NOT NULL DEFAULT 0 COMMENT '有效订单数',
+    `deal_end_time` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '有效订单的最晚结束时间',
+    `retry_count` int(11) NOT NULL DEFAULT 0,
+    `reason` varchar(255) NOT NULL DEFAULT '' COMMENT '失败原因',
+    `dispatched_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '最近一次交给备份程序的时间',
+    `alerted_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP COMMENT '最近一次到期提醒的时间',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_cid` (`cid`),
+    KEY `idx_state` (`state`) USING BTREE,
+    KEY `idx_path` (`path`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT 'Filecoin 备份任务';
+
+-- 从 Filecoin 恢复文件的请求
+CREATE TABLE IF NOT EXISTS `fil_restore_request` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `cid` varchar(255) NOT NULL DEFAULT '',
+    `hash` varchar(128) NOT NULL DEFAULT '',
+    `area_id` varchar(64) NOT NULL DEFAULT '' COMMENT '恢复到的区域',
+    `group_id` bigint(20) NOT NULL DEFAULT 0,
+    `name` varchar(255) NOT NULL DEFAULT '',
+    `state` varchar(32) NOT NULL DEFAULT 'pending' COMMENT '状态: pending, retrieving, importing, failed',
+    `reason` varchar(255) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    KEY `idx_user_id` (`user_id`) USING BTREE,
+    KEY `idx_state` (`state`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT 'Filecoin 恢复请求';
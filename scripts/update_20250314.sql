@@ -0,0 +1,32 @@
+-- 副本策略, 用户的策略优先于租户的策略
+CREATE TABLE IF NOT EXISTS `replication_policy` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `owner_type` varchar(16) NOT NULL DEFAULT '' COMMENT '策略所属: user, tenant',
+    `owner_id` varchar(128) NOT NULL DEFAULT '' COMMENT '用户名或租户id',
+    `mode` varchar(16) NOT NULL DEFAULT '' COMMENT 'fixed: 在指定的国家或区域保留副本, nearest: 保留离用户最近的区域',
+    `replicas` int(11) NOT NULL DEFAULT 1 COMMENT '副本区域数',
+    `countries` varchar(512) NOT NULL DEFAULT '' COMMENT '国家, 逗号分隔',
+    `area_ids` varchar(1024) NOT NULL DEFAULT '' COMMENT '区域, 逗号分隔',
+    `prune` tinyint(1) NOT NULL DEFAULT 0 COMMENT '是否删除策略之外的副本',
+    `enabled` tinyint(1) NOT NULL DEFAULT 1,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_owner` (`owner_type`, `owner_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '副本策略';
+
+-- 文件副本与策略的对比结果
+CREATE TABLE IF NOT EXISTS `asset_replication_status` (
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `hash` varchar(128) NOT NULL DEFAULT '',
+    `cid` varchar(255) NOT NULL DEFAULT '',
+    `policy_id` bigint(20) NOT NULL DEFAULT 0,
+    `desired_areas` varchar(1024) NOT NULL DEFAULT '' COMMENT '策略要求的区域',
+    `actual_areas` varchar(1024) NOT NULL DEFAULT '' COMMENT '已同步的区域',
+    `missing_areas` varchar(1024) NOT NULL DEFAULT '' COMMENT '缺少的区域',
+    `extra_areas` varchar(1024) NOT NULL DEFAULT '' COMMENT '策略之外的区域',
+    `compliant` tinyint(1) NOT NULL DEFAULT 0,
+    `checked_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`user_id`, `hash`),
+    KEY `idx_user_compliant` (`user_id`, `compliant`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '文件副本合规状态';
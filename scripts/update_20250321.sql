@@ -0,0 +1,20 @@
+-- 文件同步到区域的任务, 每个文件每个区域一条, 替代 redis 队列的轮询
+CREATE TABLE IF NOT EXISTS `asset_sync_task` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `kind` varchar(16) NOT NULL DEFAULT '' COMMENT 'user: 登录用户的文件, temp: 未登录上传的文件',
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `owner` varchar(128) NOT NULL DEFAULT '',
+    `cid` varchar(255) NOT NULL DEFAULT '',
+    `hash` varchar(128) NOT NULL DEFAULT '',
+    `source_area_id` varchar(64) NOT NULL DEFAULT '' COMMENT '文件上传的区域',
+    `area_id` varchar(64) NOT NULL DEFAULT '' COMMENT '同步到的区域',
+    `state` varchar(16) NOT NULL DEFAULT 'pending' COMMENT '状态: pending, pulling, servicing, failed',
+    `attempts` int(11) NOT NULL DEFAULT 0,
+    `last_error` varchar(512) NOT NULL DEFAULT '',
+    `next_run_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_kind_user_hash_area` (`kind`, `user_id`, `hash`, `area_id`),
+    KEY `idx_state_next_run_at` (`state`, `next_run_at`) USING BTREE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '文件同步任务';
@@ -0,0 +1,19 @@
+-- 记录传输请求的国家和运营商, 用于按用户网络统计区域的传输表现
+ALTER TABLE `asset_transfer_log` ADD COLUMN `country` varchar(64) NOT NULL DEFAULT '' COMMENT '请求ip的国家';
+ALTER TABLE `asset_transfer_log` ADD COLUMN `isp` varchar(128) NOT NULL DEFAULT '' COMMENT '请求ip的运营商';
+
+-- 区域的传输表现, country 和 isp 为空的记录是不区分用户网络的汇总
+CREATE TABLE IF NOT EXISTS `area_transfer_stats` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `area_id` varchar(64) NOT NULL DEFAULT '',
+    `country` varchar(64) NOT NULL DEFAULT '',
+    `isp` varchar(128) NOT NULL DEFAULT '',
+    `transfer_type` varchar(16) NOT NULL DEFAULT '' COMMENT 'upload, download',
+    `samples` bigint(20) NOT NULL DEFAULT 0 COMMENT '完成的传输数',
+    `p50_rate` bigint(20) NOT NULL DEFAULT 0 COMMENT '成功传输速率的中位数',
+    `p95_rate` bigint(20) NOT NULL DEFAULT 0 COMMENT '成功传输速率的 95 分位数',
+    `fail_rate` double NOT NULL DEFAULT 0 COMMENT '失败率',
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_area_country_isp_type` (`area_id`, `country`, `isp`, `transfer_type`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '区域传输表现统计';
@@ -0,0 +1,28 @@
+-- 未登录上传的文件增加有效期和下架状态
+ALTER TABLE temp_asset ADD COLUMN `cid` varchar(255) NOT NULL DEFAULT '';
+ALTER TABLE temp_asset ADD COLUMN `area_ids` varchar(255) NOT NULL DEFAULT '' COMMENT '文件所在的区域, 逗号分隔';
+ALTER TABLE temp_asset ADD COLUMN `state` varchar(16) NOT NULL DEFAULT 'active' COMMENT '状态: active, expired, removed';
+ALTER TABLE temp_asset ADD COLUMN `reason` varchar(255) NOT NULL DEFAULT '' COMMENT '下架原因';
+ALTER TABLE temp_asset ADD COLUMN `expires_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE temp_asset ADD COLUMN `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP;
+ALTER TABLE temp_asset ADD INDEX `idx_state_expires_at` (`state`, `expires_at`);
+UPDATE temp_asset SET expires_at = DATE_ADD(NOW(), INTERVAL 1 DAY);
+
+-- 未登录用户的每次上传, 用于统计上传限额和注册后认领文件
+CREATE TABLE IF NOT EXISTS `temp_asset_upload` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `hash` varchar(128) NOT NULL DEFAULT '',
+    `cid` varchar(255) NOT NULL DEFAULT '',
+    `asset_name` varchar(255) NOT NULL DEFAULT '',
+    `claim_token` varchar(64) NOT NULL DEFAULT '',
+    `ip` varchar(64) NOT NULL DEFAULT '',
+    `fingerprint` varchar(128) NOT NULL DEFAULT '',
+    `size` bigint(20) NOT NULL DEFAULT 0,
+    `claimed_by` varchar(128) NOT NULL DEFAULT '' COMMENT '认领的用户',
+    `claimed_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_claim_token` (`claim_token`),
+    KEY `idx_hash` (`hash`),
+    KEY `idx_created_at` (`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '未登录用户的上传记录';
@@ -0,0 +1,65 @@
+-- 内容审核, 每个文件 hash 一条审核记录, 多次举报累加
+CREATE TABLE IF NOT EXISTS `moderation_case` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `hash` varchar(128) NOT NULL DEFAULT '',
+    `cid` varchar(255) NOT NULL DEFAULT '',
+    `user_id` varchar(128) NOT NULL DEFAULT '' COMMENT '被举报的上传者, 未登录上传时为空',
+    `state` varchar(16) NOT NULL DEFAULT 'pending' COMMENT '状态: pending, actioned, dismissed, appealed, reinstated',
+    `action` varchar(32) NOT NULL DEFAULT '' COMMENT '处理方式: block_hash, disable_sharing, delete',
+    `report_count` int(11) NOT NULL DEFAULT 0,
+    `last_reason` varchar(255) NOT NULL DEFAULT '' COMMENT '最近一次举报的类型',
+    `admin_note` varchar(512) NOT NULL DEFAULT '',
+    `appeal_reason` varchar(1024) NOT NULL DEFAULT '',
+    `handled_by` varchar(128) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_hash` (`hash`),
+    KEY `idx_state` (`state`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '内容审核记录';
+
+CREATE TABLE IF NOT EXISTS `moderation_report` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `case_id` bigint(20) NOT NULL DEFAULT 0,
+    `target` varchar(512) NOT NULL DEFAULT '' COMMENT '举报的分享链接 token 或 cid',
+    `reporter` varchar(128) NOT NULL DEFAULT '' COMMENT '举报人的 ip',
+    `category` varchar(32) NOT NULL DEFAULT '',
+    `description` varchar(1024) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    KEY `idx_case_id` (`case_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '内容举报';
+
+-- 被屏蔽的文件, 分享, 打开和下载时检查
+CREATE TABLE IF NOT EXISTS `content_block` (
+    `hash` varchar(128) NOT NULL,
+    `case_id` bigint(20) NOT NULL DEFAULT 0,
+    `reason` varchar(512) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`hash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '文件屏蔽列表';
+
+-- 被禁止分享的用户
+CREATE TABLE IF NOT EXISTS `share_restriction` (
+    `user_id` varchar(128) NOT NULL,
+    `case_id` bigint(20) NOT NULL DEFAULT 0,
+    `reason` varchar(512) NOT NULL DEFAULT '',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`user_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '用户分享限制';
+
+-- 审核处理后给上传者的通知
+CREATE TABLE IF NOT EXISTS `moderation_notice` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `case_id` bigint(20) NOT NULL DEFAULT 0,
+    `hash` varchar(128) NOT NULL DEFAULT '',
+    `cid` varchar(255) NOT NULL DEFAULT '',
+    `action` varchar(32) NOT NULL DEFAULT '',
+    `reason` varchar(512) NOT NULL DEFAULT '',
+    `is_read` tinyint(1) NOT NULL DEFAULT 0,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    KEY `idx_user_id` (`user_id`),
+    KEY `idx_case_id` (`case_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '内容审核通知';
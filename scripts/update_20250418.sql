@@ -0,0 +1,39 @@
+-- 文件每天的访问汇总, 由 asset_storage_hour 汇总而来, 用于按天查询和热门文件排行
+CREATE TABLE IF NOT EXISTS `asset_access_daily` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `hash` varchar(128) NOT NULL DEFAULT '',
+    `date` date NOT NULL,
+    `download_count` bigint(20) NOT NULL DEFAULT 0,
+    `total_traffic` bigint(20) NOT NULL DEFAULT 0,
+    `peak_bandwidth` bigint(20) NOT NULL DEFAULT 0,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_user_hash_date` (`user_id`, `hash`, `date`),
+    KEY `idx_user_date` (`user_id`, `date`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '文件每天的访问汇总';
+
+-- 文件每天按国家和来源统计的访问次数
+CREATE TABLE IF NOT EXISTS `asset_access_dimension` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `hash` varchar(128) NOT NULL DEFAULT '',
+    `date` date NOT NULL,
+    `dimension` varchar(16) NOT NULL DEFAULT '' COMMENT 'country, referrer',
+    `value` varchar(128) NOT NULL DEFAULT '',
+    `count` bigint(20) NOT NULL DEFAULT 0,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_user_hash_date_dim` (`user_id`, `hash`, `date`, `dimension`, `value`),
+    KEY `idx_user_date` (`user_id`, `date`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '文件访问的国家和来源';
+
+ALTER TABLE asset_storage_hour ADD INDEX `idx_timestamp` (`timestamp`);
+ALTER TABLE asset_storage_hour ADD INDEX `idx_user_hash_timestamp` (`user_id`, `hash`, `timestamp`);
+
+-- 用已有的小时数据回填最近 90 天的每天访问汇总, asset_storage_hour 的 timestamp 是小时结束的时间
+INSERT INTO asset_access_daily (user_id, hash, date, download_count, total_traffic, peak_bandwidth)
+SELECT user_id, hash, DATE(FROM_UNIXTIME(timestamp - 1)) AS d, SUM(download_count), SUM(total_traffic), MAX(peak_bandwidth)
+FROM asset_storage_hour
+WHERE timestamp > UNIX_TIMESTAMP(DATE_SUB(CURDATE(), INTERVAL 90 DAY)) AND user_id <> ''
+GROUP BY user_id, hash, d
+ON DUPLICATE KEY UPDATE download_count = VALUES(download_count), total_traffic = VALUES(total_traffic), peak_bandwidth = VALUES(peak_bandwidth);
@@ -0,0 +1,18 @@
+-- 文件的预览图, 相同内容的文件共用一份预览
+CREATE TABLE IF NOT EXISTS `asset_preview` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `hash` varchar(128) NOT NULL DEFAULT '',
+    `cid` varchar(255) NOT NULL DEFAULT '',
+    `kind` varchar(16) NOT NULL DEFAULT '' COMMENT 'image, video',
+    `state` varchar(16) NOT NULL DEFAULT 'pending' COMMENT '状态: pending, done, failed, unsupported',
+    `width` int(11) NOT NULL DEFAULT 0,
+    `height` int(11) NOT NULL DEFAULT 0,
+    `duration` bigint(20) NOT NULL DEFAULT 0 COMMENT '视频时长, 毫秒',
+    `thumbnail_key` varchar(255) NOT NULL DEFAULT '' COMMENT '缩略图在 oss 中的路径',
+    `poster_key` varchar(255) NOT NULL DEFAULT '' COMMENT '视频封面在 oss 中的路径',
+    `reason` varchar(255) NOT NULL DEFAULT '' COMMENT '失败原因',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_hash` (`hash`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '文件预览';
@@ -0,0 +1,56 @@
+-- 存储套餐
+CREATE TABLE IF NOT EXISTS `storage_plan` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `name` varchar(64) NOT NULL DEFAULT '',
+    `storage_size` bigint(20) NOT NULL DEFAULT 0 COMMENT '存储空间, 字节',
+    `traffic` bigint(20) NOT NULL DEFAULT 0 COMMENT '总流量, 字节',
+    `max_file_size` bigint(20) NOT NULL DEFAULT 0 COMMENT '单个文件最大字节数, 0 不限制',
+    `replica_areas` int(11) NOT NULL DEFAULT 0 COMMENT '最多同步的区域数, 0 不限制',
+    `price` bigint(20) NOT NULL DEFAULT 0 COMMENT '每个周期的价格, 链上代币的最小单位',
+    `duration_days` int(11) NOT NULL DEFAULT 30 COMMENT '每个周期的天数',
+    `enabled` tinyint(1) NOT NULL DEFAULT 1,
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '存储套餐';
+
+-- 用户当前的套餐, 每个用户一条
+CREATE TABLE IF NOT EXISTS `user_subscription` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `plan_id` bigint(20) NOT NULL DEFAULT 0,
+    `state` varchar(16) NOT NULL DEFAULT 'active' COMMENT '状态: active, expired',
+    `started_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `expires_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `granted_days` int(11) NOT NULL DEFAULT 0 COMMENT '管理员赠送的天数, 不参与折算',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    `updated_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_user_id` (`user_id`),
+    KEY `idx_state_expires_at` (`state`, `expires_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '用户套餐';
+
+-- 套餐订单, 链上支付或管理员赠送
+CREATE TABLE IF NOT EXISTS `subscription_order` (
+    `id` bigint(20) NOT NULL AUTO_INCREMENT,
+    `order_id` varchar(64) NOT NULL DEFAULT '',
+    `user_id` varchar(128) NOT NULL DEFAULT '',
+    `plan_id` bigint(20) NOT NULL DEFAULT 0,
+    `kind` varchar(16) NOT NULL DEFAULT '' COMMENT '类型: new, renew, upgrade, downgrade',
+    `days` int(11) NOT NULL DEFAULT 0 COMMENT '购买的天数, 包含折算的天数',
+    `amount` bigint(20) NOT NULL DEFAULT 0 COMMENT '需要支付的金额',
+    `credit` bigint(20) NOT NULL DEFAULT 0 COMMENT '原套餐剩余时间折算的金额',
+    `source_plan_id` bigint(20) NOT NULL DEFAULT 0 COMMENT '下单时生效中的套餐',
+    `source_expires_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00' COMMENT '下单时套餐的到期时间',
+    `pay_method` varchar(16) NOT NULL DEFAULT '' COMMENT '支付方式: chain, admin',
+    `state` varchar(16) NOT NULL DEFAULT 'created' COMMENT '状态: created, paid, cancelled, refund_pending',
+    `operator` varchar(128) NOT NULL DEFAULT '' COMMENT '赠送的管理员',
+    `note` varchar(255) NOT NULL DEFAULT '',
+    `expires_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00' COMMENT '生效后套餐的到期时间',
+    `paid_at` datetime NOT NULL DEFAULT '1970-01-01 00:00:00',
+    `created_at` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`id`),
+    UNIQUE KEY `uniq_order_id` (`order_id`),
+    KEY `idx_user_id` (`user_id`),
+    KEY `idx_state` (`state`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COMMENT '套餐订单';
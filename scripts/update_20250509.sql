@@ -0,0 +1,8 @@
+-- 复制文件组时同一个文件在不同文件组中各有一条记录, 主键加上 group_id
+UPDATE user_asset SET group_id = 0 WHERE group_id IS NULL;
+ALTER TABLE user_asset MODIFY COLUMN `group_id` INT NOT NULL DEFAULT 0;
+ALTER TABLE user_asset DROP PRIMARY KEY;
+ALTER TABLE user_asset ADD PRIMARY KEY (`hash`, `user_id`, `group_id`);
+
+-- CAR 导出文件上传到 oss, 各个实例都可以下载和清理
+ALTER TABLE user_asset_car_export CHANGE COLUMN `file_path` `object_key` varchar(512) NOT NULL DEFAULT '' COMMENT 'oss 中的对象';